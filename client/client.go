@@ -0,0 +1,253 @@
+// Package client is a typed Go client for the user service API, for
+// other Go services to use instead of hand-rolled HTTP calls. It speaks
+// the /v2 enveloped format, retries requests the service refused or
+// couldn't reach, and maps error responses to *APIError.
+package client
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	crand "crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Config configures a Client. Set at most one of APIKey and Token.
+type Config struct {
+	// BaseURL is where the service is reached, e.g. http://user-service:8080.
+	BaseURL string
+	APIKey  string
+	// SigningSecret, if set with APIKey, signs every request with it, for
+	// keys the service lists in API_KEY_SECRETS.
+	SigningSecret string
+	// Token is a bearer access token from POST /login.
+	Token string
+	// Tenant, if set, is sent as X-Tenant-ID.
+	Tenant string
+	// HTTPClient defaults to a client with a 10 second timeout.
+	HTTPClient *http.Client
+	// Retries is how many times a failed request is retried; a negative
+	// value disables retries. Zero means the default of 3.
+	Retries int
+	// RetryBackoff is the wait before the first retry, doubled for each
+	// one after. Zero means the default of 100ms.
+	RetryBackoff time.Duration
+	// MaxRetryWait caps each wait, including one asked for by a
+	// Retry-After header. Zero means the default of 10s.
+	MaxRetryWait time.Duration
+}
+
+// Client calls the user service. It is safe for concurrent use.
+type Client struct {
+	base *url.URL
+	cfg  Config
+	http *http.Client
+}
+
+func New(cfg Config) (*Client, error) {
+	base, err := url.Parse(strings.TrimRight(cfg.BaseURL, "/"))
+	if err != nil {
+		return nil, fmt.Errorf("client: invalid BaseURL: %w", err)
+	}
+	if base.Scheme == "" || base.Host == "" {
+		return nil, fmt.Errorf("client: BaseURL %q needs a scheme and host", cfg.BaseURL)
+	}
+	if cfg.APIKey != "" && cfg.Token != "" {
+		return nil, errors.New("client: set APIKey or Token, not both")
+	}
+	if cfg.SigningSecret != "" && cfg.APIKey == "" {
+		return nil, errors.New("client: SigningSecret needs APIKey")
+	}
+	if cfg.Retries == 0 {
+		cfg.Retries = 3
+	}
+	if cfg.RetryBackoff == 0 {
+		cfg.RetryBackoff = 100 * time.Millisecond
+	}
+	if cfg.MaxRetryWait == 0 {
+		cfg.MaxRetryWait = 10 * time.Second
+	}
+	httpClient := cfg.HTTPClient
+	if httpClient == nil {
+		httpClient = &http.Client{Timeout: 10 * time.Second}
+	}
+	return &Client{base: base, cfg: cfg, http: httpClient}, nil
+}
+
+// envelope is the /v2 response format.
+type envelope struct {
+	Data json.RawMessage `json:"data"`
+	Meta struct {
+		RequestID  string `json:"request_id"`
+		Pagination *struct {
+			Offset int `json:"offset"`
+			Limit  int `json:"limit"`
+			Total  int `json:"total"`
+		} `json:"pagination"`
+	} `json:"meta"`
+}
+
+// do sends the request to /v2 + path and decodes the response's data
+// into out, if given. It returns the envelope for callers that need the
+// metadata.
+func (c *Client) do(ctx context.Context, method, path string, query url.Values, in, out any) (envelope, error) {
+	var env envelope
+	var body []byte
+	if in != nil {
+		var err error
+		if body, err = json.Marshal(in); err != nil {
+			return env, err
+		}
+	}
+	u := *c.base
+	u.Path += "/v2" + path
+	u.RawQuery = query.Encode()
+
+	for attempt := 0; ; attempt++ {
+		res, err := c.send(ctx, method, u.String(), body)
+		if err == nil && res.StatusCode < 300 {
+			defer res.Body.Close()
+			if res.StatusCode == http.StatusNoContent || method == http.MethodHead {
+				return env, nil
+			}
+			if err := json.NewDecoder(res.Body).Decode(&env); err != nil {
+				return env, fmt.Errorf("client: %s %s: decode response: %w", method, path, err)
+			}
+			if out != nil {
+				if err := json.Unmarshal(env.Data, out); err != nil {
+					return env, fmt.Errorf("client: %s %s: decode response: %w", method, path, err)
+				}
+			}
+			return env, nil
+		}
+
+		var apiErr *APIError
+		if err == nil {
+			apiErr = newAPIError(res)
+			err = apiErr
+		}
+		if attempt >= c.cfg.Retries || !retryable(method, apiErr, err) {
+			if apiErr == nil {
+				err = fmt.Errorf("client: %s %s: %w", method, path, err)
+			}
+			return env, err
+		}
+		if err := c.wait(ctx, attempt, apiErr); err != nil {
+			return env, err
+		}
+	}
+}
+
+func (c *Client) send(ctx context.Context, method, url string, body []byte) (*http.Response, error) {
+	var reader io.Reader
+	if body != nil {
+		reader = bytes.NewReader(body)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, url, reader)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	switch {
+	case c.cfg.APIKey != "":
+		req.Header.Set("X-API-Key", c.cfg.APIKey)
+		if c.cfg.SigningSecret != "" {
+			if err := sign(req, c.cfg.SigningSecret, body); err != nil {
+				return nil, err
+			}
+		}
+	case c.cfg.Token != "":
+		req.Header.Set("Authorization", "Bearer "+c.cfg.Token)
+	}
+	if c.cfg.Tenant != "" {
+		req.Header.Set("X-Tenant-ID", c.cfg.Tenant)
+	}
+	return c.http.Do(req)
+}
+
+// sign sets the X-Signature header: the HMAC-SHA256 under secret of the
+// time, a fresh nonce, the method, the request URI and the body's digest.
+// Each attempt is signed anew, since the service accepts a nonce once.
+func sign(req *http.Request, secret string, body []byte) error {
+	nonce := make([]byte, 16)
+	if _, err := crand.Read(nonce); err != nil {
+		return err
+	}
+	t := strconv.FormatInt(time.Now().Unix(), 10)
+	n := hex.EncodeToString(nonce)
+	digest := sha256.Sum256(body)
+	mac := hmac.New(sha256.New, []byte(secret))
+	io.WriteString(mac, t+"\n"+n+"\n"+req.Method+"\n"+req.URL.RequestURI()+"\n"+hex.EncodeToString(digest[:]))
+	req.Header.Set("X-Signature", "t="+t+",nonce="+n+",sig="+hex.EncodeToString(mac.Sum(nil)))
+	return nil
+}
+
+// retryable reports whether a failed request can safely be sent again.
+// Responses with Retry-After, from the rate, concurrency and maintenance
+// limits and the circuit breaker, mean the service refused the request
+// without acting on it, so any method is retried. Other failures may have
+// been acted on and are only retried for idempotent methods.
+func retryable(method string, apiErr *APIError, err error) bool {
+	if apiErr != nil && apiErr.RetryAfter > 0 &&
+		(apiErr.StatusCode == http.StatusTooManyRequests || apiErr.StatusCode == http.StatusServiceUnavailable) {
+		return true
+	}
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodPut, http.MethodDelete:
+	default:
+		return false
+	}
+	if apiErr == nil {
+		// A transport error, unless the caller gave up.
+		return !errors.Is(err, context.Canceled) && !errors.Is(err, context.DeadlineExceeded)
+	}
+	switch apiErr.StatusCode {
+	case http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+// wait sleeps before the next retry: an exponential backoff with jitter,
+// or longer if the service asked for it.
+func (c *Client) wait(ctx context.Context, attempt int, apiErr *APIError) error {
+	d := c.cfg.RetryBackoff << attempt
+	d = d/2 + time.Duration(rand.Int63n(int64(d/2)+1))
+	if apiErr != nil && apiErr.RetryAfter > d {
+		d = apiErr.RetryAfter
+	}
+	d = min(d, c.cfg.MaxRetryWait)
+
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+func parseRetryAfter(value string) time.Duration {
+	if secs, err := strconv.Atoi(value); err == nil && secs > 0 {
+		return time.Duration(secs) * time.Second
+	}
+	if at, err := http.ParseTime(value); err == nil {
+		return time.Until(at)
+	}
+	return 0
+}
@@ -0,0 +1,88 @@
+package client
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Errors an *APIError matches with errors.Is, by status code.
+var (
+	ErrInvalid      = errors.New("request rejected as invalid") // 400, 422
+	ErrUnauthorized = errors.New("authentication required")     // 401
+	ErrForbidden    = errors.New("forbidden")                   // 403
+	ErrNotFound     = errors.New("not found")                   // 404
+	ErrConflict     = errors.New("conflict")                    // 409
+	ErrRateLimited  = errors.New("rate limited")                // 429
+	ErrUnavailable  = errors.New("service unavailable")         // 503
+)
+
+// APIError is an error response from the service.
+type APIError struct {
+	StatusCode int
+	// Message is the service's explanation, in English unless the
+	// request asked for another language.
+	Message   string
+	RequestID string
+	// RetryAfter is how long the service asked callers to wait, if it did.
+	RetryAfter time.Duration
+	// Fields lists the fields a 422 response rejected.
+	Fields []FieldError
+}
+
+// FieldError is one request field that failed validation.
+type FieldError struct {
+	// Field is a JSON pointer into the request body, such as /email.
+	Field string `json:"field"`
+	// Rule names the failed check, such as required or format.
+	Rule    string `json:"rule"`
+	Message string `json:"message"`
+}
+
+func newAPIError(res *http.Response) *APIError {
+	defer res.Body.Close()
+	body, _ := io.ReadAll(io.LimitReader(res.Body, 64<<10))
+	apiErr := &APIError{
+		StatusCode: res.StatusCode,
+		Message:    strings.TrimSpace(string(body)),
+		RequestID:  res.Header.Get("X-Request-ID"),
+		RetryAfter: parseRetryAfter(res.Header.Get("Retry-After")),
+	}
+	if res.StatusCode == http.StatusUnprocessableEntity && strings.HasPrefix(res.Header.Get("Content-Type"), "application/json") &&
+		json.Unmarshal(body, &apiErr.Fields) == nil {
+		messages := make([]string, len(apiErr.Fields))
+		for i, f := range apiErr.Fields {
+			messages[i] = f.Field + ": " + f.Message
+		}
+		apiErr.Message = strings.Join(messages, "; ")
+	}
+	return apiErr
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("user service: %d %s: %s", e.StatusCode, http.StatusText(e.StatusCode), e.Message)
+}
+
+func (e *APIError) Is(target error) bool {
+	switch target {
+	case ErrInvalid:
+		return e.StatusCode == http.StatusBadRequest || e.StatusCode == http.StatusUnprocessableEntity
+	case ErrUnauthorized:
+		return e.StatusCode == http.StatusUnauthorized
+	case ErrForbidden:
+		return e.StatusCode == http.StatusForbidden
+	case ErrNotFound:
+		return e.StatusCode == http.StatusNotFound
+	case ErrConflict:
+		return e.StatusCode == http.StatusConflict
+	case ErrRateLimited:
+		return e.StatusCode == http.StatusTooManyRequests
+	case ErrUnavailable:
+		return e.StatusCode == http.StatusServiceUnavailable
+	}
+	return false
+}
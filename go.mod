@@ -2,4 +2,7 @@ module user-service
 
 go 1.21
 
-require github.com/gorilla/mux v1.8.1
+require (
+	github.com/gorilla/mux v1.8.1
+	golang.org/x/crypto v0.31.0
+)
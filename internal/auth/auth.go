@@ -0,0 +1,278 @@
+// Package auth authenticates callers by client certificate, API key or
+// access token and checks the scopes they were granted.
+package auth
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"user-service/internal/config"
+	"user-service/internal/i18n"
+)
+
+const (
+	ScopeUsersRead    = "users:read"
+	ScopeUsersWrite   = "users:write"
+	ScopeUsersDelete  = "users:delete"
+	ScopeUsersReadPII = "users:read_pii"
+	ScopeDeleteVote   = "users:delete_vote"
+	ScopeAdminUsers   = "admin:users"
+	ScopeAdminMetrics = "admin:metrics"
+	ScopeAdminPII     = "admin:pii"
+	ScopeAdminConfig  = "admin:config"
+	ScopeAdminBackup  = "admin:backup"
+)
+
+// Principal is the authenticated caller of a request. Tenant is the
+// tenant its credential belongs to, if any, which quotas are counted
+// against.
+type Principal struct {
+	Subject string
+	Tenant  string
+	Scopes  []string
+}
+
+// HasScope reports whether the principal was granted the scope. A granted
+// scope ending in ":*" covers every scope with that prefix.
+func (p *Principal) HasScope(scope string) bool {
+	for _, granted := range p.Scopes {
+		if granted == scope {
+			return true
+		}
+		if prefix, ok := strings.CutSuffix(granted, "*"); ok && strings.HasPrefix(scope, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// Config holds the API keys and client certificate identities accepted
+// alongside access tokens.
+type Config struct {
+	Enabled        bool
+	APIKeys        map[string][]string
+	CertIdentities map[string][]string
+	DefaultScopes  []string
+	// SigningSecrets holds, for API keys whose requests must be signed,
+	// the secret they sign with. SignatureWindow is how far a signature's
+	// time may be from now.
+	SigningSecrets  map[string]string
+	SignatureWindow time.Duration
+	// KeyTenants holds the tenant each API key belongs to.
+	KeyTenants map[string]string
+	// MaxBodyBytes caps request bodies, both those read whole to check a
+	// signature and those the handlers decode, unless LimitBody set
+	// another limit for the request.
+	MaxBodyBytes int64
+}
+
+// LoadConfig reads AUTH_ENABLED, DEFAULT_USER_SCOPES, API_KEYS,
+// MTLS_IDENTITIES, API_KEY_SECRETS, API_KEY_TENANTS, SIGNATURE_WINDOW and
+// MAX_BODY_BYTES.
+// API_KEYS is a semicolon-separated list of key=scope entries with scopes
+// separated by spaces, e.g. "k1=users:read users:write;k2=admin:*".
+// MTLS_IDENTITIES has the same form, keyed by a certificate URI SAN, DNS
+// SAN or subject CN. API_KEY_SECRETS and API_KEY_TENANTS list key=secret
+// and key=tenant entries the same way.
+func LoadConfig(src *config.Source) Config {
+	return Config{
+		Enabled:         src.String("AUTH_ENABLED", "false") == "true",
+		APIKeys:         parseScopeList(src.String("API_KEYS", "")),
+		CertIdentities:  parseScopeList(src.String("MTLS_IDENTITIES", "")),
+		DefaultScopes:   strings.Fields(src.String("DEFAULT_USER_SCOPES", ScopeUsersRead)),
+		SigningSecrets:  parseSecretList(src.String("API_KEY_SECRETS", "")),
+		SignatureWindow: src.Duration("SIGNATURE_WINDOW", 5*time.Minute),
+		KeyTenants:      parseSecretList(src.String("API_KEY_TENANTS", "")),
+		MaxBodyBytes:    int64(src.Int("MAX_BODY_BYTES", 10<<20)),
+	}
+}
+
+var errUnauthenticated = errors.New("missing or invalid credentials")
+
+// SubjectCheck reports whether the user an access token was issued to
+// may still use it. An error means it couldn't be told.
+type SubjectCheck func(ctx context.Context, subject string) (bool, error)
+
+// Authenticator resolves and authorizes the callers of requests.
+type Authenticator struct {
+	cfg      atomic.Pointer[Config]
+	tokens   *TokenIssuer
+	nonces   nonceCache
+	subjects SubjectCheck
+}
+
+func NewAuthenticator(cfg Config, tokens *TokenIssuer) *Authenticator {
+	a := &Authenticator{tokens: tokens}
+	a.cfg.Store(&cfg)
+	return a
+}
+
+func (a *Authenticator) Config() Config {
+	return *a.cfg.Load()
+}
+
+// CheckSubjects makes every access token be checked against its user on
+// use, so tokens stop working as soon as the user may no longer log in
+// rather than when they expire. It must be called before serving.
+func (a *Authenticator) CheckSubjects(check SubjectCheck) {
+	a.subjects = check
+}
+
+// Reload swaps in new API keys, signing secrets, certificate identities
+// and default scopes. Turning authentication on or off still requires a
+// restart.
+func (a *Authenticator) Reload(cfg Config) {
+	cfg.Enabled = a.Config().Enabled
+	a.cfg.Store(&cfg)
+}
+
+// authenticate resolves the caller from a mapped client certificate, an
+// X-API-Key header, signed if the key has a signing secret, or a bearer
+// access token whose user, when subjects are checked, is still allowed in.
+func (a *Authenticator) authenticate(r *http.Request) (*Principal, error) {
+	if p, ok := a.authenticateCert(r); ok {
+		return p, nil
+	}
+	if key := r.Header.Get("X-API-Key"); key != "" {
+		cfg := a.Config()
+		for candidate, scopes := range cfg.APIKeys {
+			if subtle.ConstantTimeCompare([]byte(candidate), []byte(key)) != 1 {
+				continue
+			}
+			if secret, ok := cfg.SigningSecrets[candidate]; ok {
+				if err := a.verifySignature(r, candidate, secret, cfg.SignatureWindow, bodyLimit(r, cfg.MaxBodyBytes)); err != nil {
+					return nil, err
+				}
+			}
+			return &Principal{Subject: APIKeySubject(candidate), Tenant: cfg.KeyTenants[candidate], Scopes: scopes}, nil
+		}
+		return nil, errUnauthenticated
+	}
+
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok {
+		return nil, errUnauthenticated
+	}
+	claims, err := a.tokens.Verify(token, TokenTypeAccess)
+	if err != nil {
+		return nil, errUnauthenticated
+	}
+	if a.subjects != nil {
+		allowed, err := a.subjects(r.Context(), claims.Subject)
+		if err != nil {
+			return nil, err
+		}
+		if !allowed {
+			return nil, errUnauthenticated
+		}
+	}
+	return &Principal{Subject: claims.Subject, Scopes: claims.Scopes}, nil
+}
+
+// APIKeySubject is the principal subject of callers using the API key.
+func APIKeySubject(key string) string {
+	return "apikey:" + apiKeyID(key)
+}
+
+// apiKeyID returns a short, loggable identifier for an API key: the
+// start of its SHA-256, which gives nothing of the key away and, unlike a
+// prefix of the key, tells keys apart.
+func apiKeyID(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:8])
+}
+
+type principalKey struct{}
+
+// PrincipalFrom returns the caller stored by RequireScopes, or nil when
+// authentication is disabled.
+func PrincipalFrom(ctx context.Context) *Principal {
+	p, _ := ctx.Value(principalKey{}).(*Principal)
+	return p
+}
+
+// CallerHasScope reports whether the request's caller holds the scope.
+// Every caller does when authentication is disabled.
+func (a *Authenticator) CallerHasScope(r *http.Request, scope string) bool {
+	if !a.Config().Enabled {
+		return true
+	}
+	p := PrincipalFrom(r.Context())
+	return p != nil && p.HasScope(scope)
+}
+
+// CallerIsUser reports whether the request's caller is the user, that is
+// holds an access token issued to them. Every caller is when
+// authentication is disabled.
+func (a *Authenticator) CallerIsUser(r *http.Request, id string) bool {
+	if !a.Config().Enabled {
+		return true
+	}
+	p := PrincipalFrom(r.Context())
+	return p != nil && p.Subject == id
+}
+
+// RequireScopes wraps the handler so it only runs for callers holding all
+// of the scopes. Routes without scopes are public.
+func (a *Authenticator) RequireScopes(scopes []string, next http.Handler) http.Handler {
+	if len(scopes) == 0 {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !a.Config().Enabled {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		p, err := a.authenticate(r)
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			i18n.Error(w, r, http.StatusRequestEntityTooLarge, "Request body too large")
+			return
+		}
+		if err != nil && !errors.Is(err, errUnauthenticated) {
+			log.Printf("auth: check token subject: %v", err)
+			i18n.Error(w, r, http.StatusServiceUnavailable, "Service temporarily unavailable")
+			return
+		}
+		if err != nil {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="user-service"`)
+			i18n.Error(w, r, http.StatusUnauthorized, "Authentication required")
+			return
+		}
+
+		var missing []string
+		for _, scope := range scopes {
+			if !p.HasScope(scope) {
+				missing = append(missing, scope)
+			}
+		}
+		if len(missing) > 0 {
+			w.Header().Set("WWW-Authenticate", `Bearer error="insufficient_scope", scope="`+strings.Join(missing, " ")+`"`)
+			i18n.Error(w, r, http.StatusForbidden, "Missing required scope: %s", strings.Join(missing, ", "))
+			return
+		}
+
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), principalKey{}, p)))
+	})
+}
+
+// UngrantedScopes returns the requested scopes the caller does not hold
+// itself, since callers may only hand out scopes they have.
+func (a *Authenticator) UngrantedScopes(r *http.Request, requested []string) []string {
+	var missing []string
+	for _, scope := range requested {
+		if !a.CallerHasScope(r, scope) {
+			missing = append(missing, scope)
+		}
+	}
+	return missing
+}
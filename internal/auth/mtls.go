@@ -0,0 +1,158 @@
+package auth
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+
+	"user-service/internal/config"
+)
+
+// TLSConfig says how the server terminates TLS and which client
+// certificates it accepts.
+type TLSConfig struct {
+	CertFile     string
+	KeyFile      string
+	ClientCAFile string
+	// RequireClientCert rejects handshakes without a certificate signed
+	// by the client CA; otherwise one is verified only when presented.
+	RequireClientCert bool
+}
+
+// LoadTLSConfig reads TLS_CERT_FILE, TLS_KEY_FILE, TLS_CLIENT_CA_FILE and
+// TLS_CLIENT_AUTH ("optional" or "require").
+func LoadTLSConfig(src *config.Source) TLSConfig {
+	return TLSConfig{
+		CertFile:          src.String("TLS_CERT_FILE", ""),
+		KeyFile:           src.String("TLS_KEY_FILE", ""),
+		ClientCAFile:      src.String("TLS_CLIENT_CA_FILE", ""),
+		RequireClientCert: src.String("TLS_CLIENT_AUTH", "optional") == "require",
+	}
+}
+
+// Enabled reports whether the server should listen with TLS.
+func (c TLSConfig) Enabled() bool {
+	return c.CertFile != "" || c.KeyFile != ""
+}
+
+// ServerConfig builds the listener's tls.Config, verifying client
+// certificates against the CA bundle when one is configured.
+func (c TLSConfig) ServerConfig() (*tls.Config, error) {
+	if c.CertFile == "" || c.KeyFile == "" {
+		return nil, errors.New("TLS_CERT_FILE and TLS_KEY_FILE must both be set")
+	}
+	cert, err := tls.LoadX509KeyPair(c.CertFile, c.KeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("load server certificate: %w", err)
+	}
+	cfg := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}
+	if c.ClientCAFile == "" {
+		if c.RequireClientCert {
+			return nil, errors.New("TLS_CLIENT_AUTH=require needs TLS_CLIENT_CA_FILE")
+		}
+		return cfg, nil
+	}
+
+	bundle, err := os.ReadFile(c.ClientCAFile)
+	if err != nil {
+		return nil, fmt.Errorf("read client CA bundle: %w", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(bundle) {
+		return nil, fmt.Errorf("no certificates found in %s", c.ClientCAFile)
+	}
+	cfg.ClientCAs = pool
+	cfg.ClientAuth = tls.VerifyClientCertIfGiven
+	if c.RequireClientCert {
+		cfg.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return cfg, nil
+}
+
+// certIdentities lists the names a client certificate can be mapped by:
+// its URI SANs (e.g. SPIFFE IDs), then DNS SANs, then the subject CN.
+func certIdentities(cert *x509.Certificate) []string {
+	var names []string
+	for _, uri := range cert.URIs {
+		names = append(names, uri.String())
+	}
+	names = append(names, cert.DNSNames...)
+	if cert.Subject.CommonName != "" {
+		names = append(names, cert.Subject.CommonName)
+	}
+	return names
+}
+
+// peerCertificate returns the request's client certificate if it was
+// verified against the client CA.
+func peerCertificate(r *http.Request) *x509.Certificate {
+	if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 || len(r.TLS.VerifiedChains[0]) == 0 {
+		return nil
+	}
+	return r.TLS.VerifiedChains[0][0]
+}
+
+// authenticateCert maps a verified client certificate to the scopes
+// configured for the first of its identities found in CertIdentities.
+func (a *Authenticator) authenticateCert(r *http.Request) (*Principal, bool) {
+	cert := peerCertificate(r)
+	if cert == nil {
+		return nil, false
+	}
+	identities := a.Config().CertIdentities
+	for _, name := range certIdentities(cert) {
+		if scopes, ok := identities[name]; ok {
+			return &Principal{Subject: "mtls:" + name, Scopes: scopes}, true
+		}
+	}
+	return nil, false
+}
+
+// CallerIdentity names the caller of a request for auditing: the
+// authenticated principal, or the verified client certificate when
+// authentication is disabled. It is "" for anonymous callers.
+func CallerIdentity(r *http.Request) string {
+	if p := PrincipalFrom(r.Context()); p != nil {
+		return p.Subject
+	}
+	if cert := peerCertificate(r); cert != nil {
+		if names := certIdentities(cert); len(names) > 0 {
+			return "mtls:" + names[0]
+		}
+	}
+	return ""
+}
+
+// parseScopeList parses semicolon-separated name=scope entries with
+// scopes separated by spaces.
+func parseScopeList(list string) map[string][]string {
+	entries := make(map[string][]string)
+	for _, entry := range strings.Split(list, ";") {
+		name, scopes, ok := strings.Cut(strings.TrimSpace(entry), "=")
+		if !ok || name == "" {
+			continue
+		}
+		entries[name] = strings.Fields(scopes)
+	}
+	return entries
+}
+
+// parseSecretList parses semicolon-separated name=secret entries.
+func parseSecretList(list string) map[string]string {
+	entries := make(map[string]string)
+	for _, entry := range strings.Split(list, ";") {
+		name, secret, ok := strings.Cut(strings.TrimSpace(entry), "=")
+		if !ok || name == "" || secret == "" {
+			continue
+		}
+		entries[name] = secret
+	}
+	return entries
+}
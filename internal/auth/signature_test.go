@@ -0,0 +1,42 @@
+package auth
+
+import (
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSignedBodyLimit(t *testing.T) {
+	a := NewAuthenticator(Config{
+		Enabled:         true,
+		APIKeys:         map[string][]string{"key": {ScopeUsersWrite}},
+		SigningSecrets:  map[string]string{"key": "secret"},
+		SignatureWindow: time.Minute,
+		MaxBodyBytes:    16,
+	}, nil)
+	h := a.RequireScopes([]string{ScopeUsersWrite}, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	for i, tc := range []struct {
+		body string
+		want int
+	}{
+		{"short", http.StatusOK},
+		{strings.Repeat("x", 17), http.StatusRequestEntityTooLarge},
+	} {
+		now := time.Now().Unix()
+		nonce := fmt.Sprint("nonce", i)
+		mac := signedMAC("secret", now, nonce, http.MethodPost, "/users", []byte(tc.body))
+		r := httptest.NewRequest(http.MethodPost, "/users", strings.NewReader(tc.body))
+		r.Header.Set("X-API-Key", "key")
+		r.Header.Set("X-Signature", fmt.Sprintf("t=%d,nonce=%s,sig=%s", now, nonce, hex.EncodeToString(mac)))
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		if w.Code != tc.want {
+			t.Errorf("%d byte body: status %d, want %d", len(tc.body), w.Code, tc.want)
+		}
+	}
+}
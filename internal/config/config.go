@@ -0,0 +1,313 @@
+// Package config reads settings from the environment, overlaid by an
+// optional JSON file that can be reloaded at runtime.
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+var ErrNoFile = errors.New("CONFIG_FILE is not set")
+
+// SecretPrefix marks a value that names a secret rather than holding it,
+// e.g. JWT_SECRET=secret:kv/data/user-service#jwt_secret.
+const SecretPrefix = "secret:"
+
+// SecretResolver returns the value of a secret reference, the part of a
+// value after SecretPrefix.
+type SecretResolver interface {
+	Resolve(ref string) (string, error)
+}
+
+// Source resolves config keys. Values from CONFIG_FILE take precedence
+// over the environment.
+type Source struct {
+	path string
+
+	mu      sync.RWMutex
+	file    map[string]string
+	hooks   []hook
+	secrets SecretResolver
+}
+
+type hook struct {
+	prefixes []string
+	apply    func()
+}
+
+// Load reads CONFIG_FILE at startup, if one is set.
+func Load() (*Source, error) {
+	s := &Source{path: os.Getenv("CONFIG_FILE")}
+	if s.path == "" {
+		return s, nil
+	}
+	values, err := s.readFile()
+	if err != nil {
+		return nil, err
+	}
+	s.file = values
+	return s, nil
+}
+
+func (s *Source) raw(key string) string {
+	s.mu.RLock()
+	value, ok := s.file[key]
+	s.mu.RUnlock()
+	if ok {
+		return value
+	}
+	return os.Getenv(key)
+}
+
+func (s *Source) lookup(key string) string {
+	value := s.raw(key)
+	ref, ok := strings.CutPrefix(value, SecretPrefix)
+	if !ok {
+		return value
+	}
+	s.mu.RLock()
+	resolver := s.secrets
+	s.mu.RUnlock()
+	if resolver == nil {
+		log.Printf("config: %s names a secret but SECRETS_PROVIDER is not set", key)
+		return ""
+	}
+	value, err := resolver.Resolve(ref)
+	if err != nil {
+		log.Printf("config: %s: %v", key, err)
+		return ""
+	}
+	return value
+}
+
+// secretRefs returns the secret reference of every key whose value is one.
+func (s *Source) secretRefs() map[string]string {
+	refs := make(map[string]string)
+	for _, kv := range os.Environ() {
+		key, value, _ := strings.Cut(kv, "=")
+		if ref, ok := strings.CutPrefix(value, SecretPrefix); ok {
+			refs[key] = ref
+		}
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for key, value := range s.file {
+		if ref, ok := strings.CutPrefix(value, SecretPrefix); ok {
+			refs[key] = ref
+		} else {
+			delete(refs, key)
+		}
+	}
+	return refs
+}
+
+// UseSecrets resolves the values that name secrets through r, which may
+// be nil if no secret store is configured. Every reference is resolved
+// now, so a missing secret fails startup rather than the first use.
+func (s *Source) UseSecrets(r SecretResolver) error {
+	s.mu.Lock()
+	s.secrets = r
+	s.mu.Unlock()
+
+	refs := s.secretRefs()
+	keys := make([]string, 0, len(refs))
+	for key := range refs {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		if r == nil {
+			return fmt.Errorf("config: %s names a secret but SECRETS_PROVIDER is not set", key)
+		}
+		if _, err := r.Resolve(refs[key]); err != nil {
+			return fmt.Errorf("config: %s: %w", key, err)
+		}
+	}
+	return nil
+}
+
+// SecretsChanged applies, as a reload does, the keys that name a secret
+// under one of paths, after the secret store handed out new values.
+func (s *Source) SecretsChanged(paths []string) ReloadResult {
+	changed := make(map[string]bool, len(paths))
+	for _, path := range paths {
+		changed[path] = true
+	}
+	var keys []string
+	for key, ref := range s.secretRefs() {
+		if path, _, _ := strings.Cut(ref, "#"); changed[path] {
+			keys = append(keys, key)
+		}
+	}
+	return s.apply(keys)
+}
+
+func (s *Source) String(key, fallback string) string {
+	if value := s.lookup(key); value != "" {
+		return value
+	}
+	return fallback
+}
+
+func (s *Source) Int(key string, fallback int) int {
+	value := s.lookup(key)
+	if value == "" {
+		return fallback
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		log.Printf("config: invalid %s=%q, using %d", key, value, fallback)
+		return fallback
+	}
+	return n
+}
+
+func (s *Source) Duration(key string, fallback time.Duration) time.Duration {
+	value := s.lookup(key)
+	if value == "" {
+		return fallback
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		log.Printf("config: invalid %s=%q, using %s", key, value, fallback)
+		return fallback
+	}
+	return d
+}
+
+func (s *Source) Float(key string, fallback float64) float64 {
+	value := s.lookup(key)
+	if value == "" {
+		return fallback
+	}
+	f, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		log.Printf("config: invalid %s=%q, using %g", key, value, fallback)
+		return fallback
+	}
+	return f
+}
+
+// OnReload registers apply to run when a reload changes a key starting
+// with one of the prefixes. Any other changed key needs a restart.
+func (s *Source) OnReload(apply func(), prefixes ...string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.hooks = append(s.hooks, hook{prefixes: prefixes, apply: apply})
+}
+
+// readFile parses CONFIG_FILE, a JSON object of the same keys as the
+// environment variables, e.g. {"LOGIN_MAX_FAILURES": "3"}.
+func (s *Source) readFile() (map[string]string, error) {
+	data, err := os.ReadFile(s.path)
+	if err != nil {
+		return nil, fmt.Errorf("config: %w", err)
+	}
+	values := make(map[string]string)
+	if err := json.Unmarshal(data, &values); err != nil {
+		return nil, fmt.Errorf("config: parse %s: %w", s.path, err)
+	}
+	return values, nil
+}
+
+type ReloadResult struct {
+	Changed         []string `json:"changed"`
+	Applied         []string `json:"applied"`
+	RequiresRestart []string `json:"requires_restart"`
+}
+
+// Reload re-reads CONFIG_FILE and applies the changed settings that can
+// be changed at runtime. A file that fails to parse leaves the running
+// config untouched.
+func (s *Source) Reload() (ReloadResult, error) {
+	if s.path == "" {
+		return ReloadResult{}, ErrNoFile
+	}
+	values, err := s.readFile()
+	if err != nil {
+		return ReloadResult{}, err
+	}
+
+	s.mu.Lock()
+	previous := s.file
+	s.file = values
+	s.mu.Unlock()
+
+	var changed []string
+	for key := range values {
+		if old, ok := previous[key]; !ok || old != values[key] {
+			changed = append(changed, key)
+		}
+	}
+	for key := range previous {
+		if _, ok := values[key]; !ok {
+			changed = append(changed, key)
+		}
+	}
+	return s.apply(changed), nil
+}
+
+// apply runs the hooks of the changed keys, each hook once.
+func (s *Source) apply(changed []string) ReloadResult {
+	s.mu.RLock()
+	hooks := s.hooks
+	s.mu.RUnlock()
+
+	result := ReloadResult{Changed: append([]string{}, changed...), Applied: []string{}, RequiresRestart: []string{}}
+	sort.Strings(result.Changed)
+
+	applied := make(map[int]bool)
+	for _, key := range result.Changed {
+		matched := false
+		for i, h := range hooks {
+			if !h.matches(key) {
+				continue
+			}
+			matched = true
+			if !applied[i] {
+				h.apply()
+				applied[i] = true
+			}
+		}
+		if matched {
+			result.Applied = append(result.Applied, key)
+		} else {
+			result.RequiresRestart = append(result.RequiresRestart, key)
+		}
+	}
+	return result
+}
+
+func (h hook) matches(key string) bool {
+	for _, prefix := range h.prefixes {
+		if strings.HasPrefix(key, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// ReloadOnSIGHUP reloads the config file every time the process receives
+// SIGHUP.
+func (s *Source) ReloadOnSIGHUP() {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGHUP)
+	for range signals {
+		result, err := s.Reload()
+		if err != nil {
+			log.Printf("config: reload failed: %v", err)
+			continue
+		}
+		log.Printf("config: reloaded, applied %v, requires restart %v", result.Applied, result.RequiresRestart)
+	}
+}
@@ -0,0 +1,407 @@
+// Package contract checks the running API against its OpenAPI spec: it
+// calls every documented endpoint, validates the statuses, headers and
+// bodies that come back, and reports routes the spec and the router
+// disagree on, so the two can't drift apart unnoticed.
+package contract
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"user-service/internal/auth"
+	"user-service/internal/handler"
+)
+
+// The suite calls with adminKey, which holds every scope and is the one
+// delete participant. outsiderKey may vote on delete requests but isn't a
+// participant.
+const (
+	adminKey    = "suite-admin"
+	outsiderKey = "other-voter"
+)
+
+// Run parses the contract subcommand's arguments, checks the API and
+// writes the results to out. Without -url it starts this binary as a
+// server on a free port, configured by ServerEnv, and stops it when done.
+// It returns an error if any check failed.
+func Run(args []string, out io.Writer) error {
+	fs := flag.NewFlagSet("contract", flag.ContinueOnError)
+	fs.SetOutput(out)
+	baseURL := fs.String("url", "", "base URL of an instance to check instead of starting one; it needs the suite's API_KEYS and DELETE_PARTICIPANTS")
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return nil
+		}
+		return err
+	}
+
+	if *baseURL == "" {
+		srv, err := startServer()
+		if err != nil {
+			return err
+		}
+		defer srv.stop()
+		*baseURL = srv.url
+	}
+	return Check(*baseURL, out)
+}
+
+// Check runs the suite against the instance at baseURL, which must be
+// configured as ServerEnv sets it up, and writes the results to out. It
+// returns an error if any check failed.
+func Check(baseURL string, out io.Writer) error {
+	spec, err := LoadSpec()
+	if err != nil {
+		return err
+	}
+	c := &checker{
+		spec:    spec,
+		base:    strings.TrimRight(baseURL, "/"),
+		http:    &http.Client{Timeout: 10 * time.Second},
+		out:     out,
+		covered: make(map[string]bool),
+	}
+	runSuite(c)
+	c.checkRoutes(handler.Endpoints())
+
+	fmt.Fprintf(out, "\n%d calls, %d problems\n", c.calls, len(c.problems))
+	if len(c.problems) > 0 {
+		return fmt.Errorf("contract: %d problems", len(c.problems))
+	}
+	return nil
+}
+
+// checker makes calls and records where they break the spec.
+type checker struct {
+	spec     *Spec
+	base     string
+	http     *http.Client
+	out      io.Writer
+	covered  map[string]bool
+	calls    int
+	problems []string
+}
+
+// rawBody is a request body sent as is and not validated, for calls that
+// are meant to be rejected as malformed.
+type rawBody string
+
+// response is what a call got back; Body is the decoded JSON, if any.
+type response struct {
+	Status int
+	Header http.Header
+	Body   any
+}
+
+// field returns a string field of an object body, or "".
+func (r response) field(name string) string {
+	obj, _ := r.Body.(map[string]any)
+	s, _ := obj[name].(string)
+	return s
+}
+
+// list returns an array field of an object body, or nil.
+func (r response) list(name string) []any {
+	obj, _ := r.Body.(map[string]any)
+	l, _ := obj[name].([]any)
+	return l
+}
+
+// call sends a request to the path template, with {id} filled in by the
+// args in order, and checks it against the spec: the request body, that
+// the status is want and documented, the required headers and the
+// response body's media type and schema.
+func (c *checker) call(want int, method, template, query string, body any, args ...string) response {
+	return c.callWith(nil, want, method, template, query, body, args...)
+}
+
+// callWith is call with extra request headers, such as If-Match.
+func (c *checker) callWith(header http.Header, want int, method, template, query string, body any, args ...string) response {
+	c.calls++
+	name := method + " " + template
+	c.covered[name] = true
+	var problems []string
+	defer func() {
+		if len(problems) == 0 {
+			fmt.Fprintf(c.out, "ok    %s %d\n", name, want)
+			return
+		}
+		fmt.Fprintf(c.out, "FAIL  %s %d\n", name, want)
+		for _, p := range problems {
+			fmt.Fprintf(c.out, "        %s\n", p)
+			c.problems = append(c.problems, name+": "+p)
+		}
+	}()
+
+	op, ok := c.spec.Operation(method, template)
+	if !ok {
+		problems = append(problems, "not in the spec")
+		return response{}
+	}
+
+	var payload []byte
+	switch b := body.(type) {
+	case nil:
+		if op.RequestBody != nil && op.RequestBody.Required {
+			problems = append(problems, "request: the spec requires a body")
+		}
+	case rawBody:
+		payload = []byte(b)
+	default:
+		var err error
+		if payload, err = json.Marshal(b); err != nil {
+			problems = append(problems, "request: "+err.Error())
+			return response{}
+		}
+		problems = append(problems, c.checkRequest(op, payload)...)
+	}
+
+	path := template
+	for _, arg := range args {
+		path = strings.Replace(path, "{id}", arg, 1)
+	}
+	if query != "" {
+		path += "?" + query
+	}
+	var reader io.Reader
+	if payload != nil {
+		reader = bytes.NewReader(payload)
+	}
+	req, err := http.NewRequest(method, c.base+path, reader)
+	if err != nil {
+		problems = append(problems, err.Error())
+		return response{}
+	}
+	req.Header.Set("X-API-Key", adminKey)
+	for key, values := range header {
+		req.Header[key] = values
+	}
+	if payload != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	res, err := c.http.Do(req)
+	if err != nil {
+		problems = append(problems, err.Error())
+		return response{}
+	}
+	defer res.Body.Close()
+	data, err := io.ReadAll(res.Body)
+	if err != nil {
+		problems = append(problems, "read response: "+err.Error())
+	}
+
+	resp := response{Status: res.StatusCode, Header: res.Header}
+	if res.StatusCode != want {
+		problems = append(problems, fmt.Sprintf("status %d, want %d: %s", res.StatusCode, want, bytes.TrimSpace(data)))
+	}
+	documented, ok := op.Responses[strconv.Itoa(res.StatusCode)]
+	if !ok {
+		problems = append(problems, fmt.Sprintf("status %d is not documented", res.StatusCode))
+		return resp
+	}
+	documented = c.spec.response(documented)
+	for key, h := range documented.Headers {
+		if c.spec.header(h).Required && res.Header.Get(key) == "" {
+			problems = append(problems, "missing header "+key)
+		}
+	}
+	var bodyProblems []string
+	resp.Body, bodyProblems = c.checkResponse(method, documented, res.Header.Get("Content-Type"), data)
+	problems = append(problems, bodyProblems...)
+	return resp
+}
+
+func (c *checker) checkRequest(op *Operation, payload []byte) []string {
+	if op.RequestBody == nil {
+		return []string{"request: the spec documents no body"}
+	}
+	media, ok := op.RequestBody.Content["application/json"]
+	if !ok || media.Schema == nil {
+		return nil
+	}
+	var v any
+	if err := json.Unmarshal(payload, &v); err != nil {
+		return []string{"request: " + err.Error()}
+	}
+	var problems []string
+	for _, p := range c.spec.Validate(media.Schema, v) {
+		problems = append(problems, "request "+p)
+	}
+	return problems
+}
+
+func (c *checker) checkResponse(method string, documented Response, contentType string, data []byte) (any, []string) {
+	if len(documented.Content) == 0 || method == http.MethodHead {
+		if len(data) > 0 {
+			return nil, []string{"response has a body the spec doesn't document"}
+		}
+		return nil, nil
+	}
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	media, ok := documented.Content[mediaType]
+	if !ok {
+		var types []string
+		for t := range documented.Content {
+			types = append(types, t)
+		}
+		sort.Strings(types)
+		return nil, []string{fmt.Sprintf("content type %q, want %s", contentType, strings.Join(types, " or "))}
+	}
+	if mediaType != "application/json" || media.Schema == nil {
+		return nil, nil
+	}
+	var v any
+	if err := json.Unmarshal(data, &v); err != nil {
+		return nil, []string{"response: " + err.Error()}
+	}
+	var problems []string
+	for _, p := range c.spec.Validate(media.Schema, v) {
+		problems = append(problems, "response "+p)
+	}
+	return v, problems
+}
+
+// checkRoutes compares the router's routes with the spec. The /admin and
+// /debug routes are operator tools the spec doesn't cover yet.
+func (c *checker) checkRoutes(endpoints []handler.Endpoint) {
+	served := make(map[string]bool)
+	for _, e := range endpoints {
+		if strings.HasPrefix(e.Path, "/admin") || strings.HasPrefix(e.Path, "/debug") {
+			continue
+		}
+		name := e.Method + " " + e.Path
+		served[name] = true
+		if _, ok := c.spec.Operation(e.Method, e.Path); !ok {
+			c.problem(name + ": served but not in the spec")
+		}
+	}
+	for _, name := range c.spec.Operations() {
+		switch {
+		case !served[name]:
+			c.problem(name + ": in the spec but not served")
+		case !c.covered[name]:
+			c.problem(name + ": in the spec but never called by the suite")
+		}
+	}
+}
+
+func (c *checker) problem(p string) {
+	fmt.Fprintf(c.out, "FAIL  %s\n", p)
+	c.problems = append(c.problems, p)
+}
+
+// server is an instance started for the run.
+type server struct {
+	url  string
+	cmd  *exec.Cmd
+	logs *bytes.Buffer
+	dir  string
+}
+
+// ServerEnv is the configuration, as KEY=value entries, an instance
+// needs for the suite: the suite's API keys, the in-memory store and
+// nothing reaching outside, overriding any in the environment. State
+// files go in dir.
+func ServerEnv(dir string) []string {
+	return []string{
+		"CONFIG_FILE=",
+		"AUTH_ENABLED=true",
+		"API_KEYS=" + adminKey + "=*;" + outsiderKey + "=" + auth.ScopeDeleteVote,
+		"API_KEY_SECRETS=",
+		"API_KEY_QUOTAS=",
+		"MTLS_IDENTITIES=",
+		"STORE_PRIMARY=memory",
+		"STORE_SHADOW=",
+		"SECRETS_PROVIDER=",
+		"DISCOVERY_REGISTRY=",
+		"TLS_CERT_FILE=",
+		"IP_ALLOWLIST=",
+		"EMAIL_DOMAIN_ALLOWLIST=",
+		"DELETE_PARTICIPANTS=" + auth.APIKeySubject(adminKey),
+		"CHECK_RATE_LIMIT=10000",
+		"MAINTENANCE_STATE_FILE=" + filepath.Join(dir, "maintenance.json"),
+	}
+}
+
+// startServer runs this binary as a server with ServerEnv and waits until
+// it is healthy.
+func startServer() (*server, error) {
+	exe, err := os.Executable()
+	if err != nil {
+		return nil, err
+	}
+	port, err := freePort()
+	if err != nil {
+		return nil, err
+	}
+	dir, err := os.MkdirTemp("", "contract")
+	if err != nil {
+		return nil, err
+	}
+
+	srv := &server{url: "http://127.0.0.1:" + strconv.Itoa(port), logs: &bytes.Buffer{}, dir: dir}
+	srv.cmd = exec.Command(exe)
+	srv.cmd.Dir = dir
+	srv.cmd.Env = append(append(os.Environ(), ServerEnv(dir)...), "PORT="+strconv.Itoa(port))
+	srv.cmd.Stdout = srv.logs
+	srv.cmd.Stderr = srv.logs
+	if err := srv.cmd.Start(); err != nil {
+		os.RemoveAll(dir)
+		return nil, err
+	}
+
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		res, err := http.Get(srv.url + "/health")
+		if err == nil {
+			res.Body.Close()
+			if res.StatusCode == http.StatusOK {
+				return srv, nil
+			}
+		}
+		if time.Now().After(deadline) {
+			srv.stop()
+			return nil, fmt.Errorf("contract: server didn't become healthy:\n%s", srv.logs)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
+
+func (s *server) stop() {
+	s.cmd.Process.Signal(os.Interrupt)
+	done := make(chan struct{})
+	go func() {
+		s.cmd.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		s.cmd.Process.Kill()
+		<-done
+	}
+	os.RemoveAll(s.dir)
+}
+
+func freePort() (int, error) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return 0, err
+	}
+	defer l.Close()
+	return l.Addr().(*net.TCPAddr).Port, nil
+}
@@ -0,0 +1,265 @@
+package contract
+
+import (
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+)
+
+// openAPI is the API's contract, the document clients are written against.
+//
+//go:embed openapi.json
+var openAPI []byte
+
+// Spec is the part of an OpenAPI 3 document the checks use.
+type Spec struct {
+	Paths      map[string]PathItem `json:"paths"`
+	Components struct {
+		Schemas   map[string]*Schema  `json:"schemas"`
+		Headers   map[string]Header   `json:"headers"`
+		Responses map[string]Response `json:"responses"`
+	} `json:"components"`
+}
+
+// PathItem holds a path's operations by lowercase method, next to the
+// parameters they share.
+type PathItem map[string]json.RawMessage
+
+type Operation struct {
+	OperationID string              `json:"operationId"`
+	RequestBody *RequestBody        `json:"requestBody"`
+	Responses   map[string]Response `json:"responses"`
+}
+
+type RequestBody struct {
+	Required bool                 `json:"required"`
+	Content  map[string]MediaType `json:"content"`
+}
+
+type Response struct {
+	Ref     string               `json:"$ref"`
+	Headers map[string]Header    `json:"headers"`
+	Content map[string]MediaType `json:"content"`
+}
+
+type Header struct {
+	Ref      string  `json:"$ref"`
+	Required bool    `json:"required"`
+	Schema   *Schema `json:"schema"`
+}
+
+type MediaType struct {
+	Schema *Schema `json:"schema"`
+}
+
+// Schema is the subset of the OpenAPI schema object the spec uses.
+type Schema struct {
+	Ref                  string             `json:"$ref"`
+	Type                 string             `json:"type"`
+	Format               string             `json:"format"`
+	Nullable             bool               `json:"nullable"`
+	Enum                 []string           `json:"enum"`
+	Properties           map[string]*Schema `json:"properties"`
+	Required             []string           `json:"required"`
+	AdditionalProperties json.RawMessage    `json:"additionalProperties"`
+	Items                *Schema            `json:"items"`
+	AnyOf                []*Schema          `json:"anyOf"`
+}
+
+// methods are the operation keys a PathItem can hold.
+var methods = []string{"get", "head", "post", "put", "patch", "delete"}
+
+// LoadSpec parses the embedded contract.
+func LoadSpec() (*Spec, error) {
+	var spec Spec
+	if err := json.Unmarshal(openAPI, &spec); err != nil {
+		return nil, fmt.Errorf("openapi.json: %w", err)
+	}
+	return &spec, nil
+}
+
+// Operation returns the operation for the method and path template.
+func (s *Spec) Operation(method, path string) (*Operation, bool) {
+	raw, ok := s.Paths[path][strings.ToLower(method)]
+	if !ok {
+		return nil, false
+	}
+	var op Operation
+	if err := json.Unmarshal(raw, &op); err != nil {
+		return nil, false
+	}
+	return &op, true
+}
+
+// Operations lists every documented method and path template, sorted.
+func (s *Spec) Operations() []string {
+	var ops []string
+	for path, item := range s.Paths {
+		for _, method := range methods {
+			if _, ok := item[method]; ok {
+				ops = append(ops, strings.ToUpper(method)+" "+path)
+			}
+		}
+	}
+	sort.Strings(ops)
+	return ops
+}
+
+func (s *Spec) response(r Response) Response {
+	if name, ok := strings.CutPrefix(r.Ref, "#/components/responses/"); ok {
+		return s.Components.Responses[name]
+	}
+	return r
+}
+
+func (s *Spec) header(h Header) Header {
+	if name, ok := strings.CutPrefix(h.Ref, "#/components/headers/"); ok {
+		return s.Components.Headers[name]
+	}
+	return h
+}
+
+func (s *Spec) schema(sc *Schema) *Schema {
+	if name, ok := strings.CutPrefix(sc.Ref, "#/components/schemas/"); ok {
+		return s.Components.Schemas[name]
+	}
+	return sc
+}
+
+// Validate checks a decoded JSON value against the schema and returns
+// every mismatch, each prefixed with where in the value it is.
+func (s *Spec) Validate(sc *Schema, v any) []string {
+	return s.validate(sc, v, "$")
+}
+
+func (s *Spec) validate(sc *Schema, v any, at string) []string {
+	sc = s.schema(sc)
+	if sc == nil {
+		return []string{at + ": unknown schema"}
+	}
+	if v == nil {
+		if sc.Nullable || sc.Type == "" {
+			return nil
+		}
+		return []string{at + ": null, want " + sc.Type}
+	}
+
+	if len(sc.AnyOf) > 0 {
+		return s.validateAnyOf(sc.AnyOf, v, at)
+	}
+
+	var problems []string
+	switch sc.Type {
+	case "object":
+		obj, ok := v.(map[string]any)
+		if !ok {
+			return []string{fmt.Sprintf("%s: %s, want object", at, kind(v))}
+		}
+		for _, name := range sc.Required {
+			if _, ok := obj[name]; !ok {
+				problems = append(problems, fmt.Sprintf("%s: missing required %q", at, name))
+			}
+		}
+		extra := s.additional(sc)
+		names := make([]string, 0, len(obj))
+		for name := range obj {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			child, ok := sc.Properties[name]
+			switch {
+			case ok:
+				problems = append(problems, s.validate(child, obj[name], at+"."+name)...)
+			case extra == nil && sc.Properties != nil && string(sc.AdditionalProperties) == "false":
+				problems = append(problems, fmt.Sprintf("%s: undocumented property %q", at, name))
+			case extra != nil:
+				problems = append(problems, s.validate(extra, obj[name], at+"."+name)...)
+			}
+		}
+	case "array":
+		list, ok := v.([]any)
+		if !ok {
+			return []string{fmt.Sprintf("%s: %s, want array", at, kind(v))}
+		}
+		if sc.Items != nil {
+			for i, item := range list {
+				problems = append(problems, s.validate(sc.Items, item, fmt.Sprintf("%s[%d]", at, i))...)
+			}
+		}
+	case "string":
+		str, ok := v.(string)
+		if !ok {
+			return []string{fmt.Sprintf("%s: %s, want string", at, kind(v))}
+		}
+		if len(sc.Enum) > 0 && !slices.Contains(sc.Enum, str) {
+			problems = append(problems, fmt.Sprintf("%s: %q is not one of %s", at, str, strings.Join(sc.Enum, ", ")))
+		}
+	case "integer":
+		n, ok := v.(float64)
+		if !ok || n != float64(int64(n)) {
+			return []string{fmt.Sprintf("%s: %s, want integer", at, kind(v))}
+		}
+	case "number":
+		if _, ok := v.(float64); !ok {
+			return []string{fmt.Sprintf("%s: %s, want number", at, kind(v))}
+		}
+	case "boolean":
+		if _, ok := v.(bool); !ok {
+			return []string{fmt.Sprintf("%s: %s, want boolean", at, kind(v))}
+		}
+	}
+	return problems
+}
+
+// validateAnyOf checks that the value matches one of the alternatives.
+// If it matches none, it reports why it missed the closest, preferring
+// alternatives of the value's own type.
+func (s *Spec) validateAnyOf(alternatives []*Schema, v any, at string) []string {
+	var closest []string
+	closestTyped := false
+	for _, alt := range alternatives {
+		problems := s.validate(alt, v, at)
+		if len(problems) == 0 {
+			return nil
+		}
+		typed := s.schema(alt) != nil && s.schema(alt).Type == kind(v)
+		if closest == nil || typed && !closestTyped || typed == closestTyped && len(problems) < len(closest) {
+			closest, closestTyped = problems, typed
+		}
+	}
+	return closest
+}
+
+// additional returns the schema of an object's undeclared properties, or
+// nil when it doesn't give one.
+func (s *Spec) additional(sc *Schema) *Schema {
+	raw := sc.AdditionalProperties
+	if len(raw) == 0 || raw[0] != '{' {
+		return nil
+	}
+	var extra Schema
+	if err := json.Unmarshal(raw, &extra); err != nil {
+		return nil
+	}
+	return &extra
+}
+
+func kind(v any) string {
+	switch v.(type) {
+	case map[string]any:
+		return "object"
+	case []any:
+		return "array"
+	case string:
+		return "string"
+	case float64:
+		return "number"
+	case bool:
+		return "boolean"
+	}
+	return "null"
+}
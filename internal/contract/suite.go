@@ -0,0 +1,165 @@
+package contract
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"user-service/internal/totp"
+)
+
+// runSuite calls every documented operation, for its success response
+// and the errors it should give for missing users, conflicts and bad
+// input. Users it creates get a per-run suffix so it can run against an
+// instance more than once.
+func runSuite(c *checker) {
+	run := strconv.FormatInt(time.Now().UnixNano(), 36)
+	email := func(name string) string { return name + "." + run + "@contract.example.com" }
+	newUser := func(name string, extra map[string]any) string {
+		body := map[string]any{"id": name + "-" + run, "name": name + " " + run, "email": email(name), "password": "contract-" + run}
+		for k, v := range extra {
+			body[k] = v
+		}
+		return c.call(http.StatusCreated, "POST", "/users", "", body).field("id")
+	}
+	missing := "contract-missing-" + run
+
+	c.call(http.StatusOK, "GET", "/health", "", nil)
+	c.call(http.StatusOK, "GET", "/readyz", "", nil)
+
+	// Users.
+	alice := newUser("alice", map[string]any{"phone": "+14155550100"})
+	if id := c.call(http.StatusCreated, "POST", "/users", "", map[string]any{"name": "generated " + run, "email": email("generated")}).field("id"); id == "" {
+		c.problem("POST /users: no ID generated for a user without one")
+	}
+	c.call(http.StatusConflict, "POST", "/users", "", map[string]any{"id": "alice-again-" + run, "name": "Alice again", "email": email("alice")})
+	c.call(http.StatusBadRequest, "POST", "/users", "", rawBody(`{"name":`))
+	c.call(http.StatusUnprocessableEntity, "POST", "/users", "", rawBody(`{"id":"`+missing+`","name":"","email":"not-an-email"}`))
+	c.call(http.StatusOK, "GET", "/users/{id}", "", nil, alice)
+	c.call(http.StatusNotFound, "GET", "/users/{id}", "", nil, missing)
+	version := c.call(http.StatusOK, "GET", "/users/{id}/poll", "", nil, alice).Header.Get("X-User-Version")
+	c.call(http.StatusOK, "GET", "/users/{id}/poll", "version="+version+"&timeout=10ms", nil, alice)
+	c.call(http.StatusBadRequest, "GET", "/users/{id}/poll", "timeout=soon", nil, alice)
+	c.call(http.StatusNotFound, "GET", "/users/{id}/poll", "", nil, missing)
+	c.call(http.StatusOK, "HEAD", "/users/{id}", "", nil, alice)
+	c.call(http.StatusNotFound, "HEAD", "/users/{id}", "", nil, missing)
+	c.call(http.StatusOK, "PUT", "/users/{id}", "", map[string]any{"id": alice, "name": "Alice " + run, "email": email("alice"), "phone": "+14155550100"}, alice)
+	c.call(http.StatusNotFound, "PUT", "/users/{id}", "", map[string]any{"id": missing, "name": "Nobody", "email": email("nobody")}, missing)
+	upserted := "upserted-" + run
+	c.call(http.StatusCreated, "PUT", "/users/{id}", "upsert=true", map[string]any{"id": upserted, "name": "Upserted " + run, "email": email("upserted")}, upserted)
+	dry := "dry-" + run
+	c.call(http.StatusCreated, "POST", "/users", "dry_run=true", map[string]any{"id": dry, "name": "Dry " + run, "email": email("dry")})
+	c.call(http.StatusNotFound, "GET", "/users/{id}", "", nil, dry)
+	c.call(http.StatusBadRequest, "POST", "/users", "dry_run=maybe", map[string]any{"id": dry, "name": "Dry " + run, "email": email("dry")})
+	c.call(http.StatusNoContent, "DELETE", "/users/{id}", "dry_run=true", nil, upserted)
+	c.call(http.StatusOK, "HEAD", "/users/{id}", "", nil, upserted)
+	c.call(http.StatusOK, "GET", "/users", "limit=5", nil)
+	c.call(http.StatusOK, "GET", "/users", "limit=5&fields=id,name", nil)
+	c.call(http.StatusOK, "GET", "/users", "ids="+alice+","+missing, nil)
+	c.call(http.StatusBadRequest, "GET", "/users", "limit=lots", nil)
+	c.call(http.StatusOK, "POST", "/users/batch-get", "", map[string]any{"ids": []string{alice, missing}})
+	c.call(http.StatusOK, "GET", "/users/check", "email="+email("alice")+"&name=Free+"+run, nil)
+	c.call(http.StatusOK, "GET", "/users/changes", "limit=5", nil)
+	c.call(http.StatusBadRequest, "GET", "/users/changes", "limit=lots", nil)
+
+	// Login, lifecycle and history.
+	login := map[string]any{"email": email("alice"), "password": "contract-" + run}
+	c.call(http.StatusOK, "POST", "/login", "", login)
+	c.call(http.StatusUnauthorized, "POST", "/login", "", map[string]any{"email": email("alice"), "password": "wrong"})
+	c.call(http.StatusBadRequest, "POST", "/login", "", map[string]any{"email": email("alice"), "password": ""})
+	c.call(http.StatusOK, "POST", "/users/{id}/suspend", "", nil, alice)
+	c.call(http.StatusConflict, "POST", "/users/{id}/suspend", "", nil, alice)
+	c.call(http.StatusForbidden, "POST", "/login", "", login)
+	c.call(http.StatusOK, "POST", "/users/{id}/activate", "", nil, alice)
+	c.call(http.StatusOK, "POST", "/users/{id}/lock", "", nil, alice)
+	c.call(http.StatusOK, "POST", "/users/{id}/activate", "", nil, alice)
+	c.call(http.StatusNotFound, "POST", "/users/{id}/lock", "", nil, missing)
+	c.call(http.StatusOK, "GET", "/users/{id}/login-history", "", nil, alice)
+	c.call(http.StatusNotFound, "GET", "/users/{id}/login-history", "", nil, missing)
+	c.call(http.StatusOK, "GET", "/users/{id}/data-export", "", nil, alice)
+	c.call(http.StatusNotFound, "GET", "/users/{id}/data-export", "", nil, missing)
+
+	// Phone verification. The code itself only reaches the phone.
+	c.call(http.StatusAccepted, "POST", "/users/{id}/phone/verify", "", nil, alice)
+	c.call(http.StatusTooManyRequests, "POST", "/users/{id}/phone/verify", "", nil, alice)
+	c.call(http.StatusUnauthorized, "POST", "/users/{id}/phone/verify", "", map[string]any{"code": "000000"}, alice)
+
+	// Two-factor authentication, then a login through it.
+	c.call(http.StatusConflict, "POST", "/users/{id}/2fa/verify", "", map[string]any{"code": "000000"}, alice)
+	setup := c.call(http.StatusOK, "POST", "/users/{id}/2fa/setup", "", nil, alice)
+	c.call(http.StatusUnauthorized, "POST", "/users/{id}/2fa/verify", "", map[string]any{"code": "000000"}, alice)
+	if code, err := totp.Code(setup.field("secret"), time.Now().Unix()/30); err == nil {
+		c.call(http.StatusNoContent, "POST", "/users/{id}/2fa/verify", "", map[string]any{"code": code}, alice)
+	} else {
+		c.problem(fmt.Sprintf("POST /users/{id}/2fa/verify: no TOTP code from the setup secret: %v", err))
+	}
+	c.call(http.StatusConflict, "POST", "/users/{id}/2fa/setup", "", nil, alice)
+	mfa := c.call(http.StatusOK, "POST", "/login", "", login).field("mfa_token")
+	c.call(http.StatusUnauthorized, "POST", "/login/2fa", "", map[string]any{"mfa_token": mfa, "code": "000000"})
+	c.call(http.StatusUnauthorized, "POST", "/login/2fa", "", map[string]any{"mfa_token": "not-a-token", "code": "000000"})
+	if codes := setup.list("recovery_codes"); len(codes) > 0 {
+		c.call(http.StatusOK, "POST", "/login/2fa", "", map[string]any{"mfa_token": mfa, "code": codes[0]})
+	} else {
+		c.problem("POST /login/2fa: setup returned no recovery codes")
+	}
+
+	// Preferences. The suite only relies on keys no schema has, so it
+	// passes whatever PREFERENCES the instance runs with.
+	etag := c.call(http.StatusOK, "GET", "/users/{id}/preferences", "", nil, alice).Header.Get("ETag")
+	c.callWith(http.Header{"If-None-Match": {etag}}, http.StatusNotModified, "GET", "/users/{id}/preferences", "", nil, alice)
+	c.call(http.StatusNotFound, "GET", "/users/{id}/preferences", "", nil, missing)
+	c.callWith(http.Header{"If-Match": {`"stale"`}}, http.StatusPreconditionFailed, "PUT", "/users/{id}/preferences", "", map[string]any{}, alice)
+	c.callWith(http.Header{"If-Match": {etag}}, http.StatusOK, "PUT", "/users/{id}/preferences", "", map[string]any{}, alice)
+	c.call(http.StatusUnprocessableEntity, "PUT", "/users/{id}/preferences", "", map[string]any{"contract_" + run: true}, alice)
+	c.call(http.StatusBadRequest, "PUT", "/users/{id}/preferences", "", rawBody(`{"theme":`), alice)
+	c.call(http.StatusNotFound, "PUT", "/users/{id}/preferences", "", map[string]any{}, missing)
+
+	// Custom field schema.
+	c.call(http.StatusOK, "GET", "/schema", "", nil)
+	c.call(http.StatusOK, "PUT", "/schema", "", map[string]any{"fields": []map[string]any{{"name": "department", "type": "string"}}})
+	newUser("carol", map[string]any{"custom_fields": map[string]any{"department": "sales"}})
+	c.call(http.StatusUnprocessableEntity, "PUT", "/schema", "", rawBody(`{"fields":[{"name":"level","type":"color"}]}`))
+	c.call(http.StatusNoContent, "DELETE", "/schema", "", nil)
+
+	// Merging.
+	dup := newUser("alice-dup", nil)
+	c.call(http.StatusBadRequest, "POST", "/users/{id}/merge", "", map[string]any{"source_id": alice}, alice)
+	c.call(http.StatusNotFound, "POST", "/users/{id}/merge", "", map[string]any{"source_id": missing}, alice)
+	c.call(http.StatusUnprocessableEntity, "POST", "/users/{id}/merge", "", rawBody(`{}`), alice)
+	c.call(http.StatusOK, "POST", "/users/{id}/merge", "", map[string]any{"source_id": dup}, alice)
+	c.call(http.StatusConflict, "POST", "/users/{id}/merge", "", map[string]any{"source_id": dup}, alice)
+	merged := c.call(http.StatusOK, "GET", "/users/{id}", "", nil, dup)
+	if merged.Header.Get("Content-Location") == "" {
+		c.problem("GET /users/{id}: no Content-Location for a merged user")
+	}
+
+	// Two-phase deletes. The suite runs with orders as the only participant.
+	bob := newUser("bob", nil)
+	pending := c.call(http.StatusAccepted, "POST", "/users/{id}/delete-requests", "", nil, bob).field("id")
+	c.call(http.StatusConflict, "POST", "/users/{id}/delete-requests", "", nil, bob)
+	c.call(http.StatusNotFound, "POST", "/users/{id}/delete-requests", "", nil, missing)
+	c.call(http.StatusOK, "GET", "/delete-requests/{id}", "", nil, pending)
+	c.call(http.StatusNotFound, "GET", "/delete-requests/{id}", "", nil, missing)
+	c.callWith(http.Header{"X-Api-Key": {outsiderKey}}, http.StatusForbidden, "POST", "/delete-requests/{id}/reject", "", map[string]any{"reason": "not mine"}, pending)
+	c.call(http.StatusOK, "POST", "/delete-requests/{id}/reject", "", map[string]any{"reason": "open orders"}, pending)
+	c.call(http.StatusConflict, "POST", "/delete-requests/{id}/confirm", "", map[string]any{}, pending)
+	c.call(http.StatusNotFound, "POST", "/delete-requests/{id}/confirm", "", map[string]any{}, missing)
+	again := c.call(http.StatusAccepted, "POST", "/users/{id}/delete-requests", "", nil, bob).field("id")
+	c.call(http.StatusOK, "POST", "/delete-requests/{id}/confirm", "", map[string]any{}, again)
+	c.call(http.StatusNotFound, "GET", "/users/{id}", "", nil, bob)
+
+	// Deleting and forgetting.
+	dave := newUser("dave", nil)
+	c.call(http.StatusNoContent, "DELETE", "/users/{id}", "", nil, dave)
+	c.call(http.StatusNotFound, "DELETE", "/users/{id}", "", nil, dave)
+	erin := newUser("erin", nil)
+	c.call(http.StatusNoContent, "POST", "/users/{id}/forget", "", nil, erin)
+	c.call(http.StatusNotFound, "POST", "/users/{id}/forget", "", nil, erin)
+
+	// Stats.
+	if days := c.call(http.StatusOK, "GET", "/stats", "days=7", nil).list("created_per_day"); len(days) != 7 {
+		c.problem(fmt.Sprintf("GET /stats: %d days of creations for days=7", len(days)))
+	}
+	c.call(http.StatusBadRequest, "GET", "/stats", "days=0", nil)
+}
@@ -0,0 +1,115 @@
+package discovery
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"user-service/internal/config"
+)
+
+// ConsulConfig says how to reach the local Consul agent. The variable
+// names are the ones the consul CLI reads.
+type ConsulConfig struct {
+	Addr  string
+	Token string
+}
+
+func LoadConsulConfig(src *config.Source) ConsulConfig {
+	return ConsulConfig{
+		Addr:  src.String("CONSUL_HTTP_ADDR", "http://127.0.0.1:8500"),
+		Token: src.String("CONSUL_HTTP_TOKEN", ""),
+	}
+}
+
+// Consul registers services with a Consul agent over its HTTP API.
+type Consul struct {
+	addr   string
+	token  string
+	client *http.Client
+}
+
+func NewConsul(cfg ConsulConfig) *Consul {
+	addr := strings.TrimRight(cfg.Addr, "/")
+	if !strings.Contains(addr, "://") {
+		addr = "http://" + addr
+	}
+	return &Consul{addr: addr, token: cfg.Token, client: &http.Client{Timeout: 5 * time.Second}}
+}
+
+type consulCheck struct {
+	HTTP                           string
+	Interval                       string
+	Timeout                        string
+	DeregisterCriticalServiceAfter string
+	TLSSkipVerify                  bool
+}
+
+type consulService struct {
+	ID      string
+	Name    string
+	Address string
+	Port    int
+	Tags    []string
+	Meta    map[string]string
+	Check   consulCheck
+}
+
+func (c *Consul) put(ctx context.Context, path string, body any) error {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(data)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, c.addr+path, reader)
+	if err != nil {
+		return err
+	}
+	if c.token != "" {
+		req.Header.Set("X-Consul-Token", c.token)
+	}
+	res, err := c.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("consul: %w", err)
+	}
+	defer res.Body.Close()
+	if res.StatusCode >= 300 {
+		msg, _ := io.ReadAll(io.LimitReader(res.Body, 512))
+		return fmt.Errorf("consul: PUT %s: %s: %s", path, res.Status, strings.TrimSpace(string(msg)))
+	}
+	return nil
+}
+
+// Register adds the service with an HTTP health check. The check skips
+// certificate verification: it runs against the instance's address,
+// which its certificate usually doesn't name.
+func (c *Consul) Register(ctx context.Context, reg Registration) error {
+	return c.put(ctx, "/v1/agent/service/register", consulService{
+		ID:      reg.ID,
+		Name:    reg.Name,
+		Address: reg.Address,
+		Port:    reg.Port,
+		Tags:    reg.Tags,
+		Meta:    reg.Meta,
+		Check: consulCheck{
+			HTTP:                           reg.HealthURL,
+			Interval:                       reg.CheckInterval.String(),
+			Timeout:                        "5s",
+			DeregisterCriticalServiceAfter: reg.DeregisterAfter.String(),
+			TLSSkipVerify:                  strings.HasPrefix(reg.HealthURL, "https://"),
+		},
+	})
+}
+
+func (c *Consul) Deregister(ctx context.Context, id string) error {
+	return c.put(ctx, "/v1/agent/service/deregister/"+url.PathEscape(id), nil)
+}
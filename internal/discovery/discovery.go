@@ -0,0 +1,119 @@
+// Package discovery registers the service with a service registry, such
+// as Consul, so other services can find it without hard-coded hosts.
+package discovery
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"user-service/internal/config"
+)
+
+// Registration describes one instance of the service. The registry
+// polls HealthURL and stops handing out the instance while it fails.
+type Registration struct {
+	ID        string
+	Name      string
+	Address   string
+	Port      int
+	Tags      []string
+	Meta      map[string]string
+	HealthURL string
+	// CheckInterval is how often HealthURL is polled, and DeregisterAfter
+	// how long it may fail before the registry drops the instance, which
+	// cleans up after a crash that skipped Deregister.
+	CheckInterval   time.Duration
+	DeregisterAfter time.Duration
+}
+
+// Registry is a service registry.
+type Registry interface {
+	// Register adds or replaces the instance with reg.ID.
+	Register(ctx context.Context, reg Registration) error
+	Deregister(ctx context.Context, id string) error
+}
+
+// Load returns the registry named by DISCOVERY_REGISTRY, or nil when none
+// is configured.
+func Load(src *config.Source) (Registry, error) {
+	switch name := src.String("DISCOVERY_REGISTRY", ""); name {
+	case "":
+		return nil, nil
+	case "consul":
+		return NewConsul(LoadConsulConfig(src)), nil
+	default:
+		return nil, fmt.Errorf("unknown DISCOVERY_REGISTRY %q", name)
+	}
+}
+
+// LoadRegistration describes this instance, listening on port. The
+// address defaults to the hostname, and the ID to name-address-port so
+// that instances on one host don't replace each other.
+func LoadRegistration(src *config.Source, port int, tls bool) Registration {
+	address := src.String("SERVICE_ADDRESS", "")
+	if address == "" {
+		address, _ = os.Hostname()
+	}
+	name := src.String("SERVICE_NAME", "user-service")
+	scheme := "http"
+	if tls {
+		scheme = "https"
+	}
+
+	reg := Registration{
+		ID:              src.String("SERVICE_ID", name+"-"+address+"-"+strconv.Itoa(port)),
+		Name:            name,
+		Address:         address,
+		Port:            port,
+		Tags:            []string{},
+		Meta:            map[string]string{"scheme": scheme},
+		HealthURL:       fmt.Sprintf("%s://%s:%d/health", scheme, address, port),
+		CheckInterval:   src.Duration("SERVICE_CHECK_INTERVAL", 10*time.Second),
+		DeregisterAfter: src.Duration("SERVICE_DEREGISTER_AFTER", time.Minute),
+	}
+	for _, tag := range strings.Split(src.String("SERVICE_TAGS", ""), ",") {
+		if tag = strings.TrimSpace(tag); tag != "" {
+			reg.Tags = append(reg.Tags, tag)
+		}
+	}
+	for _, pair := range strings.Split(src.String("SERVICE_META", ""), ",") {
+		key, value, ok := strings.Cut(pair, "=")
+		if key = strings.TrimSpace(key); !ok || key == "" {
+			if strings.TrimSpace(pair) != "" {
+				log.Printf("config: ignoring SERVICE_META entry %q, want key=value", pair)
+			}
+			continue
+		}
+		reg.Meta[key] = strings.TrimSpace(value)
+	}
+	return reg
+}
+
+// Keep registers reg now and again every interval until ctx is done, so
+// the instance comes back after the registry loses it, for example when
+// a Consul agent restarts. Failures are logged and retried next time.
+func Keep(ctx context.Context, registry Registry, reg Registration, interval time.Duration) {
+	register := func() {
+		callCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
+		defer cancel()
+		if err := registry.Register(callCtx, reg); err != nil {
+			log.Printf("discovery: register %s: %v", reg.ID, err)
+		}
+	}
+	register()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			register()
+		}
+	}
+}
@@ -0,0 +1,218 @@
+// Package fault injects latency, errors and dropped responses into a
+// share of requests and store calls, so consumers can test their retries
+// and timeouts against a staging instance.
+package fault
+
+import (
+	"context"
+	"errors"
+	"expvar"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"user-service/internal/config"
+)
+
+// Faults a rule can inject.
+const (
+	// Latency delays the call by LatencyMS.
+	Latency = "latency"
+	// Error fails the call: a request is answered with Status, 503 by
+	// default, and a store call returns ErrInjected.
+	Error = "error"
+	// Drop closes a request's connection without a response. It only
+	// applies to routes.
+	Drop = "drop"
+)
+
+// StorePrefix starts the target of a rule for store calls, as in
+// store.Get or store.* for every call.
+const StorePrefix = "store."
+
+var faultMetrics = expvar.NewMap("faults")
+
+// ErrInjected is what a store call fails with under an error fault. It
+// counts as a backend failure, so enough of them open the circuit
+// breaker.
+var ErrInjected = errors.New("injected store fault")
+
+// Rule injects a fault into a share of the calls to its target: a route
+// as METHOD /path/{template}, where * matches any method, or a store call
+// as store.Method, where store.* matches any call.
+type Rule struct {
+	Target      string  `json:"target"`
+	Fault       string  `json:"fault"`
+	Probability float64 `json:"probability"`
+	LatencyMS   int     `json:"latency_ms,omitempty"`
+	Status      int     `json:"status,omitempty"`
+}
+
+func (r Rule) check() error {
+	store := strings.HasPrefix(r.Target, StorePrefix)
+	if method, path, ok := strings.Cut(r.Target, " "); !store && (!ok || method == "" || !strings.HasPrefix(path, "/")) {
+		return fmt.Errorf("target %q is neither METHOD /path nor store.Method", r.Target)
+	}
+	if r.Probability <= 0 || r.Probability > 1 {
+		return fmt.Errorf("%s: probability must be within 0..1", r.Target)
+	}
+	switch r.Fault {
+	case Latency:
+		if r.LatencyMS <= 0 {
+			return fmt.Errorf("%s: latency_ms must be positive", r.Target)
+		}
+	case Error:
+		if r.Status != 0 && (r.Status < 400 || r.Status > 599) {
+			return fmt.Errorf("%s: status must be an error status", r.Target)
+		}
+	case Drop:
+		if store {
+			return fmt.Errorf("%s: store calls can't be dropped", r.Target)
+		}
+	default:
+		return fmt.Errorf("%s: unknown fault %q", r.Target, r.Fault)
+	}
+	return nil
+}
+
+// matches reports whether the rule targets the route or store call.
+func (r Rule) matches(target string) bool {
+	if r.Target == target || r.Target == StorePrefix+"*" && strings.HasPrefix(target, StorePrefix) {
+		return true
+	}
+	method, path, _ := strings.Cut(r.Target, " ")
+	_, targetPath, _ := strings.Cut(target, " ")
+	return method == "*" && path == targetPath
+}
+
+// Load reads FAULT_RULES, semicolon-separated target=fault:probability
+// entries with the latency or status last, such as
+// "GET /users/{id}=latency:0.2:500ms;POST /users=error:0.1:500;store.Get=error:0.05".
+func Load(src *config.Source) ([]Rule, error) {
+	var rules []Rule
+	for _, entry := range strings.Split(src.String("FAULT_RULES", ""), ";") {
+		if entry = strings.TrimSpace(entry); entry == "" {
+			continue
+		}
+		target, spec, _ := strings.Cut(entry, "=")
+		parts := strings.Split(spec, ":")
+		rule := Rule{Target: strings.TrimSpace(target), Fault: parts[0]}
+		var err error
+		if len(parts) < 2 || len(parts) > 3 {
+			err = fmt.Errorf("want fault:probability[:latency or status]")
+		} else if rule.Probability, err = strconv.ParseFloat(parts[1], 64); err == nil && len(parts) == 3 {
+			if rule.Fault == Latency {
+				var d time.Duration
+				d, err = time.ParseDuration(parts[2])
+				rule.LatencyMS = int(d.Milliseconds())
+			} else {
+				rule.Status, err = strconv.Atoi(parts[2])
+			}
+		}
+		if err == nil {
+			err = rule.check()
+		}
+		if err != nil {
+			return nil, fmt.Errorf("FAULT_RULES: invalid entry %q: %v", entry, err)
+		}
+		rules = append(rules, rule)
+	}
+	return rules, nil
+}
+
+// Injector holds the rules in force, which can be swapped at runtime.
+type Injector struct {
+	rules atomic.Pointer[[]Rule]
+}
+
+func NewInjector(rules []Rule) (*Injector, error) {
+	i := &Injector{}
+	if err := i.SetRules(rules); err != nil {
+		return nil, err
+	}
+	return i, nil
+}
+
+// SetRules replaces the rules, unless one of them is invalid.
+func (i *Injector) SetRules(rules []Rule) error {
+	for _, rule := range rules {
+		if err := rule.check(); err != nil {
+			return err
+		}
+	}
+	rules = append([]Rule{}, rules...)
+	i.rules.Store(&rules)
+	return nil
+}
+
+func (i *Injector) Rules() []Rule {
+	return *i.rules.Load()
+}
+
+// pick rolls the dice for each rule matching the target, in order, and
+// returns the first that fires.
+func (i *Injector) pick(target string) (Rule, bool) {
+	for _, rule := range *i.rules.Load() {
+		if rule.matches(target) && rand.Float64() < rule.Probability {
+			faultMetrics.Add(rule.Fault, 1)
+			return rule, true
+		}
+	}
+	return Rule{}, false
+}
+
+// sleep waits out a latency fault, or until the context ends.
+func sleep(ctx context.Context, rule Rule) error {
+	timer := time.NewTimer(time.Duration(rule.LatencyMS) * time.Millisecond)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// Store applies any fault for a call to the store method, returning the
+// error the call should fail with.
+func (i *Injector) Store(ctx context.Context, method string) error {
+	rule, ok := i.pick(StorePrefix + method)
+	switch {
+	case !ok:
+		return nil
+	case rule.Fault == Latency:
+		return sleep(ctx, rule)
+	default:
+		return ErrInjected
+	}
+}
+
+// Middleware applies any fault for the route, named by its method and
+// path template, to each request. Error faults are answered by fail.
+func (i *Injector) Middleware(route string, fail func(w http.ResponseWriter, r *http.Request, status int), next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rule, ok := i.pick(route)
+		switch {
+		case !ok:
+		case rule.Fault == Latency:
+			if sleep(r.Context(), rule) != nil {
+				return
+			}
+		case rule.Fault == Drop:
+			// The server closes the connection without writing anything.
+			panic(http.ErrAbortHandler)
+		default:
+			status := rule.Status
+			if status == 0 {
+				status = http.StatusServiceUnavailable
+			}
+			fail(w, r, status)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,227 @@
+(function () {
+  "use strict";
+
+  var api = document.body.dataset.api;
+  var authEnabled = document.body.dataset.auth === "true";
+  var pageSize = 20;
+  var state = { offset: 0, total: 0, q: "", status: "", editing: null };
+
+  function $(id) { return document.getElementById(id); }
+
+  function credentials() {
+    try { return JSON.parse(sessionStorage.getItem("admin-credentials")) || null; } catch (e) { return null; }
+  }
+
+  // request calls an enveloped /v2 endpoint.
+  function request(method, path, body) {
+    return call(method, api + path, body);
+  }
+
+  function call(method, url, body) {
+    var headers = { "Accept": "application/json" };
+    var cred = credentials();
+    if (cred && cred.kind === "apikey") headers["X-API-Key"] = cred.secret;
+    if (cred && cred.kind === "token") headers["Authorization"] = "Bearer " + cred.secret;
+    if (body !== undefined) headers["Content-Type"] = "application/json";
+    return fetch(url, {
+      method: method,
+      headers: headers,
+      body: body === undefined ? undefined : JSON.stringify(body)
+    }).then(function (resp) {
+      if (resp.status === 401) $("credentials").hidden = false;
+      if (!resp.ok) {
+        return resp.text().then(function (text) { throw new Error(errorMessage(resp, text) || resp.statusText); });
+      }
+      if (resp.status === 204) return null;
+      return resp.json();
+    });
+  }
+
+  // errorMessage joins the messages of a 422's field errors; other errors
+  // are plain text.
+  function errorMessage(resp, text) {
+    if (resp.status === 422 && (resp.headers.get("Content-Type") || "").indexOf("application/json") === 0) {
+      try {
+        return JSON.parse(text).map(function (e) { return e.message; }).join("; ");
+      } catch (e) { /* fall through to the raw text */ }
+    }
+    return text.trim();
+  }
+
+  function say(text, isError) {
+    var el = $("message");
+    el.textContent = text;
+    el.className = isError ? "error" : "";
+  }
+
+  function cell(row, text, className) {
+    var td = document.createElement("td");
+    td.textContent = text;
+    if (className) td.className = className;
+    row.appendChild(td);
+    return td;
+  }
+
+  function action(td, label, fn) {
+    var button = document.createElement("button");
+    button.type = "button";
+    button.textContent = label;
+    button.addEventListener("click", fn);
+    td.appendChild(button);
+  }
+
+  function loadUsers() {
+    var query = "?limit=" + pageSize + "&offset=" + state.offset;
+    if (state.q) query += "&q=" + encodeURIComponent(state.q);
+    if (state.status) query += "&status=" + encodeURIComponent(state.status);
+    return request("GET", "/users" + query).then(function (body) {
+      var tbody = $("users");
+      tbody.textContent = "";
+      body.data.forEach(function (user) {
+        var row = document.createElement("tr");
+        cell(row, user.id);
+        cell(row, user.name);
+        cell(row, user.email);
+        cell(row, user.status, "status-" + user.status);
+        var td = cell(row, "", "actions");
+        action(td, "Edit", function () { edit(user); });
+        if (user.status === "active") {
+          action(td, "Suspend", function () { transition(user.id, "suspend"); });
+        } else {
+          action(td, "Restore", function () { transition(user.id, "activate"); });
+        }
+        action(td, "Delete", function () { remove(user.id); });
+        tbody.appendChild(row);
+      });
+      var page = body.meta.pagination || { offset: 0, total: body.data.length };
+      state.total = page.total;
+      var last = Math.min(page.offset + body.data.length, page.total);
+      $("page-info").textContent = page.total ? (page.offset + 1) + "–" + last + " of " + page.total : "No users";
+      $("prev").disabled = state.offset === 0;
+      $("next").disabled = state.offset + pageSize >= state.total;
+    }).catch(function (err) { say(err.message, true); });
+  }
+
+  function edit(user) {
+    var form = $("user-form");
+    state.editing = user.id;
+    form.elements.id.value = user.id;
+    form.elements.id.readOnly = true;
+    form.elements.name.value = user.name;
+    form.elements.email.value = user.email;
+    form.elements.password.value = "";
+    $("form-title").textContent = "Edit " + user.id;
+  }
+
+  function resetForm() {
+    state.editing = null;
+    $("user-form").elements.id.readOnly = false;
+    $("form-title").textContent = "New user";
+  }
+
+  function transition(id, verb) {
+    request("POST", "/users/" + encodeURIComponent(id) + "/" + verb).then(function () {
+      say("User " + id + (verb === "suspend" ? " suspended" : " restored"));
+      loadUsers();
+    }).catch(function (err) { say(err.message, true); });
+  }
+
+  function remove(id) {
+    if (!window.confirm("Delete user " + id + "?")) return;
+    request("DELETE", "/users/" + encodeURIComponent(id)).then(function () {
+      say("User " + id + " deleted");
+      if (state.editing === id) $("user-form").reset();
+      loadUsers();
+    }).catch(function (err) { say(err.message, true); });
+  }
+
+  function save(event) {
+    event.preventDefault();
+    var form = event.target;
+    var user = { name: form.elements.name.value, email: form.elements.email.value };
+    if (state.editing && user.email.indexOf("***") !== -1) {
+      say("Emails are masked without users:read_pii; saving would overwrite this one", true);
+      return;
+    }
+    if (form.elements.password.value) user.password = form.elements.password.value;
+    var done;
+    if (state.editing) {
+      done = request("PUT", "/users/" + encodeURIComponent(state.editing), user);
+    } else {
+      user.id = form.elements.id.value;
+      done = request("POST", "/users", user);
+    }
+    done.then(function (body) {
+      say((state.editing ? "Updated " : "Created ") + body.data.id);
+      form.reset();
+      loadUsers();
+    }).catch(function (err) { say(err.message, true); });
+  }
+
+  function loadHealth() {
+    var badge = $("health");
+    Promise.all([request("GET", "/health"), request("GET", "/readyz").catch(function (err) { return { error: err.message }; })])
+      .then(function (results) {
+        var ready = !results[1].error;
+        badge.textContent = results[0].data.status + (ready ? ", ready" : ", not ready: " + results[1].error);
+        badge.className = "badge " + (ready ? "ok" : "bad");
+      })
+      .catch(function (err) {
+        badge.textContent = "unreachable: " + err.message;
+        badge.className = "badge bad";
+      });
+  }
+
+  function loadMetrics() {
+    var dl = $("metrics");
+    // expvar's handler writes its own format, outside the /v2 envelope.
+    call("GET", "/debug/vars").then(function (vars) {
+      var shown = {
+        "heap": vars.memstats && (vars.memstats.HeapAlloc / 1048576).toFixed(1) + " MiB",
+        "store breaker": vars.store_breaker,
+        "read coalescing": vars.store_coalesce,
+        "user hooks": vars.user_hooks
+      };
+      dl.textContent = "";
+      Object.keys(shown).forEach(function (name) {
+        if (shown[name] === undefined) return;
+        var dt = document.createElement("dt");
+        dt.textContent = name;
+        var dd = document.createElement("dd");
+        dd.textContent = typeof shown[name] === "object" ? JSON.stringify(shown[name]) : String(shown[name]);
+        dl.appendChild(dt);
+        dl.appendChild(dd);
+      });
+    }).catch(function (err) { dl.textContent = err.message; });
+  }
+
+  function refresh() {
+    loadHealth();
+    loadUsers();
+    loadMetrics();
+  }
+
+  $("credentials-form").addEventListener("submit", function (event) {
+    event.preventDefault();
+    var form = event.target;
+    sessionStorage.setItem("admin-credentials", JSON.stringify({ kind: form.elements.kind.value, secret: form.elements.secret.value }));
+    form.elements.secret.value = "";
+    $("credentials").hidden = true;
+    refresh();
+  });
+  $("search").addEventListener("submit", function (event) {
+    event.preventDefault();
+    state.q = event.target.elements.q.value;
+    state.status = event.target.elements.status.value;
+    state.offset = 0;
+    loadUsers();
+  });
+  $("prev").addEventListener("click", function () { state.offset = Math.max(0, state.offset - pageSize); loadUsers(); });
+  $("next").addEventListener("click", function () { state.offset += pageSize; loadUsers(); });
+  $("user-form").addEventListener("submit", save);
+  $("user-form").addEventListener("reset", resetForm);
+
+  if (authEnabled && !credentials()) $("credentials").hidden = false;
+  refresh();
+  setInterval(function () { loadHealth(); loadMetrics(); }, 10000);
+})();
@@ -0,0 +1,83 @@
+package handler
+
+import (
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"time"
+
+	"user-service/internal/service"
+	"user-service/internal/store"
+)
+
+// backup streams the backup one user at a time. It is a file rather
+// than API data, so /v2 returns it unwrapped too.
+func (h *Handler) backup(w http.ResponseWriter, r *http.Request) {
+	backup, err := h.users.Backup(r.Context())
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	name := "users-backup-" + backup.CreatedAt.Format("20060102T150405Z") + ".json"
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Disposition", `attachment; filename="`+name+`"`)
+	w.WriteHeader(http.StatusOK)
+	if err := writeBackup(w, backup); err != nil {
+		// The status is already sent; the client sees a truncated file.
+		log.Printf("backup: %v", err)
+	}
+}
+
+// writeBackup writes the same JSON as encoding the Backup, without
+// holding all of it in one buffer.
+func writeBackup(w io.Writer, backup service.Backup) error {
+	header, err := json.Marshal(struct {
+		Version   int       `json:"version"`
+		CreatedAt time.Time `json:"created_at"`
+	}{backup.Version, backup.CreatedAt})
+	if err != nil {
+		return err
+	}
+	if _, err := io.WriteString(w, string(header[:len(header)-1])+`,"users":[`); err != nil {
+		return err
+	}
+	for i, rec := range backup.Users {
+		data, err := json.Marshal(rec)
+		if err != nil {
+			return err
+		}
+		if i > 0 {
+			data = append([]byte{','}, data...)
+		}
+		if _, err := w.Write(append(data, '\n')); err != nil {
+			return err
+		}
+	}
+	_, err = io.WriteString(w, "]}\n")
+	return err
+}
+
+// restore loads a backup from the request body. ?mode= picks how users
+// that already exist are handled and defaults to skipping them.
+func (h *Handler) restore(w http.ResponseWriter, r *http.Request) {
+	mode := r.URL.Query().Get("mode")
+	if mode == "" {
+		mode = store.RestoreSkip
+	}
+
+	var backup service.Backup
+	if err := json.NewDecoder(r.Body).Decode(&backup); err != nil {
+		writeDecodeError(w, r, err)
+		return
+	}
+
+	result, err := h.users.Restore(r.Context(), backup, mode)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	writeJSON(w, r, http.StatusOK, result)
+}
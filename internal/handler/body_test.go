@@ -0,0 +1,35 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"user-service/internal/auth"
+)
+
+func TestLimitBody(t *testing.T) {
+	h := New(Options{Auth: auth.NewAuthenticator(auth.Config{MaxBodyBytes: 16}, nil), MaxRestoreBytes: 64})
+	decode := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var v any
+		if err := json.NewDecoder(r.Body).Decode(&v); err != nil {
+			writeDecodeError(w, r, err)
+		}
+	})
+	body := `"` + strings.Repeat("x", 30) + `"`
+	for _, tc := range []struct {
+		path string
+		want int
+	}{
+		{"/users", http.StatusRequestEntityTooLarge},
+		{"/admin/restore", http.StatusOK},
+	} {
+		w := httptest.NewRecorder()
+		h.limitBody(route{path: tc.path}, decode).ServeHTTP(w, httptest.NewRequest(http.MethodPost, tc.path, strings.NewReader(body)))
+		if w.Code != tc.want {
+			t.Errorf("%s: status %d, want %d", tc.path, w.Code, tc.want)
+		}
+	}
+}
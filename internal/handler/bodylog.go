@@ -0,0 +1,218 @@
+package handler
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"log"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+
+	"user-service/internal/config"
+	"user-service/internal/i18n"
+	"user-service/internal/service"
+)
+
+// sensitiveFields are JSON keys whose values never appear in body logs.
+var sensitiveFields = map[string]bool{
+	"password":       true,
+	"token":          true,
+	"mfa_token":      true,
+	"code":           true,
+	"secret":         true,
+	"uri":            true,
+	"recovery_codes": true,
+}
+
+// BodyLogConfig controls request/response body logging. Routes maps a
+// route template such as /users/{id} to whether it is logged; routes not
+// listed are logged.
+type BodyLogConfig struct {
+	Enabled    bool            `json:"enabled"`
+	SampleRate float64         `json:"sample_rate"`
+	MaxBytes   int             `json:"max_bytes"`
+	Routes     map[string]bool `json:"routes"`
+}
+
+func LoadBodyLogConfig(src *config.Source) BodyLogConfig {
+	return BodyLogConfig{
+		Enabled:    src.String("BODY_LOG_ENABLED", "false") == "true",
+		SampleRate: src.Float("BODY_LOG_SAMPLE_RATE", 0.1),
+		MaxBytes:   src.Int("BODY_LOG_MAX_BYTES", 4096),
+		Routes:     map[string]bool{},
+	}
+}
+
+// BodyLogger holds the current BodyLogConfig, which can be swapped at
+// runtime.
+type BodyLogger struct {
+	mu  sync.RWMutex
+	cfg BodyLogConfig
+}
+
+func NewBodyLogger(cfg BodyLogConfig) *BodyLogger {
+	return &BodyLogger{cfg: cfg}
+}
+
+func (b *BodyLogger) Config() BodyLogConfig {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return b.cfg
+}
+
+func (b *BodyLogger) SetConfig(cfg BodyLogConfig) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.cfg = cfg
+}
+
+func (b *BodyLogger) sampled(r *http.Request) (BodyLogConfig, bool) {
+	cfg := b.Config()
+	if !cfg.Enabled || rand.Float64() >= cfg.SampleRate {
+		return cfg, false
+	}
+	if route := mux.CurrentRoute(r); route != nil {
+		if tmpl, err := route.GetPathTemplate(); err == nil {
+			if enabled, ok := cfg.Routes[tmpl]; ok && !enabled {
+				return cfg, false
+			}
+		}
+	}
+	return cfg, true
+}
+
+// cappedBuffer keeps the first max bytes written to it.
+type cappedBuffer struct {
+	bytes.Buffer
+	max       int
+	truncated bool
+}
+
+func (c *cappedBuffer) Write(p []byte) (int, error) {
+	if room := c.max - c.Len(); room < len(p) {
+		c.truncated = true
+		if room > 0 {
+			c.Buffer.Write(p[:room])
+		}
+		return len(p), nil
+	}
+	return c.Buffer.Write(p)
+}
+
+type capturingWriter struct {
+	http.ResponseWriter
+	status int
+	body   *cappedBuffer
+}
+
+func (c *capturingWriter) WriteHeader(status int) {
+	c.status = status
+	c.ResponseWriter.WriteHeader(status)
+}
+
+func (c *capturingWriter) Write(p []byte) (int, error) {
+	c.body.Write(p)
+	return c.ResponseWriter.Write(p)
+}
+
+// Middleware logs a sample of requests with their bodies, capped to
+// MaxBytes and with sensitive fields redacted.
+func (b *BodyLogger) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		cfg, ok := b.sampled(r)
+		if !ok {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		reqBody := &cappedBuffer{max: cfg.MaxBytes}
+		if r.Body != nil {
+			r.Body = struct {
+				io.Reader
+				io.Closer
+			}{io.TeeReader(r.Body, reqBody), r.Body}
+		}
+		cw := &capturingWriter{ResponseWriter: w, status: http.StatusOK, body: &cappedBuffer{max: cfg.MaxBytes}}
+
+		start := time.Now()
+		next.ServeHTTP(cw, r)
+		log.Printf("http: %s %s %d %s id=%s request=%s response=%s",
+			r.Method, r.URL.RequestURI(), cw.status, time.Since(start).Round(time.Microsecond), requestID(r),
+			redactBody(reqBody), redactBody(cw.body))
+	})
+}
+
+// redactBody renders a captured body for the log. JSON bodies have
+// sensitive fields removed and emails masked; anything else is
+// summarized by size.
+func redactBody(body *cappedBuffer) string {
+	if body.Len() == 0 {
+		return "-"
+	}
+	if body.truncated {
+		return "[truncated]"
+	}
+	var v any
+	if err := json.Unmarshal(body.Bytes(), &v); err != nil {
+		return "[non-json " + strconv.Itoa(body.Len()) + " bytes]"
+	}
+	data, err := json.Marshal(redactJSON(v))
+	if err != nil {
+		return "[unloggable]"
+	}
+	return string(data)
+}
+
+func redactJSON(v any) any {
+	switch v := v.(type) {
+	case map[string]any:
+		for key, value := range v {
+			switch {
+			case sensitiveFields[key]:
+				v[key] = "[redacted]"
+			case key == "email":
+				if s, ok := value.(string); ok {
+					v[key] = maskEmail(s)
+				}
+			case key == "phone":
+				if s, ok := value.(string); ok {
+					v[key] = service.MaskPhone(s)
+				}
+			default:
+				v[key] = redactJSON(value)
+			}
+		}
+	case []any:
+		for i := range v {
+			v[i] = redactJSON(v[i])
+		}
+	}
+	return v
+}
+
+func (h *Handler) getBodyLog(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, r, http.StatusOK, h.bodyLog.Config())
+}
+
+func (h *Handler) putBodyLog(w http.ResponseWriter, r *http.Request) {
+	var cfg BodyLogConfig
+	if err := json.NewDecoder(r.Body).Decode(&cfg); err != nil {
+		writeDecodeError(w, r, err)
+		return
+	}
+	if cfg.SampleRate < 0 || cfg.SampleRate > 1 || cfg.MaxBytes <= 0 {
+		i18n.Error(w, r, http.StatusBadRequest, "sample_rate must be within 0..1 and max_bytes positive")
+		return
+	}
+	if cfg.Routes == nil {
+		cfg.Routes = map[string]bool{}
+	}
+
+	h.bodyLog.SetConfig(cfg)
+	writeJSON(w, r, http.StatusOK, cfg)
+}
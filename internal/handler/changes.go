@@ -0,0 +1,63 @@
+package handler
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"user-service/internal/i18n"
+	"user-service/internal/store"
+)
+
+// defaultChangesLimit is the page size of the change feed when the
+// caller doesn't pick one.
+const defaultChangesLimit = 100
+
+type changeView struct {
+	Seq         uint64    `json:"seq"`
+	Type        string    `json:"type"`
+	UserID      string    `json:"user_id"`
+	Time        time.Time `json:"time"`
+	RequestID   string    `json:"request_id,omitempty"`
+	TraceParent string    `json:"traceparent,omitempty"`
+	User        any       `json:"user,omitempty"`
+}
+
+type changesResponse struct {
+	Changes    []changeView `json:"changes"`
+	NextCursor string       `json:"next_cursor"`
+	HasMore    bool         `json:"has_more"`
+}
+
+// userChanges serves the change feed: user events after the since
+// cursor, in sequence order, with each user's current state.
+func (h *Handler) userChanges(w http.ResponseWriter, r *http.Request) {
+	page, ok := parsePage(r)
+	if !ok {
+		i18n.Error(w, r, http.StatusBadRequest, "Invalid limit or offset")
+		return
+	}
+	if page.Limit == 0 {
+		page.Limit = defaultChangesLimit
+	}
+
+	changes, err := h.users.Changes(r.Context(), r.URL.Query().Get("since"), page.Limit)
+	if errors.Is(err, store.ErrCursorExpired) {
+		i18n.Error(w, r, http.StatusGone, "Cursor has expired; resync and continue from since=now")
+		return
+	}
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	resp := changesResponse{Changes: make([]changeView, 0, len(changes.Changes)), NextCursor: changes.Cursor, HasMore: changes.HasMore}
+	for _, change := range changes.Changes {
+		view := changeView{Seq: change.Seq, Type: change.Type, UserID: change.UserID, Time: change.Time, RequestID: change.RequestID, TraceParent: change.TraceParent}
+		if change.User != nil {
+			view.User = h.renderUser(w, r, *change.User)
+		}
+		resp.Changes = append(resp.Changes, view)
+	}
+	writeJSON(w, r, http.StatusOK, resp)
+}
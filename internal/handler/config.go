@@ -0,0 +1,23 @@
+package handler
+
+import (
+	"errors"
+	"net/http"
+
+	"user-service/internal/config"
+	"user-service/internal/i18n"
+)
+
+func (h *Handler) reloadConfig(w http.ResponseWriter, r *http.Request) {
+	result, err := h.config.Reload()
+	if errors.Is(err, config.ErrNoFile) {
+		i18n.Error(w, r, http.StatusConflict, "CONFIG_FILE is not set")
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	writeJSON(w, r, http.StatusOK, result)
+}
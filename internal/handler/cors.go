@@ -0,0 +1,68 @@
+package handler
+
+import (
+	"net/http"
+	"strings"
+	"sync/atomic"
+
+	"user-service/internal/config"
+)
+
+// CORS answers cross-origin requests from the allowed origins.
+type CORS struct {
+	origins atomic.Pointer[[]string]
+}
+
+func NewCORS(origins []string) *CORS {
+	c := &CORS{}
+	c.SetOrigins(origins)
+	return c
+}
+
+// LoadCORSOrigins reads CORS_ALLOWED_ORIGINS, a comma-separated list
+// where "*" allows every origin.
+func LoadCORSOrigins(src *config.Source) []string {
+	origins := strings.Split(src.String("CORS_ALLOWED_ORIGINS", "*"), ",")
+	for i := range origins {
+		origins[i] = strings.TrimSpace(origins[i])
+	}
+	return origins
+}
+
+func (c *CORS) SetOrigins(origins []string) {
+	c.origins.Store(&origins)
+}
+
+// allowedOrigin returns the Access-Control-Allow-Origin value for the
+// request's Origin, or "" if it isn't allowed.
+func (c *CORS) allowedOrigin(origin string) string {
+	for _, allowed := range *c.origins.Load() {
+		if allowed == "*" {
+			return "*"
+		}
+		if allowed == origin {
+			return origin
+		}
+	}
+	return ""
+}
+
+func (c *CORS) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if origin := c.allowedOrigin(r.Header.Get("Origin")); origin != "" {
+			w.Header().Set("Access-Control-Allow-Origin", origin)
+			if origin != "*" {
+				w.Header().Add("Vary", "Origin")
+			}
+		}
+		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
+		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, X-API-Key, X-Request-ID, traceparent, tracestate")
+
+		if r.Method == "OPTIONS" {
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,93 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+
+	"github.com/gorilla/mux"
+
+	"user-service/internal/auth"
+	"user-service/internal/i18n"
+	"user-service/internal/service"
+)
+
+type deleteVoteRequest struct {
+	Reason string `json:"reason"`
+}
+
+func (h *Handler) createDeleteRequest(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	id := vars["id"]
+
+	req, err := h.deletions.Request(r.Context(), id)
+	if errors.Is(err, service.ErrDeletePending) {
+		i18n.Error(w, r, http.StatusConflict, "A delete request is already pending for this user")
+		return
+	}
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Location", "/delete-requests/"+req.ID)
+	writeJSON(w, r, http.StatusAccepted, req)
+}
+
+func (h *Handler) getDeleteRequest(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	id := vars["id"]
+
+	req, err := h.deletions.Get(r.Context(), id)
+	if err != nil {
+		writeDeletionError(w, r, err)
+		return
+	}
+
+	writeJSON(w, r, http.StatusOK, req)
+}
+
+// voteDeleteRequest records a participant's confirmation or rejection.
+// The participant is whoever authenticated the request, so a caller can
+// only vote for itself.
+func (h *Handler) voteDeleteRequest(confirm bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+		id := vars["id"]
+
+		var vote deleteVoteRequest
+		if err := json.NewDecoder(r.Body).Decode(&vote); err != nil && !errors.Is(err, io.EOF) {
+			writeDecodeError(w, r, err)
+			return
+		}
+
+		participant := auth.CallerIdentity(r)
+		var req service.DeleteRequest
+		var err error
+		if confirm {
+			req, err = h.deletions.Confirm(r.Context(), id, participant)
+		} else {
+			req, err = h.deletions.Reject(r.Context(), id, participant, vote.Reason)
+		}
+		if err != nil {
+			writeDeletionError(w, r, err)
+			return
+		}
+
+		writeJSON(w, r, http.StatusOK, req)
+	}
+}
+
+func writeDeletionError(w http.ResponseWriter, r *http.Request, err error) {
+	switch {
+	case errors.Is(err, service.ErrDeleteRequestNotFound):
+		i18n.Error(w, r, http.StatusNotFound, "Delete request not found")
+	case errors.Is(err, service.ErrDeleteResolved):
+		i18n.Error(w, r, http.StatusConflict, "Delete request is already resolved")
+	case errors.Is(err, service.ErrUnknownParticipant):
+		i18n.Error(w, r, http.StatusForbidden, "Unknown participant")
+	default:
+		writeError(w, r, err)
+	}
+}
@@ -0,0 +1,129 @@
+package handler
+
+import (
+	"log"
+	"net/http"
+	"reflect"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"user-service/internal/config"
+	"user-service/internal/store"
+)
+
+// FieldDeprecation marks a User JSON field as on its way out. Sunset, if
+// set, is when it will be removed.
+type FieldDeprecation struct {
+	Field  string     `json:"field"`
+	Sunset *time.Time `json:"sunset,omitempty"`
+}
+
+// DeprecationConfig lists the deprecated User fields. They are returned
+// and announced in response headers until Omit drops them, which lets
+// clients be tested against the shape the User will have next.
+type DeprecationConfig struct {
+	Fields []FieldDeprecation `json:"fields"`
+	Omit   bool               `json:"omit"`
+}
+
+// userJSONFields are the top-level field names a User encodes to.
+var userJSONFields = func() map[string]bool {
+	names := make(map[string]bool)
+	t := reflect.TypeOf(store.User{})
+	for i := 0; i < t.NumField(); i++ {
+		name, _, _ := strings.Cut(t.Field(i).Tag.Get("json"), ",")
+		if name != "" && name != "-" {
+			names[name] = true
+		}
+	}
+	return names
+}()
+
+// LoadDeprecationConfig reads DEPRECATED_USER_FIELDS, a comma-separated
+// list of field names each optionally followed by =YYYY-MM-DD, its sunset
+// date, and DEPRECATED_USER_FIELDS_OMIT. Entries naming no User field or
+// with a malformed date are logged and skipped.
+func LoadDeprecationConfig(src *config.Source) DeprecationConfig {
+	cfg := DeprecationConfig{
+		Fields: []FieldDeprecation{},
+		Omit:   src.String("DEPRECATED_USER_FIELDS_OMIT", "false") == "true",
+	}
+	for _, entry := range strings.Split(src.String("DEPRECATED_USER_FIELDS", ""), ",") {
+		if entry = strings.TrimSpace(entry); entry == "" {
+			continue
+		}
+		name, date, hasDate := strings.Cut(entry, "=")
+		dep := FieldDeprecation{Field: strings.TrimSpace(name)}
+		if !userJSONFields[dep.Field] {
+			log.Printf("config: ignoring DEPRECATED_USER_FIELDS entry %q: no such field", entry)
+			continue
+		}
+		if hasDate {
+			sunset, err := time.Parse("2006-01-02", strings.TrimSpace(date))
+			if err != nil {
+				log.Printf("config: ignoring DEPRECATED_USER_FIELDS entry %q: %v", entry, err)
+				continue
+			}
+			dep.Sunset = &sunset
+		}
+		cfg.Fields = append(cfg.Fields, dep)
+	}
+	return cfg
+}
+
+func (c DeprecationConfig) deprecated(field string) bool {
+	for _, dep := range c.Fields {
+		if dep.Field == field {
+			return true
+		}
+	}
+	return false
+}
+
+// announce tells the client which of the fields it is sent are
+// deprecated: Deprecation marks the response, X-Deprecated-Fields names
+// them and Sunset gives the earliest removal date among them.
+func (c DeprecationConfig) announce(w http.ResponseWriter, selected func(string) bool) {
+	var names []string
+	var sunset *time.Time
+	for _, dep := range c.Fields {
+		if !selected(dep.Field) {
+			continue
+		}
+		names = append(names, dep.Field)
+		if dep.Sunset != nil && (sunset == nil || dep.Sunset.Before(*sunset)) {
+			sunset = dep.Sunset
+		}
+	}
+	if len(names) == 0 {
+		return
+	}
+	sort.Strings(names)
+	w.Header().Set("Deprecation", "true")
+	w.Header().Set("X-Deprecated-Fields", strings.Join(names, ", "))
+	if sunset != nil {
+		w.Header().Set("Sunset", sunset.UTC().Format(http.TimeFormat))
+	}
+}
+
+// Deprecations holds the current DeprecationConfig, which can be swapped
+// at runtime.
+type Deprecations struct {
+	cfg atomic.Pointer[DeprecationConfig]
+}
+
+func NewDeprecations(cfg DeprecationConfig) *Deprecations {
+	d := &Deprecations{}
+	d.SetConfig(cfg)
+	return d
+}
+
+func (d *Deprecations) Config() DeprecationConfig {
+	return *d.cfg.Load()
+}
+
+func (d *Deprecations) SetConfig(cfg DeprecationConfig) {
+	d.cfg.Store(&cfg)
+}
@@ -0,0 +1,38 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"user-service/internal/service"
+)
+
+func writeEmailDomainError(w http.ResponseWriter, r *http.Request, err *service.EmailDomainError) {
+	field := service.FieldError{Field: "/email", Rule: service.RuleDomain}
+	switch err.Reason {
+	case service.DomainDisposable:
+		field.Format = "Disposable email addresses are not allowed"
+	case service.DomainDenied:
+		field.Format, field.Args = "Email domain %s is blocked", []any{err.Domain}
+	default:
+		field.Format, field.Args = "Email domain %s is not allowed", []any{err.Domain}
+	}
+	writeFieldErrors(w, r, []service.FieldError{field})
+}
+
+func (h *Handler) getEmailDomains(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, r, http.StatusOK, h.emails.Domains())
+}
+
+// putEmailDomains replaces the domain policy until the next reload. It
+// only applies to emails set from now on.
+func (h *Handler) putEmailDomains(w http.ResponseWriter, r *http.Request) {
+	var policy service.DomainPolicy
+	if err := json.NewDecoder(r.Body).Decode(&policy); err != nil {
+		writeDecodeError(w, r, err)
+		return
+	}
+
+	h.emails.SetDomains(policy)
+	writeJSON(w, r, http.StatusOK, h.emails.Domains())
+}
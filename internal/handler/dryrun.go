@@ -0,0 +1,53 @@
+package handler
+
+import (
+	"net/http"
+	"strconv"
+
+	"user-service/internal/i18n"
+	"user-service/internal/service"
+)
+
+// dryRunRoutes are the changing routes that take ?dry_run=true. On the
+// others it is refused rather than ignored, so a caller checking a
+// payload never changes data by mistake.
+var dryRunRoutes = map[string]bool{
+	"POST /users":                 true,
+	"PUT /users/{id}":             true,
+	"DELETE /users/{id}":          true,
+	"POST /users/{id}/suspend":    true,
+	"POST /users/{id}/activate":   true,
+	"POST /users/{id}/lock":       true,
+	"PUT /users/{id}/preferences": true,
+}
+
+// withDryRun turns ?dry_run=true on a changing route into a dry run: the
+// request is authorized, validated and answered as usual, with
+// X-Dry-Run: true, but nothing is stored.
+func withDryRun(rt route, next http.Handler) http.Handler {
+	if rt.method == "GET" || rt.method == "HEAD" {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		v := r.URL.Query().Get("dry_run")
+		if v == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		dry, err := strconv.ParseBool(v)
+		if err != nil {
+			i18n.Error(w, r, http.StatusBadRequest, "dry_run must be true or false")
+			return
+		}
+		if !dry {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if !dryRunRoutes[rt.method+" "+rt.path] {
+			i18n.Error(w, r, http.StatusBadRequest, "dry_run is not supported by %s %s", rt.method, rt.path)
+			return
+		}
+		w.Header().Set("X-Dry-Run", "true")
+		next.ServeHTTP(w, r.WithContext(service.WithDryRun(r.Context())))
+	})
+}
@@ -0,0 +1,19 @@
+package handler
+
+import "net/http"
+
+// getDuplicates serves the latest duplicate report. Before the first scan
+// has finished, or with refresh=true, it starts a scan and answers 202
+// with what it has so far; poll again for the result.
+func (h *Handler) getDuplicates(w http.ResponseWriter, r *http.Request) {
+	report, ok := h.duplicates.Report()
+	if !ok || r.URL.Query().Get("refresh") == "true" {
+		h.duplicates.Start(r.Context())
+		report.Running = true
+		w.Header().Set("Retry-After", "5")
+		writeJSON(w, r, http.StatusAccepted, report)
+		return
+	}
+
+	writeJSON(w, r, http.StatusOK, report)
+}
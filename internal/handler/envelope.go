@@ -0,0 +1,94 @@
+package handler
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"regexp"
+	"time"
+
+	"user-service/internal/trace"
+)
+
+// envelope is the /v2 response format.
+type envelope struct {
+	Data any          `json:"data"`
+	Meta envelopeMeta `json:"meta"`
+}
+
+type envelopeMeta struct {
+	RequestID  string      `json:"request_id"`
+	Timestamp  time.Time   `json:"timestamp"`
+	Pagination *pagination `json:"pagination,omitempty"`
+}
+
+type pagination struct {
+	Offset int `json:"offset"`
+	Limit  int `json:"limit"`
+	Total  int `json:"total"`
+}
+
+type envelopeKey struct{}
+
+// validRequestID limits the client-chosen IDs we echo back and log.
+var validRequestID = regexp.MustCompile(`^[A-Za-z0-9._-]{1,64}$`)
+
+// withRequestID tags the request with the caller's X-Request-ID, or a new
+// one, and returns it in the response. The ID and the request's trace
+// context go into the context, to be passed on to the events and calls
+// the request leads to.
+func withRequestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get("X-Request-ID")
+		if !validRequestID.MatchString(id) {
+			buf := make([]byte, 8)
+			rand.Read(buf)
+			id = hex.EncodeToString(buf)
+		}
+		w.Header().Set("X-Request-ID", id)
+		next.ServeHTTP(w, r.WithContext(trace.With(r.Context(), trace.FromRequest(r, id))))
+	})
+}
+
+func requestID(r *http.Request) string {
+	return trace.From(r.Context()).RequestID
+}
+
+// withEnvelope marks the request for the enveloped /v2 format.
+func withEnvelope(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), envelopeKey{}, true)))
+	})
+}
+
+func wantsEnvelope(r *http.Request) bool {
+	return r.Context().Value(envelopeKey{}) != nil
+}
+
+// writeJSON writes a successful response. Every handler goes through it so
+// /v2 requests get their data wrapped with the response metadata.
+func writeJSON(w http.ResponseWriter, r *http.Request, status int, v any) {
+	writeEnvelope(w, r, status, v, nil)
+}
+
+// writeList is writeJSON for a page of a list.
+func writeList(w http.ResponseWriter, r *http.Request, status int, v any, page Page) {
+	writeEnvelope(w, r, status, v, &pagination{Offset: page.Offset, Limit: page.Limit, Total: page.Total})
+}
+
+func writeEnvelope(w http.ResponseWriter, r *http.Request, status int, v any, page *pagination) {
+	if w.Header().Get("Content-Type") == "" {
+		w.Header().Set("Content-Type", "application/json")
+	}
+	w.WriteHeader(status)
+	if !wantsEnvelope(r) {
+		json.NewEncoder(w).Encode(v)
+		return
+	}
+	json.NewEncoder(w).Encode(envelope{
+		Data: v,
+		Meta: envelopeMeta{RequestID: requestID(r), Timestamp: time.Now().UTC(), Pagination: page},
+	})
+}
@@ -0,0 +1,67 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"user-service/internal/fault"
+	"user-service/internal/i18n"
+)
+
+// faultsResponse is the fault injection state.
+type faultsResponse struct {
+	Enabled bool         `json:"enabled"`
+	Rules   []fault.Rule `json:"rules"`
+}
+
+// injectFaults applies the route's faults when fault injection is on.
+// The /admin and /debug routes are left alone so faults can always be
+// inspected and turned off.
+func (h *Handler) injectFaults(rt route, next http.Handler) http.Handler {
+	if h.faults == nil || strings.HasPrefix(rt.path, "/admin") || strings.HasPrefix(rt.path, "/debug") {
+		return next
+	}
+	return h.faults.Middleware(rt.method+" "+rt.path, writeInjectedFault, next)
+}
+
+func writeInjectedFault(w http.ResponseWriter, r *http.Request, status int) {
+	if status == http.StatusServiceUnavailable || status == http.StatusTooManyRequests {
+		w.Header().Set("Retry-After", "1")
+	}
+	i18n.Error(w, r, status, "Injected fault")
+}
+
+func (h *Handler) getFaults(w http.ResponseWriter, r *http.Request) {
+	resp := faultsResponse{Rules: []fault.Rule{}}
+	if h.faults != nil {
+		resp.Enabled, resp.Rules = true, h.faults.Rules()
+	}
+	writeJSON(w, r, http.StatusOK, resp)
+}
+
+// putFaults replaces the rules until the next reload.
+func (h *Handler) putFaults(w http.ResponseWriter, r *http.Request) {
+	if h.faults == nil {
+		i18n.Error(w, r, http.StatusForbidden, "Fault injection is disabled")
+		return
+	}
+	var req faultsResponse
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeDecodeError(w, r, err)
+		return
+	}
+	if err := h.faults.SetRules(req.Rules); err != nil {
+		i18n.Error(w, r, http.StatusBadRequest, "Invalid fault rules: %s", err.Error())
+		return
+	}
+	h.getFaults(w, r)
+}
+
+// deleteFaults stops injecting faults until rules are set again.
+func (h *Handler) deleteFaults(w http.ResponseWriter, r *http.Request) {
+	if h.faults != nil {
+		h.faults.SetRules(nil)
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,59 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/gorilla/mux"
+
+	"user-service/internal/auth"
+	"user-service/internal/flags"
+	"user-service/internal/i18n"
+)
+
+// tenantOf identifies the tenant a request is rolled out for: the
+// X-Tenant-ID header, or the authenticated caller.
+func tenantOf(r *http.Request) string {
+	if tenant := r.Header.Get("X-Tenant-ID"); tenant != "" {
+		return tenant
+	}
+	return auth.CallerIdentity(r)
+}
+
+// featureEnabled reports whether the named flag is on for the request.
+func (h *Handler) featureEnabled(r *http.Request, name string) bool {
+	return h.flags.On(name, tenantOf(r))
+}
+
+func (h *Handler) listFlags(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, r, http.StatusOK, h.flags.All())
+}
+
+func (h *Handler) putFlag(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	name := vars["name"]
+
+	var flag flags.Flag
+	if err := json.NewDecoder(r.Body).Decode(&flag); err != nil {
+		writeDecodeError(w, r, err)
+		return
+	}
+	if flag.Percentage < 0 || flag.Percentage > 100 {
+		i18n.Error(w, r, http.StatusBadRequest, "percentage must be within 0..100")
+		return
+	}
+
+	h.flags.Set(name, flag)
+	writeJSON(w, r, http.StatusOK, flag)
+}
+
+func (h *Handler) deleteFlag(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	name := vars["name"]
+
+	if !h.flags.Delete(name) {
+		i18n.Error(w, r, http.StatusNotFound, "Flag not found")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
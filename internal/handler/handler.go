@@ -0,0 +1,322 @@
+// Package handler serves the user service's HTTP API.
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"expvar"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+
+	"user-service/internal/auth"
+	"user-service/internal/config"
+	"user-service/internal/fault"
+	"user-service/internal/flags"
+	"user-service/internal/i18n"
+	"user-service/internal/scheduler"
+	"user-service/internal/service"
+	"user-service/internal/store"
+)
+
+// Options are the dependencies of the HTTP handlers.
+type Options struct {
+	Users        *service.Users
+	Logins       *service.Logins
+	TwoFactor    *service.TwoFactor
+	Phones       *service.Phones
+	Schemas      *service.Schemas
+	Emails       *service.Emails
+	Deletions    *service.Deletions
+	Hub          *service.Hub
+	Quotas       *service.Quotas
+	Stats        *service.Stats
+	Jobs         *scheduler.Scheduler
+	TestData     *service.TestData
+	Duplicates   *service.Duplicates
+	Preferences  *service.Preferences
+	Faults       *fault.Injector // nil unless fault injection is enabled
+	Auth         *auth.Authenticator
+	PII          *store.EncryptingStore // nil when PII encryption is off
+	BodyLog      *BodyLogger
+	Deprecations *Deprecations
+	Limiter      *Limiter
+	CheckLimit   *RateLimiter
+	Flags        *flags.Set
+	Maintenance  *Maintenance
+	CORS         *CORS
+	Security     *SecurityHeaders
+	IPFilter     *IPFilter
+	Config       *config.Source
+	Catalog      *i18n.Catalog
+	// MaxRestoreBytes caps the size of a backup being restored.
+	MaxRestoreBytes int64
+}
+
+type Handler struct {
+	users        *service.Users
+	logins       *service.Logins
+	twoFactor    *service.TwoFactor
+	phones       *service.Phones
+	schemas      *service.Schemas
+	emails       *service.Emails
+	deletions    *service.Deletions
+	hub          *service.Hub
+	quotas       *service.Quotas
+	stats        *service.Stats
+	jobs         *scheduler.Scheduler
+	testData     *service.TestData
+	duplicates   *service.Duplicates
+	preferences  *service.Preferences
+	faults       *fault.Injector
+	auth         *auth.Authenticator
+	pii          *store.EncryptingStore
+	bodyLog      *BodyLogger
+	deprecations *Deprecations
+	limiter      *Limiter
+	checkLimit   *RateLimiter
+	flags        *flags.Set
+	maintenance  *Maintenance
+	cors         *CORS
+	security     *SecurityHeaders
+	ipFilter     *IPFilter
+	config       *config.Source
+	catalog      *i18n.Catalog
+
+	maxRestoreBytes int64
+}
+
+func New(opts Options) *Handler {
+	return &Handler{
+		users:        opts.Users,
+		logins:       opts.Logins,
+		twoFactor:    opts.TwoFactor,
+		phones:       opts.Phones,
+		schemas:      opts.Schemas,
+		emails:       opts.Emails,
+		deletions:    opts.Deletions,
+		hub:          opts.Hub,
+		quotas:       opts.Quotas,
+		stats:        opts.Stats,
+		jobs:         opts.Jobs,
+		testData:     opts.TestData,
+		duplicates:   opts.Duplicates,
+		preferences:  opts.Preferences,
+		faults:       opts.Faults,
+		auth:         opts.Auth,
+		pii:          opts.PII,
+		bodyLog:      opts.BodyLog,
+		deprecations: opts.Deprecations,
+		limiter:      opts.Limiter,
+		checkLimit:   opts.CheckLimit,
+		flags:        opts.Flags,
+		maintenance:  opts.Maintenance,
+		cors:         opts.CORS,
+		security:     opts.Security,
+		ipFilter:     opts.IPFilter,
+		config:       opts.Config,
+		catalog:      opts.Catalog,
+
+		maxRestoreBytes: opts.MaxRestoreBytes,
+	}
+}
+
+type route struct {
+	method  string
+	path    string
+	handler http.HandlerFunc
+	scopes  []string
+}
+
+// routes lists every endpoint with the scopes a caller needs to use it.
+func (h *Handler) routes() []route {
+	return []route{
+		{"GET", "/health", h.healthCheck, nil},
+		{"GET", "/readyz", h.ready, nil},
+		{"POST", "/login", h.login, nil},
+		{"POST", "/login/2fa", h.loginTwoFactor, nil},
+		{"POST", "/users", h.createUser, []string{auth.ScopeUsersWrite}},
+		{"GET", "/users", h.getAllUsers, []string{auth.ScopeUsersRead}},
+		{"POST", "/users/batch-get", h.batchGetUsers, []string{auth.ScopeUsersRead}},
+		{"GET", "/users/check", h.checkAvailability, nil},
+		{"GET", "/users/changes", h.userChanges, []string{auth.ScopeUsersRead}},
+		{"GET", "/users/{id}", h.getUser, []string{auth.ScopeUsersRead}},
+		{"HEAD", "/users/{id}", h.userExists, []string{auth.ScopeUsersRead}},
+		{"GET", "/users/{id}/poll", h.pollUser, []string{auth.ScopeUsersRead}},
+		{"PUT", "/users/{id}", h.updateUser, []string{auth.ScopeUsersWrite}},
+		{"DELETE", "/users/{id}", h.deleteUser, []string{auth.ScopeUsersDelete}},
+		{"POST", "/users/{id}/delete-requests", h.createDeleteRequest, []string{auth.ScopeUsersDelete}},
+		{"GET", "/delete-requests/{id}", h.getDeleteRequest, []string{auth.ScopeUsersDelete}},
+		{"POST", "/delete-requests/{id}/confirm", h.voteDeleteRequest(true), []string{auth.ScopeDeleteVote}},
+		{"POST", "/delete-requests/{id}/reject", h.voteDeleteRequest(false), []string{auth.ScopeDeleteVote}},
+		{"POST", "/users/{id}/suspend", h.transition(store.StatusSuspended), []string{auth.ScopeAdminUsers}},
+		{"POST", "/users/{id}/activate", h.transition(store.StatusActive), []string{auth.ScopeAdminUsers}},
+		{"POST", "/users/{id}/lock", h.transition(store.StatusLocked), []string{auth.ScopeAdminUsers}},
+		{"GET", "/users/{id}/login-history", h.loginHistory, []string{auth.ScopeAdminUsers}},
+		{"GET", "/users/{id}/data-export", h.dataExport, []string{auth.ScopeAdminUsers}},
+		{"POST", "/users/{id}/forget", h.forgetUser, []string{auth.ScopeAdminUsers, auth.ScopeUsersDelete}},
+		{"POST", "/users/{id}/merge", h.mergeUser, []string{auth.ScopeAdminUsers, auth.ScopeUsersDelete}},
+		{"POST", "/users/{id}/2fa/setup", h.twoFactorSetup, []string{auth.ScopeUsersWrite}},
+		{"POST", "/users/{id}/2fa/verify", h.twoFactorVerify, []string{auth.ScopeUsersWrite}},
+		{"POST", "/users/{id}/phone/verify", h.phoneVerify, []string{auth.ScopeUsersWrite}},
+		{"GET", "/users/{id}/preferences", h.getPreferences, []string{auth.ScopeUsersRead}},
+		{"PUT", "/users/{id}/preferences", h.setPreferences, []string{auth.ScopeUsersWrite}},
+		{"GET", "/stats", h.getStats, []string{auth.ScopeAdminUsers}},
+		{"GET", "/schema", h.getSchema, []string{auth.ScopeUsersRead}},
+		{"PUT", "/schema", h.putSchema, []string{auth.ScopeAdminConfig}},
+		{"DELETE", "/schema", h.deleteSchema, []string{auth.ScopeAdminConfig}},
+		{"GET", "/debug/vars", expvar.Handler().ServeHTTP, []string{auth.ScopeAdminMetrics}},
+		{"POST", "/admin/pii/reencrypt", h.reencrypt, []string{auth.ScopeAdminPII}},
+		{"POST", "/admin/backup", h.backup, []string{auth.ScopeAdminBackup}},
+		{"POST", "/admin/restore", h.restore, []string{auth.ScopeAdminBackup}},
+		{"POST", "/admin/emails/normalize", h.normalizeEmails, []string{auth.ScopeAdminUsers, auth.ScopeUsersDelete}},
+		{"GET", "/admin/duplicates", h.getDuplicates, []string{auth.ScopeAdminUsers}},
+		{"POST", "/admin/generate", h.generateUsers, []string{auth.ScopeAdminUsers, auth.ScopeUsersWrite}},
+		{"GET", "/admin/generate/{id}", h.getGeneration, []string{auth.ScopeAdminUsers}},
+		{"GET", "/admin/email-domains", h.getEmailDomains, []string{auth.ScopeAdminConfig}},
+		{"PUT", "/admin/email-domains", h.putEmailDomains, []string{auth.ScopeAdminConfig}},
+		{"GET", "/admin/body-logging", h.getBodyLog, []string{auth.ScopeAdminConfig}},
+		{"PUT", "/admin/body-logging", h.putBodyLog, []string{auth.ScopeAdminConfig}},
+		{"POST", "/admin/config/reload", h.reloadConfig, []string{auth.ScopeAdminConfig}},
+		{"GET", "/admin/flags", h.listFlags, []string{auth.ScopeAdminConfig}},
+		{"PUT", "/admin/flags/{name}", h.putFlag, []string{auth.ScopeAdminConfig}},
+		{"DELETE", "/admin/flags/{name}", h.deleteFlag, []string{auth.ScopeAdminConfig}},
+		{"GET", "/admin/ip-filter", h.getIPFilter, []string{auth.ScopeAdminConfig}},
+		{"PUT", "/admin/ip-filter", h.putIPFilter, []string{auth.ScopeAdminConfig}},
+		{"GET", "/admin/quotas", h.listQuotas, []string{auth.ScopeAdminConfig}},
+		{"DELETE", "/admin/quotas/{subject}", h.resetQuota, []string{auth.ScopeAdminConfig}},
+		{"GET", "/admin/jobs", h.listJobs, []string{auth.ScopeAdminMetrics}},
+		{"GET", "/admin/maintenance", h.getMaintenance, []string{auth.ScopeAdminConfig}},
+		{"POST", "/admin/maintenance", h.setMaintenance, []string{auth.ScopeAdminConfig}},
+		{"GET", "/admin/faults", h.getFaults, []string{auth.ScopeAdminConfig}},
+		{"PUT", "/admin/faults", h.putFaults, []string{auth.ScopeAdminConfig}},
+		{"DELETE", "/admin/faults", h.deleteFaults, []string{auth.ScopeAdminConfig}},
+	}
+}
+
+// Endpoint is a route's method and path template, such as /users/{id}.
+type Endpoint struct {
+	Method string
+	Path   string
+}
+
+// Endpoints lists the API routes, without their /v2 copies or the
+// dashboard, for tools that check the API against its documentation.
+func Endpoints() []Endpoint {
+	var endpoints []Endpoint
+	for _, rt := range (&Handler{}).routes() {
+		endpoints = append(endpoints, Endpoint{Method: rt.method, Path: rt.path})
+	}
+	return endpoints
+}
+
+// Router returns the complete HTTP handler, with every route also served
+// under /v2 in the enveloped format.
+func (h *Handler) Router() http.Handler {
+	router := mux.NewRouter()
+	router.Use(h.security.Middleware, h.limiter.Middleware, h.bodyLog.Middleware, h.maintenance.Middleware)
+	v2 := router.PathPrefix("/v2").Subrouter()
+	v2.Use(withEnvelope)
+	for _, rt := range h.routes() {
+		handler := h.injectFaults(rt, h.limitBody(rt, h.auth.RequireScopes(rt.scopes, h.enforceQuota(withDryRun(rt, rt.handler)))))
+		router.Handle(rt.path, handler).Methods(rt.method)
+		v2.Handle(rt.path, handler).Methods(rt.method)
+	}
+	// The dashboard is HTML rather than API data, so it has no /v2 form.
+	dashboard := []string{auth.ScopeAdminUsers}
+	router.Handle("/admin/ui", h.auth.RequireScopes(dashboard, http.HandlerFunc(h.adminUI))).Methods("GET")
+	router.Handle("/admin/ui/static/{file}", h.auth.RequireScopes(dashboard, http.HandlerFunc(h.adminUIAsset))).Methods("GET")
+	return h.cors.Middleware(withRequestID(h.catalog.Middleware(h.ipFilter.Middleware(router))))
+}
+
+// limitBody caps the route's request bodies, for the signature check and
+// the handler alike: restores at MAX_RESTORE_BYTES, since they carry a
+// whole backup, and everything else at MAX_BODY_BYTES.
+func (h *Handler) limitBody(rt route, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		limit := h.auth.Config().MaxBodyBytes
+		if rt.path == "/admin/restore" {
+			limit = h.maxRestoreBytes
+		}
+		next.ServeHTTP(w, auth.LimitBody(w, r, limit))
+	})
+}
+
+// writeDecodeError answers a request whose body couldn't be decoded:
+// 413 if it was over the body limit and 400 otherwise.
+func writeDecodeError(w http.ResponseWriter, r *http.Request, err error) {
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		i18n.Error(w, r, http.StatusRequestEntityTooLarge, "Request body too large")
+		return
+	}
+	http.Error(w, err.Error(), http.StatusBadRequest)
+}
+
+// writeError maps a service or store error to its response. Backend
+// failures are logged and returned as 500 without detail.
+func writeError(w http.ResponseWriter, r *http.Request, err error) {
+	var invalid *service.ValidationError
+	var hook *service.HookError
+	var open *store.CircuitOpenError
+	var domain *service.EmailDomainError
+	var quota *service.QuotaError
+	switch {
+	case errors.As(err, &invalid) && len(invalid.Fields) > 0:
+		writeFieldErrors(w, r, invalid.Fields)
+	case errors.As(err, &invalid):
+		i18n.Error(w, r, http.StatusBadRequest, invalid.Format, invalid.Args...)
+	case errors.As(err, &hook):
+		log.Printf("hooks: %v", err)
+		i18n.Error(w, r, http.StatusInternalServerError, "Could not remove the user's related data, try again")
+	case errors.Is(err, store.ErrUserNotFound):
+		i18n.Error(w, r, http.StatusNotFound, "User not found")
+	case errors.Is(err, store.ErrUserExists):
+		i18n.Error(w, r, http.StatusConflict, "User already exists")
+	case errors.Is(err, store.ErrUserMerged):
+		i18n.Error(w, r, http.StatusConflict, "User has been merged into another user")
+	case errors.Is(err, service.ErrEmailTaken):
+		i18n.Error(w, r, http.StatusConflict, "Email is already in use")
+	case errors.As(err, &domain):
+		writeEmailDomainError(w, r, domain)
+	case errors.As(err, &quota):
+		i18n.Error(w, r, http.StatusForbidden, "%s has reached its quota of %d users", quota.Subject, quota.Limit)
+	case errors.As(err, &open):
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(open.RetryAfter.Seconds()))))
+		i18n.Error(w, r, http.StatusServiceUnavailable, "Service temporarily unavailable")
+	case errors.Is(err, context.DeadlineExceeded), errors.Is(err, context.Canceled):
+		i18n.Error(w, r, http.StatusServiceUnavailable, "Request timed out")
+	default:
+		log.Printf("store: %v", err)
+		i18n.Error(w, r, http.StatusInternalServerError, "Internal server error")
+	}
+}
+
+// fieldError is a service.FieldError as the API returns it.
+type fieldError struct {
+	Field   string `json:"field"`
+	Rule    string `json:"rule"`
+	Message string `json:"message"`
+}
+
+// writeFieldErrors answers 422 with every field that failed validation,
+// each message in the request's language, so forms can mark the inputs at
+// fault. Like other errors it is not wrapped in the /v2 envelope.
+func writeFieldErrors(w http.ResponseWriter, r *http.Request, fields []service.FieldError) {
+	list := make([]fieldError, len(fields))
+	for i, f := range fields {
+		list[i] = fieldError{Field: f.Field, Rule: f.Rule, Message: i18n.Sprintf(r, f.Format, f.Args...)}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(http.StatusUnprocessableEntity)
+	json.NewEncoder(w).Encode(list)
+}
+
+func (h *Handler) healthCheck(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, r, http.StatusOK, map[string]string{"status": "healthy", "service": "user-service"})
+}
@@ -0,0 +1,209 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+	"sync/atomic"
+
+	"user-service/internal/config"
+	"user-service/internal/i18n"
+)
+
+// IPFilterConfig lists the networks that may call the service, as CIDRs
+// or single addresses. An empty Allow list allows every network not in
+// Deny; Deny wins over Allow. TrustedProxies are the load balancers
+// whose X-Forwarded-For header is believed.
+type IPFilterConfig struct {
+	Allow          []string `json:"allow"`
+	Deny           []string `json:"deny"`
+	TrustedProxies []string `json:"trusted_proxies"`
+}
+
+func splitList(s string) []string {
+	list := []string{}
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
+
+func LoadIPFilterConfig(src *config.Source) IPFilterConfig {
+	return IPFilterConfig{
+		Allow:          splitList(src.String("IP_ALLOWLIST", "")),
+		Deny:           splitList(src.String("IP_DENYLIST", "")),
+		TrustedProxies: splitList(src.String("TRUSTED_PROXIES", "")),
+	}
+}
+
+// ipFilter is an IPFilterConfig with its networks parsed.
+type ipFilter struct {
+	cfg                  IPFilterConfig
+	allow, deny, trusted []netip.Prefix
+}
+
+func parsePrefixes(list []string) ([]netip.Prefix, error) {
+	prefixes := make([]netip.Prefix, 0, len(list))
+	for _, s := range list {
+		if !strings.Contains(s, "/") {
+			addr, err := netip.ParseAddr(s)
+			if err != nil {
+				return nil, fmt.Errorf("invalid address %q", s)
+			}
+			addr = addr.Unmap()
+			prefixes = append(prefixes, netip.PrefixFrom(addr, addr.BitLen()))
+			continue
+		}
+		prefix, err := netip.ParsePrefix(s)
+		if err != nil {
+			return nil, fmt.Errorf("invalid network %q", s)
+		}
+		prefixes = append(prefixes, prefix.Masked())
+	}
+	return prefixes, nil
+}
+
+func compileIPFilter(cfg IPFilterConfig) (*ipFilter, error) {
+	f := &ipFilter{cfg: cfg}
+	var err error
+	if f.allow, err = parsePrefixes(cfg.Allow); err != nil {
+		return nil, err
+	}
+	if f.deny, err = parsePrefixes(cfg.Deny); err != nil {
+		return nil, err
+	}
+	if f.trusted, err = parsePrefixes(cfg.TrustedProxies); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+func containsAddr(prefixes []netip.Prefix, addr netip.Addr) bool {
+	for _, prefix := range prefixes {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+func (f *ipFilter) allowed(addr netip.Addr) bool {
+	if containsAddr(f.deny, addr) {
+		return false
+	}
+	return len(f.allow) == 0 || containsAddr(f.allow, addr)
+}
+
+// clientAddr returns the address the request came from. A request from
+// a trusted proxy is traced back through X-Forwarded-For, right to left,
+// to the first address that isn't a trusted proxy; anything to its left
+// could have been made up by the client.
+func (f *ipFilter) clientAddr(r *http.Request) (netip.Addr, bool) {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	addr, err := netip.ParseAddr(host)
+	if err != nil {
+		return netip.Addr{}, false
+	}
+	addr = addr.Unmap()
+	if !containsAddr(f.trusted, addr) {
+		return addr, true
+	}
+	hops := strings.Split(strings.Join(r.Header.Values("X-Forwarded-For"), ","), ",")
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop, err := netip.ParseAddr(strings.TrimSpace(hops[i]))
+		if err != nil {
+			break
+		}
+		addr = hop.Unmap()
+		if !containsAddr(f.trusted, addr) {
+			break
+		}
+	}
+	return addr, true
+}
+
+// IPFilter enforces the current IPFilterConfig, which can be swapped at
+// runtime.
+type IPFilter struct {
+	filter atomic.Pointer[ipFilter]
+}
+
+func NewIPFilter(cfg IPFilterConfig) (*IPFilter, error) {
+	f := &IPFilter{}
+	if err := f.SetConfig(cfg); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+func (f *IPFilter) Config() IPFilterConfig {
+	return f.filter.Load().cfg
+}
+
+// SetConfig applies cfg, or leaves the current one in place if a network
+// in it doesn't parse.
+func (f *IPFilter) SetConfig(cfg IPFilterConfig) error {
+	filter, err := compileIPFilter(cfg)
+	if err != nil {
+		return err
+	}
+	f.filter.Store(filter)
+	return nil
+}
+
+type clientIPKey struct{}
+
+// Middleware answers 403 to clients outside the allowed networks, and
+// records the client's address for clientIP.
+func (f *IPFilter) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		filter := f.filter.Load()
+		addr, ok := filter.clientAddr(r)
+		if !ok || !filter.allowed(addr) {
+			i18n.Error(w, r, http.StatusForbidden, "Access from your network is not allowed")
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), clientIPKey{}, addr.String())))
+	})
+}
+
+func (h *Handler) getIPFilter(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, r, http.StatusOK, h.ipFilter.Config())
+}
+
+// putIPFilter replaces the lists until the next reload. A change that
+// would block the caller making it is refused, so an admin can't lock
+// themselves out by mistake.
+func (h *Handler) putIPFilter(w http.ResponseWriter, r *http.Request) {
+	var cfg IPFilterConfig
+	if err := json.NewDecoder(r.Body).Decode(&cfg); err != nil {
+		writeDecodeError(w, r, err)
+		return
+	}
+	for _, list := range []*[]string{&cfg.Allow, &cfg.Deny, &cfg.TrustedProxies} {
+		if *list == nil {
+			*list = []string{}
+		}
+	}
+	filter, err := compileIPFilter(cfg)
+	if err != nil {
+		i18n.Error(w, r, http.StatusBadRequest, "Invalid IP filter: %s", err.Error())
+		return
+	}
+	if addr, ok := filter.clientAddr(r); !ok || !filter.allowed(addr) {
+		i18n.Error(w, r, http.StatusBadRequest, "This change would block your own address")
+		return
+	}
+
+	h.ipFilter.filter.Store(filter)
+	writeJSON(w, r, http.StatusOK, cfg)
+}
@@ -0,0 +1,8 @@
+package handler
+
+import "net/http"
+
+// listJobs reports each scheduled job's schedule and last run.
+func (h *Handler) listJobs(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, r, http.StatusOK, h.jobs.Status())
+}
@@ -0,0 +1,180 @@
+package handler
+
+import (
+	"expvar"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/mux"
+
+	"user-service/internal/config"
+	"user-service/internal/i18n"
+)
+
+var concurrencyMetrics = expvar.NewMap("http_concurrency")
+
+// LimitConfig caps how many requests run at once. Global covers every
+// request and Routes single routes, keyed by method and template such as
+// "GET /users/{id}"; /v2 routes share the limit of their plain form. A
+// request over a limit waits up to QueueTimeout for a slot before it is
+// turned away. Zero means no limit.
+type LimitConfig struct {
+	Global       int
+	Routes       map[string]int
+	QueueTimeout time.Duration
+}
+
+// LoadLimitConfig reads CONCURRENCY_LIMIT, CONCURRENCY_QUEUE_TIMEOUT and
+// CONCURRENCY_ROUTE_LIMITS, a "GET /users=16;POST /users/batch-get=4"
+// list. Malformed route entries are logged and skipped.
+func LoadLimitConfig(src *config.Source) LimitConfig {
+	cfg := LimitConfig{
+		Global:       src.Int("CONCURRENCY_LIMIT", 256),
+		Routes:       map[string]int{},
+		QueueTimeout: src.Duration("CONCURRENCY_QUEUE_TIMEOUT", 100*time.Millisecond),
+	}
+	for _, entry := range strings.Split(src.String("CONCURRENCY_ROUTE_LIMITS", ""), ";") {
+		if entry = strings.TrimSpace(entry); entry == "" {
+			continue
+		}
+		route, limit, ok := strings.Cut(entry, "=")
+		n, err := strconv.Atoi(strings.TrimSpace(limit))
+		if !ok || err != nil || n < 0 {
+			log.Printf("config: ignoring CONCURRENCY_ROUTE_LIMITS entry %q", entry)
+			continue
+		}
+		cfg.Routes[strings.Join(strings.Fields(route), " ")] = n
+	}
+	return cfg
+}
+
+// semaphore is a counting semaphore; a nil one never blocks.
+type semaphore chan struct{}
+
+func newSemaphore(n int) semaphore {
+	if n <= 0 {
+		return nil
+	}
+	return make(semaphore, n)
+}
+
+// acquire takes a slot, waiting until the deadline or the request ends.
+func (s semaphore) acquire(r *http.Request, deadline <-chan time.Time) bool {
+	if s == nil {
+		return true
+	}
+	select {
+	case s <- struct{}{}:
+		return true
+	default:
+	}
+	concurrencyMetrics.Add("queued", 1)
+	select {
+	case s <- struct{}{}:
+		return true
+	case <-deadline:
+	case <-r.Context().Done():
+	}
+	return false
+}
+
+func (s semaphore) release() {
+	if s != nil {
+		<-s
+	}
+}
+
+type limits struct {
+	cfg    LimitConfig
+	global semaphore
+	routes map[string]semaphore
+}
+
+// Limiter enforces the current LimitConfig. A new config takes effect for
+// requests that arrive after it; those already running keep the slots
+// they hold, so the old and new limits briefly overlap.
+type Limiter struct {
+	limits   atomic.Pointer[limits]
+	inFlight atomic.Int64
+}
+
+func NewLimiter(cfg LimitConfig) *Limiter {
+	l := &Limiter{}
+	l.SetConfig(cfg)
+	concurrencyMetrics.Set("in_flight", expvar.Func(func() any { return l.inFlight.Load() }))
+	return l
+}
+
+func (l *Limiter) Config() LimitConfig {
+	return l.limits.Load().cfg
+}
+
+func (l *Limiter) SetConfig(cfg LimitConfig) {
+	routes := make(map[string]semaphore, len(cfg.Routes))
+	for route, n := range cfg.Routes {
+		routes[route] = newSemaphore(n)
+	}
+	l.limits.Store(&limits{cfg: cfg, global: newSemaphore(cfg.Global), routes: routes})
+}
+
+// routeKey returns the key of the request's route in LimitConfig.Routes.
+func routeKey(r *http.Request) string {
+	route := mux.CurrentRoute(r)
+	if route == nil {
+		return ""
+	}
+	tmpl, err := route.GetPathTemplate()
+	if err != nil {
+		return ""
+	}
+	if rest, ok := strings.CutPrefix(tmpl, "/v2/"); ok {
+		tmpl = "/" + rest
+	}
+	return r.Method + " " + tmpl
+}
+
+// Middleware answers 503 with Retry-After when a route's limit or the
+// global one stays saturated for the queue timeout. The route's slot is
+// taken first so a saturated route can't hold global slots while it waits.
+// Health checks are exempt so a busy instance isn't mistaken for a dead one,
+// and long polls, which mostly wait, only count against their route limit.
+func (l *Limiter) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := routeKey(r)
+		if key == "GET /health" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		lim := l.limits.Load()
+		timer := time.NewTimer(lim.cfg.QueueTimeout)
+		defer timer.Stop()
+
+		route := lim.routes[key]
+		if !route.acquire(r, timer.C) {
+			l.reject(w, r)
+			return
+		}
+		defer route.release()
+		if key != "GET /users/{id}/poll" {
+			if !lim.global.acquire(r, timer.C) {
+				l.reject(w, r)
+				return
+			}
+			defer lim.global.release()
+		}
+
+		l.inFlight.Add(1)
+		defer l.inFlight.Add(-1)
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (l *Limiter) reject(w http.ResponseWriter, r *http.Request) {
+	concurrencyMetrics.Add("rejected", 1)
+	w.Header().Set("Retry-After", "1")
+	i18n.Error(w, r, http.StatusServiceUnavailable, "Server is busy, try again later")
+}
@@ -0,0 +1,102 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+
+	"github.com/gorilla/mux"
+
+	"user-service/internal/i18n"
+	"user-service/internal/service"
+	"user-service/internal/store"
+)
+
+func clientIP(r *http.Request) string {
+	if ip, ok := r.Context().Value(clientIPKey{}).(string); ok {
+		return ip
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+type loginRequest struct {
+	Email    string `json:"email"`
+	Password string `json:"password"`
+}
+
+type loginResponse struct {
+	Token       string      `json:"token,omitempty"`
+	MFARequired bool        `json:"mfa_required,omitempty"`
+	MFAToken    string      `json:"mfa_token,omitempty"`
+	User        *store.User `json:"user,omitempty"`
+}
+
+type loginTwoFactorRequest struct {
+	MFAToken string `json:"mfa_token"`
+	Code     string `json:"code"`
+}
+
+// writeLogin responds with the login result or the reason it failed.
+func writeLogin(w http.ResponseWriter, r *http.Request, result service.LoginResult, err error) {
+	var status *service.AccountStatusError
+	switch {
+	case err == nil:
+		writeJSON(w, r, http.StatusOK, loginResponse(result))
+	case errors.Is(err, service.ErrIPBlocked):
+		i18n.Error(w, r, http.StatusTooManyRequests, "Too many failed login attempts")
+	case errors.Is(err, service.ErrInvalidCredentials):
+		i18n.Error(w, r, http.StatusUnauthorized, "Invalid credentials")
+	case errors.As(err, &status):
+		i18n.Error(w, r, http.StatusForbidden, "Account %s", status.Status)
+	case errors.Is(err, service.ErrInvalidMFAToken):
+		i18n.Error(w, r, http.StatusUnauthorized, "Invalid or expired mfa token")
+	case errors.Is(err, store.ErrInvalidTwoFactorCode):
+		i18n.Error(w, r, http.StatusUnauthorized, "Invalid code")
+	default:
+		writeError(w, r, err)
+	}
+}
+
+func (h *Handler) login(w http.ResponseWriter, r *http.Request) {
+	var req loginRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeDecodeError(w, r, err)
+		return
+	}
+	if req.Email == "" || req.Password == "" {
+		i18n.Error(w, r, http.StatusBadRequest, "Email and Password are required")
+		return
+	}
+
+	result, err := h.logins.Login(r.Context(), req.Email, req.Password, clientIP(r))
+	writeLogin(w, r, result, err)
+}
+
+func (h *Handler) loginTwoFactor(w http.ResponseWriter, r *http.Request) {
+	var req loginTwoFactorRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeDecodeError(w, r, err)
+		return
+	}
+
+	result, err := h.logins.LoginTwoFactor(r.Context(), req.MFAToken, req.Code, clientIP(r))
+	writeLogin(w, r, result, err)
+}
+
+func (h *Handler) loginHistory(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	id := vars["id"]
+
+	history, err := h.users.LoginHistory(r.Context(), id)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	writeJSON(w, r, http.StatusOK, history)
+}
@@ -0,0 +1,163 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"user-service/internal/i18n"
+)
+
+// MaintenanceState is persisted to MAINTENANCE_STATE_FILE so the mode
+// survives restarts.
+type MaintenanceState struct {
+	Enabled    bool       `json:"enabled"`
+	Message    string     `json:"message,omitempty"`
+	RetryAfter int        `json:"retry_after_seconds,omitempty"`
+	Since      *time.Time `json:"since,omitempty"`
+}
+
+// Maintenance rejects writes while maintenance mode is on.
+type Maintenance struct {
+	mu    sync.RWMutex
+	path  string
+	state MaintenanceState
+}
+
+// LoadMaintenance restores the state saved at path, if any.
+func LoadMaintenance(path string) (*Maintenance, error) {
+	m := &Maintenance{path: path}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return m, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &m.state); err != nil {
+		return nil, err
+	}
+	if m.state.Enabled {
+		log.Printf("maintenance: resuming maintenance mode")
+	}
+	return m, nil
+}
+
+func (m *Maintenance) State() MaintenanceState {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.state
+}
+
+// SetState saves the state before applying it, so a failed write leaves
+// the current mode in place.
+func (m *Maintenance) SetState(state MaintenanceState) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	data, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+	tmp := m.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, m.path); err != nil {
+		return err
+	}
+	m.state = state
+	return nil
+}
+
+// maintenanceExempt are the POSTs that keep working in maintenance mode:
+// those that only read, and the ones that manage maintenance itself.
+var maintenanceExempt = map[string]bool{
+	"/users/batch-get":     true,
+	"/admin/backup":        true,
+	"/admin/maintenance":   true,
+	"/admin/config/reload": true,
+}
+
+// writesAllowed reports whether the request may run in maintenance mode.
+// Reads, logins, backups and turning maintenance off keep working; other
+// admin writes, such as generating users or normalizing emails, don't.
+func writesAllowed(r *http.Request) bool {
+	switch r.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return true
+	}
+	path := strings.TrimPrefix(r.URL.Path, "/v2")
+	return maintenanceExempt[path] || strings.HasPrefix(path, "/login")
+}
+
+// Middleware rejects writes with 503 while maintenance mode is on.
+func (m *Maintenance) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		state := m.State()
+		if !state.Enabled || writesAllowed(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if state.RetryAfter > 0 {
+			w.Header().Set("Retry-After", strconv.Itoa(state.RetryAfter))
+		}
+		message := state.Message
+		if message == "" {
+			message = i18n.Sprintf(r, "Service is in maintenance mode; writes are unavailable")
+		}
+		http.Error(w, message, http.StatusServiceUnavailable)
+	})
+}
+
+func (h *Handler) ready(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, r, http.StatusOK, map[string]any{
+		"status":      "ready",
+		"service":     "user-service",
+		"maintenance": h.maintenance.State(),
+	})
+}
+
+func (h *Handler) getMaintenance(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, r, http.StatusOK, h.maintenance.State())
+}
+
+// setMaintenance turns maintenance mode on or off. With an empty
+// body it toggles the current mode.
+func (h *Handler) setMaintenance(w http.ResponseWriter, r *http.Request) {
+	current := h.maintenance.State()
+	state := MaintenanceState{Enabled: !current.Enabled, RetryAfter: 300}
+	if err := json.NewDecoder(r.Body).Decode(&state); err != nil && err != io.EOF {
+		writeDecodeError(w, r, err)
+		return
+	}
+	if state.RetryAfter < 0 {
+		i18n.Error(w, r, http.StatusBadRequest, "retry_after_seconds must not be negative")
+		return
+	}
+	state.Since = nil
+	if state.Enabled {
+		since := time.Now().UTC()
+		if current.Enabled && current.Since != nil {
+			since = *current.Since
+		}
+		state.Since = &since
+	} else {
+		state.Message = ""
+		state.RetryAfter = 0
+	}
+
+	if err := h.maintenance.SetState(state); err != nil {
+		log.Printf("maintenance: save state: %v", err)
+		i18n.Error(w, r, http.StatusInternalServerError, "Could not save maintenance state")
+		return
+	}
+	log.Printf("maintenance: enabled=%t", state.Enabled)
+	writeJSON(w, r, http.StatusOK, state)
+}
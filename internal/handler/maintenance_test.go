@@ -0,0 +1,27 @@
+package handler
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestWritesAllowed(t *testing.T) {
+	for _, tc := range []struct {
+		method, path string
+		want         bool
+	}{
+		{"GET", "/users", true},
+		{"POST", "/login", true},
+		{"POST", "/v2/users/batch-get", true},
+		{"POST", "/admin/maintenance", true},
+		{"POST", "/admin/backup", true},
+		{"POST", "/users", false},
+		{"POST", "/admin/generate", false},
+		{"POST", "/admin/emails/normalize", false},
+		{"POST", "/admin/restore", false},
+	} {
+		if got := writesAllowed(httptest.NewRequest(tc.method, tc.path, nil)); got != tc.want {
+			t.Errorf("%s %s: allowed = %t, want %t", tc.method, tc.path, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,63 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+
+	"github.com/gorilla/mux"
+
+	"user-service/internal/i18n"
+	"user-service/internal/service"
+)
+
+type phoneVerifyRequest struct {
+	Code string `json:"code"`
+}
+
+// phoneVerify texts a verification code to the user's phone number, or
+// with a code in the body, confirms it.
+func (h *Handler) phoneVerify(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	id := vars["id"]
+
+	var req phoneVerifyRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && !errors.Is(err, io.EOF) {
+		writeDecodeError(w, r, err)
+		return
+	}
+
+	if req.Code == "" {
+		sent, err := h.phones.SendCode(r.Context(), id)
+		switch {
+		case errors.Is(err, service.ErrNoPhone):
+			i18n.Error(w, r, http.StatusConflict, "User has no phone number")
+			return
+		case errors.Is(err, service.ErrPhoneVerified):
+			i18n.Error(w, r, http.StatusConflict, "Phone number already verified")
+			return
+		case errors.Is(err, service.ErrPhoneCodeTooSoon):
+			i18n.Error(w, r, http.StatusTooManyRequests, "A code was sent recently, try again later")
+			return
+		case err != nil:
+			writeError(w, r, err)
+			return
+		}
+		sent.Phone = service.MaskPhone(sent.Phone)
+		writeJSON(w, r, http.StatusAccepted, sent)
+		return
+	}
+
+	user, err := h.phones.Confirm(r.Context(), id, req.Code)
+	if errors.Is(err, service.ErrInvalidPhoneCode) {
+		i18n.Error(w, r, http.StatusUnauthorized, "Invalid code")
+		return
+	}
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	h.writeUser(w, r, http.StatusOK, user)
+}
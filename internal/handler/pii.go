@@ -0,0 +1,65 @@
+package handler
+
+import (
+	"net/http"
+	"reflect"
+	"strings"
+
+	"user-service/internal/i18n"
+	"user-service/internal/service"
+	"user-service/internal/store"
+)
+
+func (h *Handler) reencrypt(w http.ResponseWriter, r *http.Request) {
+	if h.pii == nil {
+		i18n.Error(w, r, http.StatusConflict, "PII encryption is not enabled")
+		return
+	}
+
+	count, err := h.pii.Reencrypt(r.Context())
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	writeJSON(w, r, http.StatusOK, map[string]int{"reencrypted": count})
+}
+
+// maskEmail keeps the first character of the local part and the domain,
+// e.g. j***@example.com.
+func maskEmail(email string) string {
+	local, domain, ok := strings.Cut(email, "@")
+	if !ok || local == "" {
+		return "***"
+	}
+	return local[:1] + "***@" + domain
+}
+
+func maskValue(kind, value string) string {
+	if value == "" {
+		return ""
+	}
+	switch kind {
+	case "email":
+		return maskEmail(value)
+	case "phone":
+		return service.MaskPhone(value)
+	default:
+		return "***"
+	}
+}
+
+// redactPII returns a copy of the user with every string field tagged
+// `pii:"<kind>"` masked, so new sensitive fields only need the tag.
+func redactPII(user store.User) store.User {
+	v := reflect.ValueOf(&user).Elem()
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		kind, ok := t.Field(i).Tag.Lookup("pii")
+		if !ok || v.Field(i).Kind() != reflect.String {
+			continue
+		}
+		v.Field(i).SetString(maskValue(kind, v.Field(i).String()))
+	}
+	return user
+}
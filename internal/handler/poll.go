@@ -0,0 +1,60 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+
+	"user-service/internal/i18n"
+)
+
+const (
+	defaultPollTimeout = 30 * time.Second
+	// maxPollTimeout bounds how long one poll holds its connection.
+	maxPollTimeout = time.Minute
+)
+
+// pollUser answers like getUser once the user's version differs from the
+// version parameter, or when the timeout elapses with it unchanged,
+// whichever comes first. X-User-Version carries the version to pass on
+// the next poll. Without a version it answers at once.
+func (h *Handler) pollUser(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	id := vars["id"]
+	query := r.URL.Query()
+
+	timeout := defaultPollTimeout
+	if s := query.Get("timeout"); s != "" {
+		d, err := time.ParseDuration(s)
+		if err != nil || d <= 0 {
+			i18n.Error(w, r, http.StatusBadRequest, "Invalid timeout")
+			return
+		}
+		timeout = min(d, maxPollTimeout)
+	}
+
+	if _, err := h.users.Get(r.Context(), id); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	if s := query.Get("version"); s != "" {
+		version, err := strconv.ParseUint(s, 10, 64)
+		if err != nil {
+			i18n.Error(w, r, http.StatusBadRequest, "Invalid version")
+			return
+		}
+		ctx, cancel := context.WithTimeout(r.Context(), timeout)
+		_, err = h.hub.Wait(ctx, id, version)
+		cancel()
+		if err != nil && !errors.Is(err, context.DeadlineExceeded) {
+			// The client went away.
+			return
+		}
+	}
+
+	h.getUser(w, r)
+}
@@ -0,0 +1,70 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/gorilla/mux"
+
+	"user-service/internal/i18n"
+	"user-service/internal/service"
+)
+
+// getPreferences answers 304 when If-None-Match names the current ETag.
+func (h *Handler) getPreferences(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	id := vars["id"]
+
+	prefs, err := h.preferences.Get(r.Context(), id)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	w.Header().Set("ETag", prefs.ETag)
+	if service.MatchETag(etagList(r.Header.Get("If-None-Match")), prefs.ETag) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	writeJSON(w, r, http.StatusOK, prefs)
+}
+
+// setPreferences replaces the user's preferences with the body, an object
+// of preference values. With If-Match it answers 412 unless the current
+// preferences have one of the ETags given.
+func (h *Handler) setPreferences(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	id := vars["id"]
+
+	var values map[string]any
+	if err := json.NewDecoder(r.Body).Decode(&values); err != nil {
+		writeDecodeError(w, r, err)
+		return
+	}
+
+	prefs, err := h.preferences.Set(r.Context(), id, values, etagList(r.Header.Get("If-Match")))
+	if errors.Is(err, service.ErrPreferencesChanged) {
+		i18n.Error(w, r, http.StatusPreconditionFailed, "Preferences have changed since they were read")
+		return
+	}
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	w.Header().Set("ETag", prefs.ETag)
+	writeJSON(w, r, http.StatusOK, prefs)
+}
+
+// etagList splits an If-Match or If-None-Match header into its ETags.
+func etagList(header string) []string {
+	var tags []string
+	for _, tag := range strings.Split(header, ",") {
+		if tag = strings.TrimSpace(tag); tag != "" {
+			tags = append(tags, tag)
+		}
+	}
+	return tags
+}
@@ -0,0 +1,50 @@
+package handler
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+
+	"user-service/internal/i18n"
+)
+
+// enforceQuota counts the request against the daily request quotas of its
+// credential's tenant and API key, and answers 429 once the one with the fewest
+// requests left has none. The X-Quota-* headers describe that quota.
+func (h *Handler) enforceQuota(next http.Handler) http.Handler {
+	if h.quotas == nil {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		usage, ok := h.quotas.Request(r.Context())
+		if usage.Subject != "" {
+			reset := strconv.Itoa(int(math.Ceil(time.Until(usage.Resets).Seconds())))
+			w.Header().Set("X-Quota-Subject", usage.Subject)
+			w.Header().Set("X-Quota-Limit", strconv.Itoa(usage.Quota.RequestsPerDay))
+			w.Header().Set("X-Quota-Remaining", strconv.Itoa(usage.RequestsLeft()))
+			w.Header().Set("X-Quota-Reset", reset)
+			if !ok {
+				w.Header().Set("Retry-After", reset)
+			}
+		}
+		if !ok {
+			i18n.Error(w, r, http.StatusTooManyRequests, "Daily request quota of %s is used up", usage.Subject)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (h *Handler) listQuotas(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, r, http.StatusOK, h.quotas.Usage())
+}
+
+// resetQuota forgets the subject's requests today.
+func (h *Handler) resetQuota(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	h.quotas.Reset(vars["subject"])
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,69 @@
+package handler
+
+import (
+	"sync"
+	"time"
+
+	"user-service/internal/config"
+)
+
+// RateLimiter allows each key, such as a client IP, a number of requests
+// per sliding window. It is kept in memory, so each instance counts on
+// its own.
+type RateLimiter struct {
+	limit  int
+	window time.Duration
+
+	mu        sync.Mutex
+	hits      map[string][]time.Time
+	lastSweep time.Time
+}
+
+// NewRateLimiter allows limit requests per window; a limit of zero or
+// less allows everything.
+func NewRateLimiter(limit int, window time.Duration) *RateLimiter {
+	return &RateLimiter{limit: limit, window: window, hits: make(map[string][]time.Time)}
+}
+
+// LoadCheckRateLimiter reads CHECK_RATE_LIMIT and CHECK_RATE_WINDOW, the
+// allowance of the uniqueness check per client IP.
+func LoadCheckRateLimiter(src *config.Source) *RateLimiter {
+	return NewRateLimiter(src.Int("CHECK_RATE_LIMIT", 10), src.Duration("CHECK_RATE_WINDOW", time.Minute))
+}
+
+// Allow records a request for the key if it is within the limit. When it
+// isn't, it returns how long until the oldest request in the window
+// expires.
+func (l *RateLimiter) Allow(key string) (bool, time.Duration) {
+	if l.limit <= 0 {
+		return true, 0
+	}
+	now := time.Now()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if now.Sub(l.lastSweep) > l.window {
+		l.sweep(now)
+	}
+
+	hits := l.hits[key]
+	for len(hits) > 0 && now.Sub(hits[0]) >= l.window {
+		hits = hits[1:]
+	}
+	if len(hits) >= l.limit {
+		l.hits[key] = hits
+		return false, l.window - now.Sub(hits[0])
+	}
+	l.hits[key] = append(hits, now)
+	return true, 0
+}
+
+// sweep forgets keys with no requests in the window. Callers must hold
+// l.mu.
+func (l *RateLimiter) sweep(now time.Time) {
+	for key, hits := range l.hits {
+		if len(hits) == 0 || now.Sub(hits[len(hits)-1]) >= l.window {
+			delete(l.hits, key)
+		}
+	}
+	l.lastSweep = now
+}
@@ -0,0 +1,206 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"user-service/internal/auth"
+	"user-service/internal/store"
+)
+
+const mediaTypeHAL = "application/hal+json"
+
+type halLink struct {
+	Href string `json:"href"`
+}
+
+type halUser struct {
+	store.User
+	Links map[string]halLink `json:"_links"`
+}
+
+type halUserList struct {
+	Embedded struct {
+		Users []any `json:"users"`
+	} `json:"_embedded"`
+	Links map[string]halLink `json:"_links"`
+	Count int                `json:"count"`
+	Total int                `json:"total"`
+}
+
+// Page describes the slice of a list returned to the client.
+type Page struct {
+	Offset int
+	Limit  int
+	Total  int
+}
+
+func wantsHAL(r *http.Request) bool {
+	return strings.Contains(r.Header.Get("Accept"), mediaTypeHAL)
+}
+
+func userLinks(id string) map[string]halLink {
+	self := "/users/" + url.PathEscape(id)
+	return map[string]halLink{
+		"self":       {Href: self},
+		"update":     {Href: self},
+		"delete":     {Href: self},
+		"collection": {Href: "/users"},
+	}
+}
+
+// parsePage reads limit and offset from the query. A zero limit means the
+// whole list.
+func parsePage(r *http.Request) (Page, bool) {
+	var page Page
+	query := r.URL.Query()
+	if v := query.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			return Page{}, false
+		}
+		page.Limit = n
+	}
+	if v := query.Get("offset"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			return Page{}, false
+		}
+		page.Offset = n
+	}
+	return page, true
+}
+
+func pageLinks(r *http.Request, page Page) map[string]halLink {
+	link := func(offset int) halLink {
+		query := r.URL.Query()
+		query.Set("offset", strconv.Itoa(offset))
+		if page.Limit > 0 {
+			query.Set("limit", strconv.Itoa(page.Limit))
+		}
+		return halLink{Href: r.URL.Path + "?" + query.Encode()}
+	}
+
+	links := map[string]halLink{"self": link(page.Offset)}
+	if page.Limit == 0 {
+		return links
+	}
+	if page.Offset+page.Limit < page.Total {
+		links["next"] = link(page.Offset + page.Limit)
+	}
+	if page.Offset > 0 {
+		links["prev"] = link(max(page.Offset-page.Limit, 0))
+	}
+	return links
+}
+
+// requestedFields returns the set named by ?fields=, or nil when the
+// client wants every field.
+func requestedFields(r *http.Request) map[string]bool {
+	param := r.URL.Query().Get("fields")
+	if param == "" {
+		return nil
+	}
+	fields := make(map[string]bool)
+	for _, f := range strings.Split(param, ",") {
+		if f = strings.TrimSpace(f); f != "" {
+			fields[f] = true
+		}
+	}
+	return fields
+}
+
+// project keeps only the top-level JSON fields of v that keep accepts.
+// Working on the encoded form means any field added to User is selectable
+// without further changes. HAL links are always kept.
+func project(v any, keep func(name string) bool) any {
+	if keep == nil {
+		return v
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		return v
+	}
+	var all map[string]json.RawMessage
+	if err := json.Unmarshal(data, &all); err != nil {
+		return v
+	}
+	selected := make(map[string]json.RawMessage, len(all))
+	for name, value := range all {
+		if keep(name) || name == "_links" {
+			selected[name] = value
+		}
+	}
+	return selected
+}
+
+// renderUser returns the representation of the user sent to the client.
+// PII is masked for callers without the users:read_pii scope. Deprecated
+// fields are dropped, or announced in the response headers while they are
+// still sent.
+func (h *Handler) renderUser(w http.ResponseWriter, r *http.Request, user store.User) any {
+	if !h.auth.CallerHasScope(r, auth.ScopeUsersReadPII) {
+		user = redactPII(user)
+	}
+	var v any = user
+	if wantsHAL(r) {
+		v = halUser{User: user, Links: userLinks(user.ID)}
+	}
+
+	fields := requestedFields(r)
+	selected := func(name string) bool { return fields == nil || fields[name] }
+	var keep func(name string) bool
+	if fields != nil {
+		keep = selected
+	}
+	deprecations := h.deprecations.Config()
+	switch {
+	case len(deprecations.Fields) == 0:
+	case deprecations.Omit:
+		keep = func(name string) bool { return selected(name) && !deprecations.deprecated(name) }
+	default:
+		deprecations.announce(w, selected)
+	}
+	return project(v, keep)
+}
+
+func (h *Handler) renderUsers(w http.ResponseWriter, r *http.Request, users []store.User) []any {
+	rendered := make([]any, 0, len(users))
+	for _, user := range users {
+		rendered = append(rendered, h.renderUser(w, r, user))
+	}
+	return rendered
+}
+
+func contentType(r *http.Request) string {
+	if wantsHAL(r) {
+		return mediaTypeHAL
+	}
+	return "application/json"
+}
+
+// writeUser encodes a single user, as HAL when the client asks for it.
+func (h *Handler) writeUser(w http.ResponseWriter, r *http.Request, status int, user store.User) {
+	w.Header().Set("Content-Type", contentType(r))
+	writeJSON(w, r, status, h.renderUser(w, r, user))
+}
+
+// writeUsers encodes a page of users. Plain JSON clients get an array;
+// HAL clients get the users embedded with pagination links.
+func (h *Handler) writeUsers(w http.ResponseWriter, r *http.Request, users []store.User, page Page) {
+	w.Header().Set("Content-Type", contentType(r))
+	if wantsHAL(r) {
+		var list halUserList
+		list.Embedded.Users = h.renderUsers(w, r, users)
+		list.Links = pageLinks(r, page)
+		list.Count = len(users)
+		list.Total = page.Total
+		writeList(w, r, http.StatusOK, list, page)
+		return
+	}
+
+	writeList(w, r, http.StatusOK, h.renderUsers(w, r, users), page)
+}
@@ -0,0 +1,54 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"user-service/internal/service"
+)
+
+// schemaTenant is the tenant whose custom field schema the /schema
+// endpoints manage: the X-Tenant-ID header, or the default schema without
+// one. Users are validated against their own tenant's schema instead.
+func schemaTenant(r *http.Request) string {
+	return r.Header.Get("X-Tenant-ID")
+}
+
+type schemaResponse struct {
+	Tenant string `json:"tenant"`
+	// Inherited is set when the tenant has no schema of its own and the
+	// default applies.
+	Inherited bool `json:"inherited"`
+	service.Schema
+}
+
+func (h *Handler) getSchema(w http.ResponseWriter, r *http.Request) {
+	tenant := schemaTenant(r)
+	schema, own := h.schemas.Get(tenant)
+	if schema.Fields == nil {
+		schema.Fields = []service.FieldDef{}
+	}
+	writeJSON(w, r, http.StatusOK, schemaResponse{Tenant: tenant, Inherited: !own && tenant != "", Schema: schema})
+}
+
+func (h *Handler) putSchema(w http.ResponseWriter, r *http.Request) {
+	var schema service.Schema
+	if err := json.NewDecoder(r.Body).Decode(&schema); err != nil {
+		writeDecodeError(w, r, err)
+		return
+	}
+
+	tenant := schemaTenant(r)
+	schema, err := h.schemas.Set(tenant, schema)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	writeJSON(w, r, http.StatusOK, schemaResponse{Tenant: tenant, Schema: schema})
+}
+
+func (h *Handler) deleteSchema(w http.ResponseWriter, r *http.Request) {
+	h.schemas.Delete(schemaTenant(r))
+	w.WriteHeader(http.StatusNoContent)
+}
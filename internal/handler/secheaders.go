@@ -0,0 +1,131 @@
+package handler
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"sync/atomic"
+
+	"github.com/gorilla/mux"
+
+	"user-service/internal/config"
+)
+
+const headerHSTS = "Strict-Transport-Security"
+
+// defaultSecurityHeaders suit an API that only ever returns data: nothing
+// may be loaded, framed or sniffed from its responses.
+var defaultSecurityHeaders = map[string]string{
+	headerHSTS:                "max-age=31536000; includeSubDomains",
+	"X-Content-Type-Options":  "nosniff",
+	"X-Frame-Options":         "DENY",
+	"Referrer-Policy":         "no-referrer",
+	"Content-Security-Policy": "default-src 'none'; frame-ancestors 'none'",
+}
+
+// defaultRouteSecurityHeaders let the dashboard load its own scripts and
+// styles.
+var defaultRouteSecurityHeaders = map[string]map[string]string{
+	"/admin/ui":               {"Content-Security-Policy": "default-src 'self'; frame-ancestors 'none'"},
+	"/admin/ui/static/{file}": {"Content-Security-Policy": "default-src 'self'; frame-ancestors 'none'"},
+}
+
+// SecurityHeadersConfig holds the security headers added to responses.
+// Routes overrides Headers for a route template such as /users/{id};
+// /v2 routes have their own templates. An empty value drops the header.
+type SecurityHeadersConfig struct {
+	Headers map[string]string            `json:"headers"`
+	Routes  map[string]map[string]string `json:"routes"`
+}
+
+// LoadSecurityHeadersConfig starts from the defaults and applies
+// SECURITY_HEADERS, a JSON object of header to value, and
+// SECURITY_HEADERS_ROUTES, a JSON object of route template to such an
+// object. Invalid JSON is logged and ignored.
+func LoadSecurityHeadersConfig(src *config.Source) SecurityHeadersConfig {
+	cfg := SecurityHeadersConfig{Headers: map[string]string{}, Routes: map[string]map[string]string{}}
+	mergeHeaders(cfg.Headers, defaultSecurityHeaders)
+	for route, headers := range defaultRouteSecurityHeaders {
+		cfg.Routes[route] = map[string]string{}
+		mergeHeaders(cfg.Routes[route], headers)
+	}
+
+	if raw := src.String("SECURITY_HEADERS", ""); raw != "" {
+		var headers map[string]string
+		if err := json.Unmarshal([]byte(raw), &headers); err != nil {
+			log.Printf("config: invalid SECURITY_HEADERS: %v", err)
+		} else {
+			mergeHeaders(cfg.Headers, headers)
+		}
+	}
+	if raw := src.String("SECURITY_HEADERS_ROUTES", ""); raw != "" {
+		var routes map[string]map[string]string
+		if err := json.Unmarshal([]byte(raw), &routes); err != nil {
+			log.Printf("config: invalid SECURITY_HEADERS_ROUTES: %v", err)
+		} else {
+			for route, headers := range routes {
+				if cfg.Routes[route] == nil {
+					cfg.Routes[route] = map[string]string{}
+				}
+				mergeHeaders(cfg.Routes[route], headers)
+			}
+		}
+	}
+	return cfg
+}
+
+func mergeHeaders(dst, src map[string]string) {
+	for name, value := range src {
+		dst[http.CanonicalHeaderKey(name)] = value
+	}
+}
+
+// SecurityHeaders holds the current SecurityHeadersConfig, which can be
+// swapped at runtime.
+type SecurityHeaders struct {
+	cfg atomic.Pointer[SecurityHeadersConfig]
+}
+
+func NewSecurityHeaders(cfg SecurityHeadersConfig) *SecurityHeaders {
+	s := &SecurityHeaders{}
+	s.SetConfig(cfg)
+	return s
+}
+
+func (s *SecurityHeaders) Config() SecurityHeadersConfig {
+	return *s.cfg.Load()
+}
+
+func (s *SecurityHeaders) SetConfig(cfg SecurityHeadersConfig) {
+	s.cfg.Store(&cfg)
+}
+
+// Middleware sets the headers before the handler runs, so a handler can
+// still replace one. Strict-Transport-Security is only sent on HTTPS
+// connections, where browsers honor it.
+func (s *SecurityHeaders) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		cfg := s.cfg.Load()
+		var overrides map[string]string
+		if route := mux.CurrentRoute(r); route != nil {
+			if tmpl, err := route.GetPathTemplate(); err == nil {
+				overrides = cfg.Routes[tmpl]
+			}
+		}
+		set := func(name, value string) {
+			if value == "" || (name == headerHSTS && r.TLS == nil) {
+				return
+			}
+			w.Header().Set(name, value)
+		}
+		for name, value := range cfg.Headers {
+			if _, ok := overrides[name]; !ok {
+				set(name, value)
+			}
+		}
+		for name, value := range overrides {
+			set(name, value)
+		}
+		next.ServeHTTP(w, r)
+	})
+}
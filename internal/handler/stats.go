@@ -0,0 +1,35 @@
+package handler
+
+import (
+	"net/http"
+	"strconv"
+
+	"user-service/internal/i18n"
+	"user-service/internal/service"
+)
+
+// defaultStatsDays is how many days of creations /stats reports when the
+// caller doesn't pick.
+const defaultStatsDays = 30
+
+// getStats serves user counts: the total, counts by status and scope, and
+// creations per UTC day over the number of days the days parameter asks
+// for.
+func (h *Handler) getStats(w http.ResponseWriter, r *http.Request) {
+	days := defaultStatsDays
+	if s := r.URL.Query().Get("days"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 1 || n > service.MaxStatsDays {
+			i18n.Error(w, r, http.StatusBadRequest, "days must be within 1..%d", service.MaxStatsDays)
+			return
+		}
+		days = n
+	}
+
+	stats, err := h.stats.Get(r.Context(), days)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeJSON(w, r, http.StatusOK, stats)
+}
@@ -0,0 +1,68 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+
+	"user-service/internal/i18n"
+	"user-service/internal/service"
+)
+
+// generateUsers starts filling the store with ?count fake users, taking
+// tenants and tags for their custom fields from the optional body, and
+// answers with the generation to poll.
+func (h *Handler) generateUsers(w http.ResponseWriter, r *http.Request) {
+	var req service.GenerateRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && !errors.Is(err, io.EOF) {
+		writeDecodeError(w, r, err)
+		return
+	}
+	if v := r.URL.Query().Get("count"); v != "" {
+		count, err := strconv.Atoi(v)
+		if err != nil {
+			i18n.Error(w, r, http.StatusBadRequest, "count must be a number")
+			return
+		}
+		req.Count = count
+	}
+
+	generation, err := h.testData.Generate(r.Context(), req)
+	if err != nil {
+		writeGenerationError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Location", "/admin/generate/"+generation.ID)
+	writeJSON(w, r, http.StatusAccepted, generation)
+}
+
+func (h *Handler) getGeneration(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	id := vars["id"]
+
+	generation, err := h.testData.Get(id)
+	if err != nil {
+		writeGenerationError(w, r, err)
+		return
+	}
+
+	writeJSON(w, r, http.StatusOK, generation)
+}
+
+func writeGenerationError(w http.ResponseWriter, r *http.Request, err error) {
+	switch {
+	case errors.Is(err, service.ErrGenerationDisabled):
+		i18n.Error(w, r, http.StatusForbidden, "Test data generation is disabled")
+	case errors.Is(err, service.ErrGenerationRunning):
+		i18n.Error(w, r, http.StatusConflict, "A generation is already running")
+	case errors.Is(err, service.ErrGenerationNotFound):
+		i18n.Error(w, r, http.StatusNotFound, "Generation not found")
+	default:
+		writeError(w, r, err)
+	}
+}
@@ -0,0 +1,77 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/gorilla/mux"
+
+	"user-service/internal/auth"
+	"user-service/internal/i18n"
+	"user-service/internal/store"
+)
+
+type twoFactorCodeRequest struct {
+	Code string `json:"code"`
+}
+
+// checkTwoFactorOwner lets only the user themselves, or an admin, set up
+// their second factor; otherwise any users:write caller could enrol a
+// secret of their own on someone else's account.
+func (h *Handler) checkTwoFactorOwner(w http.ResponseWriter, r *http.Request, id string) bool {
+	if h.auth.CallerIsUser(r, id) || h.auth.CallerHasScope(r, auth.ScopeAdminUsers) {
+		return true
+	}
+	i18n.Error(w, r, http.StatusForbidden, "Missing required scope: %s", auth.ScopeAdminUsers)
+	return false
+}
+
+func (h *Handler) twoFactorSetup(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	id := vars["id"]
+	if !h.checkTwoFactorOwner(w, r, id) {
+		return
+	}
+
+	setup, err := h.twoFactor.Setup(r.Context(), id)
+	if errors.Is(err, store.ErrTwoFactorEnabled) {
+		i18n.Error(w, r, http.StatusConflict, "Two-factor authentication already enabled")
+		return
+	}
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	writeJSON(w, r, http.StatusOK, setup)
+}
+
+func (h *Handler) twoFactorVerify(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	id := vars["id"]
+	if !h.checkTwoFactorOwner(w, r, id) {
+		return
+	}
+
+	var req twoFactorCodeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeDecodeError(w, r, err)
+		return
+	}
+
+	err := h.twoFactor.Verify(r.Context(), id, req.Code)
+	switch {
+	case errors.Is(err, store.ErrTwoFactorNotSetUp):
+		i18n.Error(w, r, http.StatusConflict, "Two-factor authentication not set up")
+		return
+	case errors.Is(err, store.ErrInvalidTwoFactorCode):
+		i18n.Error(w, r, http.StatusUnauthorized, "Invalid code")
+		return
+	case err != nil:
+		writeError(w, r, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,53 @@
+package handler
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"user-service/internal/auth"
+	"user-service/internal/config"
+)
+
+func TestTwoFactorSetupIsForTheUserOrAnAdmin(t *testing.T) {
+	src, err := config.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	tokens := auth.LoadTokenIssuer(src)
+	a := auth.NewAuthenticator(auth.Config{Enabled: true, APIKeys: map[string][]string{
+		"writer": {auth.ScopeUsersWrite},
+		"admin":  {auth.ScopeUsersWrite, auth.ScopeAdminUsers},
+	}}, tokens)
+	h := New(Options{Auth: a})
+	next := a.RequireScopes([]string{auth.ScopeUsersWrite}, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if h.checkTwoFactorOwner(w, r, "1") {
+			w.WriteHeader(http.StatusNoContent)
+		}
+	}))
+
+	token := func(subject string) string {
+		token, err := tokens.Issue(subject, auth.TokenTypeAccess, []string{auth.ScopeUsersWrite})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return "Bearer " + token
+	}
+	for _, tc := range []struct {
+		name, header, value string
+		want                int
+	}{
+		{"own token", "Authorization", token("1"), http.StatusNoContent},
+		{"other user's token", "Authorization", token("2"), http.StatusForbidden},
+		{"users:write key", "X-API-Key", "writer", http.StatusForbidden},
+		{"admin key", "X-API-Key", "admin", http.StatusNoContent},
+	} {
+		r := httptest.NewRequest(http.MethodPost, "/users/1/2fa/setup", nil)
+		r.Header.Set(tc.header, tc.value)
+		w := httptest.NewRecorder()
+		next.ServeHTTP(w, r)
+		if w.Code != tc.want {
+			t.Errorf("%s: status %d, want %d", tc.name, w.Code, tc.want)
+		}
+	}
+}
@@ -0,0 +1,284 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+
+	"user-service/internal/auth"
+	"user-service/internal/i18n"
+	"user-service/internal/service"
+	"user-service/internal/store"
+)
+
+// checkGrant rejects the request if it hands out scopes the caller
+// doesn't hold.
+func (h *Handler) checkGrant(w http.ResponseWriter, r *http.Request, scopes []string) bool {
+	if missing := h.auth.UngrantedScopes(r, scopes); len(missing) > 0 {
+		i18n.Error(w, r, http.StatusForbidden, "Cannot grant scope: %s", strings.Join(missing, ", "))
+		return false
+	}
+	return true
+}
+
+func (h *Handler) createUser(w http.ResponseWriter, r *http.Request) {
+	var user store.User
+	if err := json.NewDecoder(r.Body).Decode(&user); err != nil {
+		writeDecodeError(w, r, err)
+		return
+	}
+	if !h.checkGrant(w, r, user.Scopes) {
+		return
+	}
+	// Starting a user out suspended or locked skips the lifecycle
+	// routes, which need admin:users.
+	if user.Status != "" && user.Status != store.StatusActive && store.ValidStatus(user.Status) &&
+		!h.auth.CallerHasScope(r, auth.ScopeAdminUsers) {
+		i18n.Error(w, r, http.StatusForbidden, "Missing required scope: %s", auth.ScopeAdminUsers)
+		return
+	}
+
+	user, err := h.users.Create(r.Context(), user)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	h.writeUser(w, r, http.StatusCreated, user)
+}
+
+func (h *Handler) getUser(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	id := vars["id"]
+
+	// Read before the user, so a change in between is seen as newer than
+	// the version reported rather than missed.
+	version := h.hub.Version(id)
+	user, err := h.users.Get(r.Context(), id)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	w.Header().Set("X-User-Version", strconv.FormatUint(version, 10))
+	if user.ID != id {
+		// A merged ID; point clients at the one to use from now on.
+		w.Header().Set("Content-Location", strings.TrimSuffix(r.URL.Path, id)+user.ID)
+	}
+
+	h.writeUser(w, r, http.StatusOK, user)
+}
+
+// userExists answers HEAD /users/{id} with just a status, 200 or 404,
+// for callers that only need to know whether the user exists.
+func (h *Handler) userExists(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	id := vars["id"]
+
+	if _, err := h.users.Get(r.Context(), id); err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+// checkAvailability tells signup forms whether an email or name is free.
+// It needs no credentials, so each client IP gets only a few checks per
+// window to keep it from being used to enumerate users.
+func (h *Handler) checkAvailability(w http.ResponseWriter, r *http.Request) {
+	if ok, retryAfter := h.checkLimit.Allow(clientIP(r)); !ok {
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+		i18n.Error(w, r, http.StatusTooManyRequests, "Too many checks, try again later")
+		return
+	}
+
+	query := r.URL.Query()
+	result, err := h.users.CheckAvailable(r.Context(), query.Get("email"), query.Get("name"))
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	writeJSON(w, r, http.StatusOK, result)
+}
+
+type batchGetRequest struct {
+	IDs []string `json:"ids"`
+}
+
+type batchGetResponse struct {
+	Users   []any    `json:"users"`
+	Missing []string `json:"missing"`
+}
+
+func (h *Handler) writeBatch(w http.ResponseWriter, r *http.Request, ids []string) {
+	users, missing, err := h.users.GetMany(r.Context(), ids)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	w.Header().Set("Content-Type", contentType(r))
+	writeJSON(w, r, http.StatusOK, batchGetResponse{Users: h.renderUsers(w, r, users), Missing: missing})
+}
+
+func (h *Handler) batchGetUsers(w http.ResponseWriter, r *http.Request) {
+	var req batchGetRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeDecodeError(w, r, err)
+		return
+	}
+
+	h.writeBatch(w, r, req.IDs)
+}
+
+func (h *Handler) getAllUsers(w http.ResponseWriter, r *http.Request) {
+	if ids := r.URL.Query().Get("ids"); ids != "" {
+		h.writeBatch(w, r, strings.Split(ids, ","))
+		return
+	}
+
+	page, ok := parsePage(r)
+	if !ok {
+		i18n.Error(w, r, http.StatusBadRequest, "Invalid limit or offset")
+		return
+	}
+
+	var phoneVerified *bool
+	if v := r.URL.Query().Get("phone_verified"); v != "" {
+		verified, err := strconv.ParseBool(v)
+		if err != nil {
+			i18n.Error(w, r, http.StatusBadRequest, "phone_verified must be true or false")
+			return
+		}
+		phoneVerified = &verified
+	}
+
+	opts := service.ListOptions{
+		Status: r.URL.Query().Get("status"),
+		Query:  r.URL.Query().Get("q"),
+		// Searching emails the caller can't see would reveal them.
+		SearchEmails:  h.auth.CallerHasScope(r, auth.ScopeUsersReadPII),
+		PhoneVerified: phoneVerified,
+		Offset:        page.Offset,
+		Limit:         page.Limit,
+	}
+	users, total, err := h.users.List(r.Context(), opts)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	page.Total = total
+	h.writeUsers(w, r, users, page)
+}
+
+func (h *Handler) updateUser(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	id := vars["id"]
+
+	var user store.User
+	if err := json.NewDecoder(r.Body).Decode(&user); err != nil {
+		writeDecodeError(w, r, err)
+		return
+	}
+
+	user.ID = id
+	if !h.checkGrant(w, r, user.Scopes) {
+		return
+	}
+	if r.URL.Query().Get("upsert") == "true" {
+		user, created, err := h.users.Upsert(r.Context(), user)
+		if err != nil {
+			writeError(w, r, err)
+			return
+		}
+		status := http.StatusOK
+		if created {
+			status = http.StatusCreated
+		}
+		h.writeUser(w, r, status, user)
+		return
+	}
+
+	user, err := h.users.Update(r.Context(), user)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	h.writeUser(w, r, http.StatusOK, user)
+}
+
+func (h *Handler) deleteUser(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	id := vars["id"]
+
+	if err := h.users.Delete(r.Context(), id); err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// transition returns a handler moving the user to the given status.
+func (h *Handler) transition(status string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+		id := vars["id"]
+
+		user, err := h.users.Transition(r.Context(), id, status)
+		if errors.Is(err, store.ErrInvalidTransition) {
+			i18n.Error(w, r, http.StatusConflict, "Cannot move user to status %s", status)
+			return
+		}
+		if err != nil {
+			writeError(w, r, err)
+			return
+		}
+
+		h.writeUser(w, r, http.StatusOK, user)
+	}
+}
+
+// normalizeEmails rewrites stored emails under the current policy and
+// removes the duplicates it finds. It only reports what it would do
+// unless apply=true.
+func (h *Handler) normalizeEmails(w http.ResponseWriter, r *http.Request) {
+	migration, err := h.users.NormalizeEmails(r.Context(), r.URL.Query().Get("apply") == "true")
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	writeJSON(w, r, http.StatusOK, migration)
+}
+
+type mergeRequest struct {
+	SourceID string `json:"source_id"`
+}
+
+// mergeUser folds the duplicate account named in the body into the user
+// in the path and returns the result.
+func (h *Handler) mergeUser(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	id := vars["id"]
+
+	var req mergeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeDecodeError(w, r, err)
+		return
+	}
+
+	user, err := h.users.Merge(r.Context(), id, req.SourceID)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	h.writeUser(w, r, http.StatusOK, user)
+}
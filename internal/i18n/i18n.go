@@ -0,0 +1,166 @@
+// Package i18n translates the service's error and validation messages
+// into the language asked for by the Accept-Language header.
+//
+// Messages are keyed by their English format string, so English needs no
+// bundle and untranslated messages fall back to it. Bundles are JSON
+// objects of format string to translation, one file per language named
+// after its tag (de.json, pt-BR.json); the embedded ones can be extended
+// or overridden from a directory at startup.
+package i18n
+
+import (
+	"context"
+	"embed"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// DefaultLanguage is the language messages are written in.
+const DefaultLanguage = "en"
+
+//go:embed locales/*.json
+var embedded embed.FS
+
+// Catalog holds the translations of each language.
+type Catalog struct {
+	bundles map[string]map[string]string
+}
+
+// Load returns the embedded bundles, overlaid by the *.json bundles in
+// dir when it is not empty.
+func Load(dir string) (*Catalog, error) {
+	c := &Catalog{bundles: make(map[string]map[string]string)}
+	if err := c.addDir(embedded, "locales"); err != nil {
+		return nil, err
+	}
+	if dir != "" {
+		if err := c.addDir(os.DirFS(dir), "."); err != nil {
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+func (c *Catalog) addDir(fsys fs.FS, dir string) error {
+	files, err := fs.Glob(fsys, path.Join(dir, "*.json"))
+	if err != nil {
+		return err
+	}
+	for _, file := range files {
+		data, err := fs.ReadFile(fsys, file)
+		if err != nil {
+			return err
+		}
+		var messages map[string]string
+		if err := json.Unmarshal(data, &messages); err != nil {
+			return fmt.Errorf("i18n: %s: %w", file, err)
+		}
+		lang := strings.ToLower(strings.TrimSuffix(path.Base(file), ".json"))
+		if c.bundles[lang] == nil {
+			c.bundles[lang] = make(map[string]string)
+		}
+		for key, message := range messages {
+			c.bundles[lang][key] = message
+		}
+	}
+	return nil
+}
+
+// Languages lists the languages with a bundle, plus the default.
+func (c *Catalog) Languages() []string {
+	langs := []string{DefaultLanguage}
+	for lang := range c.bundles {
+		if lang != DefaultLanguage {
+			langs = append(langs, lang)
+		}
+	}
+	sort.Strings(langs[1:])
+	return langs
+}
+
+// Match picks the best supported language for an Accept-Language header,
+// trying each requested tag and then its base language ("de-CH" → "de").
+func (c *Catalog) Match(acceptLanguage string) string {
+	type choice struct {
+		tag string
+		q   float64
+	}
+	var choices []choice
+	for _, part := range strings.Split(acceptLanguage, ",") {
+		tag, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		q := 1.0
+		if v, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			parsed, err := strconv.ParseFloat(v, 64)
+			if err != nil {
+				continue
+			}
+			q = parsed
+		}
+		if tag != "" && q > 0 {
+			choices = append(choices, choice{strings.ToLower(tag), q})
+		}
+	}
+	sort.SliceStable(choices, func(i, j int) bool { return choices[i].q > choices[j].q })
+
+	for _, ch := range choices {
+		base, _, _ := strings.Cut(ch.tag, "-")
+		for _, tag := range []string{ch.tag, base} {
+			if tag == DefaultLanguage {
+				return DefaultLanguage
+			}
+			if _, ok := c.bundles[tag]; ok {
+				return tag
+			}
+		}
+	}
+	return DefaultLanguage
+}
+
+// Sprintf formats the message in the language, falling back to English
+// when it has no translation.
+func (c *Catalog) Sprintf(lang, format string, args ...any) string {
+	if c != nil {
+		if translated, ok := c.bundles[lang][format]; ok {
+			format = translated
+		}
+	}
+	return fmt.Sprintf(format, args...)
+}
+
+type localeKey struct{}
+
+type locale struct {
+	catalog *Catalog
+	lang    string
+}
+
+// Middleware resolves each request's language so handlers can reply with
+// Error, and reports it in the Content-Language header.
+func (c *Catalog) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lang := c.Match(r.Header.Get("Accept-Language"))
+		w.Header().Set("Content-Language", lang)
+		w.Header().Add("Vary", "Accept-Language")
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), localeKey{}, locale{c, lang})))
+	})
+}
+
+// Sprintf formats the message in the request's language.
+func Sprintf(r *http.Request, format string, args ...any) string {
+	loc, _ := r.Context().Value(localeKey{}).(locale)
+	return loc.catalog.Sprintf(loc.lang, format, args...)
+}
+
+// Error replies like http.Error with the message translated into the
+// request's language. format is the English message and the key of its
+// translations.
+func Error(w http.ResponseWriter, r *http.Request, status int, format string, args ...any) {
+	http.Error(w, Sprintf(r, format, args...), status)
+}
@@ -0,0 +1,80 @@
+// Package idgen generates IDs for new users that don't bring their own.
+// Besides random UUIDs it offers IDs that sort by creation time, for
+// downstream systems that page or partition by ID.
+package idgen
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"sync/atomic"
+
+	"user-service/internal/config"
+)
+
+// Generator hands out new IDs. It is safe for concurrent use and never
+// returns the same ID twice within a process.
+type Generator interface {
+	NewID() (string, error)
+}
+
+// Load returns the generator named by ID_STRATEGY: uuid, the default,
+// ulid, ksuid, snowflake, with the node from ID_NODE, or sequential.
+func Load(src *config.Source) (Generator, error) {
+	switch name := src.String("ID_STRATEGY", "uuid"); name {
+	case "uuid":
+		return UUID{}, nil
+	case "ulid":
+		return NewULID(), nil
+	case "ksuid":
+		return KSUID{}, nil
+	case "snowflake":
+		return NewSnowflake(src.Int("ID_NODE", 0))
+	case "sequential":
+		return &Sequential{}, nil
+	default:
+		return nil, fmt.Errorf("unknown ID_STRATEGY %q", name)
+	}
+}
+
+// UUID generates random version 4 UUIDs, such as
+// 0b5e9d44-7a43-4f0e-9c1a-2b5d1f0c8e7a. They don't sort by time.
+type UUID struct{}
+
+func (UUID) NewID() (string, error) {
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return "", err
+	}
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	h := hex.EncodeToString(b[:])
+	return h[:8] + "-" + h[8:12] + "-" + h[12:16] + "-" + h[16:20] + "-" + h[20:], nil
+}
+
+// Sequential numbers IDs 1, 2, 3 and so on, the way an auto-increment
+// column does. The numbers sort as integers, not as strings, so they
+// suit backends that store IDs as numbers.
+type Sequential struct {
+	last atomic.Uint64
+}
+
+func (s *Sequential) NewID() (string, error) {
+	return strconv.FormatUint(s.last.Add(1), 10), nil
+}
+
+// Skip makes later IDs follow id if it is a number, so that IDs already
+// in use are not handed out.
+func (s *Sequential) Skip(id string) {
+	n, err := strconv.ParseUint(id, 10, 64)
+	if err != nil {
+		return
+	}
+	for {
+		last := s.last.Load()
+		if n <= last || s.last.CompareAndSwap(last, n) {
+			return
+		}
+	}
+}
@@ -0,0 +1,151 @@
+package idgen
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// ULID generates ULIDs: a millisecond timestamp and 80 random bits in 26
+// Crockford base32 characters, such as 01JA2Z6V8J5M7XQ4W3E9R0T1YB. Within
+// one millisecond the random part counts up instead, so IDs from one
+// generator sort in the order they were made.
+type ULID struct {
+	mu     sync.Mutex
+	lastMS uint64
+	random [10]byte
+}
+
+func NewULID() *ULID {
+	return &ULID{}
+}
+
+const crockford = "0123456789ABCDEFGHJKMNPQRSTVWXYZ"
+
+func (u *ULID) NewID() (string, error) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	ms := max(uint64(time.Now().UnixMilli()), u.lastMS)
+	if ms == u.lastMS && increment(u.random[:]) {
+		// The random part ran out; borrow the next millisecond.
+		ms++
+	}
+	if ms != u.lastMS {
+		if _, err := rand.Read(u.random[:]); err != nil {
+			return "", err
+		}
+		u.lastMS = ms
+	}
+
+	var id [16]byte
+	binary.BigEndian.PutUint16(id[0:], uint16(ms>>32))
+	binary.BigEndian.PutUint32(id[2:], uint32(ms))
+	copy(id[6:], u.random[:])
+	// 26 characters of 5 bits hold 130 bits, so the first has only 3.
+	hi, lo := binary.BigEndian.Uint64(id[:8]), binary.BigEndian.Uint64(id[8:])
+	var out [26]byte
+	for i := len(out) - 1; i >= 0; i-- {
+		out[i] = crockford[lo&31]
+		lo = lo>>5 | hi<<59
+		hi >>= 5
+	}
+	return string(out[:]), nil
+}
+
+// increment adds one to the big-endian number in b, reporting whether it
+// wrapped around to zero.
+func increment(b []byte) bool {
+	for i := len(b) - 1; i >= 0; i-- {
+		b[i]++
+		if b[i] != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// KSUID generates KSUIDs: a timestamp in seconds and 128 random bits in
+// 27 base62 characters, such as 2nVZlPDvDL9x2XqH3U4dq5p8tEk. They sort
+// by the second they were made in, and randomly within it.
+type KSUID struct{}
+
+// ksuidEpoch is the KSUID timestamps' zero, 2014-05-13T16:53:20Z.
+const ksuidEpoch = 1400000000
+
+const base62 = "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz"
+
+func (KSUID) NewID() (string, error) {
+	var id [20]byte
+	binary.BigEndian.PutUint32(id[:4], uint32(time.Now().Unix()-ksuidEpoch))
+	if _, err := rand.Read(id[4:]); err != nil {
+		return "", err
+	}
+	// Divide the 160-bit number by 62 until nothing is left, filling the
+	// digits in from the right; the rest stay zero.
+	var out [27]byte
+	for i := range out {
+		out[i] = base62[0]
+	}
+	num := id[:]
+	for i := len(out) - 1; len(num) > 0; i-- {
+		var rem int
+		var quotient []byte
+		for _, b := range num {
+			acc := rem<<8 | int(b)
+			if q := byte(acc / 62); q != 0 || len(quotient) > 0 {
+				quotient = append(quotient, q)
+			}
+			rem = acc % 62
+		}
+		out[i] = base62[rem]
+		num = quotient
+	}
+	return string(out[:]), nil
+}
+
+// Snowflake generates Twitter-style snowflake IDs: 41 bits of
+// milliseconds since 2010-11-04T01:42:54.657Z, a 10-bit node and a 12-bit
+// sequence, written in decimal. Every instance needs its own node.
+// Generation waits for the next millisecond after 4096 IDs in one, and
+// doesn't go back if the clock does.
+type Snowflake struct {
+	node   uint64
+	mu     sync.Mutex
+	lastMS uint64
+	seq    uint64
+}
+
+const (
+	snowflakeEpoch   = 1288834974657
+	snowflakeMaxNode = 1<<10 - 1
+	snowflakeMaxSeq  = 1<<12 - 1
+)
+
+func NewSnowflake(node int) (*Snowflake, error) {
+	if node < 0 || node > snowflakeMaxNode {
+		return nil, fmt.Errorf("snowflake node %d is outside 0..%d", node, snowflakeMaxNode)
+	}
+	return &Snowflake{node: uint64(node)}, nil
+}
+
+func (s *Snowflake) NewID() (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ms := max(uint64(time.Now().UnixMilli()-snowflakeEpoch), s.lastMS)
+	if ms == s.lastMS {
+		s.seq = (s.seq + 1) & snowflakeMaxSeq
+		if s.seq == 0 {
+			for ms <= s.lastMS {
+				time.Sleep(100 * time.Microsecond)
+				ms = uint64(time.Now().UnixMilli() - snowflakeEpoch)
+			}
+		}
+	} else {
+		s.seq = 0
+	}
+	s.lastMS = ms
+	return strconv.FormatUint(ms<<22|s.node<<12|s.seq, 10), nil
+}
@@ -0,0 +1,309 @@
+// Package loadtest drives concurrent CRUD traffic against a running
+// instance and reports latency percentiles per operation.
+package loadtest
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	opCreate = "create"
+	opGet    = "get"
+	opUpdate = "update"
+	opDelete = "delete"
+)
+
+var ops = []string{opCreate, opGet, opUpdate, opDelete}
+
+// Config is a load test run.
+type Config struct {
+	URL         string
+	Concurrency int
+	Duration    time.Duration
+	// Mix weighs how often each operation is picked.
+	Mix    map[string]int
+	APIKey string
+	Token  string
+}
+
+// Run parses the loadtest subcommand's arguments, runs it and writes the
+// report to out.
+func Run(args []string, out io.Writer) error {
+	fs := flag.NewFlagSet("loadtest", flag.ContinueOnError)
+	fs.SetOutput(out)
+	cfg := Config{}
+	fs.StringVar(&cfg.URL, "url", "http://localhost:8080", "base URL of the instance under test")
+	fs.IntVar(&cfg.Concurrency, "c", 10, "concurrent workers")
+	fs.DurationVar(&cfg.Duration, "d", 10*time.Second, "how long to run")
+	mix := fs.String("mix", "create=10,get=70,update=15,delete=5", "operation weights")
+	fs.StringVar(&cfg.APIKey, "api-key", "", "X-API-Key to send")
+	fs.StringVar(&cfg.Token, "token", "", "bearer access token to send")
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return nil
+		}
+		return err
+	}
+
+	var err error
+	if cfg.Mix, err = parseMix(*mix); err != nil {
+		return err
+	}
+	if cfg.Concurrency < 1 {
+		return errors.New("-c must be at least 1")
+	}
+
+	report := New(cfg).Run()
+	report.Write(out)
+	return nil
+}
+
+// parseMix parses op=weight pairs separated by commas.
+func parseMix(s string) (map[string]int, error) {
+	mix := make(map[string]int)
+	total := 0
+	for _, pair := range strings.Split(s, ",") {
+		op, weight, ok := strings.Cut(strings.TrimSpace(pair), "=")
+		if !ok {
+			return nil, fmt.Errorf("-mix: %q is not op=weight", pair)
+		}
+		n, err := strconv.Atoi(weight)
+		if err != nil || n < 0 {
+			return nil, fmt.Errorf("-mix: invalid weight for %s", op)
+		}
+		known := false
+		for _, o := range ops {
+			known = known || o == op
+		}
+		if !known {
+			return nil, fmt.Errorf("-mix: unknown operation %q", op)
+		}
+		mix[op] = n
+		total += n
+	}
+	if total == 0 {
+		return nil, errors.New("-mix: weights add up to zero")
+	}
+	return mix, nil
+}
+
+// Tester runs a load test.
+type Tester struct {
+	cfg    Config
+	client *http.Client
+	run    string
+
+	mu      sync.Mutex
+	results map[string]*result
+}
+
+type result struct {
+	latencies []time.Duration
+	errors    int
+}
+
+func New(cfg Config) *Tester {
+	return &Tester{
+		cfg:     cfg,
+		client:  &http.Client{Timeout: 10 * time.Second, Transport: &http.Transport{MaxIdleConnsPerHost: cfg.Concurrency}},
+		run:     strconv.FormatInt(time.Now().UnixNano(), 36),
+		results: make(map[string]*result),
+	}
+}
+
+// Run fires traffic from every worker until the duration is up, then
+// deletes the users the workers left behind.
+func (t *Tester) Run() Report {
+	deadline := time.Now().Add(t.cfg.Duration)
+	start := time.Now()
+	var wg sync.WaitGroup
+	for i := 0; i < t.cfg.Concurrency; i++ {
+		wg.Add(1)
+		go func(worker int) {
+			defer wg.Done()
+			t.work(worker, deadline)
+		}(i)
+	}
+	wg.Wait()
+	return t.report(time.Since(start))
+}
+
+// work runs one worker. Each worker only touches the users it created
+// itself, so operations don't race each other into spurious 404s.
+func (t *Tester) work(worker int, deadline time.Time) {
+	rng := rand.New(rand.NewSource(time.Now().UnixNano() + int64(worker)))
+	var ids []string
+	created := 0
+	for time.Now().Before(deadline) {
+		op := t.pick(rng)
+		if op != opCreate && len(ids) == 0 {
+			op = opCreate
+		}
+
+		switch op {
+		case opCreate:
+			id := fmt.Sprintf("lt-%s-%d-%d", t.run, worker, created)
+			created++
+			if t.do(op, http.MethodPost, "/users", user(id, "")) {
+				ids = append(ids, id)
+			}
+		case opGet:
+			t.do(op, http.MethodGet, "/users/"+ids[rng.Intn(len(ids))], nil)
+		case opUpdate:
+			id := ids[rng.Intn(len(ids))]
+			t.do(op, http.MethodPut, "/users/"+id, user(id, " (updated)"))
+		case opDelete:
+			i := rng.Intn(len(ids))
+			if t.do(op, http.MethodDelete, "/users/"+ids[i], nil) {
+				ids = append(ids[:i], ids[i+1:]...)
+			}
+		}
+	}
+
+	for _, id := range ids {
+		t.send(http.MethodDelete, "/users/"+id, nil)
+	}
+}
+
+func (t *Tester) pick(rng *rand.Rand) string {
+	total := 0
+	for _, w := range t.cfg.Mix {
+		total += w
+	}
+	n := rng.Intn(total)
+	for _, op := range ops {
+		if n < t.cfg.Mix[op] {
+			return op
+		}
+		n -= t.cfg.Mix[op]
+	}
+	return opGet
+}
+
+func user(id, suffix string) map[string]string {
+	return map[string]string{"id": id, "name": "Load Test " + id + suffix, "email": id + "@loadtest.invalid"}
+}
+
+// do times one request and records it under op. It reports whether the
+// request succeeded with a 2xx status.
+func (t *Tester) do(op, method, path string, body any) bool {
+	start := time.Now()
+	ok := t.send(method, path, body)
+	elapsed := time.Since(start)
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	res := t.results[op]
+	if res == nil {
+		res = &result{}
+		t.results[op] = res
+	}
+	res.latencies = append(res.latencies, elapsed)
+	if !ok {
+		res.errors++
+	}
+	return ok
+}
+
+func (t *Tester) send(method, path string, body any) bool {
+	var reader io.Reader
+	if body != nil {
+		payload, err := json.Marshal(body)
+		if err != nil {
+			return false
+		}
+		reader = bytes.NewReader(payload)
+	}
+	req, err := http.NewRequest(method, strings.TrimSuffix(t.cfg.URL, "/")+path, reader)
+	if err != nil {
+		return false
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if t.cfg.APIKey != "" {
+		req.Header.Set("X-API-Key", t.cfg.APIKey)
+	}
+	if t.cfg.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+t.cfg.Token)
+	}
+
+	resp, err := t.client.Do(req)
+	if err != nil {
+		return false
+	}
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+	return resp.StatusCode >= 200 && resp.StatusCode < 300
+}
+
+// Report summarizes a run.
+type Report struct {
+	Elapsed time.Duration
+	Ops     []OpReport
+}
+
+// OpReport is the outcome of one operation type.
+type OpReport struct {
+	Op            string
+	Count, Errors int
+	P50, P90, P99 time.Duration
+	Max           time.Duration
+}
+
+func (t *Tester) report(elapsed time.Duration) Report {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	report := Report{Elapsed: elapsed}
+	for _, op := range ops {
+		res := t.results[op]
+		if res == nil {
+			continue
+		}
+		sort.Slice(res.latencies, func(i, j int) bool { return res.latencies[i] < res.latencies[j] })
+		report.Ops = append(report.Ops, OpReport{
+			Op:     op,
+			Count:  len(res.latencies),
+			Errors: res.errors,
+			P50:    percentile(res.latencies, 50),
+			P90:    percentile(res.latencies, 90),
+			P99:    percentile(res.latencies, 99),
+			Max:    res.latencies[len(res.latencies)-1],
+		})
+	}
+	return report
+}
+
+// percentile returns the nearest-rank percentile of sorted latencies.
+func percentile(sorted []time.Duration, p int) time.Duration {
+	rank := (p*len(sorted) + 99) / 100
+	if rank < 1 {
+		rank = 1
+	}
+	return sorted[rank-1]
+}
+
+func (r Report) Write(w io.Writer) {
+	total := 0
+	fmt.Fprintf(w, "%-8s %8s %7s %10s %10s %10s %10s\n", "op", "count", "errors", "p50", "p90", "p99", "max")
+	for _, op := range r.Ops {
+		total += op.Count
+		fmt.Fprintf(w, "%-8s %8d %7d %10s %10s %10s %10s\n", op.Op, op.Count, op.Errors,
+			round(op.P50), round(op.P90), round(op.P99), round(op.Max))
+	}
+	fmt.Fprintf(w, "%d requests in %s (%.0f req/s)\n", total, r.Elapsed.Round(time.Millisecond), float64(total)/r.Elapsed.Seconds())
+}
+
+func round(d time.Duration) time.Duration {
+	return d.Round(time.Microsecond)
+}
@@ -0,0 +1,239 @@
+// Package scheduler runs recurring maintenance jobs inside the service,
+// each on its own schedule, and keeps track of how their runs went.
+package scheduler
+
+import (
+	"context"
+	"expvar"
+	"fmt"
+	"log"
+	"math/rand"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"user-service/internal/config"
+)
+
+var jobMetrics = expvar.NewMap("jobs")
+
+// Off in JOB_SCHEDULES disables a job.
+const Off = "off"
+
+// Scheduler runs jobs on their schedules. Each job runs in its own
+// goroutine, one run at a time, so a slow run delays the job's next run
+// rather than overlapping it.
+type Scheduler struct {
+	// jitter delays each run by up to this fraction of the time until
+	// it, so instances started together don't run jobs in lockstep.
+	jitter float64
+	// schedules overrides the default schedules of jobs by name.
+	schedules map[string]string
+
+	mu   sync.Mutex
+	jobs []*job
+	// running counts the job loops started by Run, for Wait.
+	running sync.WaitGroup
+}
+
+type job struct {
+	name     string
+	schedule Schedule
+	run      func(context.Context) error
+	metrics  *expvar.Map
+
+	// status is guarded by Scheduler.mu.
+	status JobStatus
+}
+
+// JobStatus is a job's schedule and how its last run went.
+type JobStatus struct {
+	Name     string `json:"name"`
+	Schedule string `json:"schedule"`
+	Running  bool   `json:"running"`
+	Runs     int    `json:"runs"`
+	Failures int    `json:"failures"`
+	// LastRun is when the last run started and LastDurationMS how long it
+	// took; LastError is its error, if it failed.
+	LastRun        *time.Time `json:"last_run,omitempty"`
+	LastDurationMS int64      `json:"last_duration_ms"`
+	LastError      string     `json:"last_error,omitempty"`
+	NextRun        *time.Time `json:"next_run,omitempty"`
+}
+
+// New returns a scheduler that delays runs by up to jitter, a fraction
+// of the wait before each run, and takes schedules by job name in
+// preference to the defaults jobs are added with.
+func New(jitter float64, schedules map[string]string) *Scheduler {
+	return &Scheduler{jitter: min(max(jitter, 0), 1), schedules: schedules}
+}
+
+// Load returns a scheduler configured by JOB_JITTER, 0.1 by default, and
+// JOB_SCHEDULES, semicolon-separated name=schedule entries such as
+// "stats-refresh=5m;phone-codes=off".
+func Load(src *config.Source) *Scheduler {
+	schedules := make(map[string]string)
+	for _, entry := range strings.Split(src.String("JOB_SCHEDULES", ""), ";") {
+		name, spec, ok := strings.Cut(strings.TrimSpace(entry), "=")
+		if ok && name != "" {
+			schedules[strings.TrimSpace(name)] = strings.TrimSpace(spec)
+		}
+	}
+	return New(src.Float("JOB_JITTER", 0.1), schedules)
+}
+
+// Add registers a job to run on its configured schedule, or on spec
+// when none is configured. It must be called before Run.
+func (s *Scheduler) Add(name, spec string, run func(context.Context) error) error {
+	if configured, ok := s.schedules[name]; ok {
+		spec = configured
+	}
+	j := &job{name: name, run: run, status: JobStatus{Name: name, Schedule: spec}}
+	if spec != Off {
+		schedule, err := ParseSchedule(spec)
+		if err != nil {
+			return fmt.Errorf("job %s: %w", name, err)
+		}
+		j.schedule = schedule
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, other := range s.jobs {
+		if other.name == name {
+			return fmt.Errorf("job %s added twice", name)
+		}
+	}
+	j.metrics = new(expvar.Map).Init()
+	jobMetrics.Set(name, j.metrics)
+	s.jobs = append(s.jobs, j)
+	return nil
+}
+
+// Run starts the jobs that aren't off and returns. They stop once ctx
+// is done.
+func (s *Scheduler) Run(ctx context.Context) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for name := range s.schedules {
+		if !s.has(name) {
+			log.Printf("jobs: JOB_SCHEDULES names unknown job %s", name)
+		}
+	}
+	for _, j := range s.jobs {
+		if j.schedule != nil {
+			s.running.Add(1)
+			go func(j *job) {
+				defer s.running.Done()
+				s.loop(ctx, j)
+			}(j)
+		}
+	}
+}
+
+// Wait blocks until the jobs have stopped once Run's context is done,
+// which lets runs in progress finish, or until ctx is done first.
+func (s *Scheduler) Wait(ctx context.Context) error {
+	stopped := make(chan struct{})
+	go func() {
+		s.running.Wait()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// has reports whether a job is named name. Callers must hold s.mu.
+func (s *Scheduler) has(name string) bool {
+	for _, j := range s.jobs {
+		if j.name == name {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *Scheduler) loop(ctx context.Context, j *job) {
+	for {
+		now := time.Now()
+		next := j.schedule.Next(now)
+		if next.IsZero() {
+			return
+		}
+		if wait := next.Sub(now); s.jitter > 0 && wait > 0 {
+			next = next.Add(time.Duration(rand.Int63n(int64(float64(wait)*s.jitter) + 1)))
+		}
+		nextRun := next.UTC()
+		s.mu.Lock()
+		j.status.NextRun = &nextRun
+		s.mu.Unlock()
+
+		timer := time.NewTimer(time.Until(next))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+			s.runOnce(ctx, j)
+		}
+	}
+}
+
+// runOnce runs the job and records the outcome. A panicking job counts
+// as failed rather than taking the service down.
+func (s *Scheduler) runOnce(ctx context.Context, j *job) {
+	start := time.Now()
+	s.mu.Lock()
+	j.status.Running = true
+	j.status.NextRun = nil
+	s.mu.Unlock()
+
+	err := func() (err error) {
+		defer func() {
+			if p := recover(); p != nil {
+				err = fmt.Errorf("panic: %v", p)
+			}
+		}()
+		return j.run(ctx)
+	}()
+	elapsed := time.Since(start)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	startedAt := start.UTC()
+	j.status.Running = false
+	j.status.Runs++
+	j.status.LastRun = &startedAt
+	j.status.LastDurationMS = elapsed.Milliseconds()
+	j.status.LastError = ""
+	j.metrics.Add("runs", 1)
+	j.metrics.Set("last_duration_ms", intVar(elapsed.Milliseconds()))
+	if err != nil {
+		log.Printf("jobs: %s: %v", j.name, err)
+		j.status.Failures++
+		j.status.LastError = err.Error()
+		j.metrics.Add("failures", 1)
+	}
+}
+
+func intVar(n int64) *expvar.Int {
+	v := new(expvar.Int)
+	v.Set(n)
+	return v
+}
+
+// Status lists the jobs by name.
+func (s *Scheduler) Status() []JobStatus {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	list := make([]JobStatus, len(s.jobs))
+	for i, j := range s.jobs {
+		list[i] = j.status
+	}
+	sort.Slice(list, func(i, k int) bool { return list[i].Name < list[k].Name })
+	return list
+}
@@ -0,0 +1,36 @@
+package scheduler
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestWaitForRunningJobs(t *testing.T) {
+	s := New(0, nil)
+	started, finished := make(chan struct{}), make(chan struct{})
+	err := s.Add("slow", "10ms", func(ctx context.Context) error {
+		close(started)
+		<-ctx.Done()
+		close(finished)
+		return ctx.Err()
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	s.Run(ctx)
+	<-started
+	cancel()
+
+	wait, done := context.WithTimeout(context.Background(), time.Second)
+	defer done()
+	if err := s.Wait(wait); err != nil {
+		t.Fatalf("Wait = %v, want the job stopped", err)
+	}
+	select {
+	case <-finished:
+	default:
+		t.Fatal("Wait returned before the running job did")
+	}
+}
@@ -0,0 +1,175 @@
+// Package secrets resolves config values that name a secret in a secret
+// store, such as Vault, and keeps them fresh.
+package secrets
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"maps"
+	"strings"
+	"sync"
+	"time"
+
+	"user-service/internal/config"
+)
+
+// ErrNotRenewable is returned by providers for secrets without a lease.
+var ErrNotRenewable = errors.New("secret is not renewable")
+
+// Secret is the data read from one path. Dynamic secrets carry a lease
+// that expires unless renewed.
+type Secret struct {
+	Data          map[string]string
+	LeaseID       string
+	LeaseDuration time.Duration
+	Renewable     bool
+}
+
+// Provider reads secrets from a secret store.
+type Provider interface {
+	Read(ctx context.Context, path string) (Secret, error)
+	// Renew extends the secret's lease and returns its new duration.
+	Renew(ctx context.Context, secret Secret) (time.Duration, error)
+}
+
+// ParseRef splits a reference such as kv/data/user-service#jwt_secret,
+// the part of a config value after config.SecretPrefix, into the path
+// to read and the field of it to use.
+func ParseRef(ref string) (path, field string, err error) {
+	path, field, ok := strings.Cut(ref, "#")
+	if !ok || path == "" || field == "" {
+		return "", "", fmt.Errorf("invalid secret reference %q, want path#field", ref)
+	}
+	return path, field, nil
+}
+
+type entry struct {
+	secret Secret
+	due    time.Time // when to renew or re-read; zero for never
+}
+
+// Cache resolves references through a Provider, reading each path once
+// however many fields are used from it, so the username and password of
+// one set of dynamic credentials stay a pair. Run keeps the cached
+// secrets fresh.
+type Cache struct {
+	provider Provider
+	refresh  time.Duration
+	timeout  time.Duration
+
+	mu      sync.Mutex
+	entries map[string]*entry
+}
+
+// NewCache returns a Cache over provider. Secrets without a lease are
+// re-read every refresh interval to pick up rotations; zero never does.
+func NewCache(provider Provider, refresh time.Duration) *Cache {
+	return &Cache{provider: provider, refresh: refresh, timeout: 10 * time.Second, entries: make(map[string]*entry)}
+}
+
+// due returns when the secret, renewed or read now, needs attention
+// again: two thirds into its lease, or after the refresh interval.
+func (c *Cache) due(secret Secret, lease time.Duration, now time.Time) time.Time {
+	switch {
+	case secret.LeaseID != "" && lease > 0:
+		return now.Add(lease * 2 / 3)
+	case c.refresh > 0:
+		return now.Add(c.refresh)
+	}
+	return time.Time{}
+}
+
+// Resolve returns the value the reference names.
+func (c *Cache) Resolve(ref string) (string, error) {
+	path, field, err := ParseRef(ref)
+	if err != nil {
+		return "", err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.entries[path]
+	if !ok {
+		ctx, cancel := context.WithTimeout(context.Background(), c.timeout)
+		defer cancel()
+		secret, err := c.provider.Read(ctx, path)
+		if err != nil {
+			return "", fmt.Errorf("read secret %s: %w", path, err)
+		}
+		e = &entry{secret: secret, due: c.due(secret, secret.LeaseDuration, time.Now())}
+		c.entries[path] = e
+	}
+	value, ok := e.secret.Data[field]
+	if !ok {
+		return "", fmt.Errorf("secret %s has no field %q", path, field)
+	}
+	return value, nil
+}
+
+// Run renews leases and re-reads secrets as they come due, until ctx is
+// done. A secret whose lease can't be renewed is read again, which for
+// dynamic credentials issues new ones. changed is called with the paths
+// whose data differs after a read.
+func (c *Cache) Run(ctx context.Context, interval time.Duration, changed func(paths []string)) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			if paths := c.refreshDue(ctx, now); len(paths) > 0 {
+				changed(paths)
+			}
+		}
+	}
+}
+
+func (c *Cache) refreshDue(ctx context.Context, now time.Time) []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var changed []string
+	for path, e := range c.entries {
+		if e.due.IsZero() || now.Before(e.due) {
+			continue
+		}
+		callCtx, cancel := context.WithTimeout(ctx, c.timeout)
+		if e.secret.Renewable {
+			lease, err := c.provider.Renew(callCtx, e.secret)
+			if err == nil {
+				e.due = c.due(e.secret, lease, now)
+				cancel()
+				continue
+			}
+			log.Printf("secrets: renew %s: %v, reading it again", path, err)
+		}
+		secret, err := c.provider.Read(callCtx, path)
+		cancel()
+		if err != nil {
+			// Keep the value we have and try again shortly.
+			log.Printf("secrets: read %s: %v", path, err)
+			e.due = now.Add(30 * time.Second)
+			continue
+		}
+		if !maps.Equal(secret.Data, e.secret.Data) {
+			changed = append(changed, path)
+		}
+		e.secret = secret
+		e.due = c.due(secret, secret.LeaseDuration, now)
+	}
+	return changed
+}
+
+// Load returns the provider named by SECRETS_PROVIDER, or nil when none
+// is configured.
+func Load(src *config.Source) (Provider, error) {
+	switch name := src.String("SECRETS_PROVIDER", ""); name {
+	case "":
+		return nil, nil
+	case "vault":
+		return NewVault(LoadVaultConfig(src))
+	default:
+		return nil, fmt.Errorf("unknown SECRETS_PROVIDER %q", name)
+	}
+}
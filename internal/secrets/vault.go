@@ -0,0 +1,221 @@
+package secrets
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"user-service/internal/config"
+)
+
+// VaultConfig says how to reach Vault. TokenFile, if set, is read on
+// every request, for a token kept fresh by Vault Agent; otherwise Token
+// is used and renewed by KeepTokenAlive.
+type VaultConfig struct {
+	Addr      string
+	Token     string
+	TokenFile string
+	Namespace string
+	Timeout   time.Duration
+}
+
+func LoadVaultConfig(src *config.Source) VaultConfig {
+	return VaultConfig{
+		Addr:      src.String("VAULT_ADDR", ""),
+		Token:     src.String("VAULT_TOKEN", ""),
+		TokenFile: src.String("VAULT_TOKEN_FILE", ""),
+		Namespace: src.String("VAULT_NAMESPACE", ""),
+		Timeout:   src.Duration("VAULT_TIMEOUT", 5*time.Second),
+	}
+}
+
+// Vault reads secrets over Vault's HTTP API. It handles both KV version 2
+// mounts, whose data is nested under data.data, and secrets engines that
+// issue dynamic credentials with a lease, such as database/creds/<role>.
+type Vault struct {
+	cfg    VaultConfig
+	client *http.Client
+}
+
+func NewVault(cfg VaultConfig) (*Vault, error) {
+	if cfg.Addr == "" {
+		return nil, errors.New("VAULT_ADDR is not set")
+	}
+	if cfg.Token == "" && cfg.TokenFile == "" {
+		return nil, errors.New("VAULT_TOKEN or VAULT_TOKEN_FILE must be set")
+	}
+	cfg.Addr = strings.TrimRight(cfg.Addr, "/")
+	return &Vault{cfg: cfg, client: &http.Client{Timeout: cfg.Timeout}}, nil
+}
+
+func (v *Vault) token() (string, error) {
+	if v.cfg.TokenFile == "" {
+		return v.cfg.Token, nil
+	}
+	data, err := os.ReadFile(v.cfg.TokenFile)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(data)), nil
+}
+
+// vaultResponse is the envelope of Vault's secret and auth responses.
+type vaultResponse struct {
+	LeaseID       string                     `json:"lease_id"`
+	LeaseDuration int                        `json:"lease_duration"`
+	Renewable     bool                       `json:"renewable"`
+	Data          map[string]json.RawMessage `json:"data"`
+	Auth          *struct {
+		LeaseDuration int  `json:"lease_duration"`
+		Renewable     bool `json:"renewable"`
+	} `json:"auth"`
+	Errors []string `json:"errors"`
+}
+
+func (v *Vault) do(ctx context.Context, method, path string, body any) (vaultResponse, error) {
+	var resp vaultResponse
+	token, err := v.token()
+	if err != nil {
+		return resp, fmt.Errorf("vault token: %w", err)
+	}
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return resp, err
+		}
+		reader = bytes.NewReader(data)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, v.cfg.Addr+"/v1/"+strings.TrimLeft(path, "/"), reader)
+	if err != nil {
+		return resp, err
+	}
+	req.Header.Set("X-Vault-Token", token)
+	if v.cfg.Namespace != "" {
+		req.Header.Set("X-Vault-Namespace", v.cfg.Namespace)
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	res, err := v.client.Do(req)
+	if err != nil {
+		return resp, fmt.Errorf("vault: %w", err)
+	}
+	defer res.Body.Close()
+	if err := json.NewDecoder(res.Body).Decode(&resp); err != nil && err != io.EOF {
+		return resp, fmt.Errorf("vault: %s %s: %s", method, path, res.Status)
+	}
+	if res.StatusCode >= 300 {
+		if len(resp.Errors) > 0 {
+			return resp, fmt.Errorf("vault: %s %s: %s: %s", method, path, res.Status, strings.Join(resp.Errors, "; "))
+		}
+		return resp, fmt.Errorf("vault: %s %s: %s", method, path, res.Status)
+	}
+	return resp, nil
+}
+
+// Read returns the secret at path. Values that aren't strings are
+// returned as their JSON encoding.
+func (v *Vault) Read(ctx context.Context, path string) (Secret, error) {
+	resp, err := v.do(ctx, http.MethodGet, path, nil)
+	if err != nil {
+		return Secret{}, err
+	}
+	data := resp.Data
+	if inner, ok := data["data"]; ok {
+		if _, ok := data["metadata"]; ok {
+			// KV version 2.
+			data = nil
+			if err := json.Unmarshal(inner, &data); err != nil {
+				return Secret{}, fmt.Errorf("vault: %s: %w", path, err)
+			}
+		}
+	}
+	secret := Secret{
+		Data:          make(map[string]string, len(data)),
+		LeaseID:       resp.LeaseID,
+		LeaseDuration: time.Duration(resp.LeaseDuration) * time.Second,
+		Renewable:     resp.Renewable,
+	}
+	for field, raw := range data {
+		var s string
+		if err := json.Unmarshal(raw, &s); err == nil {
+			secret.Data[field] = s
+		} else {
+			secret.Data[field] = string(raw)
+		}
+	}
+	return secret, nil
+}
+
+// Renew asks for the secret's lease to be extended by its original
+// duration. Vault may grant less, up to the lease's maximum TTL.
+func (v *Vault) Renew(ctx context.Context, secret Secret) (time.Duration, error) {
+	if !secret.Renewable || secret.LeaseID == "" {
+		return 0, ErrNotRenewable
+	}
+	resp, err := v.do(ctx, http.MethodPut, "sys/leases/renew", map[string]any{
+		"lease_id":  secret.LeaseID,
+		"increment": int(secret.LeaseDuration / time.Second),
+	})
+	if err != nil {
+		return 0, err
+	}
+	lease := time.Duration(resp.LeaseDuration) * time.Second
+	if lease <= 0 {
+		return 0, fmt.Errorf("vault: lease %s was not extended", secret.LeaseID)
+	}
+	return lease, nil
+}
+
+// KeepTokenAlive renews VAULT_TOKEN two thirds into its TTL until ctx is
+// done. It returns at once for a token file, which Vault Agent renews,
+// and for tokens that don't expire or can't be renewed.
+func (v *Vault) KeepTokenAlive(ctx context.Context) {
+	if v.cfg.TokenFile != "" {
+		return
+	}
+	resp, err := v.do(ctx, http.MethodGet, "auth/token/lookup-self", nil)
+	if err != nil {
+		log.Printf("secrets: vault token lookup: %v", err)
+		return
+	}
+	var ttl int
+	var renewable bool
+	json.Unmarshal(resp.Data["ttl"], &ttl)
+	json.Unmarshal(resp.Data["renewable"], &renewable)
+	if ttl <= 0 || !renewable {
+		return
+	}
+
+	wait := time.Duration(ttl) * time.Second * 2 / 3
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(wait):
+		}
+		resp, err := v.do(ctx, http.MethodPost, "auth/token/renew-self", map[string]any{})
+		if err == nil && resp.Auth == nil {
+			err = errors.New("no auth in response")
+		}
+		if err != nil {
+			log.Printf("secrets: vault token renewal: %v", err)
+			wait = 30 * time.Second
+			continue
+		}
+		if !resp.Auth.Renewable || resp.Auth.LeaseDuration <= 0 {
+			return
+		}
+		wait = time.Duration(resp.Auth.LeaseDuration) * time.Second * 2 / 3
+	}
+}
@@ -0,0 +1,119 @@
+package seed
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"user-service/internal/store"
+)
+
+// Run implements the seed subcommand, which loads a seed file into a
+// running instance through its API with the same skip-on-existing
+// semantics as seeding at startup.
+func Run(args []string, out io.Writer) error {
+	fs := flag.NewFlagSet("seed", flag.ContinueOnError)
+	fs.SetOutput(out)
+	baseURL := fs.String("url", "http://localhost:8080", "base URL of the instance to seed")
+	file := fs.String("file", "", "seed file (.json or .csv)")
+	apiKey := fs.String("api-key", "", "X-API-Key to send")
+	token := fs.String("token", "", "bearer access token to send")
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return nil
+		}
+		return err
+	}
+	if *file == "" {
+		return errors.New("seed: -file is required")
+	}
+
+	users, err := Load(*file)
+	if err != nil {
+		return err
+	}
+	c := &client{
+		base:   strings.TrimSuffix(*baseURL, "/"),
+		apiKey: *apiKey,
+		token:  *token,
+		http:   &http.Client{Timeout: 10 * time.Second},
+	}
+	var result Result
+	for _, user := range users {
+		created, err := c.createIfMissing(user)
+		if err != nil {
+			fmt.Fprintf(out, "created %d, skipped %d before failing\n", result.Created, result.Skipped)
+			return fmt.Errorf("seed: %s: %w", user.ID, err)
+		}
+		if created {
+			result.Created++
+		} else {
+			result.Skipped++
+		}
+	}
+	fmt.Fprintf(out, "created %d, skipped %d\n", result.Created, result.Skipped)
+	return nil
+}
+
+type client struct {
+	base          string
+	apiKey, token string
+	http          *http.Client
+}
+
+// createIfMissing creates the user unless one with its ID exists,
+// reporting whether it did.
+func (c *client) createIfMissing(user store.User) (bool, error) {
+	status, err := c.send(http.MethodGet, "/users/"+url.PathEscape(user.ID), nil)
+	if err != nil {
+		return false, err
+	}
+	switch status {
+	case http.StatusOK:
+		return false, nil
+	case http.StatusNotFound:
+	default:
+		return false, fmt.Errorf("lookup returned %d", status)
+	}
+
+	body, err := json.Marshal(user)
+	if err != nil {
+		return false, err
+	}
+	status, err = c.send(http.MethodPost, "/users", body)
+	if err != nil {
+		return false, err
+	}
+	if status != http.StatusCreated {
+		return false, fmt.Errorf("create returned %d", status)
+	}
+	return true, nil
+}
+
+func (c *client) send(method, path string, body []byte) (int, error) {
+	req, err := http.NewRequest(method, c.base+path, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if c.apiKey != "" {
+		req.Header.Set("X-API-Key", c.apiKey)
+	}
+	if c.token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+	return resp.StatusCode, nil
+}
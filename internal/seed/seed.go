@@ -0,0 +1,158 @@
+// Package seed loads initial users from JSON or CSV files, either into
+// the service at startup or into a running instance over HTTP.
+package seed
+
+import (
+	"bytes"
+	"context"
+	_ "embed"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"user-service/internal/service"
+	"user-service/internal/store"
+)
+
+//go:embed sample_users.json
+var sampleUsers []byte
+
+// Samples returns the demo users seeded when no seed file is given.
+func Samples() []store.User {
+	users, err := parseJSON(bytes.NewReader(sampleUsers))
+	if err != nil {
+		panic("seed: bad sample_users.json: " + err.Error())
+	}
+	return users
+}
+
+// Load reads and validates the users in a .json or .csv file.
+func Load(path string) ([]store.User, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var users []store.User
+	switch ext := strings.ToLower(filepath.Ext(path)); ext {
+	case ".json":
+		users, err = parseJSON(f)
+	case ".csv":
+		users, err = parseCSV(f)
+	default:
+		return nil, fmt.Errorf("seed: %s: unsupported format %q, want .json or .csv", path, ext)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("seed: %s: %w", path, err)
+	}
+	if err := Validate(users); err != nil {
+		return nil, fmt.Errorf("seed: %s: %w", path, err)
+	}
+	return users, nil
+}
+
+// parseJSON reads an array of users in the API's JSON shape.
+func parseJSON(r io.Reader) ([]store.User, error) {
+	var users []store.User
+	if err := json.NewDecoder(r).Decode(&users); err != nil {
+		return nil, err
+	}
+	return users, nil
+}
+
+// csvColumns are the columns a CSV seed file may have, in any order.
+// scopes are separated by spaces.
+var csvColumns = map[string]bool{"id": true, "name": true, "email": true, "status": true, "scopes": true, "password": true}
+
+// parseCSV reads users from a CSV file whose first row names its columns.
+func parseCSV(r io.Reader) ([]store.User, error) {
+	records, err := csv.NewReader(r).ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	if len(records) == 0 {
+		return nil, errors.New("missing header row")
+	}
+	header := records[0]
+	for i, column := range header {
+		header[i] = strings.ToLower(strings.TrimSpace(column))
+		if !csvColumns[header[i]] {
+			return nil, fmt.Errorf("unknown column %q", column)
+		}
+	}
+
+	users := make([]store.User, 0, len(records)-1)
+	for _, record := range records[1:] {
+		var user store.User
+		for i, value := range record {
+			value = strings.TrimSpace(value)
+			switch header[i] {
+			case "id":
+				user.ID = value
+			case "name":
+				user.Name = value
+			case "email":
+				user.Email = value
+			case "status":
+				user.Status = value
+			case "scopes":
+				if value != "" {
+					user.Scopes = strings.Fields(value)
+				}
+			case "password":
+				user.Password = value
+			}
+		}
+		users = append(users, user)
+	}
+	return users, nil
+}
+
+// Validate checks every user as the API would on create and rejects
+// duplicate IDs, so a bad file is refused before anything is written.
+func Validate(users []store.User) error {
+	seen := make(map[string]bool, len(users))
+	for i, user := range users {
+		if err := service.ValidateNew(user); err != nil {
+			return fmt.Errorf("user %d: %w", i+1, err)
+		}
+		if seen[user.ID] {
+			return fmt.Errorf("user %d: duplicate ID %q", i+1, user.ID)
+		}
+		seen[user.ID] = true
+	}
+	return nil
+}
+
+// Result counts what a seed run did.
+type Result struct {
+	Created int `json:"created"`
+	Skipped int `json:"skipped"`
+}
+
+// Apply creates the users that don't exist yet, leaving existing ones
+// untouched.
+func Apply(ctx context.Context, users *service.Users, seed []store.User) (Result, error) {
+	var result Result
+	for _, user := range seed {
+		_, err := users.Get(ctx, user.ID)
+		if err == nil {
+			result.Skipped++
+			continue
+		}
+		if !errors.Is(err, store.ErrUserNotFound) {
+			return result, err
+		}
+		if _, err := users.Create(ctx, user); err != nil {
+			return result, fmt.Errorf("create %s: %w", user.ID, err)
+		}
+		result.Created++
+	}
+	return result, nil
+}
@@ -0,0 +1,94 @@
+package service
+
+import (
+	"context"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+
+	"user-service/internal/store"
+)
+
+// BackupVersion is the format version of backups written now. Restore
+// accepts this version and earlier ones.
+const BackupVersion = 1
+
+// Backup is a full dump of user data: every user with their password
+// hash, 2FA secret and login history. The outbox is not included.
+type Backup struct {
+	Version   int                `json:"version"`
+	CreatedAt time.Time          `json:"created_at"`
+	Users     []store.UserRecord `json:"users"`
+}
+
+// RestoreResult counts what a restore did with the backup's users.
+type RestoreResult struct {
+	Created     int `json:"created"`
+	Overwritten int `json:"overwritten"`
+	Merged      int `json:"merged"`
+	Skipped     int `json:"skipped"`
+}
+
+// Backup takes a consistent snapshot of every user.
+func (u *Users) Backup(ctx context.Context) (Backup, error) {
+	records, err := u.store.Dump(ctx)
+	if err != nil {
+		return Backup{}, err
+	}
+	return Backup{Version: BackupVersion, CreatedAt: time.Now().UTC(), Users: records}, nil
+}
+
+// Restore loads a backup, handling users that already exist as mode
+// says: store.RestoreSkip, RestoreOverwrite or RestoreMerge. The whole
+// backup is checked before anything is written, but it is then restored
+// one user at a time, so a store failure leaves the users before it
+// restored. Restored users bypass hooks, email and custom field checks:
+// the backup is trusted to hold data this service stored. Password hashes
+// must still be bcrypt, and plaintext passwords are dropped.
+func (u *Users) Restore(ctx context.Context, backup Backup, mode string) (RestoreResult, error) {
+	switch mode {
+	case store.RestoreSkip, store.RestoreOverwrite, store.RestoreMerge:
+	default:
+		return RestoreResult{}, invalid("Unknown restore mode %q, use skip, overwrite or merge", mode)
+	}
+	if backup.Version < 1 || backup.Version > BackupVersion {
+		return RestoreResult{}, invalid("Unsupported backup version %d", backup.Version)
+	}
+	seen := make(map[string]bool, len(backup.Users))
+	for _, rec := range backup.Users {
+		if rec.User.ID == "" {
+			return RestoreResult{}, invalid("Backup has a user without an ID")
+		}
+		if seen[rec.User.ID] {
+			return RestoreResult{}, invalid("Backup has user %s twice", rec.User.ID)
+		}
+		seen[rec.User.ID] = true
+		if rec.User.Status != "" && !store.ValidStatus(rec.User.Status) {
+			return RestoreResult{}, invalid("Backup has user %s with an invalid status", rec.User.ID)
+		}
+		if rec.PasswordHash != "" {
+			if _, err := bcrypt.Cost([]byte(rec.PasswordHash)); err != nil {
+				return RestoreResult{}, invalid("Backup has user %s with an invalid password hash", rec.User.ID)
+			}
+		}
+	}
+
+	var result RestoreResult
+	for _, rec := range backup.Users {
+		outcome, err := u.store.Restore(ctx, rec, mode)
+		if err != nil {
+			return result, err
+		}
+		switch outcome {
+		case store.RestoreCreated:
+			result.Created++
+		case store.RestoreOverwritten:
+			result.Overwritten++
+		case store.RestoreMerged:
+			result.Merged++
+		case store.RestoreSkipped:
+			result.Skipped++
+		}
+	}
+	return result, nil
+}
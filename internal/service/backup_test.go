@@ -0,0 +1,46 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+
+	"user-service/internal/store"
+)
+
+func TestRestoreKeepsOnlyPasswordHashes(t *testing.T) {
+	ctx := context.Background()
+	s := store.NewUserStore()
+	users := &Users{store: s}
+	hash, err := bcrypt.GenerateFromPassword([]byte("secret"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	backup := Backup{Version: BackupVersion, Users: []store.UserRecord{{
+		User:         store.User{ID: "1", Name: "User 1", Email: "1@example.com", Password: "plaintext"},
+		PasswordHash: string(hash),
+	}}}
+	if _, err := users.Restore(ctx, backup, store.RestoreSkip); err != nil {
+		t.Fatal(err)
+	}
+	user, err := s.Get(ctx, "1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user.Password != "" || user.PasswordHash != string(hash) {
+		t.Fatalf("restored password %q, hash %q; want only the hash", user.Password, user.PasswordHash)
+	}
+
+	backup.Users[0].User.ID = "2"
+	backup.Users[0].PasswordHash = "plaintext"
+	var invalidErr *ValidationError
+	if _, err := users.Restore(ctx, backup, store.RestoreSkip); !errors.As(err, &invalidErr) {
+		t.Fatalf("Restore = %v, want a validation error for a hash that is not bcrypt", err)
+	}
+	if _, err := s.Get(ctx, "2"); !errors.Is(err, store.ErrUserNotFound) {
+		t.Fatalf("Get = %v, want nothing restored", err)
+	}
+}
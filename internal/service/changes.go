@@ -0,0 +1,104 @@
+package service
+
+import (
+	"context"
+	"strconv"
+
+	"user-service/internal/store"
+)
+
+// MaxChangesPage caps how many events one page of the change feed scans.
+const MaxChangesPage = 1000
+
+// changeEvents are the events the change feed reports; the outbox also
+// holds security and workflow events that aren't changes to user data.
+var changeEvents = map[string]bool{
+	store.EventUserCreated:   true,
+	store.EventUserUpdated:   true,
+	store.EventUserDeleted:   true,
+	store.EventUserForgotten: true,
+	store.EventUserSuspended: true,
+	store.EventUserActivated: true,
+	store.EventUserLocked:    true,
+	store.EventUserMerged:    true,
+}
+
+// Change is one entry of the change feed. User is the user's current
+// state, not the state right after the change, and is absent once the
+// user no longer exists.
+type Change struct {
+	store.Event
+	User *store.User
+}
+
+// ChangePage is a page of the change feed. Cursor resumes after it, even
+// when the page holds no changes because the events scanned weren't
+// user changes.
+type ChangePage struct {
+	Changes []Change
+	Cursor  string
+	HasMore bool
+}
+
+// Changes returns the user changes after the cursor, an event sequence
+// number from an earlier page. An empty cursor starts from the first
+// event and "now" from the latest, for consumers that take a snapshot
+// with List and then follow the feed.
+func (u *Users) Changes(ctx context.Context, cursor string, limit int) (ChangePage, error) {
+	if limit <= 0 || limit > MaxChangesPage {
+		limit = MaxChangesPage
+	}
+	var since uint64
+	switch cursor {
+	case "":
+	case "now":
+		last, err := u.store.LastEventSeq(ctx)
+		if err != nil {
+			return ChangePage{}, err
+		}
+		return ChangePage{Changes: []Change{}, Cursor: strconv.FormatUint(last, 10)}, nil
+	default:
+		var err error
+		if since, err = strconv.ParseUint(cursor, 10, 64); err != nil {
+			return ChangePage{}, invalid("Invalid cursor")
+		}
+	}
+
+	events, err := u.store.EventsSince(ctx, since, limit)
+	if err != nil {
+		return ChangePage{}, err
+	}
+	page := ChangePage{Changes: []Change{}, Cursor: strconv.FormatUint(since, 10), HasMore: len(events) == limit}
+	var ids []string
+	for _, event := range events {
+		page.Cursor = strconv.FormatUint(event.Seq, 10)
+		if !changeEvents[event.Type] {
+			continue
+		}
+		page.Changes = append(page.Changes, Change{Event: event})
+		ids = append(ids, event.UserID)
+		// A merge changes both users: the target gains the source's data
+		// and the source becomes a tombstone.
+		if source := event.Data["source_id"]; event.Type == store.EventUserMerged && source != "" {
+			tombstone := event
+			tombstone.UserID = source
+			page.Changes = append(page.Changes, Change{Event: tombstone})
+			ids = append(ids, source)
+		}
+	}
+
+	found, _, err := u.store.GetMany(ctx, ids)
+	if err != nil {
+		return ChangePage{}, err
+	}
+	current := make(map[string]store.User, len(found))
+	for _, user := range found {
+		current[user.ID] = user
+	}
+	for i := range page.Changes {
+		if user, ok := current[page.Changes[i].UserID]; ok {
+			page.Changes[i].User = &user
+		}
+	}
+	return page, nil
+}
@@ -0,0 +1,39 @@
+package service
+
+import (
+	"context"
+	"testing"
+
+	"user-service/internal/store"
+)
+
+func TestChangesReportMergeForBothUsers(t *testing.T) {
+	ctx := context.Background()
+	s := store.NewUserStore()
+	for _, id := range []string{"1", "2"} {
+		if err := s.Create(ctx, store.User{ID: id, Name: "User " + id, Email: id + "@example.com"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := s.Merge(ctx, "2", "1"); err != nil {
+		t.Fatal(err)
+	}
+
+	page, err := (&Users{store: s}).Changes(ctx, "", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	merged := map[string]Change{}
+	for _, change := range page.Changes {
+		if change.Type == store.EventUserMerged {
+			merged[change.UserID] = change
+		}
+	}
+	target, source := merged["1"], merged["2"]
+	if target.User == nil || source.User == nil || target.Seq != source.Seq {
+		t.Fatalf("merge changes = %+v; want one for each user with the same seq", merged)
+	}
+	if source.User.Status != store.StatusMerged || source.User.MergedInto != "1" {
+		t.Fatalf("source = %+v, want the tombstone", source.User)
+	}
+}
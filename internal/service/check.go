@@ -0,0 +1,53 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"strings"
+
+	"user-service/internal/store"
+)
+
+// Availability says whether the email and name checked are free for a
+// new user. Fields that weren't checked are nil.
+type Availability struct {
+	EmailAvailable *bool `json:"email_available,omitempty"`
+	NameAvailable  *bool `json:"name_available,omitempty"`
+}
+
+// CheckAvailable reports whether a new user could take the email, after
+// normalizing it, and whether any user already has the name, ignoring
+// case. Names need not be unique, so that answer is only advice. Empty
+// values are not checked.
+func (u *Users) CheckAvailable(ctx context.Context, email, name string) (Availability, error) {
+	email, name = strings.TrimSpace(email), strings.TrimSpace(name)
+	if email == "" && name == "" {
+		return Availability{}, invalid("Give an email or name to check")
+	}
+	var result Availability
+	if email != "" {
+		_, err := u.store.GetByEmail(ctx, u.emails.Normalize(email))
+		if err != nil && !errors.Is(err, store.ErrUserNotFound) {
+			return Availability{}, err
+		}
+		available := err != nil
+		result.EmailAvailable = &available
+	}
+	if name != "" {
+		err := u.store.ForEach(ctx, func(user store.User) error {
+			if strings.EqualFold(strings.TrimSpace(user.Name), name) {
+				return errNameTaken
+			}
+			return nil
+		})
+		if err != nil && !errors.Is(err, errNameTaken) {
+			return Availability{}, err
+		}
+		available := err == nil
+		result.NameAvailable = &available
+	}
+	return result, nil
+}
+
+// errNameTaken stops the scan for a name once a user has it.
+var errNameTaken = errors.New("name taken")
@@ -0,0 +1,335 @@
+package service
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"user-service/internal/config"
+	"user-service/internal/store"
+)
+
+// Statuses of a delete request.
+const (
+	DeletePending    = "pending"
+	DeleteCommitted  = "committed"
+	DeleteRolledBack = "rolled_back"
+	DeleteFailed     = "failed"
+)
+
+// Events published as a delete request progresses. A committed request
+// also publishes the usual user.deleted.
+const (
+	EventDeleteRequested  = "user.delete_requested"
+	EventDeleteRolledBack = "user.delete_rolled_back"
+)
+
+var (
+	ErrDeleteRequestNotFound = errors.New("delete request not found")
+	ErrDeletePending         = errors.New("a delete request is already pending")
+	ErrDeleteResolved        = errors.New("delete request already resolved")
+	ErrUnknownParticipant    = errors.New("unknown participant")
+)
+
+// DeletionPolicy configures two-phase deletes.
+type DeletionPolicy struct {
+	// Participants must each confirm before a user is deleted. They are
+	// caller identities, as auth.CallerIdentity names them, such as
+	// mtls:orders.
+	Participants []string
+	// Timeout is how long participants have to answer.
+	Timeout time.Duration
+	// CommitOnTimeout deletes the user when the timeout passes without a
+	// rejection; otherwise the request rolls back.
+	CommitOnTimeout bool
+	// Retention is how long resolved requests stay queryable.
+	Retention time.Duration
+}
+
+func LoadDeletionPolicy(src *config.Source) DeletionPolicy {
+	var participants []string
+	for _, name := range strings.Split(src.String("DELETE_PARTICIPANTS", ""), ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			participants = append(participants, name)
+		}
+	}
+	return DeletionPolicy{
+		Participants:    participants,
+		Timeout:         src.Duration("DELETE_REQUEST_TIMEOUT", 5*time.Minute),
+		CommitOnTimeout: src.String("DELETE_REQUEST_ON_TIMEOUT", "rollback") == "commit",
+		Retention:       src.Duration("DELETE_REQUEST_RETENTION", 24*time.Hour),
+	}
+}
+
+// Vote is a participant's answer to a delete request.
+type Vote struct {
+	Confirmed bool      `json:"confirmed"`
+	Reason    string    `json:"reason,omitempty"`
+	At        time.Time `json:"at"`
+}
+
+// DeleteRequest is a pending or resolved two-phase delete.
+type DeleteRequest struct {
+	ID           string          `json:"id"`
+	UserID       string          `json:"user_id"`
+	Status       string          `json:"status"`
+	Participants []string        `json:"participants"`
+	Votes        map[string]Vote `json:"votes"`
+	CreatedAt    time.Time       `json:"created_at"`
+	Deadline     time.Time       `json:"deadline"`
+	ResolvedAt   *time.Time      `json:"resolved_at,omitempty"`
+	Reason       string          `json:"reason,omitempty"`
+}
+
+// copy returns a snapshot that shares nothing with the request.
+func (d *DeleteRequest) copy() DeleteRequest {
+	c := *d
+	c.Participants = append([]string(nil), d.Participants...)
+	c.Votes = make(map[string]Vote, len(d.Votes))
+	for name, vote := range d.Votes {
+		c.Votes[name] = vote
+	}
+	return c
+}
+
+// Deletions runs two-phase deletes. Creating a request publishes
+// user.delete_requested; each participant answers with Confirm or
+// Reject. The user is deleted once all have confirmed, and kept if any
+// rejects or, unless CommitOnTimeout is set, if the deadline passes
+// first. Requests are kept in memory.
+type Deletions struct {
+	users  *Users
+	store  store.Store
+	policy DeletionPolicy
+
+	mu       sync.Mutex
+	requests map[string]*DeleteRequest
+	byUser   map[string]string // user ID to pending request ID
+}
+
+func NewDeletions(users *Users, s store.Store, policy DeletionPolicy) *Deletions {
+	return &Deletions{
+		users:    users,
+		store:    s,
+		policy:   policy,
+		requests: make(map[string]*DeleteRequest),
+		byUser:   make(map[string]string),
+	}
+}
+
+// randomID returns a random ID for a request or job.
+func randomID() (string, error) {
+	buf := make([]byte, 12)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(buf), nil
+}
+
+// Request starts a two-phase delete of the user. Without participants
+// it commits at once.
+func (d *Deletions) Request(ctx context.Context, userID string) (DeleteRequest, error) {
+	if _, err := d.store.Get(ctx, userID); err != nil {
+		return DeleteRequest{}, err
+	}
+	id, err := randomID()
+	if err != nil {
+		return DeleteRequest{}, err
+	}
+	now := time.Now().UTC()
+	req := &DeleteRequest{
+		ID:           id,
+		UserID:       userID,
+		Status:       DeletePending,
+		Participants: append([]string{}, d.policy.Participants...),
+		Votes:        make(map[string]Vote),
+		CreatedAt:    now,
+		Deadline:     now.Add(d.policy.Timeout),
+	}
+
+	d.mu.Lock()
+	if _, ok := d.byUser[userID]; ok {
+		d.mu.Unlock()
+		return DeleteRequest{}, ErrDeletePending
+	}
+	d.requests[id] = req
+	d.byUser[userID] = id
+	d.mu.Unlock()
+
+	event := store.NewEvent(EventDeleteRequested, userID).
+		With("delete_request_id", id).
+		With("deadline", req.Deadline.Format(time.RFC3339))
+	if err := d.store.Emit(ctx, event); err != nil {
+		d.mu.Lock()
+		delete(d.requests, id)
+		delete(d.byUser, userID)
+		d.mu.Unlock()
+		return DeleteRequest{}, err
+	}
+
+	if len(req.Participants) == 0 {
+		d.mu.Lock()
+		d.commit(ctx, req)
+		d.mu.Unlock()
+	}
+	return d.Get(ctx, id)
+}
+
+// Get returns the request's current state, resolving it first if its
+// deadline has passed.
+func (d *Deletions) Get(ctx context.Context, id string) (DeleteRequest, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	req, ok := d.requests[id]
+	if !ok {
+		return DeleteRequest{}, ErrDeleteRequestNotFound
+	}
+	d.expire(ctx, req, time.Now())
+	return req.copy(), nil
+}
+
+// Confirm records the participant's consent, deleting the user once
+// every participant has confirmed.
+func (d *Deletions) Confirm(ctx context.Context, id, participant string) (DeleteRequest, error) {
+	return d.vote(ctx, id, participant, Vote{Confirmed: true})
+}
+
+// Reject keeps the user and resolves the request as rolled back.
+func (d *Deletions) Reject(ctx context.Context, id, participant, reason string) (DeleteRequest, error) {
+	return d.vote(ctx, id, participant, Vote{Reason: reason})
+}
+
+func (d *Deletions) vote(ctx context.Context, id, participant string, vote Vote) (DeleteRequest, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	req, ok := d.requests[id]
+	if !ok {
+		return DeleteRequest{}, ErrDeleteRequestNotFound
+	}
+	d.expire(ctx, req, time.Now())
+	if req.Status != DeletePending {
+		return req.copy(), ErrDeleteResolved
+	}
+	if !contains(req.Participants, participant) {
+		return DeleteRequest{}, ErrUnknownParticipant
+	}
+
+	vote.At = time.Now().UTC()
+	req.Votes[participant] = vote
+	switch {
+	case !vote.Confirmed:
+		reason := participant + " rejected"
+		if vote.Reason != "" {
+			reason += ": " + vote.Reason
+		}
+		d.rollBack(ctx, req, reason)
+	case len(req.Votes) == len(req.Participants) && allConfirmed(req.Votes):
+		d.commit(ctx, req)
+	}
+	return req.copy(), nil
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+func allConfirmed(votes map[string]Vote) bool {
+	for _, vote := range votes {
+		if !vote.Confirmed {
+			return false
+		}
+	}
+	return true
+}
+
+// expire resolves a pending request whose deadline has passed. Callers
+// must hold d.mu.
+func (d *Deletions) expire(ctx context.Context, req *DeleteRequest, now time.Time) {
+	if req.Status != DeletePending || now.Before(req.Deadline) {
+		return
+	}
+	if d.policy.CommitOnTimeout {
+		d.commit(ctx, req)
+		return
+	}
+	var missing []string
+	for _, name := range req.Participants {
+		if _, ok := req.Votes[name]; !ok {
+			missing = append(missing, name)
+		}
+	}
+	sort.Strings(missing)
+	d.rollBack(ctx, req, "timed out waiting for "+strings.Join(missing, ", "))
+}
+
+// commit deletes the user through the usual delete hooks. Callers must
+// hold d.mu.
+func (d *Deletions) commit(ctx context.Context, req *DeleteRequest) {
+	err := d.users.Delete(ctx, req.UserID)
+	switch {
+	case err == nil:
+		d.resolve(req, DeleteCommitted, "")
+	case errors.Is(err, store.ErrUserNotFound):
+		d.resolve(req, DeleteCommitted, "user was already deleted")
+	default:
+		log.Printf("delete request %s: %v", req.ID, err)
+		d.resolve(req, DeleteFailed, "delete failed, create a new request to retry")
+	}
+}
+
+// rollBack keeps the user and publishes the rollback. Callers must hold
+// d.mu.
+func (d *Deletions) rollBack(ctx context.Context, req *DeleteRequest, reason string) {
+	d.resolve(req, DeleteRolledBack, reason)
+	event := store.NewEvent(EventDeleteRolledBack, req.UserID).With("delete_request_id", req.ID).With("reason", reason)
+	if err := d.store.Emit(ctx, event); err != nil {
+		log.Printf("delete request %s: emit rollback: %v", req.ID, err)
+	}
+}
+
+func (d *Deletions) resolve(req *DeleteRequest, status, reason string) {
+	now := time.Now().UTC()
+	req.Status = status
+	req.Reason = reason
+	req.ResolvedAt = &now
+	delete(d.byUser, req.UserID)
+}
+
+// UserDeleted resolves the user's pending request as committed once the
+// user has been deleted some other way, unless the request came after
+// the delete. It is registered as an async delete hook, since a
+// committing request deletes the user under d.mu.
+func (d *Deletions) UserDeleted(ctx context.Context, event store.Event) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if req := d.requests[d.byUser[event.UserID]]; req != nil && !event.Time.Before(req.CreatedAt) {
+		d.resolve(req, DeleteCommitted, "user was already deleted")
+	}
+	return nil
+}
+
+// Sweep resolves requests whose deadlines have passed and forgets
+// resolved ones after the retention period.
+func (d *Deletions) Sweep(ctx context.Context) error {
+	now := time.Now()
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for id, req := range d.requests {
+		d.expire(ctx, req, now)
+		if req.ResolvedAt != nil && now.Sub(*req.ResolvedAt) > d.policy.Retention {
+			delete(d.requests, id)
+		}
+	}
+	return nil
+}
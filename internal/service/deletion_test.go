@@ -0,0 +1,131 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"user-service/internal/store"
+)
+
+func newDeletions(t *testing.T, policy DeletionPolicy) (*Deletions, store.Store) {
+	t.Helper()
+	s := store.NewUserStore()
+	if err := s.Create(context.Background(), store.User{ID: "1", Name: "User 1", Email: "1@example.com"}); err != nil {
+		t.Fatal(err)
+	}
+	users := NewUsers(s, func() []string { return nil }, nil, NewEmails(EmailPolicy{}), nil, nil, nil)
+	return NewDeletions(users, s, policy), s
+}
+
+func TestDeleteRequestCommitsOnceAllConfirm(t *testing.T) {
+	ctx := context.Background()
+	d, s := newDeletions(t, DeletionPolicy{Participants: []string{"mtls:orders", "mtls:billing"}, Timeout: time.Minute})
+
+	req, err := d.Request(ctx, "1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if req.Status != DeletePending {
+		t.Fatalf("status %s, want %s", req.Status, DeletePending)
+	}
+	if _, err := d.Request(ctx, "1"); !errors.Is(err, ErrDeletePending) {
+		t.Fatalf("second Request = %v, want %v", err, ErrDeletePending)
+	}
+	if _, err := d.Confirm(ctx, req.ID, "mtls:shipping"); !errors.Is(err, ErrUnknownParticipant) {
+		t.Fatalf("Confirm by a stranger = %v, want %v", err, ErrUnknownParticipant)
+	}
+
+	if req, err = d.Confirm(ctx, req.ID, "mtls:orders"); err != nil || req.Status != DeletePending {
+		t.Fatalf("first Confirm = %+v, %v; want still pending", req, err)
+	}
+	if _, err := s.Get(ctx, "1"); err != nil {
+		t.Fatalf("user gone before everyone confirmed: %v", err)
+	}
+	if req, err = d.Confirm(ctx, req.ID, "mtls:billing"); err != nil || req.Status != DeleteCommitted {
+		t.Fatalf("last Confirm = %+v, %v; want committed", req, err)
+	}
+	if _, err := s.Get(ctx, "1"); !errors.Is(err, store.ErrUserNotFound) {
+		t.Fatalf("Get after commit = %v, want %v", err, store.ErrUserNotFound)
+	}
+	if _, err := d.Reject(ctx, req.ID, "mtls:orders", "too late"); !errors.Is(err, ErrDeleteResolved) {
+		t.Fatalf("Reject after commit = %v, want %v", err, ErrDeleteResolved)
+	}
+}
+
+func TestDeleteRequestRollsBack(t *testing.T) {
+	ctx := context.Background()
+	d, s := newDeletions(t, DeletionPolicy{Participants: []string{"mtls:orders"}, Timeout: time.Minute})
+
+	req, err := d.Request(ctx, "1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if req, err = d.Reject(ctx, req.ID, "mtls:orders", "open order"); err != nil {
+		t.Fatal(err)
+	}
+	if req.Status != DeleteRolledBack || req.Reason != "mtls:orders rejected: open order" {
+		t.Fatalf("request %+v, want rolled back with the reason", req)
+	}
+	if _, err := s.Get(ctx, "1"); err != nil {
+		t.Fatalf("user gone after a rejection: %v", err)
+	}
+	events, err := s.EventsForUser(ctx, "1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if last := events[len(events)-1]; last.Type != EventDeleteRolledBack || last.Data["delete_request_id"] != req.ID {
+		t.Fatalf("last event %+v, want the rollback", last)
+	}
+
+	// Once resolved, the user can be asked about again.
+	if _, err := d.Request(ctx, "1"); err != nil {
+		t.Fatalf("Request after a rollback: %v", err)
+	}
+}
+
+func TestDeleteRequestTimesOut(t *testing.T) {
+	ctx := context.Background()
+	for _, commit := range []bool{false, true} {
+		d, s := newDeletions(t, DeletionPolicy{Participants: []string{"mtls:orders", "mtls:billing"}, Timeout: time.Millisecond, CommitOnTimeout: commit})
+		req, err := d.Request(ctx, "1")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := d.Confirm(ctx, req.ID, "mtls:orders"); err != nil {
+			t.Fatal(err)
+		}
+		time.Sleep(5 * time.Millisecond)
+		if err := d.Sweep(ctx); err != nil {
+			t.Fatal(err)
+		}
+		req, err = d.Get(ctx, req.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, getErr := s.Get(ctx, "1")
+		switch {
+		case commit && (req.Status != DeleteCommitted || !errors.Is(getErr, store.ErrUserNotFound)):
+			t.Fatalf("CommitOnTimeout: request %+v, Get %v; want the user deleted", req, getErr)
+		case !commit && (req.Status != DeleteRolledBack || !strings.HasSuffix(req.Reason, "mtls:billing") || getErr != nil):
+			t.Fatalf("request %+v, Get %v; want a rollback waiting for mtls:billing", req, getErr)
+		}
+	}
+}
+
+func TestDeleteRequestWithoutParticipantsCommits(t *testing.T) {
+	ctx := context.Background()
+	d, s := newDeletions(t, DeletionPolicy{Timeout: time.Minute})
+	req, err := d.Request(ctx, "1")
+	if err != nil || req.Status != DeleteCommitted {
+		t.Fatalf("Request = %+v, %v; want committed at once", req, err)
+	}
+	if _, err := s.Get(ctx, "1"); !errors.Is(err, store.ErrUserNotFound) {
+		t.Fatalf("Get = %v, want %v", err, store.ErrUserNotFound)
+	}
+	if _, err := d.Request(ctx, "1"); !errors.Is(err, store.ErrUserNotFound) {
+		t.Fatalf("Request for a deleted user = %v, want %v", err, store.ErrUserNotFound)
+	}
+}
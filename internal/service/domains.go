@@ -0,0 +1,113 @@
+package service
+
+import (
+	"fmt"
+	"strings"
+
+	"user-service/internal/config"
+)
+
+// Reasons an EmailDomainError gives.
+const (
+	DomainNotAllowed = "not_allowed"
+	DomainDenied     = "denied"
+	DomainDisposable = "disposable"
+)
+
+// EmailDomainError rejects an email whose domain the DomainPolicy
+// doesn't accept.
+type EmailDomainError struct {
+	Domain string
+	Reason string
+}
+
+func (e *EmailDomainError) Error() string {
+	return fmt.Sprintf("email domain %s is %s", e.Domain, strings.ReplaceAll(e.Reason, "_", " "))
+}
+
+// disposableDomains are well-known throwaway mail providers, denied when
+// DomainPolicy.DenyDisposable is set.
+var disposableDomains = []string{
+	"10minutemail.com",
+	"dispostable.com",
+	"fakeinbox.com",
+	"getnada.com",
+	"guerrillamail.com",
+	"mailinator.com",
+	"maildrop.cc",
+	"sharklasers.com",
+	"temp-mail.org",
+	"throwawaymail.com",
+	"trashmail.com",
+	"yopmail.com",
+}
+
+// DomainPolicy restricts the domains new emails may use. A domain also
+// covers its subdomains. A non-empty Allow list admits only its domains,
+// as for a corporate deployment; Deny, and the built-in disposable
+// domains when DenyDisposable is set, win over Allow.
+type DomainPolicy struct {
+	Allow          []string `json:"allow"`
+	Deny           []string `json:"deny"`
+	DenyDisposable bool     `json:"deny_disposable"`
+}
+
+func LoadDomainPolicy(src *config.Source) DomainPolicy {
+	return DomainPolicy{
+		Allow:          splitDomains(src.String("EMAIL_DOMAIN_ALLOWLIST", "")),
+		Deny:           splitDomains(src.String("EMAIL_DOMAIN_DENYLIST", "")),
+		DenyDisposable: src.String("EMAIL_DOMAIN_DENY_DISPOSABLE", "false") == "true",
+	}
+}
+
+func splitDomains(s string) []string {
+	return normalizeDomains(strings.Split(s, ","))
+}
+
+// normalizeDomains lowercases the domains and drops blanks and leading
+// @ or dots, so "@Example.com" and ".example.com" both mean example.com.
+func normalizeDomains(domains []string) []string {
+	list := []string{}
+	for _, domain := range domains {
+		domain = strings.TrimLeft(strings.ToLower(strings.TrimSpace(domain)), "@.")
+		if domain != "" {
+			list = append(list, domain)
+		}
+	}
+	return list
+}
+
+// Normalized returns the policy with its domains in canonical form.
+func (p DomainPolicy) Normalized() DomainPolicy {
+	p.Allow = normalizeDomains(p.Allow)
+	p.Deny = normalizeDomains(p.Deny)
+	return p
+}
+
+func matchesDomain(domains []string, domain string) bool {
+	for _, d := range domains {
+		if domain == d || strings.HasSuffix(domain, "."+d) {
+			return true
+		}
+	}
+	return false
+}
+
+// Check returns an *EmailDomainError if the email's domain is refused.
+// Addresses without a domain are left to the other validation.
+func (p DomainPolicy) Check(email string) error {
+	at := strings.LastIndex(email, "@")
+	if at < 0 {
+		return nil
+	}
+	domain := strings.TrimSuffix(strings.ToLower(email[at+1:]), ".")
+	switch {
+	case matchesDomain(p.Deny, domain):
+		return &EmailDomainError{Domain: domain, Reason: DomainDenied}
+	case p.DenyDisposable && matchesDomain(disposableDomains, domain):
+		return &EmailDomainError{Domain: domain, Reason: DomainDisposable}
+	case len(p.Allow) > 0 && !matchesDomain(p.Allow, domain):
+		return &EmailDomainError{Domain: domain, Reason: DomainNotAllowed}
+	}
+	return nil
+}
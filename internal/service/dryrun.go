@@ -0,0 +1,18 @@
+package service
+
+import "context"
+
+type dryRunKey struct{}
+
+// WithDryRun marks the request as a dry run: the user operations that
+// honour it validate and check everything as usual, then return what they
+// would have stored without storing it, emitting events or running hooks.
+func WithDryRun(ctx context.Context) context.Context {
+	return context.WithValue(ctx, dryRunKey{}, true)
+}
+
+// DryRun reports whether WithDryRun marked the request.
+func DryRun(ctx context.Context) bool {
+	dry, _ := ctx.Value(dryRunKey{}).(bool)
+	return dry
+}
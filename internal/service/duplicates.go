@@ -0,0 +1,346 @@
+package service
+
+import (
+	"context"
+	"math"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+	"unicode"
+
+	"user-service/internal/config"
+	"user-service/internal/store"
+)
+
+// Duplicate matching rules.
+const (
+	RuleSameEmail   = "email"
+	RuleSamePhone   = "phone"
+	RuleSimilarName = "name"
+)
+
+// Confidence a match on each rule gives on its own. A name match gives
+// its weight times the names' similarity; matches on several rules add
+// up as independent evidence.
+var ruleWeights = map[string]float64{
+	RuleSameEmail:   0.95,
+	RuleSamePhone:   0.8,
+	RuleSimilarName: 0.6,
+}
+
+// maxNameBlock bounds how many users with the same name key are compared
+// with each other, so one very common name can't make a scan quadratic
+// in the user count.
+const maxNameBlock = 500
+
+// DuplicateRules configures duplicate detection.
+type DuplicateRules struct {
+	// Rules lists the rules that find candidates.
+	Rules []string
+	// NameSimilarity is how alike, from 0 to 1, two names must be to
+	// match.
+	NameSimilarity float64
+	// MinConfidence is the confidence below which pairs are not
+	// reported.
+	MinConfidence float64
+}
+
+// LoadDuplicateRules reads DUPLICATE_RULES, a comma-separated list of
+// email, phone and name, DUPLICATE_NAME_SIMILARITY and
+// DUPLICATE_MIN_CONFIDENCE.
+func LoadDuplicateRules(src *config.Source) DuplicateRules {
+	var rules []string
+	for _, rule := range strings.Split(src.String("DUPLICATE_RULES", "email,phone,name"), ",") {
+		if rule = strings.TrimSpace(rule); ruleWeights[rule] > 0 {
+			rules = append(rules, rule)
+		}
+	}
+	return DuplicateRules{
+		Rules:          rules,
+		NameSimilarity: src.Float("DUPLICATE_NAME_SIMILARITY", 0.85),
+		MinConfidence:  src.Float("DUPLICATE_MIN_CONFIDENCE", 0.5),
+	}
+}
+
+// DuplicateMatch is a pair of users that look like the same person and
+// the rules they matched on.
+type DuplicateMatch struct {
+	UserIDs    [2]string `json:"user_ids"`
+	Confidence float64   `json:"confidence"`
+	Rules      []string  `json:"rules"`
+}
+
+// DuplicateCandidate is a user in a duplicate group.
+type DuplicateCandidate struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+}
+
+// DuplicateGroup is a set of users linked by matches, to be merged into
+// one of them. Confidence is that of its strongest match.
+type DuplicateGroup struct {
+	Users      []DuplicateCandidate `json:"users"`
+	Confidence float64              `json:"confidence"`
+	Matches    []DuplicateMatch     `json:"matches"`
+}
+
+// DuplicateReport is the result of a scan, strongest groups first.
+type DuplicateReport struct {
+	Running     bool             `json:"running"`
+	GeneratedAt *time.Time       `json:"generated_at,omitempty"`
+	Scanned     int              `json:"scanned"`
+	Groups      []DuplicateGroup `json:"groups"`
+}
+
+// Duplicates finds likely duplicate accounts. A scan reads every user, so
+// it runs in the background and the latest report is served until the
+// next one finishes.
+type Duplicates struct {
+	store  store.Store
+	emails *Emails
+	rules  atomic.Pointer[DuplicateRules]
+
+	mu      sync.Mutex
+	running bool
+	report  DuplicateReport
+}
+
+func NewDuplicates(s store.Store, emails *Emails, rules DuplicateRules) *Duplicates {
+	d := &Duplicates{store: s, emails: emails}
+	d.SetRules(rules)
+	return d
+}
+
+func (d *Duplicates) SetRules(rules DuplicateRules) {
+	d.rules.Store(&rules)
+}
+
+// Report returns the latest report, and whether a scan has finished yet.
+func (d *Duplicates) Report() (DuplicateReport, bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	report := d.report
+	report.Running = d.running
+	return report, report.GeneratedAt != nil
+}
+
+// Start begins a scan in the background unless one is running.
+func (d *Duplicates) Start(ctx context.Context) {
+	go d.Scan(context.WithoutCancel(ctx))
+}
+
+// Scan reads every user and replaces the report with what it finds. It
+// returns at once if another scan is running.
+func (d *Duplicates) Scan(ctx context.Context) error {
+	d.mu.Lock()
+	if d.running {
+		d.mu.Unlock()
+		return nil
+	}
+	d.running = true
+	d.mu.Unlock()
+	defer func() {
+		d.mu.Lock()
+		d.running = false
+		d.mu.Unlock()
+	}()
+
+	var users []store.User
+	err := d.store.ForEach(ctx, func(user store.User) error {
+		if user.Status != store.StatusMerged {
+			users = append(users, user)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	groups := findDuplicates(users, *d.rules.Load(), d.emails.Normalize)
+
+	now := time.Now().UTC()
+	d.mu.Lock()
+	d.report = DuplicateReport{GeneratedAt: &now, Scanned: len(users), Groups: groups}
+	d.mu.Unlock()
+	return nil
+}
+
+// findDuplicates matches users that share a key under each rule and
+// groups those linked by matches of at least the minimum confidence.
+func findDuplicates(users []store.User, rules DuplicateRules, normalizeEmail func(string) string) []DuplicateGroup {
+	// pairs holds, per pair of user indexes with the lower first, the
+	// confidence each rule gives it.
+	pairs := make(map[[2]int]map[string]float64)
+	match := func(i, j int, rule string, confidence float64) {
+		key := [2]int{min(i, j), max(i, j)}
+		if pairs[key] == nil {
+			pairs[key] = make(map[string]float64)
+		}
+		pairs[key][rule] = confidence
+	}
+	for _, rule := range rules.Rules {
+		blocks := make(map[string][]int)
+		for i, user := range users {
+			var keys []string
+			switch rule {
+			case RuleSameEmail:
+				keys = []string{normalizeEmail(user.Email)}
+			case RuleSamePhone:
+				keys = []string{user.Phone}
+			case RuleSimilarName:
+				keys = nameKeys(user.Name)
+			}
+			for _, key := range keys {
+				if key != "" {
+					blocks[key] = append(blocks[key], i)
+				}
+			}
+		}
+		for _, block := range blocks {
+			if rule == RuleSimilarName && len(block) > maxNameBlock {
+				continue
+			}
+			for a := 0; a < len(block); a++ {
+				for b := a + 1; b < len(block); b++ {
+					i, j := block[a], block[b]
+					if rule != RuleSimilarName {
+						match(i, j, rule, ruleWeights[rule])
+					} else if sim := nameSimilarity(users[i].Name, users[j].Name); sim >= rules.NameSimilarity {
+						match(i, j, rule, ruleWeights[rule]*sim)
+					}
+				}
+			}
+		}
+	}
+
+	// Link the users of every pair that is confident enough.
+	parent := make([]int, len(users))
+	for i := range parent {
+		parent[i] = i
+	}
+	var find func(int) int
+	find = func(i int) int {
+		if parent[i] != i {
+			parent[i] = find(parent[i])
+		}
+		return parent[i]
+	}
+	matches := make(map[int][]DuplicateMatch)
+	for key, byRule := range pairs {
+		doubt := 1.0
+		matched := make([]string, 0, len(byRule))
+		for rule, confidence := range byRule {
+			doubt *= 1 - confidence
+			matched = append(matched, rule)
+		}
+		confidence := math.Round((1-doubt)*100) / 100
+		if confidence < rules.MinConfidence {
+			continue
+		}
+		sort.Strings(matched)
+		parent[find(key[0])] = find(key[1])
+		m := DuplicateMatch{UserIDs: [2]string{users[key[0]].ID, users[key[1]].ID}, Confidence: confidence, Rules: matched}
+		sort.Strings(m.UserIDs[:])
+		matches[key[0]] = append(matches[key[0]], m)
+	}
+
+	byRoot := make(map[int]*DuplicateGroup)
+	members := make(map[int][]int)
+	for i := range users {
+		members[find(i)] = append(members[find(i)], i)
+	}
+	for i, list := range matches {
+		root := find(i)
+		g := byRoot[root]
+		if g == nil {
+			g = &DuplicateGroup{}
+			for _, m := range members[root] {
+				g.Users = append(g.Users, DuplicateCandidate{ID: users[m].ID, Name: users[m].Name})
+			}
+			sort.Slice(g.Users, func(a, b int) bool { return g.Users[a].ID < g.Users[b].ID })
+			byRoot[root] = g
+		}
+		for _, m := range list {
+			g.Matches = append(g.Matches, m)
+			g.Confidence = max(g.Confidence, m.Confidence)
+		}
+	}
+
+	groups := make([]DuplicateGroup, 0, len(byRoot))
+	for _, g := range byRoot {
+		sort.Slice(g.Matches, func(a, b int) bool {
+			if g.Matches[a].Confidence != g.Matches[b].Confidence {
+				return g.Matches[a].Confidence > g.Matches[b].Confidence
+			}
+			return g.Matches[a].UserIDs[0] < g.Matches[b].UserIDs[0]
+		})
+		groups = append(groups, *g)
+	}
+	sort.Slice(groups, func(a, b int) bool {
+		if groups[a].Confidence != groups[b].Confidence {
+			return groups[a].Confidence > groups[b].Confidence
+		}
+		return groups[a].Users[0].ID < groups[b].Users[0].ID
+	})
+	return groups
+}
+
+// normalizeName lowercases the name, drops everything but letters,
+// digits and spaces, and sorts its words, so "Doe, John" and "john doe"
+// come out the same.
+func normalizeName(name string) string {
+	words := strings.FieldsFunc(strings.ToLower(name), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	sort.Strings(words)
+	return strings.Join(words, " ")
+}
+
+// nameKeys blocks names by the first three letters of each of their
+// words. Names are only compared when one of their words starts the same,
+// which keeps scans fast but misses names with typos at the start of
+// every word.
+func nameKeys(name string) []string {
+	var keys []string
+	for _, word := range strings.Fields(normalizeName(name)) {
+		runes := []rune(word)
+		key := string(runes[:min(3, len(runes))])
+		if len(keys) == 0 || keys[len(keys)-1] != key {
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
+
+// nameSimilarity is one minus the edit distance between the normalized
+// names over the longer one's length.
+func nameSimilarity(a, b string) float64 {
+	ra, rb := []rune(normalizeName(a)), []rune(normalizeName(b))
+	longest := max(len(ra), len(rb))
+	if longest == 0 {
+		return 0
+	}
+	return 1 - float64(editDistance(ra, rb))/float64(longest)
+}
+
+// editDistance is the Levenshtein distance between a and b.
+func editDistance(a, b []rune) int {
+	prev := make([]int, len(b)+1)
+	cur := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(b)]
+}
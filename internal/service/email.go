@@ -0,0 +1,227 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"hash/fnv"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"user-service/internal/config"
+	"user-service/internal/store"
+)
+
+// ErrEmailTaken rejects a write that would give two users the same
+// normalized email.
+var ErrEmailTaken = errors.New("email already in use")
+
+// EmailPolicy says how addresses are normalized before they are stored,
+// looked up at login or compared for uniqueness. Surrounding spaces are
+// always trimmed.
+type EmailPolicy struct {
+	// Lowercase lowercases the whole address.
+	Lowercase bool
+	// FoldGmail drops dots and +suffixes from Gmail local parts, which
+	// Gmail ignores, and maps googlemail.com to gmail.com.
+	FoldGmail bool
+}
+
+func LoadEmailPolicy(src *config.Source) EmailPolicy {
+	return EmailPolicy{
+		Lowercase: src.String("EMAIL_LOWERCASE", "true") == "true",
+		FoldGmail: src.String("EMAIL_FOLD_GMAIL", "false") == "true",
+	}
+}
+
+// Normalize returns the canonical form of the address under the policy.
+func (p EmailPolicy) Normalize(email string) string {
+	email = strings.TrimSpace(email)
+	if p.Lowercase {
+		email = strings.ToLower(email)
+	}
+	if !p.FoldGmail {
+		return email
+	}
+	at := strings.LastIndex(email, "@")
+	if at < 0 {
+		return email
+	}
+	local, domain := email[:at], email[at+1:]
+	if !strings.EqualFold(domain, "gmail.com") && !strings.EqualFold(domain, "googlemail.com") {
+		return email
+	}
+	local, _, _ = strings.Cut(local, "+")
+	local = strings.ReplaceAll(local, ".", "")
+	return local + "@gmail.com"
+}
+
+// emailStripes is how many locks the addresses being written are spread
+// over.
+const emailStripes = 64
+
+// Emails normalizes addresses under a policy that can be swapped at
+// runtime. It is shared by Users and Logins so both agree on the form an
+// address is stored and looked up in.
+type Emails struct {
+	policy  atomic.Pointer[EmailPolicy]
+	domains atomic.Pointer[DomainPolicy]
+	// stripes serialize the writes that give a user an address, so two
+	// users can't claim the same one between the uniqueness check and the
+	// write. Each guards the addresses that hash to it.
+	stripes [emailStripes]sync.Mutex
+}
+
+func NewEmails(policy EmailPolicy) *Emails {
+	e := &Emails{}
+	e.SetPolicy(policy)
+	return e
+}
+
+func (e *Emails) Policy() EmailPolicy {
+	return *e.policy.Load()
+}
+
+func (e *Emails) SetPolicy(policy EmailPolicy) {
+	e.policy.Store(&policy)
+}
+
+// Domains returns the DomainPolicy new emails are checked against.
+func (e *Emails) Domains() DomainPolicy {
+	if domains := e.domains.Load(); domains != nil {
+		return *domains
+	}
+	return DomainPolicy{Allow: []string{}, Deny: []string{}}
+}
+
+func (e *Emails) SetDomains(domains DomainPolicy) {
+	domains = domains.Normalized()
+	e.domains.Store(&domains)
+}
+
+func (e *Emails) Normalize(email string) string {
+	return e.Policy().Normalize(email)
+}
+
+// lock locks the stripe of the normalized email and returns the function
+// that unlocks it.
+func (e *Emails) lock(email string) func() {
+	h := fnv.New32a()
+	h.Write([]byte(email))
+	mu := &e.stripes[h.Sum32()%emailStripes]
+	mu.Lock()
+	return mu.Unlock
+}
+
+// lockAll locks every stripe, in order, and returns the function that
+// unlocks them.
+func (e *Emails) lockAll() func() {
+	for i := range e.stripes {
+		e.stripes[i].Lock()
+	}
+	return func() {
+		for i := range e.stripes {
+			e.stripes[i].Unlock()
+		}
+	}
+}
+
+// claim checks that no user other than id has the email, seeing every
+// write that finished before it. Callers must hold the email's lock until
+// the write that stores it is done.
+func (e *Emails) claim(ctx context.Context, s store.Store, id, email string) error {
+	if email == "" {
+		return nil
+	}
+	existing, err := s.GetByEmail(store.Fresh(ctx), email)
+	if errors.Is(err, store.ErrUserNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if existing.ID != id {
+		return ErrEmailTaken
+	}
+	return nil
+}
+
+// EmailDuplicate is a set of users whose emails normalize to the same
+// address. Kept keeps the address; the users in Removed are deleted.
+type EmailDuplicate struct {
+	Email   string   `json:"email"`
+	Kept    string   `json:"kept"`
+	Removed []string `json:"removed"`
+}
+
+// EmailMigration reports what NormalizeEmails changed, or would change
+// on a dry run.
+type EmailMigration struct {
+	Applied    bool             `json:"applied"`
+	Normalized []string         `json:"normalized"`
+	Duplicates []EmailDuplicate `json:"duplicates"`
+}
+
+// NormalizeEmails rewrites stored emails into their normalized form and
+// resolves the duplicates that reveals. Of each set of duplicates the
+// active user is kept, or failing that the one with the lowest ID, and
+// the others are deleted through the usual delete hooks. Unless apply is
+// set nothing is written and the report says what would be.
+func (u *Users) NormalizeEmails(ctx context.Context, apply bool) (EmailMigration, error) {
+	defer u.emails.lockAll()()
+
+	groups := make(map[string][]store.User)
+	err := u.store.ForEach(ctx, func(user store.User) error {
+		email := u.emails.Normalize(user.Email)
+		groups[email] = append(groups[email], user)
+		return nil
+	})
+	if err != nil {
+		return EmailMigration{}, err
+	}
+	emails := make([]string, 0, len(groups))
+	for email := range groups {
+		emails = append(emails, email)
+	}
+	sort.Strings(emails)
+
+	migration := EmailMigration{Applied: apply, Normalized: []string{}, Duplicates: []EmailDuplicate{}}
+	for _, email := range emails {
+		group := groups[email]
+		sort.Slice(group, func(i, j int) bool {
+			if active := group[i].Status == store.StatusActive; active != (group[j].Status == store.StatusActive) {
+				return active
+			}
+			return group[i].ID < group[j].ID
+		})
+		kept := group[0]
+		if len(group) > 1 {
+			dup := EmailDuplicate{Email: email, Kept: kept.ID}
+			for _, user := range group[1:] {
+				dup.Removed = append(dup.Removed, user.ID)
+			}
+			migration.Duplicates = append(migration.Duplicates, dup)
+		}
+		if kept.Email != email {
+			migration.Normalized = append(migration.Normalized, kept.ID)
+		}
+		if !apply {
+			continue
+		}
+
+		for _, user := range group[1:] {
+			if err := u.Delete(ctx, user.ID); err != nil && !errors.Is(err, store.ErrUserNotFound) {
+				return migration, err
+			}
+		}
+		if kept.Email != email {
+			kept.Email = email
+			if err := u.store.Update(ctx, kept); err != nil {
+				return migration, err
+			}
+			u.hooks.runAfter(ctx, store.NewEvent(store.EventUserUpdated, kept.ID))
+		}
+	}
+	return migration, nil
+}
@@ -0,0 +1,72 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"time"
+
+	"user-service/internal/store"
+)
+
+// EventPublisher delivers user events to interested consumers. Delivery
+// is at least once, so consumers should deduplicate on Seq.
+type EventPublisher interface {
+	Publish(event store.Event) error
+}
+
+// LogPublisher writes events to the service log as JSON lines.
+type LogPublisher struct{}
+
+func (LogPublisher) Publish(event store.Event) error {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	log.Printf("event: %s", data)
+	return nil
+}
+
+// OutboxRelay publishes outbox events in order, retrying from the first
+// failure on the next tick.
+type OutboxRelay struct {
+	store     store.Store
+	publisher EventPublisher
+	interval  time.Duration
+	batchSize int
+}
+
+func NewOutboxRelay(s store.Store, publisher EventPublisher, interval time.Duration, batchSize int) *OutboxRelay {
+	return &OutboxRelay{store: s, publisher: publisher, interval: interval, batchSize: batchSize}
+}
+
+func (o *OutboxRelay) Run(ctx context.Context) {
+	ticker := time.NewTicker(o.interval)
+	defer ticker.Stop()
+	for {
+		o.flush(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (o *OutboxRelay) flush(ctx context.Context) {
+	pending, err := o.store.PendingEvents(ctx, o.batchSize)
+	if err != nil {
+		log.Printf("outbox: load pending: %v", err)
+		return
+	}
+	for _, event := range pending {
+		if err := o.publisher.Publish(event); err != nil {
+			log.Printf("outbox: publish %d (%s): %v", event.Seq, event.Type, err)
+			return
+		}
+		if err := o.store.MarkSent(ctx, event.Seq, time.Now().UTC()); err != nil {
+			log.Printf("outbox: mark %d sent: %v", event.Seq, err)
+			return
+		}
+	}
+}
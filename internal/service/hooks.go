@@ -0,0 +1,174 @@
+package service
+
+import (
+	"context"
+	"expvar"
+	"fmt"
+	"log"
+	"sync"
+
+	"user-service/internal/store"
+	"user-service/internal/trace"
+)
+
+var hookMetrics = expvar.NewMap("user_hooks")
+
+// Hook reacts to a change to a user, for example by removing data that
+// another module keeps about them. It receives the event describing the
+// change, and a context carrying the trace of the request behind it, which
+// hooks calling other services should pass on with trace.From(ctx).Inject.
+type Hook func(ctx context.Context, event store.Event) error
+
+// HookMode says when a hook runs.
+type HookMode int
+
+const (
+	// Sync hooks run inside the Users call. Delete hooks run before the
+	// user is removed, and any failure aborts the delete so it can be
+	// retried without leaving orphaned data; update hooks run after the
+	// change and failures are logged.
+	Sync HookMode = iota
+	// Async hooks run from the outbox relay once the event is published.
+	// A failure stops the relay at that event and it is retried on the
+	// next tick, so async hooks must be idempotent. They also see changes
+	// made outside Users, such as automatic lockouts.
+	Async
+)
+
+// deleteEvents and updateEvents are the event types each kind of hook
+// fires on.
+var (
+	deleteEvents = map[string]bool{
+		store.EventUserDeleted:   true,
+		store.EventUserForgotten: true,
+	}
+	updateEvents = map[string]bool{
+		store.EventUserUpdated:   true,
+		store.EventUserSuspended: true,
+		store.EventUserActivated: true,
+		store.EventUserLocked:    true,
+		store.EventUserMerged:    true,
+	}
+)
+
+// HookError reports the hook that failed.
+type HookError struct {
+	Hook string
+	Err  error
+}
+
+func (e *HookError) Error() string {
+	return fmt.Sprintf("hook %s: %v", e.Hook, e.Err)
+}
+
+func (e *HookError) Unwrap() error {
+	return e.Err
+}
+
+type namedHook struct {
+	name string
+	mode HookMode
+	fn   Hook
+}
+
+// Hooks holds the callbacks other modules register to cascade user
+// deletes and updates into their own data.
+type Hooks struct {
+	mu       sync.RWMutex
+	onDelete []namedHook
+	onUpdate []namedHook
+}
+
+func NewHooks() *Hooks {
+	return &Hooks{}
+}
+
+// OnDelete registers fn to run when a user is deleted or forgotten.
+func (h *Hooks) OnDelete(name string, mode HookMode, fn Hook) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.onDelete = append(h.onDelete, namedHook{name: name, mode: mode, fn: fn})
+}
+
+// OnUpdate registers fn to run when a user is updated or changes status.
+func (h *Hooks) OnUpdate(name string, mode HookMode, fn Hook) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.onUpdate = append(h.onUpdate, namedHook{name: name, mode: mode, fn: fn})
+}
+
+// hooksFor returns the hooks of the mode registered for the event type.
+func (h *Hooks) hooksFor(eventType string, mode HookMode) []namedHook {
+	if h == nil {
+		return nil
+	}
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	var registered []namedHook
+	switch {
+	case deleteEvents[eventType]:
+		registered = h.onDelete
+	case updateEvents[eventType]:
+		registered = h.onUpdate
+	}
+	var hooks []namedHook
+	for _, hook := range registered {
+		if hook.mode == mode {
+			hooks = append(hooks, hook)
+		}
+	}
+	return hooks
+}
+
+// run calls the hooks of the mode for the event in registration order,
+// stopping at the first failure.
+func (h *Hooks) run(ctx context.Context, mode HookMode, event store.Event) error {
+	event = event.WithTrace(trace.From(ctx))
+	for _, hook := range h.hooksFor(event.Type, mode) {
+		hookMetrics.Add("calls", 1)
+		if err := hook.fn(ctx, event); err != nil {
+			hookMetrics.Add("failures", 1)
+			return &HookError{Hook: hook.name, Err: err}
+		}
+	}
+	return nil
+}
+
+// runAfter runs the sync hooks for a change that has already been made,
+// logging failures since the change stands.
+func (h *Hooks) runAfter(ctx context.Context, event store.Event) {
+	if err := h.run(ctx, Sync, event); err != nil {
+		log.Printf("hooks: %s %s: %v", event.Type, event.UserID, err)
+	}
+}
+
+// Publisher wraps next so that async hooks run for each event after it
+// is published. When a hook fails the relay retries the event, but only
+// the hooks run again; next has it already.
+func (h *Hooks) Publisher(next EventPublisher) EventPublisher {
+	return &hookPublisher{next: next, hooks: h}
+}
+
+// hookPublisher is used by the one relay, which publishes an event at a
+// time.
+type hookPublisher struct {
+	next  EventPublisher
+	hooks *Hooks
+	// failed is the sequence number of the event whose hooks failed after
+	// it was published to next, or 0.
+	failed uint64
+}
+
+func (p *hookPublisher) Publish(event store.Event) error {
+	if event.Seq == 0 || event.Seq != p.failed {
+		if err := p.next.Publish(event); err != nil {
+			return err
+		}
+	}
+	if err := p.hooks.run(trace.With(context.Background(), event.Trace()), Async, event); err != nil {
+		p.failed = event.Seq
+		return err
+	}
+	p.failed = 0
+	return nil
+}
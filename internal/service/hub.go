@@ -0,0 +1,119 @@
+package service
+
+import (
+	"context"
+	"sync"
+
+	"user-service/internal/store"
+)
+
+// Hub tells in-process subscribers, such as long polls, when a user
+// changes. It is fed by the outbox relay through Publisher, so it sees
+// events in sequence order, at least once, about one relay interval after
+// they are committed.
+//
+// A user's version is the sequence number of the latest change event the
+// hub has seen for them, or 0 if it has seen none since it started.
+type Hub struct {
+	mu       sync.Mutex
+	versions map[string]uint64
+	// changed holds, per user with waiters, a channel closed on the
+	// user's next change.
+	changed map[string]chan struct{}
+	closed  bool
+}
+
+func NewHub() *Hub {
+	return &Hub{versions: make(map[string]uint64), changed: make(map[string]chan struct{})}
+}
+
+// Publisher wraps next so that the hub learns of each event once next has
+// published it.
+func (h *Hub) Publisher(next EventPublisher) EventPublisher {
+	return hubPublisher{next: next, hub: h}
+}
+
+type hubPublisher struct {
+	next EventPublisher
+	hub  *Hub
+}
+
+func (p hubPublisher) Publish(event store.Event) error {
+	if err := p.next.Publish(event); err != nil {
+		return err
+	}
+	p.hub.observe(event)
+	return nil
+}
+
+// observe records a change event. A merge changes both users: the target
+// gains the source's data and the source becomes a tombstone.
+func (h *Hub) observe(event store.Event) {
+	if !changeEvents[event.Type] {
+		return
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.bump(event.UserID, event.Seq)
+	if source := event.Data["source_id"]; event.Type == store.EventUserMerged && source != "" {
+		h.bump(source, event.Seq)
+	}
+}
+
+// bump raises the user's version and wakes its waiters. Events the relay
+// publishes again after a failure don't count twice.
+func (h *Hub) bump(id string, seq uint64) {
+	if seq <= h.versions[id] {
+		return
+	}
+	h.versions[id] = seq
+	if ch, ok := h.changed[id]; ok {
+		close(ch)
+		delete(h.changed, id)
+	}
+}
+
+// Version returns the user's current version.
+func (h *Hub) Version(id string) uint64 {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.versions[id]
+}
+
+// Wait blocks until the user's version differs from version and returns
+// the new one. A version from before a restart, ahead of the hub's, counts
+// as different. It returns the unchanged version with ctx's error if ctx
+// ends first, and at once after Close.
+func (h *Hub) Wait(ctx context.Context, id string, version uint64) (uint64, error) {
+	h.mu.Lock()
+	current := h.versions[id]
+	if current != version || h.closed {
+		h.mu.Unlock()
+		return current, nil
+	}
+	ch, ok := h.changed[id]
+	if !ok {
+		ch = make(chan struct{})
+		h.changed[id] = ch
+	}
+	h.mu.Unlock()
+
+	select {
+	case <-ch:
+		return h.Version(id), nil
+	case <-ctx.Done():
+		return version, ctx.Err()
+	}
+}
+
+// Close wakes every waiter and makes later waits return at once, so long
+// polls answer promptly when the server shuts down.
+func (h *Hub) Close() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.closed = true
+	for id, ch := range h.changed {
+		close(ch)
+		delete(h.changed, id)
+	}
+}
@@ -0,0 +1,241 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+
+	"user-service/internal/auth"
+	"user-service/internal/config"
+	"user-service/internal/store"
+)
+
+var (
+	ErrIPBlocked          = errors.New("too many failed login attempts")
+	ErrInvalidCredentials = errors.New("invalid credentials")
+	ErrInvalidMFAToken    = errors.New("invalid or expired mfa token")
+)
+
+// AccountStatusError rejects a login to an account that isn't active.
+type AccountStatusError struct {
+	Status string
+}
+
+func (e *AccountStatusError) Error() string {
+	return "account " + e.Status
+}
+
+func LoadLoginPolicy(src *config.Source) store.LoginPolicy {
+	return store.LoginPolicy{
+		MaxFailures:     src.Int("LOGIN_MAX_FAILURES", 5),
+		LockoutDuration: src.Duration("LOGIN_LOCKOUT_DURATION", 15*time.Minute),
+		IPMaxFailures:   src.Int("LOGIN_IP_MAX_FAILURES", 20),
+		IPWindow:        src.Duration("LOGIN_IP_WINDOW", 15*time.Minute),
+	}
+}
+
+// LoginResult is a completed login, or the first step of one that needs
+// a second factor.
+type LoginResult struct {
+	Token       string
+	MFARequired bool
+	MFAToken    string
+	User        *store.User
+}
+
+// Logins authenticates users by password and second factor, enforcing
+// the lockout policy.
+type Logins struct {
+	store  store.Store
+	tokens *auth.TokenIssuer
+	emails *Emails
+	policy atomic.Pointer[store.LoginPolicy]
+}
+
+func NewLogins(s store.Store, tokens *auth.TokenIssuer, emails *Emails, policy store.LoginPolicy) *Logins {
+	l := &Logins{store: s, tokens: tokens, emails: emails}
+	l.SetPolicy(policy)
+	dummyHash() // so the first unknown email isn't slower than the rest
+	return l
+}
+
+func (l *Logins) Policy() store.LoginPolicy {
+	return *l.policy.Load()
+}
+
+func (l *Logins) SetPolicy(policy store.LoginPolicy) {
+	l.policy.Store(&policy)
+}
+
+// checkIP rejects the login if its IP is blocked for failed logins.
+func (l *Logins) checkIP(ctx context.Context, ip string) error {
+	blocked, err := l.store.IPBlocked(ctx, ip, l.Policy())
+	if err != nil {
+		return err
+	}
+	if blocked {
+		if err := l.store.Emit(ctx, store.NewEvent(store.EventLoginIPBlocked, "").With("ip", ip)); err != nil {
+			log.Printf("login: emit ip blocked: %v", err)
+		}
+		return ErrIPBlocked
+	}
+	return nil
+}
+
+// reject records the failed attempt and its security event, then returns
+// the reason the login failed.
+func (l *Logins) reject(ctx context.Context, attempt store.LoginAttempt, reason error) error {
+	if err := l.store.RecordLoginAttempt(ctx, attempt, l.Policy()); err != nil {
+		return err
+	}
+	event := store.NewEvent(store.EventLoginFailed, attempt.UserID).With("ip", attempt.IP).With("reason", attempt.Reason)
+	if err := l.store.Emit(ctx, event); err != nil {
+		log.Printf("login: emit failure: %v", err)
+	}
+	return reason
+}
+
+// dummyHash is compared against when there is no password hash to check,
+// so a login to an unknown email takes as long as a wrong password.
+var dummyHash = sync.OnceValue(func() []byte {
+	hash, _ := bcrypt.GenerateFromPassword([]byte("no password"), bcrypt.DefaultCost)
+	return hash
+})
+
+// checkPassword reports whether the password matches the hash, spending
+// the same bcrypt work whether or not there is a hash.
+func checkPassword(hash, password string) bool {
+	if hash == "" {
+		bcrypt.CompareHashAndPassword(dummyHash(), []byte(password))
+		return false
+	}
+	return bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) == nil
+}
+
+// Login checks the email and password. Users with two-factor
+// authentication get an mfa token to finish with LoginTwoFactor. The
+// account's status is only reported to callers who know the password,
+// and a locked account refuses every password alike, so guesses can't go
+// on during a lockout.
+func (l *Logins) Login(ctx context.Context, email, password, ip string) (LoginResult, error) {
+	if err := l.checkIP(ctx, ip); err != nil {
+		return LoginResult{}, err
+	}
+
+	attempt := store.LoginAttempt{IP: ip, Time: time.Now().UTC()}
+	user, err := l.store.GetByEmail(ctx, l.emails.Normalize(email))
+	if errors.Is(err, store.ErrUserNotFound) {
+		checkPassword("", password)
+		attempt.Reason = "unknown user"
+		return LoginResult{}, l.reject(ctx, attempt, ErrInvalidCredentials)
+	}
+	if err != nil {
+		return LoginResult{}, err
+	}
+	attempt.UserID = user.ID
+
+	released, err := l.store.ReleaseExpiredLock(ctx, user.ID, attempt.Time)
+	if err == nil && released {
+		user, err = l.store.Get(store.Fresh(ctx), user.ID)
+	}
+	if err != nil {
+		return LoginResult{}, err
+	}
+
+	valid := checkPassword(user.PasswordHash, password)
+	switch {
+	case user.Status == store.StatusLocked:
+		attempt.Reason = "account locked"
+		return LoginResult{}, l.reject(ctx, attempt, ErrInvalidCredentials)
+	case !valid:
+		attempt.Reason = "invalid password"
+		return LoginResult{}, l.reject(ctx, attempt, ErrInvalidCredentials)
+	case user.Status == store.StatusSuspended:
+		attempt.Reason = "account suspended"
+		return LoginResult{}, l.reject(ctx, attempt, &AccountStatusError{Status: user.Status})
+	}
+
+	enabled, err := l.store.TwoFactorEnabled(ctx, user.ID)
+	if err != nil {
+		return LoginResult{}, err
+	}
+	if enabled {
+		mfaToken, err := l.tokens.Issue(user.ID, auth.TokenTypeMFA, nil)
+		if err != nil {
+			return LoginResult{}, err
+		}
+		return LoginResult{MFARequired: true, MFAToken: mfaToken}, nil
+	}
+
+	return l.complete(ctx, attempt, user)
+}
+
+// TokenSubjectAllowed reports whether the user an access token names may
+// still use it: one that was deleted, suspended or merged away may not. A
+// login lockout keeps sessions already open, so failed guesses by
+// someone else can't sign the user out.
+func (l *Logins) TokenSubjectAllowed(ctx context.Context, id string) (bool, error) {
+	user, err := l.store.Get(ctx, id)
+	if errors.Is(err, store.ErrUserNotFound) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return user.Status == store.StatusActive || user.Status == store.StatusLocked, nil
+}
+
+// LoginTwoFactor finishes a login for users with two-factor
+// authentication, exchanging the mfa token and a TOTP or recovery code
+// for an access token.
+func (l *Logins) LoginTwoFactor(ctx context.Context, mfaToken, code, ip string) (LoginResult, error) {
+	if err := l.checkIP(ctx, ip); err != nil {
+		return LoginResult{}, err
+	}
+
+	claims, err := l.tokens.Verify(mfaToken, auth.TokenTypeMFA)
+	if err != nil {
+		return LoginResult{}, ErrInvalidMFAToken
+	}
+
+	user, err := l.store.Get(ctx, claims.Subject)
+	if err != nil && !errors.Is(err, store.ErrUserNotFound) {
+		return LoginResult{}, err
+	}
+	if err != nil || user.Status != store.StatusActive {
+		return LoginResult{}, ErrInvalidMFAToken
+	}
+
+	attempt := store.LoginAttempt{UserID: user.ID, IP: ip, Time: time.Now().UTC()}
+	err = l.store.VerifyTwoFactor(ctx, user.ID, code, true, attempt.Time)
+	if err != nil && !store.IsDomainError(err) {
+		return LoginResult{}, err
+	}
+	if err != nil {
+		attempt.Reason = "invalid two-factor code"
+		return LoginResult{}, l.reject(ctx, attempt, store.ErrInvalidTwoFactorCode)
+	}
+
+	return l.complete(ctx, attempt, user)
+}
+
+func (l *Logins) complete(ctx context.Context, attempt store.LoginAttempt, user store.User) (LoginResult, error) {
+	token, err := l.tokens.Issue(user.ID, auth.TokenTypeAccess, user.Scopes)
+	if err != nil {
+		return LoginResult{}, err
+	}
+
+	attempt.Success = true
+	if err := l.store.RecordLoginAttempt(ctx, attempt, l.Policy()); err != nil {
+		return LoginResult{}, err
+	}
+	if err := l.store.Emit(ctx, store.NewEvent(store.EventLoginSucceeded, user.ID).With("ip", attempt.IP)); err != nil {
+		log.Printf("login: emit success: %v", err)
+	}
+	return LoginResult{Token: token, User: &user}, nil
+}
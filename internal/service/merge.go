@@ -0,0 +1,30 @@
+package service
+
+import (
+	"context"
+
+	"user-service/internal/store"
+)
+
+// maxMergeHops bounds how many merges Get follows, in case a target was
+// itself merged later.
+const maxMergeHops = 10
+
+// Merge folds sourceID, a duplicate account, into targetID and returns
+// the target as stored. The source keeps resolving to the target through
+// Get. Update hooks receive the user.merged event with the source ID in
+// its data, so other modules can move what they hold about the source.
+func (u *Users) Merge(ctx context.Context, targetID, sourceID string) (store.User, error) {
+	if sourceID == "" {
+		return store.User{}, invalidField("/source_id", RuleRequired, "source_id is required")
+	}
+	if sourceID == targetID {
+		return store.User{}, invalid("A user can't be merged into itself")
+	}
+	user, err := u.store.Merge(ctx, sourceID, targetID)
+	if err != nil {
+		return store.User{}, err
+	}
+	u.hooks.runAfter(ctx, store.NewEvent(store.EventUserMerged, targetID).With("source_id", sourceID))
+	return user, nil
+}
@@ -0,0 +1,54 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"user-service/internal/store"
+)
+
+func TestMerge(t *testing.T) {
+	ctx := context.Background()
+	s := store.NewUserStore()
+	for _, id := range []string{"1", "2"} {
+		if err := s.Create(ctx, store.User{ID: id, Name: "User " + id, Email: id + "@example.com"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	hooks := NewHooks()
+	var seen []store.Event
+	hooks.OnUpdate("record", Sync, func(ctx context.Context, event store.Event) error {
+		seen = append(seen, event)
+		return nil
+	})
+	users := NewUsers(s, func() []string { return nil }, hooks, NewEmails(EmailPolicy{}), nil, nil, nil)
+
+	var invalidErr *ValidationError
+	if _, err := users.Merge(ctx, "1", ""); !errors.As(err, &invalidErr) {
+		t.Fatalf("Merge without a source = %v, want a validation error", err)
+	}
+	if _, err := users.Merge(ctx, "1", "1"); !errors.As(err, &invalidErr) {
+		t.Fatalf("Merge into itself = %v, want a validation error", err)
+	}
+	if len(seen) != 0 {
+		t.Fatalf("hooks ran for refused merges: %v", seen)
+	}
+
+	target, err := users.Merge(ctx, "1", "2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if target.ID != "1" {
+		t.Fatalf("Merge returned %+v, want the target", target)
+	}
+	if len(seen) != 1 || seen[0].Type != store.EventUserMerged || seen[0].UserID != "1" || seen[0].Data["source_id"] != "2" {
+		t.Fatalf("hooks saw %+v, want one merge of 2 into 1", seen)
+	}
+	if resolved, err := users.Get(ctx, "2"); err != nil || resolved.ID != "1" {
+		t.Fatalf("Get of the source = %+v, %v; want the target", resolved, err)
+	}
+	if _, err := users.Merge(ctx, "1", "2"); err == nil {
+		t.Fatal("merging an already merged source succeeded")
+	}
+}
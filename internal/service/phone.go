@@ -0,0 +1,236 @@
+package service
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+	"sync"
+	"time"
+
+	"user-service/internal/config"
+	"user-service/internal/store"
+)
+
+var (
+	ErrNoPhone           = errors.New("user has no phone number")
+	ErrPhoneVerified     = errors.New("phone number already verified")
+	ErrPhoneCodeTooSoon  = errors.New("verification code requested too recently")
+	ErrInvalidPhoneCode  = errors.New("invalid or expired verification code")
+	errInvalidPhoneInput = invalidField("/phone", RuleFormat, "Invalid phone number, use E.164 such as +14155550100")
+)
+
+// NormalizePhone returns the number in E.164 form: a + followed by the
+// country code and subscriber number, 8 to 15 digits in all. Spaces,
+// dashes, dots and parentheses are dropped and a leading 00 is read as +.
+// There is no default country, so national numbers are rejected.
+func NormalizePhone(phone string) (string, error) {
+	var digits strings.Builder
+	phone = strings.TrimSpace(phone)
+	if rest, ok := strings.CutPrefix(phone, "00"); ok {
+		phone = "+" + rest
+	}
+	if !strings.HasPrefix(phone, "+") {
+		return "", errInvalidPhoneInput
+	}
+	for _, c := range phone[1:] {
+		switch {
+		case c >= '0' && c <= '9':
+			digits.WriteRune(c)
+		case c == ' ' || c == '-' || c == '.' || c == '(' || c == ')':
+		default:
+			return "", errInvalidPhoneInput
+		}
+	}
+	n := digits.String()
+	if len(n) < 8 || len(n) > 15 || n[0] == '0' {
+		return "", errInvalidPhoneInput
+	}
+	return "+" + n, nil
+}
+
+// normalizePhone puts the user's phone number, if any, in E.164 form.
+func normalizePhone(user *store.User) error {
+	if user.Phone == "" {
+		return nil
+	}
+	phone, err := NormalizePhone(user.Phone)
+	if err != nil {
+		return err
+	}
+	user.Phone = phone
+	return nil
+}
+
+// MaskPhone keeps the last two digits of a number, e.g. ***00.
+func MaskPhone(phone string) string {
+	if len(phone) <= 2 {
+		return "***"
+	}
+	return "***" + phone[len(phone)-2:]
+}
+
+// SMSSender delivers text messages, usually by way of an SMS gateway.
+// LoadSMSSender picks one from SMS_SENDER.
+type SMSSender interface {
+	Send(ctx context.Context, phone, message string) error
+}
+
+// PhonePolicy controls verification codes.
+type PhonePolicy struct {
+	CodeTTL        time.Duration
+	ResendInterval time.Duration
+	MaxAttempts    int
+}
+
+func LoadPhonePolicy(src *config.Source) PhonePolicy {
+	return PhonePolicy{
+		CodeTTL:        src.Duration("PHONE_CODE_TTL", 10*time.Minute),
+		ResendInterval: src.Duration("PHONE_CODE_RESEND_INTERVAL", 30*time.Second),
+		MaxAttempts:    src.Int("PHONE_CODE_MAX_ATTEMPTS", 5),
+	}
+}
+
+// phoneCode is a code sent to a user, valid only for the number it was
+// sent to.
+type phoneCode struct {
+	phone    string
+	code     string
+	sentAt   time.Time
+	attempts int
+}
+
+// Phones verifies users' phone numbers by texting them a code. Pending
+// codes are kept in memory by the instance that sent them.
+type Phones struct {
+	store  store.Store
+	sender SMSSender
+	policy PhonePolicy
+
+	mu    sync.Mutex
+	codes map[string]*phoneCode
+}
+
+func NewPhones(s store.Store, sender SMSSender, policy PhonePolicy) *Phones {
+	return &Phones{store: s, sender: sender, policy: policy, codes: make(map[string]*phoneCode)}
+}
+
+// PhoneVerification describes a code that was sent.
+type PhoneVerification struct {
+	Phone     string    `json:"phone"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// SendCode texts a new six-digit code to the user's phone number,
+// replacing any earlier one.
+func (p *Phones) SendCode(ctx context.Context, id string) (PhoneVerification, error) {
+	user, err := p.store.Get(ctx, id)
+	if err != nil {
+		return PhoneVerification{}, err
+	}
+	if user.Phone == "" {
+		return PhoneVerification{}, ErrNoPhone
+	}
+	if user.PhoneVerified {
+		return PhoneVerification{}, ErrPhoneVerified
+	}
+
+	now := time.Now()
+	p.mu.Lock()
+	if prev, ok := p.codes[id]; ok && now.Sub(prev.sentAt) < p.policy.ResendInterval {
+		p.mu.Unlock()
+		return PhoneVerification{}, ErrPhoneCodeTooSoon
+	}
+	n, err := rand.Int(rand.Reader, big.NewInt(1000000))
+	if err != nil {
+		p.mu.Unlock()
+		return PhoneVerification{}, err
+	}
+	code := fmt.Sprintf("%06d", n.Int64())
+	p.codes[id] = &phoneCode{phone: user.Phone, code: code, sentAt: now}
+	p.mu.Unlock()
+
+	message := fmt.Sprintf("Your verification code is %s", code)
+	if err := p.sender.Send(ctx, user.Phone, message); err != nil {
+		p.mu.Lock()
+		delete(p.codes, id)
+		p.mu.Unlock()
+		return PhoneVerification{}, fmt.Errorf("send sms: %w", err)
+	}
+	return PhoneVerification{Phone: user.Phone, ExpiresAt: now.Add(p.policy.CodeTTL).UTC()}, nil
+}
+
+// ExpireCodes forgets the codes that have expired, which Confirm would
+// refuse anyway.
+func (p *Phones) ExpireCodes(ctx context.Context) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for id, pending := range p.codes {
+		if time.Since(pending.sentAt) > p.policy.CodeTTL {
+			delete(p.codes, id)
+		}
+	}
+	return nil
+}
+
+// ForgetUser drops the user's pending code. It is registered as a delete
+// hook.
+func (p *Phones) ForgetUser(ctx context.Context, event store.Event) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	delete(p.codes, event.UserID)
+	return nil
+}
+
+// Confirm checks the code and marks the phone number verified. A code
+// stops working once it expires, after too many wrong guesses, or when
+// the user's number changes.
+func (p *Phones) Confirm(ctx context.Context, id, code string) (store.User, error) {
+	p.mu.Lock()
+	pending, ok := p.codes[id]
+	if ok && time.Since(pending.sentAt) > p.policy.CodeTTL {
+		delete(p.codes, id)
+		ok = false
+	}
+	if !ok {
+		p.mu.Unlock()
+		return store.User{}, ErrInvalidPhoneCode
+	}
+	if subtle.ConstantTimeCompare([]byte(code), []byte(pending.code)) != 1 {
+		pending.attempts++
+		if pending.attempts >= p.policy.MaxAttempts {
+			delete(p.codes, id)
+		}
+		p.mu.Unlock()
+		return store.User{}, ErrInvalidPhoneCode
+	}
+	delete(p.codes, id)
+	p.mu.Unlock()
+
+	// Only the flag is set, and only on the user as read, so edits made
+	// in between are kept; a swap that loses to one reads the user again.
+	for {
+		user, err := p.store.Get(store.Fresh(ctx), id)
+		if err != nil {
+			return store.User{}, err
+		}
+		if user.Phone != pending.phone {
+			return store.User{}, ErrInvalidPhoneCode
+		}
+		verified := user
+		verified.PhoneVerified = true
+		swapped, err := p.store.CompareAndSwap(ctx, user, verified)
+		if err != nil {
+			return store.User{}, err
+		}
+		if swapped {
+			if err := p.store.Emit(ctx, store.NewEvent(store.EventUserUpdated, id)); err != nil {
+				return store.User{}, err
+			}
+			return verified, nil
+		}
+	}
+}
@@ -0,0 +1,78 @@
+package service
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"user-service/internal/store"
+)
+
+type codeSender struct{ message string }
+
+func (c *codeSender) Send(ctx context.Context, phone, message string) error {
+	c.message = message
+	return nil
+}
+
+// racingStore renames the user just before the first swap, as a
+// concurrent update would.
+type racingStore struct {
+	store.Store
+	raced bool
+}
+
+func (r *racingStore) CompareAndSwap(ctx context.Context, old, user store.User) (bool, error) {
+	if !r.raced {
+		r.raced = true
+		renamed := old
+		renamed.Name = "Renamed"
+		if err := r.Store.Update(ctx, renamed); err != nil {
+			return false, err
+		}
+	}
+	return r.Store.CompareAndSwap(ctx, old, user)
+}
+
+func TestConfirmKeepsConcurrentEdits(t *testing.T) {
+	ctx := context.Background()
+	s := &racingStore{Store: store.NewUserStore()}
+	if err := s.Create(ctx, store.User{ID: "1", Name: "User 1", Email: "1@example.com", Phone: "+15550001234"}); err != nil {
+		t.Fatal(err)
+	}
+	sender := &codeSender{}
+	phones := NewPhones(s, sender, PhonePolicy{CodeTTL: time.Minute, MaxAttempts: 5})
+	if _, err := phones.SendCode(ctx, "1"); err != nil {
+		t.Fatal(err)
+	}
+	code := sender.message[strings.LastIndex(sender.message, " ")+1:]
+
+	user, err := phones.Confirm(ctx, "1", code)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stored, err := s.Get(ctx, "1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !user.PhoneVerified || !stored.PhoneVerified || stored.Name != "Renamed" {
+		t.Fatalf("stored %+v; want the phone verified and the rename kept", stored)
+	}
+
+	events, err := s.EventsForUser(ctx, "1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if last := events[len(events)-1]; last.Type != store.EventUserUpdated {
+		t.Fatalf("last event %s, want %s", last.Type, store.EventUserUpdated)
+	}
+}
+
+func TestMaskPhone(t *testing.T) {
+	for phone, want := range map[string]string{"+15550001234": "***34", "12": "***", "": "***"} {
+		if got := MaskPhone(phone); got != want {
+			t.Errorf("MaskPhone(%q) = %q, want %q", phone, got, want)
+		}
+	}
+}
@@ -0,0 +1,322 @@
+package service
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"user-service/internal/config"
+	"user-service/internal/store"
+)
+
+// PreferenceEnum is the preference type whose value is one of a list of
+// strings. Preferences may also be FieldBoolean, FieldInteger or
+// FieldNumber.
+const PreferenceEnum = "enum"
+
+// ErrPreferencesChanged rejects a write made against preferences that
+// have changed since they were read.
+var ErrPreferencesChanged = errors.New("preferences have changed")
+
+// PreferenceDef defines one preference. Values lists an enum's choices;
+// Min and Max, when set, bound a number or integer.
+type PreferenceDef struct {
+	Key     string
+	Type    string
+	Values  []string
+	Min     *float64
+	Max     *float64
+	Default any
+}
+
+// PreferenceSchema holds the preferences users may set, by key.
+type PreferenceSchema map[string]PreferenceDef
+
+// LoadPreferenceSchema reads PREFERENCES, semicolon-separated key=type
+// default entries such as
+// "theme=enum(light|dark|system) system;page_size=integer(10..100) 25;beta=boolean false".
+// Either bound of a range may be left out, as in number(0..).
+func LoadPreferenceSchema(src *config.Source) (PreferenceSchema, error) {
+	schema := make(PreferenceSchema)
+	for _, entry := range strings.Split(src.String("PREFERENCES", ""), ";") {
+		if entry = strings.TrimSpace(entry); entry == "" {
+			continue
+		}
+		def, err := parsePreferenceDef(entry)
+		if err != nil {
+			return nil, fmt.Errorf("PREFERENCES: %w", err)
+		}
+		if _, ok := schema[def.Key]; ok {
+			return nil, fmt.Errorf("PREFERENCES: %s is defined twice", def.Key)
+		}
+		schema[def.Key] = def
+	}
+	return schema, nil
+}
+
+func parsePreferenceDef(entry string) (PreferenceDef, error) {
+	key, spec, _ := strings.Cut(entry, "=")
+	def := PreferenceDef{Key: strings.TrimSpace(key)}
+	if !fieldNamePattern.MatchString(def.Key) {
+		return def, fmt.Errorf("invalid preference key %q", def.Key)
+	}
+	typ, value, ok := strings.Cut(strings.TrimSpace(spec), " ")
+	if !ok {
+		return def, fmt.Errorf("%s has no default", def.Key)
+	}
+	typ, args, hasArgs := strings.Cut(typ, "(")
+	def.Type = typ
+	args, closed := strings.CutSuffix(args, ")")
+	if hasArgs && !closed {
+		return def, fmt.Errorf("%s has an unclosed %q", def.Key, "(")
+	}
+	switch def.Type {
+	case FieldBoolean:
+		if args != "" {
+			return def, fmt.Errorf("%s: a boolean takes no arguments", def.Key)
+		}
+	case FieldInteger, FieldNumber:
+		if args == "" {
+			break
+		}
+		lo, hi, ok := strings.Cut(args, "..")
+		if !ok {
+			return def, fmt.Errorf("%s has an invalid range %q", def.Key, args)
+		}
+		var err error
+		if def.Min, err = parseBound(lo); err == nil {
+			def.Max, err = parseBound(hi)
+		}
+		if err != nil || (def.Min != nil && def.Max != nil && *def.Min > *def.Max) {
+			return def, fmt.Errorf("%s has an invalid range %q", def.Key, args)
+		}
+	case PreferenceEnum:
+		def.Values = strings.Split(args, "|")
+		for _, v := range def.Values {
+			if v == "" {
+				return def, fmt.Errorf("%s has an empty enum value", def.Key)
+			}
+		}
+	default:
+		return def, fmt.Errorf("%s has unknown type %q", def.Key, def.Type)
+	}
+
+	value = strings.TrimSpace(value)
+	def.Default = def.parse(value)
+	var errs fieldErrors
+	if def.check(&errs, def.Default); len(errs) > 0 {
+		return def, fmt.Errorf("%s has an invalid default %q", def.Key, value)
+	}
+	return def, nil
+}
+
+// parse reads a value written in PREFERENCES as the type of the
+// definition, or returns it as a string to fail the type check if it
+// isn't one.
+func (def PreferenceDef) parse(s string) any {
+	switch def.Type {
+	case FieldBoolean:
+		if s == "true" || s == "false" {
+			return s == "true"
+		}
+	case FieldInteger, FieldNumber:
+		if n, err := strconv.ParseFloat(s, 64); err == nil && !math.IsNaN(n) && !math.IsInf(n, 0) {
+			return n
+		}
+	}
+	return s
+}
+
+func parseBound(s string) (*float64, error) {
+	if s = strings.TrimSpace(s); s == "" {
+		return nil, nil
+	}
+	n, err := strconv.ParseFloat(s, 64)
+	return &n, err
+}
+
+// check checks one value, as decoded from JSON, against the definition.
+func (def PreferenceDef) check(errs *fieldErrors, value any) {
+	at := pointer(def.Key)
+	switch v := value.(type) {
+	case bool:
+		if def.Type == FieldBoolean {
+			return
+		}
+	case string:
+		if def.Type != PreferenceEnum {
+			break
+		}
+		for _, allowed := range def.Values {
+			if v == allowed {
+				return
+			}
+		}
+		errs.add(at, RuleEnum, "Preference %s must be one of %s", def.Key, strings.Join(def.Values, ", "))
+		return
+	case float64:
+		if def.Type != FieldNumber && (def.Type != FieldInteger || v != math.Trunc(v)) {
+			break
+		}
+		if (def.Min != nil && v < *def.Min) || (def.Max != nil && v > *def.Max) {
+			errs.add(at, RuleRange, "Preference %s must be within %s", def.Key, def.bounds())
+		}
+		return
+	}
+	errs.add(at, RuleType, "Preference %s must be a valid %s", def.Key, def.Type)
+}
+
+// bounds formats the definition's range as min..max, leaving out a bound
+// that isn't set.
+func (def PreferenceDef) bounds() string {
+	var lo, hi string
+	if def.Min != nil {
+		lo = strconv.FormatFloat(*def.Min, 'f', -1, 64)
+	}
+	if def.Max != nil {
+		hi = strconv.FormatFloat(*def.Max, 'f', -1, 64)
+	}
+	return lo + ".." + hi
+}
+
+// UserPreferences are a user's preferences with defaults filled in.
+// Defaults lists the keys that have their default value because the user
+// never set them. ETag identifies this representation, so a client can
+// send it back to make sure it overwrites what it read.
+type UserPreferences struct {
+	Values   map[string]any `json:"values"`
+	Defaults []string       `json:"defaults"`
+	ETag     string         `json:"-"`
+}
+
+// Preferences holds typed per-user settings, checked against a schema set
+// by configuration, as opposed to the free-form custom fields on the
+// user. Stored values the schema no longer accepts read as their default.
+type Preferences struct {
+	store  store.Store
+	schema atomic.Pointer[PreferenceSchema]
+
+	// mu serializes writes so the check against the ETag a client sends
+	// and the write that follows it can't interleave with another write.
+	mu sync.Mutex
+}
+
+func NewPreferences(s store.Store, schema PreferenceSchema) *Preferences {
+	p := &Preferences{store: s}
+	p.SetSchema(schema)
+	return p
+}
+
+func (p *Preferences) SetSchema(schema PreferenceSchema) {
+	p.schema.Store(&schema)
+}
+
+// Get returns the user's preferences.
+func (p *Preferences) Get(ctx context.Context, id string) (UserPreferences, error) {
+	stored, err := p.read(ctx, id)
+	if err != nil {
+		return UserPreferences{}, err
+	}
+	return p.resolve(stored), nil
+}
+
+// Set replaces the user's preferences with values, checking each against
+// the schema. Keys left out or set to null go back to their default. With
+// ifMatch, the write only goes ahead if the current preferences have one
+// of those ETags.
+func (p *Preferences) Set(ctx context.Context, id string, values map[string]any, ifMatch []string) (UserPreferences, error) {
+	schema := *p.schema.Load()
+	var errs fieldErrors
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	stored := make(map[string]any, len(values))
+	for _, key := range keys {
+		if values[key] == nil {
+			continue
+		}
+		def, ok := schema[key]
+		if !ok {
+			errs.add(pointer(key), RuleUnknown, "Unknown preference %s", key)
+			continue
+		}
+		def.check(&errs, values[key])
+		stored[key] = values[key]
+	}
+	if err := errs.err(); err != nil {
+		return UserPreferences{}, err
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	current, err := p.read(ctx, id)
+	if err != nil {
+		return UserPreferences{}, err
+	}
+	if len(ifMatch) > 0 && !MatchETag(ifMatch, p.resolve(current).ETag) {
+		return UserPreferences{}, ErrPreferencesChanged
+	}
+	if !DryRun(ctx) {
+		if err := p.store.SetPreferences(ctx, id, stored); err != nil {
+			return UserPreferences{}, err
+		}
+	}
+	return p.resolve(stored), nil
+}
+
+// read returns the stored values of a user who hasn't been merged away.
+func (p *Preferences) read(ctx context.Context, id string) (map[string]any, error) {
+	user, err := p.store.Get(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if user.MergedInto != "" {
+		return nil, store.ErrUserMerged
+	}
+	return p.store.GetPreferences(ctx, id)
+}
+
+// resolve fills in defaults for every key of the schema the stored values
+// don't validly set, and drops stored keys the schema doesn't have.
+func (p *Preferences) resolve(stored map[string]any) UserPreferences {
+	schema := *p.schema.Load()
+	prefs := UserPreferences{Values: make(map[string]any, len(schema)), Defaults: []string{}}
+	for key, def := range schema {
+		var errs fieldErrors
+		if value, ok := stored[key]; ok {
+			if def.check(&errs, value); len(errs) == 0 {
+				prefs.Values[key] = value
+				continue
+			}
+		}
+		prefs.Values[key] = def.Default
+		prefs.Defaults = append(prefs.Defaults, key)
+	}
+	sort.Strings(prefs.Defaults)
+	body, _ := json.Marshal(prefs)
+	sum := sha256.Sum256(body)
+	prefs.ETag = `"` + hex.EncodeToString(sum[:16]) + `"`
+	return prefs
+}
+
+// MatchETag reports whether the ETag is one of tags, as listed in an
+// If-Match or If-None-Match header, or tags holds *.
+func MatchETag(tags []string, etag string) bool {
+	for _, tag := range tags {
+		if tag == "*" || tag == etag {
+			return true
+		}
+	}
+	return false
+}
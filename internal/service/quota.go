@@ -0,0 +1,341 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"user-service/internal/auth"
+	"user-service/internal/config"
+	"user-service/internal/store"
+)
+
+// Quota limits what one tenant or API key may do. Zero means no limit.
+type Quota struct {
+	// RequestsPerDay counts requests per UTC day.
+	RequestsPerDay int `json:"requests_per_day"`
+	// Users counts the users the subject created that still exist.
+	Users int `json:"users"`
+}
+
+// QuotaPolicy holds the quotas of tenants and API keys, keyed by subject
+// (tenant:<id> or apikey:<id>). The entries tenant:* and apikey:* apply
+// to tenants and API keys that have none of their own.
+type QuotaPolicy struct {
+	Quotas map[string]Quota
+}
+
+// LoadQuotaPolicy reads TENANT_QUOTAS and API_KEY_QUOTAS, semicolon-
+// separated name=limits entries such as
+// "acme=requests:10000 users:500;*=requests:1000". API keys are named by
+// the key itself, as in API_KEYS.
+func LoadQuotaPolicy(src *config.Source) QuotaPolicy {
+	policy := QuotaPolicy{Quotas: make(map[string]Quota)}
+	for name, quota := range parseQuotaList(src.String("TENANT_QUOTAS", "")) {
+		policy.Quotas[tenantSubject(name)] = quota
+	}
+	for key, quota := range parseQuotaList(src.String("API_KEY_QUOTAS", "")) {
+		if key == "*" {
+			policy.Quotas["apikey:*"] = quota
+		} else {
+			policy.Quotas[auth.APIKeySubject(key)] = quota
+		}
+	}
+	return policy
+}
+
+func parseQuotaList(list string) map[string]Quota {
+	entries := make(map[string]Quota)
+	for _, entry := range strings.Split(list, ";") {
+		name, limits, ok := strings.Cut(strings.TrimSpace(entry), "=")
+		if !ok || name == "" {
+			continue
+		}
+		var quota Quota
+		for _, limit := range strings.Fields(limits) {
+			kind, value, _ := strings.Cut(limit, ":")
+			n, err := strconv.Atoi(value)
+			if err != nil || n < 0 {
+				continue
+			}
+			switch kind {
+			case "requests":
+				quota.RequestsPerDay = n
+			case "users":
+				quota.Users = n
+			}
+		}
+		entries[name] = quota
+	}
+	return entries
+}
+
+func tenantSubject(tenant string) string {
+	return "tenant:" + tenant
+}
+
+// QuotaError rejects a new user that would take a subject past its users
+// quota.
+type QuotaError struct {
+	Subject string
+	Limit   int
+}
+
+func (e *QuotaError) Error() string {
+	return fmt.Sprintf("%s has reached its quota of %d users", e.Subject, e.Limit)
+}
+
+// QuotaUsage is how much of its quota a subject has used today.
+type QuotaUsage struct {
+	Subject  string    `json:"subject"`
+	Quota    Quota     `json:"quota"`
+	Requests int       `json:"requests"`
+	Users    int       `json:"users"`
+	Resets   time.Time `json:"resets"`
+}
+
+// RequestsLeft is how many more requests the subject may make today, or
+// -1 without a limit.
+func (u QuotaUsage) RequestsLeft() int {
+	if u.Quota.RequestsPerDay == 0 {
+		return -1
+	}
+	return max(u.Quota.RequestsPerDay-u.Requests, 0)
+}
+
+// Quotas counts the requests and users of tenants and API keys against
+// their quotas. Counts are kept in memory, so each instance counts on its
+// own and starts over on restart; users stored are learned again as
+// they are created. Deletions are learned from the outbox relay through
+// Publisher, so a deleted user frees its place about one relay interval
+// later.
+type Quotas struct {
+	policy atomic.Pointer[QuotaPolicy]
+
+	mu       sync.Mutex
+	day      string
+	requests map[string]int
+	// owners holds the subjects each counted user was created by, and
+	// users how many counted users each subject has.
+	owners map[string][]string
+	users  map[string]int
+}
+
+func NewQuotas(policy QuotaPolicy) *Quotas {
+	q := &Quotas{requests: make(map[string]int), owners: make(map[string][]string), users: make(map[string]int)}
+	q.SetPolicy(policy)
+	return q
+}
+
+func (q *Quotas) SetPolicy(policy QuotaPolicy) {
+	q.policy.Store(&policy)
+}
+
+// quota returns the subject's quota and whether it has one.
+func (q *Quotas) quota(subject string) (Quota, bool) {
+	quotas := q.policy.Load().Quotas
+	if quota, ok := quotas[subject]; ok {
+		return quota, true
+	}
+	kind, _, _ := strings.Cut(subject, ":")
+	quota, ok := quotas[kind+":*"]
+	return quota, ok
+}
+
+// subjects returns who a request acts as: the tenant its credential
+// belongs to and the API key it authenticated with, where present. The
+// tenant a request names in X-Tenant-ID plays no part, since the caller
+// can pick any.
+func subjects(ctx context.Context) []string {
+	var list []string
+	if tenant := credentialTenant(ctx); tenant != "" {
+		list = append(list, tenantSubject(tenant))
+	}
+	if p := auth.PrincipalFrom(ctx); p != nil && strings.HasPrefix(p.Subject, "apikey:") {
+		list = append(list, p.Subject)
+	}
+	return list
+}
+
+// credentialTenant is the tenant the request's credential belongs to, or
+// "" without one.
+func credentialTenant(ctx context.Context) string {
+	if p := auth.PrincipalFrom(ctx); p != nil {
+		return p.Tenant
+	}
+	return ""
+}
+
+// Request counts a request against the daily quotas of its subjects. It
+// returns the usage, after this request, of the subject with the fewest
+// requests left, or a usage without a subject if no request quota
+// applies. If that subject has none left it returns false and counts
+// nothing.
+func (q *Quotas) Request(ctx context.Context) (QuotaUsage, bool) {
+	now := time.Now().UTC()
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.rollOver(now)
+
+	var limited []QuotaUsage
+	for _, subject := range subjects(ctx) {
+		if quota, ok := q.quota(subject); ok && quota.RequestsPerDay > 0 {
+			limited = append(limited, q.usage(subject, quota, now))
+		}
+	}
+	if len(limited) == 0 {
+		return QuotaUsage{}, true
+	}
+	tightest := limited[0]
+	for _, u := range limited[1:] {
+		if u.RequestsLeft() < tightest.RequestsLeft() {
+			tightest = u
+		}
+	}
+	if tightest.RequestsLeft() == 0 {
+		return tightest, false
+	}
+	for _, u := range limited {
+		q.requests[u.Subject]++
+	}
+	tightest.Requests++
+	return tightest, true
+}
+
+// rollOver starts the day's request counts afresh once the UTC day
+// changes. Callers must hold q.mu.
+func (q *Quotas) rollOver(now time.Time) {
+	if day := now.Format(time.DateOnly); day != q.day {
+		q.day = day
+		clear(q.requests)
+	}
+}
+
+// usage reports the subject's usage. Callers must hold q.mu.
+func (q *Quotas) usage(subject string, quota Quota, now time.Time) QuotaUsage {
+	return QuotaUsage{
+		Subject:  subject,
+		Quota:    quota,
+		Requests: q.requests[subject],
+		Users:    q.users[subject],
+		Resets:   now.Truncate(24*time.Hour).AddDate(0, 0, 1),
+	}
+}
+
+// reserveUsers counts a new user against the request's subjects before it
+// is stored, so concurrent creates can't together go past a users quota,
+// or returns a *QuotaError if this one would. The returned function must
+// be called once the write is done: it records the subjects as the
+// user's owners if the user was created, or else takes the user off the
+// counts again.
+func (q *Quotas) reserveUsers(ctx context.Context, id string) (func(created bool), error) {
+	list := subjects(ctx)
+	if q == nil || len(list) == 0 {
+		return func(bool) {}, nil
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for _, subject := range list {
+		if quota, ok := q.quota(subject); ok && quota.Users > 0 && q.users[subject] >= quota.Users {
+			return nil, &QuotaError{Subject: subject, Limit: quota.Users}
+		}
+	}
+	for _, subject := range list {
+		q.users[subject]++
+	}
+	return func(created bool) {
+		q.mu.Lock()
+		defer q.mu.Unlock()
+		if created {
+			q.release(id)
+			q.owners[id] = list
+			return
+		}
+		for _, subject := range list {
+			if q.users[subject]--; q.users[subject] <= 0 {
+				delete(q.users, subject)
+			}
+		}
+	}, nil
+}
+
+// release stops counting the user. Callers must hold q.mu.
+func (q *Quotas) release(id string) {
+	for _, subject := range q.owners[id] {
+		if q.users[subject]--; q.users[subject] <= 0 {
+			delete(q.users, subject)
+		}
+	}
+	delete(q.owners, id)
+}
+
+// Usage lists the usage of every subject with a quota or with requests or
+// users counted, ordered by subject. Wildcard entries are not listed.
+func (q *Quotas) Usage() []QuotaUsage {
+	now := time.Now().UTC()
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.rollOver(now)
+	seen := make(map[string]bool)
+	for subject := range q.policy.Load().Quotas {
+		if !strings.HasSuffix(subject, ":*") {
+			seen[subject] = true
+		}
+	}
+	for subject := range q.requests {
+		seen[subject] = true
+	}
+	for subject := range q.users {
+		seen[subject] = true
+	}
+	list := make([]QuotaUsage, 0, len(seen))
+	for subject := range seen {
+		quota, _ := q.quota(subject)
+		list = append(list, q.usage(subject, quota, now))
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Subject < list[j].Subject })
+	return list
+}
+
+// Reset forgets the subject's requests today. Users stored are not reset,
+// since they still exist.
+func (q *Quotas) Reset(subject string) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	delete(q.requests, subject)
+}
+
+// Publisher wraps next so that users deleted, forgotten or merged away
+// stop counting against the quotas of those who created them.
+func (q *Quotas) Publisher(next EventPublisher) EventPublisher {
+	return quotaPublisher{next: next, quotas: q}
+}
+
+type quotaPublisher struct {
+	next   EventPublisher
+	quotas *Quotas
+}
+
+func (p quotaPublisher) Publish(event store.Event) error {
+	if err := p.next.Publish(event); err != nil {
+		return err
+	}
+	var id string
+	switch event.Type {
+	case store.EventUserDeleted, store.EventUserForgotten:
+		id = event.UserID
+	case store.EventUserMerged:
+		id = event.Data["source_id"]
+	default:
+		return nil
+	}
+	p.quotas.mu.Lock()
+	defer p.quotas.mu.Unlock()
+	p.quotas.release(id)
+	return nil
+}
@@ -0,0 +1,89 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"user-service/internal/auth"
+	"user-service/internal/store"
+)
+
+func TestParseQuotaList(t *testing.T) {
+	got := parseQuotaList("acme=requests:100 users:5; *=requests:10 ;broken;bad=users:-1 requests:x")
+	want := map[string]Quota{
+		"acme": {RequestsPerDay: 100, Users: 5},
+		"*":    {RequestsPerDay: 10},
+		"bad":  {},
+	}
+	if len(got) != len(want) {
+		t.Fatalf("parsed %v, want %v", got, want)
+	}
+	for name, quota := range want {
+		if got[name] != quota {
+			t.Errorf("%s = %+v, want %+v", name, got[name], quota)
+		}
+	}
+}
+
+func TestQuotaRequests(t *testing.T) {
+	quotas := NewQuotas(QuotaPolicy{Quotas: map[string]Quota{
+		"tenant:acme": {RequestsPerDay: 3},
+		"apikey:*":    {RequestsPerDay: 2},
+	}})
+	key := auth.APIKeySubject("key")
+	asTenantKey(t, "acme", func(ctx context.Context) {
+		// The key's wildcard quota is tighter than the tenant's, so it is
+		// the one reported and the one that runs out.
+		for i := 1; i <= 2; i++ {
+			usage, ok := quotas.Request(ctx)
+			if !ok || usage.Subject != key || usage.Requests != i || usage.RequestsLeft() != 2-i {
+				t.Fatalf("request %d: %+v, %v", i, usage, ok)
+			}
+		}
+		if usage, ok := quotas.Request(ctx); ok || usage.RequestsLeft() != 0 {
+			t.Fatalf("request over quota: %+v, %v", usage, ok)
+		}
+		quotas.Reset(key)
+		if usage, ok := quotas.Request(ctx); !ok || usage.Subject != "tenant:acme" || usage.RequestsLeft() != 0 {
+			t.Fatalf("request after reset: %+v, %v", usage, ok)
+		}
+	})
+
+	// Requests without a credential have no quota.
+	if usage, ok := quotas.Request(context.Background()); !ok || usage.Subject != "" {
+		t.Fatalf("anonymous request: %+v, %v", usage, ok)
+	}
+}
+
+func TestQuotaUsers(t *testing.T) {
+	s := store.NewUserStore()
+	quotas := NewQuotas(QuotaPolicy{Quotas: map[string]Quota{"tenant:acme": {Users: 1}}})
+	users := NewUsers(s, func() []string { return nil }, nil, NewEmails(EmailPolicy{}), nil, nil, quotas)
+	asTenantKey(t, "acme", func(ctx context.Context) {
+		if _, err := users.Create(ctx, store.User{ID: "1", Name: "User 1", Email: "1@example.com"}); err != nil {
+			t.Fatal(err)
+		}
+		var quotaErr *QuotaError
+		if _, err := users.Create(ctx, store.User{ID: "2", Name: "User 2", Email: "2@example.com"}); !errors.As(err, &quotaErr) || quotaErr.Subject != "tenant:acme" {
+			t.Fatalf("Create over quota = %v, want a quota error for tenant:acme", err)
+		}
+
+		// A delete frees the user's place once the relay publishes it.
+		if err := users.Delete(ctx, "1"); err != nil {
+			t.Fatal(err)
+		}
+		publisher := quotas.Publisher(LogPublisher{})
+		if err := publisher.Publish(store.NewEvent(store.EventUserDeleted, "1")); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := users.Create(ctx, store.User{ID: "2", Name: "User 2", Email: "2@example.com"}); err != nil {
+			t.Fatalf("Create after the delete was published: %v", err)
+		}
+	})
+	for _, usage := range quotas.Usage() {
+		if usage.Subject == "tenant:acme" && usage.Users != 1 {
+			t.Fatalf("usage %+v, want one user", usage)
+		}
+	}
+}
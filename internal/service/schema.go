@@ -0,0 +1,186 @@
+package service
+
+import (
+	"math"
+	"regexp"
+	"sort"
+	"strconv"
+	"sync"
+)
+
+// Field types a custom field may have.
+const (
+	FieldString  = "string"
+	FieldNumber  = "number"
+	FieldInteger = "integer"
+	FieldBoolean = "boolean"
+)
+
+// MaxCustomFields caps how many fields one schema may define.
+const MaxCustomFields = 50
+
+var fieldNamePattern = regexp.MustCompile(`^[a-z][a-z0-9_]{0,63}$`)
+
+// FieldDef defines one custom field. Pattern, a regular expression the
+// whole value must match, only applies to strings.
+type FieldDef struct {
+	Name     string `json:"name"`
+	Type     string `json:"type"`
+	Required bool   `json:"required,omitempty"`
+	Pattern  string `json:"pattern,omitempty"`
+
+	pattern *regexp.Regexp
+}
+
+// Schema lists the custom fields users may carry.
+type Schema struct {
+	Fields []FieldDef `json:"fields"`
+}
+
+// compile checks the definitions and prepares their patterns. Failures
+// point into the request body that set the schema.
+func (s *Schema) compile() error {
+	if len(s.Fields) > MaxCustomFields {
+		return invalidField("/fields", RuleMaxItems, "At most %d custom fields per schema", MaxCustomFields)
+	}
+	var errs fieldErrors
+	seen := make(map[string]bool, len(s.Fields))
+	for i := range s.Fields {
+		def := &s.Fields[i]
+		at := pointer("fields", strconv.Itoa(i))
+		switch {
+		case !fieldNamePattern.MatchString(def.Name):
+			errs.add(at+"/name", RuleFormat, "Invalid field name %q, use lowercase letters, digits and underscores", def.Name)
+		case seen[def.Name]:
+			errs.add(at+"/name", RuleDuplicate, "Field %s is defined twice", def.Name)
+		}
+		seen[def.Name] = true
+		switch def.Type {
+		case FieldString, FieldNumber, FieldInteger, FieldBoolean:
+		default:
+			errs.add(at+"/type", RuleEnum, "Field %s has unknown type %q", def.Name, def.Type)
+			continue
+		}
+		if def.Pattern == "" {
+			continue
+		}
+		if def.Type != FieldString {
+			errs.add(at+"/pattern", RuleType, "Field %s: pattern only applies to strings", def.Name)
+			continue
+		}
+		re, err := regexp.Compile("^(?:" + def.Pattern + ")$")
+		if err != nil {
+			errs.add(at+"/pattern", RuleFormat, "Field %s has an invalid pattern", def.Name)
+			continue
+		}
+		def.pattern = re
+	}
+	if err := errs.err(); err != nil {
+		return err
+	}
+	sort.Slice(s.Fields, func(i, j int) bool { return s.Fields[i].Name < s.Fields[j].Name })
+	return nil
+}
+
+// validate checks a complete set of custom fields against the schema,
+// reporting every field that fails.
+func (s Schema) validate(fields map[string]any) error {
+	var errs fieldErrors
+	defs := make(map[string]FieldDef, len(s.Fields))
+	for _, def := range s.Fields {
+		defs[def.Name] = def
+		if _, ok := fields[def.Name]; def.Required && !ok {
+			errs.add(pointer("custom_fields", def.Name), RuleRequired, "Field %s is required", def.Name)
+		}
+	}
+	names := make([]string, 0, len(fields))
+	for name := range fields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		def, ok := defs[name]
+		if !ok {
+			errs.add(pointer("custom_fields", name), RuleUnknown, "Unknown field %s", name)
+			continue
+		}
+		def.check(&errs, fields[name])
+	}
+	return errs.err()
+}
+
+// check checks one value, as decoded from JSON, against the definition.
+func (def FieldDef) check(errs *fieldErrors, value any) {
+	at := pointer("custom_fields", def.Name)
+	ok := false
+	switch v := value.(type) {
+	case string:
+		ok = def.Type == FieldString
+		if ok && def.pattern != nil && !def.pattern.MatchString(v) {
+			errs.add(at, RulePattern, "Field %s does not match %s", def.Name, def.Pattern)
+			return
+		}
+	case float64:
+		ok = def.Type == FieldNumber || (def.Type == FieldInteger && v == math.Trunc(v))
+	case bool:
+		ok = def.Type == FieldBoolean
+	}
+	if !ok {
+		errs.add(at, RuleType, "Field %s must be a valid %s", def.Name, def.Type)
+	}
+}
+
+// Schemas holds the custom field schema of each tenant. Tenants without
+// their own schema use the default one, kept under the empty tenant.
+// Schemas live in memory and are lost on restart.
+type Schemas struct {
+	mu      sync.RWMutex
+	schemas map[string]Schema
+}
+
+func NewSchemas() *Schemas {
+	return &Schemas{schemas: make(map[string]Schema)}
+}
+
+// Get returns the schema that applies to the tenant, and whether the
+// tenant has one of its own.
+func (s *Schemas) Get(tenant string) (Schema, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if schema, ok := s.schemas[tenant]; ok {
+		return schema, true
+	}
+	return s.schemas[""], false
+}
+
+// Set replaces the tenant's schema after checking it. Users stored
+// before the change are not revalidated until they are next written.
+func (s *Schemas) Set(tenant string, schema Schema) (Schema, error) {
+	if schema.Fields == nil {
+		schema.Fields = []FieldDef{}
+	}
+	if err := schema.compile(); err != nil {
+		return Schema{}, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.schemas[tenant] = schema
+	return schema, nil
+}
+
+// Delete drops the tenant's schema, so the default applies again.
+func (s *Schemas) Delete(tenant string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.schemas, tenant)
+}
+
+// validate checks the fields against the schema of the tenant, the one
+// the user belongs to. Without schemas no custom fields are allowed.
+func (s *Schemas) validate(tenant string, fields map[string]any) error {
+	var schema Schema
+	if s != nil {
+		schema, _ = s.Get(tenant)
+	}
+	return schema.validate(fields)
+}
@@ -0,0 +1,64 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"user-service/internal/auth"
+	"user-service/internal/store"
+)
+
+// asTenantKey runs fn with the context of a request made with an API key
+// of the tenant, and a different X-Tenant-ID header.
+func asTenantKey(t *testing.T, tenant string, fn func(ctx context.Context)) {
+	t.Helper()
+	a := auth.NewAuthenticator(auth.Config{
+		Enabled:    true,
+		APIKeys:    map[string][]string{"key": {auth.ScopeUsersWrite}},
+		KeyTenants: map[string]string{"key": tenant},
+	}, nil)
+	r := httptest.NewRequest(http.MethodPost, "/users", nil)
+	r.Header.Set("X-API-Key", "key")
+	r.Header.Set("X-Tenant-ID", "other")
+	called := false
+	a.RequireScopes([]string{auth.ScopeUsersWrite}, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		called = true
+		fn(r.Context())
+	})).ServeHTTP(httptest.NewRecorder(), r)
+	if !called {
+		t.Fatal("request was not authenticated")
+	}
+}
+
+func TestCustomFieldsFollowTheUsersTenant(t *testing.T) {
+	schemas := NewSchemas()
+	if _, err := schemas.Set("acme", Schema{Fields: []FieldDef{{Name: "plan", Type: FieldString, Required: true}}}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := schemas.Set("other", Schema{Fields: []FieldDef{}}); err != nil {
+		t.Fatal(err)
+	}
+	s := store.NewUserStore()
+	users := NewUsers(s, func() []string { return nil }, NewHooks(), NewEmails(EmailPolicy{}), schemas, nil, NewQuotas(QuotaPolicy{}))
+
+	var invalid *ValidationError
+	asTenantKey(t, "acme", func(ctx context.Context) {
+		_, err := users.Create(ctx, store.User{ID: "1", Name: "One", Email: "one@example.com"})
+		if !errors.As(err, &invalid) {
+			t.Fatalf("Create without acme's required field = %v, want a validation error", err)
+		}
+		if _, err := users.Create(ctx, store.User{ID: "1", Name: "One", Email: "one@example.com", CustomFields: map[string]any{"plan": "pro"}}); err != nil {
+			t.Fatal(err)
+		}
+	})
+	// Another tenant's caller still updates the user against acme's schema.
+	asTenantKey(t, "other", func(ctx context.Context) {
+		_, err := users.Update(ctx, store.User{ID: "1", Name: "One", Email: "one@example.com", CustomFields: map[string]any{}})
+		if !errors.As(err, &invalid) {
+			t.Fatalf("Update dropping acme's required field = %v, want a validation error", err)
+		}
+	})
+}
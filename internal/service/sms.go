@@ -0,0 +1,82 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"user-service/internal/config"
+	"user-service/internal/trace"
+)
+
+// LoadSMSSender returns the sender named by SMS_SENDER: log, the
+// default, or http.
+func LoadSMSSender(src *config.Source) (SMSSender, error) {
+	switch name := src.String("SMS_SENDER", "log"); name {
+	case "log":
+		return LogSMSSender{}, nil
+	case "http":
+		url := src.String("SMS_GATEWAY_URL", "")
+		if url == "" {
+			return nil, fmt.Errorf("SMS_SENDER=http needs SMS_GATEWAY_URL")
+		}
+		return NewHTTPSMSSender(url, src.String("SMS_GATEWAY_TOKEN", "")), nil
+	default:
+		return nil, fmt.Errorf("unknown SMS_SENDER %q", name)
+	}
+}
+
+// LogSMSSender logs that a message would have been sent instead of
+// sending it, for development. The number is masked and the message,
+// which holds the code, is left out.
+type LogSMSSender struct{}
+
+func (LogSMSSender) Send(ctx context.Context, phone, message string) error {
+	log.Printf("sms to %s: %d characters, not sent", MaskPhone(phone), len(message))
+	return nil
+}
+
+// HTTPSMSSender posts each message to a gateway as {"to", "message"}
+// JSON, with the token, if any, as a bearer token and the caller's trace
+// context.
+type HTTPSMSSender struct {
+	url    string
+	token  string
+	client *http.Client
+}
+
+func NewHTTPSMSSender(url, token string) *HTTPSMSSender {
+	return &HTTPSMSSender{url: url, token: token, client: &http.Client{Timeout: 10 * time.Second}}
+}
+
+func (s *HTTPSMSSender) Send(ctx context.Context, phone, message string) error {
+	body, err := json.Marshal(map[string]string{"to": phone, "message": message})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	trace.From(ctx).Inject(req.Header)
+	if s.token != "" {
+		req.Header.Set("Authorization", "Bearer "+s.token)
+	}
+	res, err := s.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("sms gateway: %w", err)
+	}
+	defer res.Body.Close()
+	if res.StatusCode >= 300 {
+		msg, _ := io.ReadAll(io.LimitReader(res.Body, 512))
+		return fmt.Errorf("sms gateway: %s: %s", res.Status, strings.TrimSpace(string(msg)))
+	}
+	return nil
+}
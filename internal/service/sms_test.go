@@ -0,0 +1,51 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"user-service/internal/trace"
+)
+
+func TestLogSMSSenderHidesNumberAndCode(t *testing.T) {
+	var out bytes.Buffer
+	defer log.SetOutput(log.Writer())
+	log.SetOutput(&out)
+	if err := (LogSMSSender{}).Send(context.Background(), "+15550001234", "Your verification code is 123456"); err != nil {
+		t.Fatal(err)
+	}
+	if line := out.String(); strings.Contains(line, "123456") || strings.Contains(line, "5550001234") || !strings.Contains(line, "***34") {
+		t.Fatalf("logged %q; want the number masked and no code", line)
+	}
+}
+
+func TestHTTPSMSSender(t *testing.T) {
+	var got map[string]string
+	var auth, requestID string
+	gateway := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		auth, requestID = r.Header.Get("Authorization"), r.Header.Get(trace.HeaderRequestID)
+		json.NewDecoder(r.Body).Decode(&got)
+		if got["to"] == "+15559999999" {
+			http.Error(w, "unreachable", http.StatusBadGateway)
+		}
+	}))
+	defer gateway.Close()
+
+	sender := NewHTTPSMSSender(gateway.URL, "token")
+	ctx := trace.With(context.Background(), trace.Context{RequestID: "req-1"})
+	if err := sender.Send(ctx, "+15550001234", "hello"); err != nil {
+		t.Fatal(err)
+	}
+	if got["to"] != "+15550001234" || got["message"] != "hello" || auth != "Bearer token" || requestID != "req-1" {
+		t.Fatalf("gateway got %v with %q, request %q", got, auth, requestID)
+	}
+	if err := sender.Send(context.Background(), "+15559999999", "hello"); err == nil || !strings.Contains(err.Error(), "unreachable") {
+		t.Fatalf("Send = %v, want the gateway's error", err)
+	}
+}
@@ -0,0 +1,99 @@
+package service
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"user-service/internal/store"
+)
+
+// MaxStatsDays caps how many days of creation counts one stats request
+// may ask for.
+const MaxStatsDays = 365
+
+// UserStats summarizes the stored users.
+type UserStats struct {
+	Total         int            `json:"total"`
+	ByStatus      map[string]int `json:"by_status"`
+	ByScope       map[string]int `json:"by_scope"`
+	ByTenant      map[string]int `json:"by_tenant"`
+	CreatedPerDay []DayCount     `json:"created_per_day"`
+	GeneratedAt   time.Time      `json:"generated_at"`
+}
+
+// DayCount is the number of users created on one UTC day.
+type DayCount struct {
+	Date  string `json:"date"`
+	Count int    `json:"count"`
+}
+
+// Stats serves user counts from the store's running totals, caching them
+// for a while so that frequent requests don't all walk every shard.
+type Stats struct {
+	store store.Store
+	ttl   time.Duration
+
+	mu     sync.Mutex
+	cached store.Stats
+	at     time.Time
+}
+
+// NewStats returns a Stats service that reuses store counts for ttl; a
+// ttl of zero or less reads them fresh each time.
+func NewStats(s store.Store, ttl time.Duration) *Stats {
+	return &Stats{store: s, ttl: ttl}
+}
+
+// Get returns the counts, with the creations of each UTC day over the
+// given number of days up to today, oldest first and zeros filled in.
+// days is kept between 1 and MaxStatsDays.
+func (s *Stats) Get(ctx context.Context, days int) (UserStats, error) {
+	days = min(max(days, 1), MaxStatsDays)
+	counts, at, err := s.counts(ctx)
+	if err != nil {
+		return UserStats{}, err
+	}
+
+	stats := UserStats{Total: counts.Total, ByStatus: counts.ByStatus, ByScope: counts.ByScope, ByTenant: counts.ByTenant, GeneratedAt: at}
+	stats.CreatedPerDay = make([]DayCount, days)
+	today := at.UTC().Truncate(24 * time.Hour)
+	for i := range stats.CreatedPerDay {
+		date := today.AddDate(0, 0, i-days+1).Format(time.DateOnly)
+		stats.CreatedPerDay[i] = DayCount{Date: date, Count: counts.CreatedPerDay[date]}
+	}
+	return stats, nil
+}
+
+// counts returns the cached store counts and when they were read,
+// reading them again once they are older than the ttl.
+func (s *Stats) counts(ctx context.Context) (store.Stats, time.Time, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	if !s.at.IsZero() && now.Sub(s.at) < s.ttl {
+		return s.cached, s.at, nil
+	}
+	if err := s.read(ctx, now); err != nil {
+		return store.Stats{}, time.Time{}, err
+	}
+	return s.cached, s.at, nil
+}
+
+// Refresh reads the store counts into the cache ahead of the requests
+// that would otherwise wait on it.
+func (s *Stats) Refresh(ctx context.Context) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.read(ctx, time.Now())
+}
+
+// read caches the store counts as of now. Callers must hold s.mu.
+func (s *Stats) read(ctx context.Context, now time.Time) error {
+	counts, err := s.store.Stats(ctx)
+	if err != nil {
+		return err
+	}
+	s.cached, s.at = counts, now.UTC()
+	return nil
+}
@@ -0,0 +1,259 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math/rand"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"user-service/internal/config"
+	"user-service/internal/store"
+)
+
+// Statuses of a test data generation.
+const (
+	GenerationRunning = "running"
+	GenerationDone    = "done"
+	GenerationFailed  = "failed"
+)
+
+var (
+	ErrGenerationDisabled = errors.New("test data generation is disabled")
+	ErrGenerationRunning  = errors.New("a generation is already running")
+	ErrGenerationNotFound = errors.New("generation not found")
+)
+
+// TestDataPolicy guards test data generation.
+type TestDataPolicy struct {
+	// Enabled must be set for anything to be generated, so a production
+	// instance can't be filled with fake users by mistake.
+	Enabled bool
+	// MaxCount caps the users one generation may create.
+	MaxCount int
+	// Rate caps how many users per second a generation creates.
+	Rate int
+}
+
+func LoadTestDataPolicy(src *config.Source) TestDataPolicy {
+	return TestDataPolicy{
+		Enabled:  src.String("GENERATE_ENABLED", "false") == "true",
+		MaxCount: src.Int("GENERATE_MAX_COUNT", 100000),
+		Rate:     src.Int("GENERATE_RATE", 5000),
+	}
+}
+
+// GenerateRequest asks for count fake users. Each gets one of Tenants
+// as its tenant custom field and a few of Tags, comma-separated, as its
+// tags custom field, when those are given.
+type GenerateRequest struct {
+	Count   int      `json:"count"`
+	Tenants []string `json:"tenants,omitempty"`
+	Tags    []string `json:"tags,omitempty"`
+}
+
+// Generation is the progress of a generate request.
+type Generation struct {
+	ID         string     `json:"id"`
+	Status     string     `json:"status"`
+	Requested  int        `json:"requested"`
+	Created    int        `json:"created"`
+	StartedAt  time.Time  `json:"started_at"`
+	FinishedAt *time.Time `json:"finished_at,omitempty"`
+	Error      string     `json:"error,omitempty"`
+}
+
+// maxGenerations is how many generations are remembered; the oldest
+// finished one is forgotten to make room.
+const maxGenerations = 20
+
+// TestData fills the store with fake users for load tests and staging.
+// Users are written straight to the store, without hooks or quotas, one
+// generation at a time. Generations are kept in memory.
+type TestData struct {
+	users  *Users
+	policy TestDataPolicy
+
+	mu          sync.Mutex
+	generations map[string]*Generation
+}
+
+func NewTestData(users *Users, policy TestDataPolicy) *TestData {
+	return &TestData{users: users, policy: policy, generations: make(map[string]*Generation)}
+}
+
+// Generate starts creating the users in the background and returns the
+// generation to follow them by. The custom fields are checked against
+// the schema of the request's tenant first.
+func (t *TestData) Generate(ctx context.Context, req GenerateRequest) (Generation, error) {
+	if !t.policy.Enabled {
+		return Generation{}, ErrGenerationDisabled
+	}
+	if req.Count < 1 || req.Count > t.policy.MaxCount {
+		return Generation{}, invalid("count must be within 1..%d", t.policy.MaxCount)
+	}
+	if t.users.ids == nil {
+		return Generation{}, errors.New("generate: no ID generator")
+	}
+	rng := rand.New(rand.NewSource(time.Now().UnixNano()))
+	if err := t.users.schemas.validate(credentialTenant(ctx), fakeCustomFields(rng, req)); err != nil {
+		return Generation{}, err
+	}
+	id, err := randomID()
+	if err != nil {
+		return Generation{}, err
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for _, g := range t.generations {
+		if g.Status == GenerationRunning {
+			return Generation{}, ErrGenerationRunning
+		}
+	}
+	t.forgetOldest()
+	g := &Generation{ID: id, Status: GenerationRunning, Requested: req.Count, StartedAt: time.Now().UTC()}
+	t.generations[id] = g
+	// The generation outlives the request that started it.
+	go t.run(context.WithoutCancel(ctx), g, req, rng)
+	return *g, nil
+}
+
+// forgetOldest makes room for a new generation. Callers must hold t.mu.
+func (t *TestData) forgetOldest() {
+	if len(t.generations) < maxGenerations {
+		return
+	}
+	list := make([]*Generation, 0, len(t.generations))
+	for _, g := range t.generations {
+		list = append(list, g)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].StartedAt.Before(list[j].StartedAt) })
+	delete(t.generations, list[0].ID)
+}
+
+// Get returns the generation's progress.
+func (t *TestData) Get(id string) (Generation, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	g, ok := t.generations[id]
+	if !ok {
+		return Generation{}, ErrGenerationNotFound
+	}
+	return *g, nil
+}
+
+// run creates the users and records how it went.
+func (t *TestData) run(ctx context.Context, g *Generation, req GenerateRequest, rng *rand.Rand) {
+	err := t.create(ctx, g, req, rng)
+	if err != nil {
+		log.Printf("generate %s: %v", g.ID, err)
+	}
+	t.finish(g, err)
+}
+
+// create writes the users in batches of a tenth of the rate, one batch
+// every 100ms.
+func (t *TestData) create(ctx context.Context, g *Generation, req GenerateRequest, rng *rand.Rand) error {
+	batch := max(t.policy.Rate/10, 1)
+	ticker := time.NewTicker(100 * time.Millisecond)
+	defer ticker.Stop()
+	scopes := t.users.defaultScopes()
+	for n := 0; n < req.Count; n++ {
+		if n > 0 && n%batch == 0 {
+			<-ticker.C
+		}
+		id, err := t.users.newID(ctx)
+		if err != nil {
+			return err
+		}
+		user := fakeUser(rng, id, g.ID[:6]+strconv.Itoa(n), req)
+		user.Email = t.users.emails.Normalize(user.Email)
+		user.Scopes, user.Tenant = scopes, credentialTenant(ctx)
+		if err := t.users.store.Create(ctx, user); err != nil {
+			return err
+		}
+		t.mu.Lock()
+		g.Created = n + 1
+		t.mu.Unlock()
+	}
+	return nil
+}
+
+func (t *TestData) finish(g *Generation, err error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	now := time.Now().UTC()
+	g.FinishedAt = &now
+	g.Status = GenerationDone
+	if err != nil {
+		g.Status = GenerationFailed
+		g.Error = err.Error()
+	}
+}
+
+var (
+	fakeFirstNames = []string{
+		"Ada", "Aiko", "Amara", "Ana", "Arjun", "Ben", "Carlos", "Chen", "Chloe", "Dmitri",
+		"Elena", "Emeka", "Fatima", "Finn", "Grace", "Hana", "Ibrahim", "Ines", "Jamal", "Jonas",
+		"Kai", "Laila", "Leo", "Lucia", "Mateo", "Maya", "Mei", "Nia", "Noah", "Olga",
+		"Omar", "Priya", "Rafael", "Rosa", "Sami", "Sofia", "Tariq", "Yara", "Yusuf", "Zoe",
+	}
+	fakeLastNames = []string{
+		"Abara", "Andersen", "Bauer", "Costa", "Dubois", "Eriksson", "Fernandez", "Garcia", "Haddad", "Ivanova",
+		"Jensen", "Kim", "Kowalski", "Larsen", "Mendes", "Moreau", "Nakamura", "Novak", "Okafor", "Olsen",
+		"Patel", "Quinn", "Rossi", "Sato", "Schmidt", "Silva", "Singh", "Tanaka", "Torres", "Walker",
+	}
+	// fakeDomains are reserved for examples, so no mail can reach anyone.
+	fakeDomains = []string{"example.com", "example.net", "example.org"}
+)
+
+// fakeUser makes up a user. unique goes into the email to keep it apart
+// from every other generated one. Numbers are in the 555-0100 to
+// 555-0199 range set aside for fiction, and the statuses are mixed as
+// in a user base that has been running a while.
+func fakeUser(rng *rand.Rand, id, unique string, req GenerateRequest) store.User {
+	first := fakeFirstNames[rng.Intn(len(fakeFirstNames))]
+	last := fakeLastNames[rng.Intn(len(fakeLastNames))]
+	user := store.User{
+		ID:           id,
+		Name:         first + " " + last,
+		Email:        strings.ToLower(first+"."+last+"."+unique) + "@" + fakeDomains[rng.Intn(len(fakeDomains))],
+		Status:       store.StatusActive,
+		CustomFields: fakeCustomFields(rng, req),
+	}
+	if rng.Intn(2) == 0 {
+		user.Phone = fmt.Sprintf("+1%d55501%02d", 200+rng.Intn(800), rng.Intn(100))
+	}
+	switch p := rng.Intn(100); {
+	case p < 5:
+		user.Status = store.StatusSuspended
+	case p < 7:
+		user.Status = store.StatusLocked
+	}
+	return user
+}
+
+func fakeCustomFields(rng *rand.Rand, req GenerateRequest) map[string]any {
+	if len(req.Tenants) == 0 && len(req.Tags) == 0 {
+		return nil
+	}
+	fields := make(map[string]any)
+	if len(req.Tenants) > 0 {
+		fields["tenant"] = req.Tenants[rng.Intn(len(req.Tenants))]
+	}
+	if len(req.Tags) > 0 {
+		tags := make([]string, 0, 3)
+		for _, i := range rng.Perm(len(req.Tags))[:min(1+rng.Intn(3), len(req.Tags))] {
+			tags = append(tags, req.Tags[i])
+		}
+		sort.Strings(tags)
+		fields["tags"] = strings.Join(tags, ",")
+	}
+	return fields
+}
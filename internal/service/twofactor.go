@@ -0,0 +1,60 @@
+package service
+
+import (
+	"context"
+	"time"
+
+	"user-service/internal/store"
+	"user-service/internal/totp"
+)
+
+const totpIssuer = "user-service"
+
+// TwoFactor enrolls users in TOTP two-factor authentication.
+type TwoFactor struct {
+	store store.Store
+}
+
+func NewTwoFactor(s store.Store) *TwoFactor {
+	return &TwoFactor{store: s}
+}
+
+// TwoFactorSetup is shown to the user once, when they enroll.
+type TwoFactorSetup struct {
+	Secret        string   `json:"secret"`
+	URI           string   `json:"uri"`
+	RecoveryCodes []string `json:"recovery_codes"`
+}
+
+// Setup creates a new pending secret and recovery codes for the user. It
+// takes effect once a code is verified.
+func (t *TwoFactor) Setup(ctx context.Context, id string) (TwoFactorSetup, error) {
+	user, err := t.store.Get(ctx, id)
+	if err != nil {
+		return TwoFactorSetup{}, err
+	}
+
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		return TwoFactorSetup{}, err
+	}
+	plain, hashed, err := totp.GenerateRecoveryCodes()
+	if err != nil {
+		return TwoFactorSetup{}, err
+	}
+
+	if err := t.store.SetupTwoFactor(ctx, id, store.TwoFactor{Secret: secret, RecoveryCodes: hashed}); err != nil {
+		return TwoFactorSetup{}, err
+	}
+	return TwoFactorSetup{
+		Secret:        secret,
+		URI:           totp.URI(totpIssuer, secret, user.Email),
+		RecoveryCodes: plain,
+	}, nil
+}
+
+// Verify checks a TOTP code, enabling two-factor authentication on the
+// first success.
+func (t *TwoFactor) Verify(ctx context.Context, id, code string) error {
+	return t.store.VerifyTwoFactor(ctx, id, code, false, time.Now())
+}
@@ -0,0 +1,77 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"user-service/internal/store"
+	"user-service/internal/totp"
+)
+
+func TestTwoFactorSetupAndVerify(t *testing.T) {
+	ctx := context.Background()
+	s := store.NewUserStore()
+	if err := s.Create(ctx, store.User{ID: "1", Name: "User 1", Email: "1@example.com"}); err != nil {
+		t.Fatal(err)
+	}
+	twoFactor := NewTwoFactor(s)
+
+	setup, err := twoFactor.Setup(ctx, "1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(setup.URI, "secret="+setup.Secret) || len(setup.RecoveryCodes) != totp.RecoveryCodeCount {
+		t.Fatalf("setup = %+v", setup)
+	}
+	if enabled, _ := s.TwoFactorEnabled(ctx, "1"); enabled {
+		t.Fatal("2FA is enabled before a code was verified")
+	}
+
+	// Recovery codes only stand in for a code once 2FA is enabled.
+	if err := s.VerifyTwoFactor(ctx, "1", setup.RecoveryCodes[0], true, time.Now()); !errors.Is(err, store.ErrInvalidTwoFactorCode) {
+		t.Fatalf("recovery code before enabling: %v", err)
+	}
+	code, err := totp.Code(setup.Secret, time.Now().Unix()/totp.Period)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := twoFactor.Verify(ctx, "1", code); err != nil {
+		t.Fatal(err)
+	}
+	if enabled, _ := s.TwoFactorEnabled(ctx, "1"); !enabled {
+		t.Fatal("2FA is not enabled after a code was verified")
+	}
+
+	// A code works once, and an enabled setup can't be replaced.
+	if err := twoFactor.Verify(ctx, "1", code); !errors.Is(err, store.ErrInvalidTwoFactorCode) {
+		t.Fatalf("replayed code: %v", err)
+	}
+	if _, err := twoFactor.Setup(ctx, "1"); !errors.Is(err, store.ErrTwoFactorEnabled) {
+		t.Fatalf("Setup after enabling: %v", err)
+	}
+
+	if err := s.VerifyTwoFactor(ctx, "1", setup.RecoveryCodes[0], true, time.Now()); err != nil {
+		t.Fatalf("recovery code: %v", err)
+	}
+	if err := s.VerifyTwoFactor(ctx, "1", setup.RecoveryCodes[0], true, time.Now()); !errors.Is(err, store.ErrInvalidTwoFactorCode) {
+		t.Fatalf("reused recovery code: %v", err)
+	}
+}
+
+func TestTwoFactorVerifyWithoutSetup(t *testing.T) {
+	ctx := context.Background()
+	s := store.NewUserStore()
+	if err := s.Create(ctx, store.User{ID: "1", Name: "User 1", Email: "1@example.com"}); err != nil {
+		t.Fatal(err)
+	}
+	twoFactor := NewTwoFactor(s)
+	if err := twoFactor.Verify(ctx, "1", "123456"); !errors.Is(err, store.ErrTwoFactorNotSetUp) {
+		t.Fatalf("Verify = %v, want %v", err, store.ErrTwoFactorNotSetUp)
+	}
+	if _, err := twoFactor.Setup(ctx, "2"); !errors.Is(err, store.ErrUserNotFound) {
+		t.Fatalf("Setup of a missing user = %v, want %v", err, store.ErrUserNotFound)
+	}
+}
@@ -0,0 +1,516 @@
+// Package service holds the business rules of the user service between
+// the HTTP handlers and the store.
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+
+	"user-service/internal/idgen"
+	"user-service/internal/store"
+)
+
+// MaxBatchIDs caps how many users one batch lookup may request.
+const MaxBatchIDs = 500
+
+// ValidationError reports a request that breaks a business rule. Its
+// message is meant for the caller; Format is the English message and the
+// key of its translations. Fields, when the failure is about the request's
+// fields, lists each one that failed and which rule.
+type ValidationError struct {
+	Format string
+	Args   []any
+	Fields []FieldError
+}
+
+func (e *ValidationError) Error() string {
+	return fmt.Sprintf(e.Format, e.Args...)
+}
+
+func invalid(format string, args ...any) error {
+	return &ValidationError{Format: format, Args: args}
+}
+
+// Users manages user records.
+type Users struct {
+	store         store.Store
+	defaultScopes func() []string
+	hooks         *Hooks
+	emails        *Emails
+	schemas       *Schemas
+	ids           idgen.Generator
+	quotas        *Quotas
+}
+
+// NewUsers returns a Users service. defaultScopes supplies the scopes of
+// new users that don't ask for any; hooks may be nil. Emails are
+// normalized by emails before they are stored and must be unique.
+// Custom fields are checked against schemas, or refused if it is nil.
+// New users without an ID get one from ids, or are refused if it is nil.
+// New users count against the users quotas in quotas, which may be nil.
+func NewUsers(s store.Store, defaultScopes func() []string, hooks *Hooks, emails *Emails, schemas *Schemas, ids idgen.Generator, quotas *Quotas) *Users {
+	return &Users{store: s, defaultScopes: defaultScopes, hooks: hooks, emails: emails, schemas: schemas, ids: ids, quotas: quotas}
+}
+
+// ValidateNew checks the fields a new user must have, reporting every
+// one that fails.
+func ValidateNew(user store.User) error {
+	var errs fieldErrors
+	if user.ID == "" {
+		errs.add("/id", RuleRequired, "ID is required")
+	}
+	checkNameAndEmail(&errs, user)
+	if user.Status != "" && (!store.ValidStatus(user.Status) || user.Status == store.StatusMerged) {
+		errs.add("/status", RuleEnum, "Invalid status")
+	}
+	if user.Phone != "" {
+		if _, err := NormalizePhone(user.Phone); err != nil {
+			errs = append(errs, errInvalidPhoneInput.Fields...)
+		}
+	}
+	return errs.err()
+}
+
+// checkNameAndEmail adds the failures of the fields every user needs.
+func checkNameAndEmail(errs *fieldErrors, user store.User) {
+	if user.Name == "" {
+		errs.add("/name", RuleRequired, "Name is required")
+	}
+	checkEmail(errs, user.Email)
+}
+
+func checkEmail(errs *fieldErrors, email string) {
+	switch {
+	case email == "":
+		errs.add("/email", RuleRequired, "Email is required")
+	case !validEmail(email):
+		errs.add("/email", RuleFormat, "Invalid email address")
+	}
+}
+
+// keepPhoneVerified carries over a verification only while the number
+// stays the same; callers can't set it themselves.
+func keepPhoneVerified(user *store.User, existing store.User) {
+	user.PhoneVerified = existing.PhoneVerified && existing.Phone == user.Phone
+}
+
+// checkCustomFields validates the custom fields a replacement of existing
+// ends up with, its own or the existing ones when it has none, against
+// the schema of the tenant the user belongs to: the existing user's, or
+// the caller's credential's for a user that doesn't exist yet.
+func (u *Users) checkCustomFields(ctx context.Context, user, existing store.User) error {
+	fields := user.CustomFields
+	if fields == nil {
+		fields = existing.CustomFields
+	}
+	tenant := existing.Tenant
+	if existing.ID == "" {
+		tenant = credentialTenant(ctx)
+	}
+	return u.schemas.validate(tenant, fields)
+}
+
+func hashPassword(password string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	return string(hash), err
+}
+
+// setPassword replaces the plaintext password on the user with its hash.
+func setPassword(user *store.User) error {
+	if user.Password == "" {
+		return nil
+	}
+	hash, err := hashPassword(user.Password)
+	if err != nil {
+		return err
+	}
+	user.PasswordHash = hash
+	user.Password = ""
+	return nil
+}
+
+// Create stores a new user, with a generated ID if it has none, failing
+// with store.ErrUserExists if the ID is taken. New users start active
+// unless they ask for another status. A dry run still uses up the
+// generated ID.
+func (u *Users) Create(ctx context.Context, user store.User) (store.User, error) {
+	if user.ID == "" && u.ids != nil {
+		id, err := u.newID(ctx)
+		if err != nil {
+			return store.User{}, err
+		}
+		user.ID = id
+	}
+	user.Email = u.emails.Normalize(user.Email)
+	user.Tenant = credentialTenant(ctx)
+	if err := joinInvalid(ValidateNew(user), u.schemas.validate(user.Tenant, user.CustomFields)); err != nil {
+		return store.User{}, err
+	}
+	if err := u.emails.Domains().Check(user.Email); err != nil {
+		return store.User{}, err
+	}
+	if err := normalizePhone(&user); err != nil {
+		return store.User{}, err
+	}
+	user.PhoneVerified = false
+	user.LockedUntil, user.MergedInto = nil, ""
+	if user.Scopes == nil {
+		user.Scopes = u.defaultScopes()
+	}
+	if err := setPassword(&user); err != nil {
+		return store.User{}, err
+	}
+
+	defer u.emails.lock(user.Email)()
+	reserved, err := u.quotas.reserveUsers(ctx, user.ID)
+	if err != nil {
+		return store.User{}, err
+	}
+	created := false
+	defer func() { reserved(created) }()
+	// The store refuses a taken ID too; checking here covers dry runs.
+	if _, err := u.store.Get(store.Fresh(ctx), user.ID); err == nil {
+		return store.User{}, store.ErrUserExists
+	} else if !errors.Is(err, store.ErrUserNotFound) {
+		return store.User{}, err
+	}
+	if err := u.emails.claim(ctx, u.store, user.ID, user.Email); err != nil {
+		return store.User{}, err
+	}
+	if !DryRun(ctx) {
+		if err := u.store.Create(ctx, user); err != nil {
+			return store.User{}, err
+		}
+		created = true
+	}
+	if user.Status == "" {
+		user.Status = store.StatusActive
+	}
+	return user, nil
+}
+
+// maxIDAttempts bounds how many generated IDs newID tries before giving
+// up on finding one that isn't taken.
+const maxIDAttempts = 5
+
+// newID generates an ID no stored user has. Generated IDs don't repeat,
+// but a caller or a restore may have brought in the same one.
+func (u *Users) newID(ctx context.Context) (string, error) {
+	for i := 0; i < maxIDAttempts; i++ {
+		id, err := u.ids.NewID()
+		if err != nil {
+			return "", err
+		}
+		_, err = u.store.Get(store.Fresh(ctx), id)
+		if errors.Is(err, store.ErrUserNotFound) {
+			return id, nil
+		}
+		if err != nil {
+			return "", err
+		}
+	}
+	return "", fmt.Errorf("no free ID after %d attempts", maxIDAttempts)
+}
+
+// Get returns the user, or for a user merged into another, the one it was
+// merged into.
+func (u *Users) Get(ctx context.Context, id string) (store.User, error) {
+	user, err := u.store.Get(ctx, id)
+	for hops := 0; err == nil && user.MergedInto != "" && hops < maxMergeHops; hops++ {
+		user, err = u.store.Get(ctx, user.MergedInto)
+	}
+	return user, err
+}
+
+// GetMany resolves the IDs, ignoring blanks and duplicates, and returns
+// the users found along with the IDs that don't exist.
+func (u *Users) GetMany(ctx context.Context, ids []string) ([]store.User, []string, error) {
+	seen := make(map[string]bool, len(ids))
+	unique := make([]string, 0, len(ids))
+	for _, id := range ids {
+		id = strings.TrimSpace(id)
+		if id == "" || seen[id] {
+			continue
+		}
+		seen[id] = true
+		unique = append(unique, id)
+	}
+	if len(unique) > MaxBatchIDs {
+		return nil, nil, invalid("At most %d IDs per request", MaxBatchIDs)
+	}
+	return u.store.GetMany(ctx, unique)
+}
+
+// ListOptions selects a page of users. A zero Limit means the whole list.
+// Query keeps users whose ID or name contains it, ignoring case, and also
+// matches emails when SearchEmails is set. A non-nil PhoneVerified keeps
+// users whose phone verification matches it.
+type ListOptions struct {
+	Status        string
+	Query         string
+	SearchEmails  bool
+	PhoneVerified *bool
+	Offset        int
+	Limit         int
+}
+
+// matches reports whether the user passes the options' filters.
+func (o ListOptions) matches(user store.User) bool {
+	if o.Status != "" && user.Status != o.Status {
+		return false
+	}
+	if o.Status == "" && user.Status == store.StatusMerged {
+		return false
+	}
+	if o.PhoneVerified != nil && user.PhoneVerified != *o.PhoneVerified {
+		return false
+	}
+	if o.Query == "" {
+		return true
+	}
+	q := strings.ToLower(o.Query)
+	return strings.Contains(strings.ToLower(user.ID), q) ||
+		strings.Contains(strings.ToLower(user.Name), q) ||
+		(o.SearchEmails && strings.Contains(strings.ToLower(user.Email), q))
+}
+
+// List returns the requested page of users, ordered by ID, and the total
+// number of matching users.
+func (u *Users) List(ctx context.Context, opts ListOptions) ([]store.User, int, error) {
+	if opts.Status != "" && !store.ValidStatus(opts.Status) {
+		return nil, 0, invalid("Invalid status")
+	}
+	users := make([]store.User, 0)
+	err := u.store.ForEach(ctx, func(user store.User) error {
+		if opts.matches(user) {
+			users = append(users, user)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+
+	sort.Slice(users, func(i, j int) bool { return users[i].ID < users[j].ID })
+	total := len(users)
+	if opts.Offset >= len(users) {
+		return []store.User{}, total, nil
+	}
+	users = users[opts.Offset:]
+	if opts.Limit > 0 && opts.Limit < len(users) {
+		users = users[:opts.Limit]
+	}
+	return users, total, nil
+}
+
+// Update replaces an existing user and returns it as stored.
+func (u *Users) Update(ctx context.Context, user store.User) (store.User, error) {
+	user.Email = u.emails.Normalize(user.Email)
+	if err := normalizePhone(&user); err != nil {
+		return store.User{}, err
+	}
+	existing, err := u.store.Get(store.Fresh(ctx), user.ID)
+	if err != nil {
+		return store.User{}, err
+	}
+	if existing.MergedInto != "" {
+		return store.User{}, store.ErrUserMerged
+	}
+	if err := u.checkNewEmail(user, existing); err != nil {
+		return store.User{}, err
+	}
+	keepPhoneVerified(&user, existing)
+	if err := u.checkCustomFields(ctx, user, existing); err != nil {
+		return store.User{}, err
+	}
+	if err := setPassword(&user); err != nil {
+		return store.User{}, err
+	}
+	unlock := u.emails.lock(user.Email)
+	err = u.emails.claim(ctx, u.store, user.ID, user.Email)
+	if err == nil && !DryRun(ctx) {
+		err = u.store.Update(ctx, user)
+	}
+	unlock()
+	if err != nil {
+		return store.User{}, err
+	}
+	if DryRun(ctx) {
+		return store.MergeStored(user, existing), nil
+	}
+	u.hooks.runAfter(ctx, store.NewEvent(store.EventUserUpdated, user.ID))
+	return u.store.Get(store.Fresh(ctx), user.ID)
+}
+
+// checkNewEmail checks an email being set for its format and against the
+// domain policy, so users whose domain was refused after they signed up
+// can still be updated as long as they keep their address.
+func (u *Users) checkNewEmail(user, existing store.User) error {
+	if user.Email == existing.Email {
+		return nil
+	}
+	var errs fieldErrors
+	checkEmail(&errs, user.Email)
+	if err := errs.err(); err != nil {
+		return err
+	}
+	return u.emails.Domains().Check(user.Email)
+}
+
+// Upsert creates or replaces the user, reporting whether it was created.
+func (u *Users) Upsert(ctx context.Context, user store.User) (store.User, bool, error) {
+	user.Email = u.emails.Normalize(user.Email)
+	var errs fieldErrors
+	checkNameAndEmail(&errs, user)
+	if err := joinInvalid(errs.err(), normalizePhone(&user)); err != nil {
+		return store.User{}, false, err
+	}
+	if err := setPassword(&user); err != nil {
+		return store.User{}, false, err
+	}
+	// The store keeps an existing user's tenant.
+	user.Tenant = credentialTenant(ctx)
+	existing, err := u.store.Get(store.Fresh(ctx), user.ID)
+	if errors.Is(err, store.ErrUserNotFound) && user.Scopes == nil {
+		user.Scopes = u.defaultScopes()
+	} else if err != nil && !errors.Is(err, store.ErrUserNotFound) {
+		return store.User{}, false, err
+	}
+	if existing.MergedInto != "" {
+		return store.User{}, false, store.ErrUserMerged
+	}
+	if err := u.checkNewEmail(user, existing); err != nil {
+		return store.User{}, false, err
+	}
+	keepPhoneVerified(&user, existing)
+	if err := u.checkCustomFields(ctx, user, existing); err != nil {
+		return store.User{}, false, err
+	}
+	unlock := u.emails.lock(user.Email)
+	created, reserved := false, func(bool) {}
+	if existing.ID == "" {
+		reserved, err = u.quotas.reserveUsers(ctx, user.ID)
+	}
+	if err == nil {
+		err = u.emails.claim(ctx, u.store, user.ID, user.Email)
+	}
+	if err == nil && !DryRun(ctx) {
+		created, err = u.store.Upsert(ctx, user)
+	}
+	if reserved != nil {
+		reserved(created)
+	}
+	unlock()
+	if err != nil {
+		return store.User{}, false, err
+	}
+	if DryRun(ctx) {
+		if existing.ID == "" {
+			user.Status = store.StatusActive
+			user.LockedUntil, user.MergedInto = nil, ""
+			return user, true, nil
+		}
+		return store.MergeStored(user, existing), false, nil
+	}
+	if !created {
+		u.hooks.runAfter(ctx, store.NewEvent(store.EventUserUpdated, user.ID))
+	}
+	user, err = u.store.Get(store.Fresh(ctx), user.ID)
+	return user, created, err
+}
+
+// Delete removes the user once every sync delete hook has cleaned up
+// after them.
+func (u *Users) Delete(ctx context.Context, id string) error {
+	if err := u.beforeDelete(ctx, store.NewEvent(store.EventUserDeleted, id)); err != nil || DryRun(ctx) {
+		return err
+	}
+	return u.store.Delete(ctx, id)
+}
+
+// beforeDelete runs the sync delete hooks for a user that exists. A dry
+// run only checks that the user exists, since the hooks act on other
+// services.
+func (u *Users) beforeDelete(ctx context.Context, event store.Event) error {
+	if _, err := u.store.Get(ctx, event.UserID); err != nil || DryRun(ctx) {
+		return err
+	}
+	return u.hooks.run(ctx, Sync, event)
+}
+
+// Transition moves the user to the status if the lifecycle allows it.
+func (u *Users) Transition(ctx context.Context, id, status string) (store.User, error) {
+	if DryRun(ctx) {
+		return u.dryTransition(ctx, id, status)
+	}
+	user, err := u.store.Transition(ctx, id, status)
+	if err != nil {
+		return store.User{}, err
+	}
+	u.hooks.runAfter(ctx, store.NewEvent(store.StatusEvent(status), id))
+	return user, nil
+}
+
+// dryTransition returns the user as Transition would leave them.
+func (u *Users) dryTransition(ctx context.Context, id, status string) (store.User, error) {
+	user, err := u.store.Get(ctx, id)
+	if err != nil {
+		return store.User{}, err
+	}
+	if !store.CanTransition(user.Status, status) {
+		return store.User{}, store.ErrInvalidTransition
+	}
+	user.Status = status
+	user.LockedUntil = nil
+	return user, nil
+}
+
+// Forget erases the user and everything held about them, including what
+// delete hooks hold.
+func (u *Users) Forget(ctx context.Context, id, requestedBy string) error {
+	if err := u.beforeDelete(ctx, store.NewEvent(store.EventUserForgotten, id)); err != nil {
+		return err
+	}
+	return u.store.Forget(ctx, id, requestedBy)
+}
+
+func (u *Users) LoginHistory(ctx context.Context, id string) ([]store.LoginAttempt, error) {
+	return u.store.LoginHistory(ctx, id)
+}
+
+// DataExport is everything held about a user.
+type DataExport struct {
+	ExportedAt       time.Time            `json:"exported_at"`
+	User             store.User           `json:"user"`
+	LoginHistory     []store.LoginAttempt `json:"login_history"`
+	TwoFactorEnabled bool                 `json:"two_factor_enabled"`
+	Preferences      map[string]any       `json:"preferences,omitempty"`
+	Events           []store.Event        `json:"events"`
+}
+
+func (u *Users) Export(ctx context.Context, id string) (DataExport, error) {
+	export := DataExport{ExportedAt: time.Now().UTC()}
+	var err error
+	if export.User, err = u.store.Get(ctx, id); err != nil {
+		return DataExport{}, err
+	}
+	if export.LoginHistory, err = u.store.LoginHistory(ctx, id); err != nil {
+		return DataExport{}, err
+	}
+	if export.TwoFactorEnabled, err = u.store.TwoFactorEnabled(ctx, id); err != nil {
+		return DataExport{}, err
+	}
+	if export.Preferences, err = u.store.GetPreferences(ctx, id); err != nil {
+		return DataExport{}, err
+	}
+	if export.Events, err = u.store.EventsForUser(ctx, id); err != nil {
+		return DataExport{}, err
+	}
+	return export, nil
+}
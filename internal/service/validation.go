@@ -0,0 +1,98 @@
+package service
+
+import (
+	"errors"
+	"strings"
+	"unicode"
+)
+
+// Rules a FieldError can name.
+const (
+	RuleRequired  = "required"
+	RuleFormat    = "format"
+	RuleEnum      = "enum"
+	RuleType      = "type"
+	RulePattern   = "pattern"
+	RuleUnknown   = "unknown"
+	RuleDuplicate = "duplicate"
+	RuleMaxItems  = "max_items"
+	RuleDomain    = "domain"
+	RuleRange     = "range"
+)
+
+// FieldError is one check a request field failed. Field is a JSON pointer
+// into the request body, such as /email or /custom_fields/team, Rule
+// names the check, and Format is the English message and the key of its
+// translations.
+type FieldError struct {
+	Field  string
+	Rule   string
+	Format string
+	Args   []any
+}
+
+// fieldErrors collects the failed checks of one request so they can be
+// reported together.
+type fieldErrors []FieldError
+
+func (f *fieldErrors) add(field, rule, format string, args ...any) {
+	*f = append(*f, FieldError{Field: field, Rule: rule, Format: format, Args: args})
+}
+
+// err returns the failures as a *ValidationError whose message is the
+// first one's, or nil if there are none.
+func (f fieldErrors) err() error {
+	if len(f) == 0 {
+		return nil
+	}
+	return &ValidationError{Format: f[0].Format, Args: f[0].Args, Fields: f}
+}
+
+func invalidField(field, rule, format string, args ...any) *ValidationError {
+	var f fieldErrors
+	f.add(field, rule, format, args...)
+	return f.err().(*ValidationError)
+}
+
+// joinInvalid combines the field failures of several checks into one
+// error. Any other error, or a validation error not tied to fields, is
+// returned as it is, first come.
+func joinInvalid(errs ...error) error {
+	var all fieldErrors
+	for _, err := range errs {
+		if err == nil {
+			continue
+		}
+		var invalid *ValidationError
+		if !errors.As(err, &invalid) || len(invalid.Fields) == 0 {
+			return err
+		}
+		all = append(all, invalid.Fields...)
+	}
+	return all.err()
+}
+
+// pointer builds a JSON pointer from its reference tokens, escaping them
+// as RFC 6901 requires.
+func pointer(tokens ...string) string {
+	var b strings.Builder
+	for _, token := range tokens {
+		b.WriteByte('/')
+		b.WriteString(strings.NewReplacer("~", "~0", "/", "~1").Replace(token))
+	}
+	return b.String()
+}
+
+// validEmail is a sanity check rather than RFC 5322: a local part and a
+// domain around the last @, with no spaces or control characters.
+func validEmail(email string) bool {
+	at := strings.LastIndex(email, "@")
+	if at <= 0 || at == len(email)-1 {
+		return false
+	}
+	domain := email[at+1:]
+	if strings.HasPrefix(domain, ".") || strings.HasSuffix(domain, ".") || strings.Contains(domain, "..") {
+		return false
+	}
+	return strings.IndexFunc(email, func(r rune) bool { return unicode.IsSpace(r) || unicode.IsControl(r) }) < 0
+}
@@ -0,0 +1,204 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"maps"
+	"sort"
+	"time"
+)
+
+// Ways Restore handles a user that already exists.
+const (
+	// RestoreSkip keeps the stored user untouched.
+	RestoreSkip = "skip"
+	// RestoreOverwrite replaces the stored user and everything held
+	// about them with the backup's.
+	RestoreOverwrite = "overwrite"
+	// RestoreMerge keeps what is stored and fills in what it lacks from
+	// the backup.
+	RestoreMerge = "merge"
+)
+
+// What Restore did with a record.
+const (
+	RestoreCreated     = "created"
+	RestoreSkipped     = "skipped"
+	RestoreOverwritten = "overwritten"
+	RestoreMerged      = "merged"
+)
+
+var ErrUnknownRestoreMode = errors.New("unknown restore mode")
+
+// UserRecord is a user and everything held about them, as backed up. The
+// outbox and the login failure counters are not included.
+type UserRecord struct {
+	User         User           `json:"user"`
+	PasswordHash string         `json:"password_hash,omitempty"`
+	TwoFactor    *TwoFactor     `json:"two_factor,omitempty"`
+	LoginHistory []LoginAttempt `json:"login_history,omitempty"`
+	Preferences  map[string]any `json:"preferences,omitempty"`
+}
+
+// Dump returns every user record ordered by ID. All shards are locked
+// together while they are copied, so the dump is a consistent snapshot.
+func (s *UserStore) Dump(ctx context.Context) ([]UserRecord, error) {
+	for _, sh := range s.shards {
+		sh.mu.RLock()
+	}
+	records := make([]UserRecord, 0)
+	for _, sh := range s.shards {
+		for id, user := range sh.users {
+			records = append(records, sh.record(id, user))
+		}
+	}
+	for _, sh := range s.shards {
+		sh.mu.RUnlock()
+	}
+	sort.Slice(records, func(i, j int) bool { return records[i].User.ID < records[j].User.ID })
+	return records, nil
+}
+
+// record copies out the user's record. Callers must hold sh.mu.
+func (sh *shard) record(id string, user User) UserRecord {
+	rec := UserRecord{PasswordHash: user.PasswordHash}
+	user.PasswordHash = ""
+	user.CustomFields = maps.Clone(user.CustomFields)
+	rec.User = user
+	if tf, ok := sh.twoFactor[id]; ok {
+		tf.RecoveryCodes = append([]string(nil), tf.RecoveryCodes...)
+		rec.TwoFactor = &tf
+	}
+	rec.LoginHistory = append([]LoginAttempt(nil), sh.loginHistory[id]...)
+	rec.Preferences = maps.Clone(sh.preferences[id])
+	return rec
+}
+
+// Restore writes one record back, handling an existing user as mode says,
+// and reports what it did. Created and overwritten or merged users emit
+// user.created and user.updated.
+func (s *UserStore) Restore(ctx context.Context, rec UserRecord, mode string) (string, error) {
+	switch mode {
+	case RestoreSkip, RestoreOverwrite, RestoreMerge:
+	default:
+		return "", ErrUnknownRestoreMode
+	}
+	id := rec.User.ID
+	sh := s.shardFor(id)
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+
+	outcome := RestoreCreated
+	if existing, exists := sh.users[id]; exists {
+		switch mode {
+		case RestoreSkip:
+			return RestoreSkipped, nil
+		case RestoreOverwrite:
+			outcome = RestoreOverwritten
+		case RestoreMerge:
+			rec = mergeRecord(sh.record(id, existing), rec)
+			outcome = RestoreMerged
+		}
+	}
+
+	sh.put(rec)
+
+	if outcome == RestoreCreated {
+		sh.stats.countCreated(time.Now())
+		s.appendEvent(ctx, NewEvent(EventUserCreated, id))
+	} else {
+		s.appendEvent(ctx, NewEvent(EventUserUpdated, id))
+	}
+	return outcome, nil
+}
+
+// put replaces everything held about the record's user with the record.
+// Callers must hold sh.mu.
+func (sh *shard) put(rec UserRecord) {
+	id := rec.User.ID
+	user := rec.User
+	if user.Status == "" {
+		user.Status = StatusActive
+	}
+	// A backup only ever holds the hash; a plaintext password in the
+	// file is never stored.
+	user.Password = ""
+	user.PasswordHash = rec.PasswordHash
+	user.CustomFields = maps.Clone(user.CustomFields)
+	sh.remove(id)
+	sh.set(user)
+	if rec.TwoFactor != nil {
+		tf := *rec.TwoFactor
+		tf.RecoveryCodes = append([]string(nil), tf.RecoveryCodes...)
+		sh.twoFactor[id] = tf
+	}
+	if history := rec.LoginHistory; len(history) > 0 {
+		if len(history) > maxLoginHistory {
+			history = history[len(history)-maxLoginHistory:]
+		}
+		sh.loginHistory[id] = append([]LoginAttempt(nil), history...)
+	}
+	if len(rec.Preferences) > 0 {
+		sh.preferences[id] = maps.Clone(rec.Preferences)
+	}
+}
+
+// mergeRecord keeps the stored record and fills in from the backup the
+// fields it left empty, custom fields and preferences it doesn't have and
+// a 2FA setup if it has none. Login histories are combined in time order.
+func mergeRecord(stored, backup UserRecord) UserRecord {
+	user, from := stored.User, backup.User
+	if user.Name == "" {
+		user.Name = from.Name
+	}
+	if user.Email == "" {
+		user.Email, user.EmailIndex = from.Email, from.EmailIndex
+	}
+	if user.Phone == "" {
+		user.Phone, user.PhoneVerified = from.Phone, from.PhoneVerified
+	}
+	if user.Scopes == nil {
+		user.Scopes = from.Scopes
+	}
+	if len(from.CustomFields) > 0 {
+		fields := maps.Clone(from.CustomFields)
+		maps.Copy(fields, user.CustomFields)
+		user.CustomFields = fields
+	}
+	stored.User = user
+	if stored.PasswordHash == "" {
+		stored.PasswordHash = backup.PasswordHash
+	}
+	if stored.TwoFactor == nil {
+		stored.TwoFactor = backup.TwoFactor
+	}
+	if len(backup.Preferences) > 0 {
+		preferences := maps.Clone(backup.Preferences)
+		maps.Copy(preferences, stored.Preferences)
+		stored.Preferences = preferences
+	}
+
+	type attemptKey struct {
+		ip      string
+		at      int64
+		success bool
+		reason  string
+	}
+	key := func(a LoginAttempt) attemptKey { return attemptKey{a.IP, a.Time.UnixNano(), a.Success, a.Reason} }
+	seen := make(map[attemptKey]bool, len(stored.LoginHistory))
+	history := append([]LoginAttempt(nil), stored.LoginHistory...)
+	for _, attempt := range history {
+		seen[key(attempt)] = true
+	}
+	for _, attempt := range backup.LoginHistory {
+		if !seen[key(attempt)] {
+			history = append(history, attempt)
+		}
+	}
+	sort.SliceStable(history, func(i, j int) bool { return history[i].Time.Before(history[j].Time) })
+	if len(history) > maxLoginHistory {
+		history = history[len(history)-maxLoginHistory:]
+	}
+	stored.LoginHistory = history
+	return stored
+}
@@ -0,0 +1,273 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"expvar"
+	"log"
+	"math"
+	"sync"
+)
+
+var bloomMetrics = expvar.NewMap("store_bloom")
+
+// bloomFilter is a set of IDs that may report an ID it never saw but
+// never misses one it did.
+type bloomFilter struct {
+	bits []uint64
+	k    uint64
+}
+
+// newBloomFilter sizes a filter for n IDs at the false positive rate p.
+func newBloomFilter(n int, p float64) *bloomFilter {
+	n = max(n, 1)
+	m := uint64(math.Ceil(-float64(n) * math.Log(p) / (math.Ln2 * math.Ln2)))
+	k := uint64(math.Max(1, math.Round(float64(m)/float64(n)*math.Ln2)))
+	return &bloomFilter{bits: make([]uint64, (m+63)/64), k: k}
+}
+
+// positions derives the filter's k bit positions for the ID from the two
+// halves of its 64-bit FNV-1a hash.
+func (f *bloomFilter) positions(id string, fn func(word uint64, mask uint64)) {
+	h := uint64(14695981039346656037)
+	for i := 0; i < len(id); i++ {
+		h ^= uint64(id[i])
+		h *= 1099511628211
+	}
+	h1, h2 := h&0xffffffff, h>>32|1
+	m := uint64(len(f.bits)) * 64
+	for i := uint64(0); i < f.k; i++ {
+		bit := (h1 + i*h2) % m
+		fn(bit/64, 1<<(bit%64))
+	}
+}
+
+func (f *bloomFilter) add(id string) {
+	f.positions(id, func(word, mask uint64) { f.bits[word] |= mask })
+}
+
+func (f *bloomFilter) mayContain(id string) bool {
+	found := true
+	f.positions(id, func(word, mask uint64) {
+		if f.bits[word]&mask == 0 {
+			found = false
+		}
+	})
+	return found
+}
+
+// BloomConfig sizes the existence filter.
+type BloomConfig struct {
+	ExpectedUsers     int
+	FalsePositiveRate float64
+}
+
+// BloomStore answers lookups of IDs that were never stored without
+// calling the wrapped Store, using a bloom filter of every ID written
+// through it. Deleted IDs stay in the filter until it is rebuilt, which
+// happens once deletes or growth would push the false positive rate well
+// past the configured one. Every write must go through the BloomStore,
+// or lookups of users written around it will wrongly miss.
+type BloomStore struct {
+	Store
+	cfg BloomConfig
+
+	mu sync.RWMutex
+	// filter answers lookups. While a rebuild runs, next receives
+	// the same adds so nothing written meanwhile is lost when it
+	// replaces filter.
+	filter     *bloomFilter
+	next       *bloomFilter
+	capacity   int
+	added      int
+	deleted    int
+	rebuilding bool
+}
+
+// NewBloomStore wraps next, filling the filter from the users it already
+// holds.
+func NewBloomStore(ctx context.Context, next Store, cfg BloomConfig) (*BloomStore, error) {
+	b := &BloomStore{Store: next, cfg: cfg}
+	bloomMetrics.Set("fill", expvar.Func(func() any { return b.fill() }))
+	if err := b.Rebuild(ctx); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// fill reports the fraction of the filter's bits that are set.
+func (b *BloomStore) fill() float64 {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	if b.filter == nil {
+		return 0
+	}
+	set := 0
+	for _, word := range b.filter.bits {
+		for ; word != 0; word &= word - 1 {
+			set++
+		}
+	}
+	return float64(set) / float64(len(b.filter.bits)*64)
+}
+
+// Rebuild replaces the filter with one built from the users stored now,
+// sized for at least twice their number.
+func (b *BloomStore) Rebuild(ctx context.Context) error {
+	b.mu.Lock()
+	b.rebuilding = true
+	capacity := max(b.cfg.ExpectedUsers, 2*(b.added-b.deleted))
+	b.next = newBloomFilter(capacity, b.cfg.FalsePositiveRate)
+	next := b.next
+	b.mu.Unlock()
+
+	count := 0
+	err := b.Store.ForEach(ctx, func(user User) error {
+		b.mu.Lock()
+		next.add(user.ID)
+		b.mu.Unlock()
+		count++
+		return nil
+	})
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.rebuilding = false
+	if err != nil {
+		b.next = nil
+		return err
+	}
+	b.filter, b.next = next, nil
+	b.capacity, b.added, b.deleted = capacity, count, 0
+	bloomMetrics.Add("rebuilds", 1)
+	return nil
+}
+
+// needsRebuild reports whether the filter has taken on more IDs than it
+// was sized for, counting deleted ones it still holds. Upserts of
+// existing users count too, which only brings a rebuild forward. Callers
+// must hold b.mu.
+func (b *BloomStore) needsRebuild() bool {
+	return !b.rebuilding && b.added+b.deleted > b.capacity
+}
+
+func (b *BloomStore) rebuildInBackground() {
+	b.rebuilding = true
+	go func() {
+		if err := b.Rebuild(context.Background()); err != nil {
+			log.Printf("bloom: rebuild: %v", err)
+		}
+	}()
+}
+
+func (b *BloomStore) add(id string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.filter.add(id)
+	if b.next != nil {
+		b.next.add(id)
+	}
+	b.added++
+	if b.needsRebuild() {
+		b.rebuildInBackground()
+	}
+}
+
+func (b *BloomStore) removed() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.deleted++
+	if b.needsRebuild() {
+		b.rebuildInBackground()
+	}
+}
+
+func (b *BloomStore) mayContain(id string) bool {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	bloomMetrics.Add("lookups", 1)
+	if b.filter.mayContain(id) {
+		return true
+	}
+	bloomMetrics.Add("short_circuited", 1)
+	return false
+}
+
+func (b *BloomStore) Create(ctx context.Context, user User) error {
+	// Added before the write so a concurrent Get can't miss it.
+	b.add(user.ID)
+	return b.Store.Create(ctx, user)
+}
+
+func (b *BloomStore) Upsert(ctx context.Context, user User) (bool, error) {
+	b.add(user.ID)
+	return b.Store.Upsert(ctx, user)
+}
+
+func (b *BloomStore) Get(ctx context.Context, id string) (User, error) {
+	if !b.mayContain(id) {
+		return User{}, ErrUserNotFound
+	}
+	user, err := b.Store.Get(ctx, id)
+	if errors.Is(err, ErrUserNotFound) {
+		bloomMetrics.Add("false_positives", 1)
+	}
+	return user, err
+}
+
+// GetMany only asks the wrapped store for IDs the filter may hold.
+func (b *BloomStore) GetMany(ctx context.Context, ids []string) ([]User, []string, error) {
+	maybe := make([]string, 0, len(ids))
+	missing := make([]string, 0)
+	for _, id := range ids {
+		if b.mayContain(id) {
+			maybe = append(maybe, id)
+		} else {
+			missing = append(missing, id)
+		}
+	}
+	if len(maybe) == 0 {
+		return []User{}, missing, nil
+	}
+	found, notFound, err := b.Store.GetMany(ctx, maybe)
+	if err != nil {
+		return nil, nil, err
+	}
+	bloomMetrics.Add("false_positives", int64(len(notFound)))
+	if len(missing) == 0 {
+		return found, notFound, nil
+	}
+	// Keep the missing IDs in request order.
+	absent := make(map[string]bool, len(missing)+len(notFound))
+	for _, id := range append(missing, notFound...) {
+		absent[id] = true
+	}
+	missing = missing[:0]
+	for _, id := range ids {
+		if absent[id] {
+			missing = append(missing, id)
+		}
+	}
+	return found, missing, nil
+}
+
+func (b *BloomStore) Delete(ctx context.Context, id string) error {
+	err := b.Store.Delete(ctx, id)
+	if err == nil {
+		b.removed()
+	}
+	return err
+}
+
+func (b *BloomStore) Forget(ctx context.Context, id, requestedBy string) error {
+	err := b.Store.Forget(ctx, id, requestedBy)
+	if err == nil {
+		b.removed()
+	}
+	return err
+}
+
+func (b *BloomStore) Restore(ctx context.Context, rec UserRecord, mode string) (string, error) {
+	b.add(rec.User.ID)
+	return b.Store.Restore(ctx, rec, mode)
+}
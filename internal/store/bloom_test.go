@@ -0,0 +1,119 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+func TestBloomFilter(t *testing.T) {
+	const n, p = 1000, 0.01
+	f := newBloomFilter(n, p)
+	for i := 0; i < n; i++ {
+		f.add(fmt.Sprint("user-", i))
+	}
+	for i := 0; i < n; i++ {
+		if !f.mayContain(fmt.Sprint("user-", i)) {
+			t.Fatalf("filter misses user-%d", i)
+		}
+	}
+	positives := 0
+	for i := 0; i < 10*n; i++ {
+		if f.mayContain(fmt.Sprint("other-", i)) {
+			positives++
+		}
+	}
+	if rate := float64(positives) / (10 * n); rate > 3*p {
+		t.Fatalf("false positive rate %.3f, configured %.3f", rate, p)
+	}
+}
+
+// countingStore counts the lookups that reach it.
+type countingStore struct {
+	Store
+	gets, getManys int
+	asked          []string
+}
+
+func (c *countingStore) Get(ctx context.Context, id string) (User, error) {
+	c.gets++
+	return c.Store.Get(ctx, id)
+}
+
+func (c *countingStore) GetMany(ctx context.Context, ids []string) ([]User, []string, error) {
+	c.getManys++
+	c.asked = append(c.asked, ids...)
+	return c.Store.GetMany(ctx, ids)
+}
+
+func TestBloomStoreShortCircuitsMisses(t *testing.T) {
+	ctx := context.Background()
+	backend := NewUserStore()
+	// A user stored before the filter is built is found through it.
+	if err := backend.Create(ctx, User{ID: "old", Name: "Old", Email: "old@example.com"}); err != nil {
+		t.Fatal(err)
+	}
+	next := &countingStore{Store: backend}
+	b, err := NewBloomStore(ctx, next, BloomConfig{ExpectedUsers: 100, FalsePositiveRate: 0.001})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Create(ctx, User{ID: "new", Name: "New", Email: "new@example.com"}); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, id := range []string{"old", "new"} {
+		if _, err := b.Get(ctx, id); err != nil {
+			t.Fatalf("Get(%s) = %v", id, err)
+		}
+	}
+	if next.gets != 2 {
+		t.Fatalf("%d Gets reached the store, want 2", next.gets)
+	}
+	if _, err := b.Get(ctx, "never"); !errors.Is(err, ErrUserNotFound) || next.gets != 2 {
+		t.Fatalf("Get of an unknown ID = %v after %d store Gets; want not found without asking", err, next.gets)
+	}
+
+	found, missing, err := b.GetMany(ctx, []string{"never", "new", "nor-this", "old"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(found) != 2 || !reflect.DeepEqual(missing, []string{"never", "nor-this"}) {
+		t.Fatalf("GetMany = %v, missing %v", found, missing)
+	}
+	if !reflect.DeepEqual(next.asked, []string{"new", "old"}) {
+		t.Fatalf("store was asked for %v, want only the stored IDs", next.asked)
+	}
+	if _, _, err := b.GetMany(ctx, []string{"never"}); err != nil || next.getManys != 1 {
+		t.Fatalf("GetMany of unknown IDs = %v after %d store calls; want no call", err, next.getManys)
+	}
+}
+
+func TestBloomStoreRebuild(t *testing.T) {
+	ctx := context.Background()
+	b, err := NewBloomStore(ctx, NewUserStore(), BloomConfig{ExpectedUsers: 10, FalsePositiveRate: 0.01})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 30; i++ {
+		id := fmt.Sprint(i)
+		if err := b.Create(ctx, User{ID: id, Name: "User " + id, Email: id + "@example.com"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i := 0; i < 20; i++ {
+		if err := b.Delete(ctx, fmt.Sprint(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := b.Rebuild(ctx); err != nil {
+		t.Fatal(err)
+	}
+	for i := 20; i < 30; i++ {
+		if _, err := b.Get(ctx, fmt.Sprint(i)); err != nil {
+			t.Fatalf("Get(%d) after rebuild = %v", i, err)
+		}
+	}
+}
@@ -0,0 +1,414 @@
+package store
+
+import (
+	"context"
+	"expvar"
+	"fmt"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+const (
+	breakerClosed   = "closed"
+	breakerOpen     = "open"
+	breakerHalfOpen = "half-open"
+)
+
+// CircuitOpenError is returned without calling the backend while the
+// breaker is open.
+type CircuitOpenError struct {
+	RetryAfter time.Duration
+}
+
+func (e *CircuitOpenError) Error() string {
+	return fmt.Sprintf("store circuit open, retry after %s", e.RetryAfter)
+}
+
+type BreakerConfig struct {
+	Threshold    int
+	Cooldown     time.Duration
+	Retries      int
+	RetryBackoff time.Duration
+}
+
+var breakerMetrics = expvar.NewMap("store_breaker")
+
+// CircuitBreaker opens after Threshold consecutive failures, rejects calls
+// for Cooldown, then lets a single probe through to decide whether to
+// close again.
+type CircuitBreaker struct {
+	mu        sync.Mutex
+	state     string
+	failures  int
+	openedAt  time.Time
+	probing   bool
+	threshold int
+	cooldown  time.Duration
+}
+
+func NewCircuitBreaker(threshold int, cooldown time.Duration) *CircuitBreaker {
+	return &CircuitBreaker{state: breakerClosed, threshold: threshold, cooldown: cooldown}
+}
+
+func (b *CircuitBreaker) State() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.state
+}
+
+// Allow reports whether a call may go to the backend.
+func (b *CircuitBreaker) Allow() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	switch b.state {
+	case breakerOpen:
+		remaining := b.cooldown - time.Since(b.openedAt)
+		if remaining > 0 {
+			breakerMetrics.Add("rejected", 1)
+			return &CircuitOpenError{RetryAfter: remaining}
+		}
+		b.state = breakerHalfOpen
+		b.probing = true
+		return nil
+	case breakerHalfOpen:
+		if b.probing {
+			breakerMetrics.Add("rejected", 1)
+			return &CircuitOpenError{RetryAfter: b.cooldown}
+		}
+		b.probing = true
+	}
+	return nil
+}
+
+// Record feeds the outcome of an allowed call back into the breaker.
+func (b *CircuitBreaker) Record(failed bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.probing = false
+	if !failed {
+		b.state = breakerClosed
+		b.failures = 0
+		return
+	}
+
+	breakerMetrics.Add("failures", 1)
+	b.failures++
+	if b.state == breakerHalfOpen || b.failures >= b.threshold {
+		if b.state != breakerOpen {
+			breakerMetrics.Add("opened", 1)
+		}
+		b.state = breakerOpen
+		b.openedAt = time.Now()
+		b.failures = 0
+	}
+}
+
+// BreakerStore guards a Store with a circuit breaker. Reads are retried
+// with jittered backoff; writes are not, since a failed write may still
+// have been applied.
+type BreakerStore struct {
+	next    Store
+	breaker *CircuitBreaker
+	cfg     BreakerConfig
+}
+
+func NewBreakerStore(next Store, cfg BreakerConfig) *BreakerStore {
+	b := &BreakerStore{next: next, breaker: NewCircuitBreaker(cfg.Threshold, cfg.Cooldown), cfg: cfg}
+	breakerMetrics.Set("state", expvar.Func(func() any { return b.breaker.State() }))
+	return b
+}
+
+// do runs fn through the breaker. Cancelled or expired contexts are the
+// caller's doing, so they neither count as failures nor get retried.
+func (b *BreakerStore) do(ctx context.Context, retry bool, fn func() error) error {
+	attempts := 1
+	if retry {
+		attempts += b.cfg.Retries
+	}
+	var err error
+	for attempt := 0; attempt < attempts; attempt++ {
+		if attempt > 0 {
+			breakerMetrics.Add("retries", 1)
+			timer := time.NewTimer(jitter(b.cfg.RetryBackoff << (attempt - 1)))
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+				return ctx.Err()
+			case <-timer.C:
+			}
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err = b.breaker.Allow(); err != nil {
+			return err
+		}
+		err = fn()
+		failed := err != nil && !IsDomainError(err) && ctx.Err() == nil
+		b.breaker.Record(failed)
+		if !failed {
+			return err
+		}
+	}
+	return err
+}
+
+// jitter returns a random duration between d/2 and 3d/2.
+func jitter(d time.Duration) time.Duration {
+	if d <= 0 {
+		return 0
+	}
+	return d/2 + time.Duration(rand.Int63n(int64(d)))
+}
+
+func (b *BreakerStore) Create(ctx context.Context, user User) error {
+	return b.do(ctx, false, func() error { return b.next.Create(ctx, user) })
+}
+
+func (b *BreakerStore) Get(ctx context.Context, id string) (User, error) {
+	var user User
+	err := b.do(ctx, true, func() (err error) {
+		user, err = b.next.Get(ctx, id)
+		return err
+	})
+	return user, err
+}
+
+func (b *BreakerStore) GetMany(ctx context.Context, ids []string) ([]User, []string, error) {
+	var users []User
+	var missing []string
+	err := b.do(ctx, true, func() (err error) {
+		users, missing, err = b.next.GetMany(ctx, ids)
+		return err
+	})
+	return users, missing, err
+}
+
+func (b *BreakerStore) GetByEmail(ctx context.Context, email string) (User, error) {
+	var user User
+	err := b.do(ctx, true, func() (err error) {
+		user, err = b.next.GetByEmail(ctx, email)
+		return err
+	})
+	return user, err
+}
+
+func (b *BreakerStore) GetAll(ctx context.Context) ([]User, error) {
+	var users []User
+	err := b.do(ctx, true, func() (err error) {
+		users, err = b.next.GetAll(ctx)
+		return err
+	})
+	return users, err
+}
+
+func (b *BreakerStore) GetByStatus(ctx context.Context, status string) ([]User, error) {
+	var users []User
+	err := b.do(ctx, true, func() (err error) {
+		users, err = b.next.GetByStatus(ctx, status)
+		return err
+	})
+	return users, err
+}
+
+// ForEach is not retried, since fn may already have seen some users.
+// Errors returned by fn are passed through without counting against the
+// breaker.
+func (b *BreakerStore) ForEach(ctx context.Context, fn func(User) error) error {
+	var fnErr error
+	err := b.do(ctx, false, func() error {
+		err := b.next.ForEach(ctx, func(user User) error {
+			fnErr = fn(user)
+			return fnErr
+		})
+		if fnErr != nil {
+			return nil
+		}
+		return err
+	})
+	if fnErr != nil {
+		return fnErr
+	}
+	return err
+}
+
+func (b *BreakerStore) Stats(ctx context.Context) (Stats, error) {
+	var stats Stats
+	err := b.do(ctx, true, func() (err error) {
+		stats, err = b.next.Stats(ctx)
+		return err
+	})
+	return stats, err
+}
+
+func (b *BreakerStore) Update(ctx context.Context, user User) error {
+	return b.do(ctx, false, func() error { return b.next.Update(ctx, user) })
+}
+
+func (b *BreakerStore) CompareAndSwap(ctx context.Context, old, user User) (bool, error) {
+	var swapped bool
+	err := b.do(ctx, false, func() (err error) {
+		swapped, err = b.next.CompareAndSwap(ctx, old, user)
+		return err
+	})
+	return swapped, err
+}
+
+func (b *BreakerStore) Upsert(ctx context.Context, user User) (bool, error) {
+	var created bool
+	err := b.do(ctx, false, func() (err error) {
+		created, err = b.next.Upsert(ctx, user)
+		return err
+	})
+	return created, err
+}
+
+func (b *BreakerStore) Transition(ctx context.Context, id, status string) (User, error) {
+	var user User
+	err := b.do(ctx, false, func() (err error) {
+		user, err = b.next.Transition(ctx, id, status)
+		return err
+	})
+	return user, err
+}
+
+func (b *BreakerStore) Merge(ctx context.Context, sourceID, targetID string) (User, error) {
+	var user User
+	err := b.do(ctx, false, func() (err error) {
+		user, err = b.next.Merge(ctx, sourceID, targetID)
+		return err
+	})
+	return user, err
+}
+
+func (b *BreakerStore) Delete(ctx context.Context, id string) error {
+	return b.do(ctx, false, func() error { return b.next.Delete(ctx, id) })
+}
+
+func (b *BreakerStore) Forget(ctx context.Context, id, requestedBy string) error {
+	return b.do(ctx, false, func() error { return b.next.Forget(ctx, id, requestedBy) })
+}
+
+func (b *BreakerStore) RecordLoginAttempt(ctx context.Context, attempt LoginAttempt, policy LoginPolicy) error {
+	return b.do(ctx, false, func() error { return b.next.RecordLoginAttempt(ctx, attempt, policy) })
+}
+
+func (b *BreakerStore) IPBlocked(ctx context.Context, ip string, policy LoginPolicy) (bool, error) {
+	var blocked bool
+	err := b.do(ctx, true, func() (err error) {
+		blocked, err = b.next.IPBlocked(ctx, ip, policy)
+		return err
+	})
+	return blocked, err
+}
+
+func (b *BreakerStore) ReleaseExpiredLock(ctx context.Context, id string, now time.Time) (bool, error) {
+	var released bool
+	err := b.do(ctx, false, func() (err error) {
+		released, err = b.next.ReleaseExpiredLock(ctx, id, now)
+		return err
+	})
+	return released, err
+}
+
+func (b *BreakerStore) LoginHistory(ctx context.Context, id string) ([]LoginAttempt, error) {
+	var history []LoginAttempt
+	err := b.do(ctx, true, func() (err error) {
+		history, err = b.next.LoginHistory(ctx, id)
+		return err
+	})
+	return history, err
+}
+
+func (b *BreakerStore) SetupTwoFactor(ctx context.Context, id string, tf TwoFactor) error {
+	return b.do(ctx, false, func() error { return b.next.SetupTwoFactor(ctx, id, tf) })
+}
+
+func (b *BreakerStore) TwoFactorEnabled(ctx context.Context, id string) (bool, error) {
+	var enabled bool
+	err := b.do(ctx, true, func() (err error) {
+		enabled, err = b.next.TwoFactorEnabled(ctx, id)
+		return err
+	})
+	return enabled, err
+}
+
+func (b *BreakerStore) VerifyTwoFactor(ctx context.Context, id, code string, allowRecovery bool, now time.Time) error {
+	return b.do(ctx, false, func() error { return b.next.VerifyTwoFactor(ctx, id, code, allowRecovery, now) })
+}
+
+func (b *BreakerStore) GetPreferences(ctx context.Context, id string) (map[string]any, error) {
+	var values map[string]any
+	err := b.do(ctx, true, func() (err error) {
+		values, err = b.next.GetPreferences(ctx, id)
+		return err
+	})
+	return values, err
+}
+
+func (b *BreakerStore) SetPreferences(ctx context.Context, id string, values map[string]any) error {
+	return b.do(ctx, false, func() error { return b.next.SetPreferences(ctx, id, values) })
+}
+
+func (b *BreakerStore) Emit(ctx context.Context, event Event) error {
+	return b.do(ctx, false, func() error { return b.next.Emit(ctx, event) })
+}
+
+func (b *BreakerStore) PendingEvents(ctx context.Context, limit int) ([]Event, error) {
+	var events []Event
+	err := b.do(ctx, true, func() (err error) {
+		events, err = b.next.PendingEvents(ctx, limit)
+		return err
+	})
+	return events, err
+}
+
+func (b *BreakerStore) MarkSent(ctx context.Context, seq uint64, at time.Time) error {
+	return b.do(ctx, true, func() error { return b.next.MarkSent(ctx, seq, at) })
+}
+
+func (b *BreakerStore) EventsForUser(ctx context.Context, id string) ([]Event, error) {
+	var events []Event
+	err := b.do(ctx, true, func() (err error) {
+		events, err = b.next.EventsForUser(ctx, id)
+		return err
+	})
+	return events, err
+}
+
+func (b *BreakerStore) EventsSince(ctx context.Context, seq uint64, limit int) ([]Event, error) {
+	var events []Event
+	err := b.do(ctx, true, func() (err error) {
+		events, err = b.next.EventsSince(ctx, seq, limit)
+		return err
+	})
+	return events, err
+}
+
+func (b *BreakerStore) LastEventSeq(ctx context.Context) (uint64, error) {
+	var seq uint64
+	err := b.do(ctx, true, func() (err error) {
+		seq, err = b.next.LastEventSeq(ctx)
+		return err
+	})
+	return seq, err
+}
+
+func (b *BreakerStore) Dump(ctx context.Context) ([]UserRecord, error) {
+	var records []UserRecord
+	err := b.do(ctx, true, func() (err error) {
+		records, err = b.next.Dump(ctx)
+		return err
+	})
+	return records, err
+}
+
+func (b *BreakerStore) Restore(ctx context.Context, rec UserRecord, mode string) (string, error) {
+	var outcome string
+	err := b.do(ctx, false, func() (err error) {
+		outcome, err = b.next.Restore(ctx, rec, mode)
+		return err
+	})
+	return outcome, err
+}
@@ -0,0 +1,134 @@
+package store
+
+import (
+	"context"
+	"expvar"
+	"sync"
+)
+
+var coalesceMetrics = expvar.NewMap("store_coalesce")
+
+// flight is a read in progress that later identical reads wait on.
+type flight struct {
+	done  chan struct{}
+	value any
+	err   error
+}
+
+// flightGroup runs at most one call per key at a time; callers arriving
+// while it runs share its result.
+type flightGroup struct {
+	mu      sync.Mutex
+	flights map[string]*flight
+}
+
+type freshKey struct{}
+
+// Fresh marks the reads made with the returned context as needing every
+// write that finished before them, so a CoalescingStore runs them on
+// their own instead of joining a read already in flight. Uniqueness
+// checks and reads of a user just written need it.
+func Fresh(ctx context.Context) context.Context {
+	return context.WithValue(ctx, freshKey{}, true)
+}
+
+// do runs fn for the key unless a call for it is already in flight, or
+// runs it alone for a Fresh read. A shared call runs without the
+// caller's cancellation so that one caller giving up doesn't fail the
+// others; each caller still stops waiting when its own context ends.
+func (g *flightGroup) do(ctx context.Context, key string, fn func(ctx context.Context) (any, error)) (any, error) {
+	if fresh, _ := ctx.Value(freshKey{}).(bool); fresh {
+		coalesceMetrics.Add("fresh", 1)
+		return fn(ctx)
+	}
+	g.mu.Lock()
+	f, ok := g.flights[key]
+	if ok {
+		g.mu.Unlock()
+		coalesceMetrics.Add("coalesced", 1)
+	} else {
+		f = &flight{done: make(chan struct{})}
+		if g.flights == nil {
+			g.flights = make(map[string]*flight)
+		}
+		g.flights[key] = f
+		g.mu.Unlock()
+		coalesceMetrics.Add("calls", 1)
+
+		go func() {
+			f.value, f.err = fn(context.WithoutCancel(ctx))
+			g.mu.Lock()
+			delete(g.flights, key)
+			g.mu.Unlock()
+			close(f.done)
+		}()
+	}
+
+	select {
+	case <-f.done:
+		return f.value, f.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// CoalescingStore shares one backend call between identical reads that
+// are in flight at the same time. A read joining a call may therefore miss
+// a write that finished after that call started, unless it is Fresh.
+// Writes pass straight through.
+type CoalescingStore struct {
+	Store
+	group flightGroup
+}
+
+func NewCoalescingStore(next Store) *CoalescingStore {
+	return &CoalescingStore{Store: next}
+}
+
+func (c *CoalescingStore) Get(ctx context.Context, id string) (User, error) {
+	v, err := c.group.do(ctx, "get\x00"+id, func(ctx context.Context) (any, error) {
+		return c.Store.Get(ctx, id)
+	})
+	if err != nil {
+		return User{}, err
+	}
+	return v.(User), nil
+}
+
+func (c *CoalescingStore) GetByEmail(ctx context.Context, email string) (User, error) {
+	v, err := c.group.do(ctx, "email\x00"+email, func(ctx context.Context) (any, error) {
+		return c.Store.GetByEmail(ctx, email)
+	})
+	if err != nil {
+		return User{}, err
+	}
+	return v.(User), nil
+}
+
+func (c *CoalescingStore) GetAll(ctx context.Context) ([]User, error) {
+	v, err := c.group.do(ctx, "all", func(ctx context.Context) (any, error) {
+		return c.Store.GetAll(ctx)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return copyUsers(v.([]User)), nil
+}
+
+func (c *CoalescingStore) GetByStatus(ctx context.Context, status string) ([]User, error) {
+	v, err := c.group.do(ctx, "status\x00"+status, func(ctx context.Context) (any, error) {
+		return c.Store.GetByStatus(ctx, status)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return copyUsers(v.([]User)), nil
+}
+
+// copyUsers gives each caller of a shared list read its own slice, since
+// callers sort and filter their results in place.
+func copyUsers(users []User) []User {
+	out := make([]User, len(users))
+	copy(out, users)
+	return out
+}
@@ -0,0 +1,74 @@
+package store
+
+import (
+	"sort"
+	"strings"
+	"sync"
+)
+
+// emailIndex maps the email keys of stored users to their IDs, so
+// GetByEmail looks a user up instead of scanning every shard. It is
+// shared by the shards and changed by shard.set and shard.remove under
+// the shard's lock; its own lock is never held while taking a shard's.
+type emailIndex struct {
+	mu  sync.RWMutex
+	ids map[string]map[string]struct{}
+}
+
+func newEmailIndex() *emailIndex {
+	return &emailIndex{ids: make(map[string]map[string]struct{})}
+}
+
+// emailKey is what the user is found by: the blind index an
+// EncryptingStore gave the email, or else the lowercased address. A user
+// without an email, or with an encrypted one and no blind index, has
+// none, since no lookup could match it.
+func emailKey(user User) string {
+	if user.EmailIndex != "" {
+		return strings.ToLower(user.EmailIndex)
+	}
+	if encrypted(user.Email) {
+		return ""
+	}
+	return strings.ToLower(user.Email)
+}
+
+func (ix *emailIndex) add(key, id string) {
+	if key == "" {
+		return
+	}
+	ix.mu.Lock()
+	defer ix.mu.Unlock()
+	ids, ok := ix.ids[key]
+	if !ok {
+		ids = make(map[string]struct{}, 1)
+		ix.ids[key] = ids
+	}
+	ids[id] = struct{}{}
+}
+
+func (ix *emailIndex) remove(key, id string) {
+	if key == "" {
+		return
+	}
+	ix.mu.Lock()
+	defer ix.mu.Unlock()
+	if ids, ok := ix.ids[key]; ok {
+		delete(ids, id)
+		if len(ids) == 0 {
+			delete(ix.ids, key)
+		}
+	}
+}
+
+// lookup returns the IDs indexed under the key, in order.
+func (ix *emailIndex) lookup(key string) []string {
+	ix.mu.RLock()
+	list := make([]string, 0, len(ix.ids[key]))
+	for id := range ix.ids[key] {
+		list = append(list, id)
+	}
+	ix.mu.RUnlock()
+	sort.Strings(list)
+	return list
+}
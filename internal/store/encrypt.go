@@ -0,0 +1,429 @@
+package store
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+const (
+	// encryptedPrefix marks values sealed with the key ID and the user's
+	// ID as additional data, so a value copied onto another user fails to
+	// decrypt. legacyPrefix values were sealed with the key ID alone.
+	encryptedPrefix = "enc:v2:"
+	legacyPrefix    = "enc:v1:"
+	indexPrefix     = "idx:v1:"
+)
+
+var ErrUnknownKey = errors.New("unknown encryption key")
+
+// FieldCipher encrypts individual fields with AES-GCM. Values are
+// encrypted with the primary key and carry its ID, so older keys can
+// still decrypt them after a rotation. Each key also has an HMAC key,
+// derived from it, for blind indexes.
+type FieldCipher struct {
+	primary   string
+	ids       []string
+	keys      map[string]cipher.AEAD
+	indexKeys map[string][]byte
+}
+
+// ParseFieldCipher builds a cipher from a comma-separated list of
+// id:base64-key pairs with 16, 24 or 32 byte keys. The first key is used
+// for new writes.
+func ParseFieldCipher(config string) (*FieldCipher, error) {
+	fc := &FieldCipher{keys: make(map[string]cipher.AEAD), indexKeys: make(map[string][]byte)}
+	for _, entry := range strings.Split(config, ",") {
+		id, encoded, ok := strings.Cut(strings.TrimSpace(entry), ":")
+		if !ok || id == "" {
+			return nil, fmt.Errorf("malformed key entry %q", entry)
+		}
+		key, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return nil, fmt.Errorf("key %s: %w", id, err)
+		}
+		block, err := aes.NewCipher(key)
+		if err != nil {
+			return nil, fmt.Errorf("key %s: %w", id, err)
+		}
+		aead, err := cipher.NewGCM(block)
+		if err != nil {
+			return nil, err
+		}
+		if fc.primary == "" {
+			fc.primary = id
+		}
+		if _, ok := fc.keys[id]; !ok {
+			fc.ids = append(fc.ids, id)
+		}
+		fc.keys[id] = aead
+		mac := hmac.New(sha256.New, key)
+		mac.Write([]byte("blind index"))
+		fc.indexKeys[id] = mac.Sum(nil)
+	}
+	return fc, nil
+}
+
+// Encrypt seals a value of the user's with the primary key.
+func (fc *FieldCipher) Encrypt(userID, plaintext string) (string, error) {
+	aead := fc.keys[fc.primary]
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	sealed := aead.Seal(nonce, nonce, []byte(plaintext), []byte(fc.primary+":"+userID))
+	return encryptedPrefix + fc.primary + ":" + base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// Decrypt returns the plaintext of a value of the user's. Values written
+// before encryption was enabled are returned unchanged.
+func (fc *FieldCipher) Decrypt(userID, value string) (string, error) {
+	rest, bound := strings.CutPrefix(value, encryptedPrefix)
+	if !bound {
+		var ok bool
+		if rest, ok = strings.CutPrefix(value, legacyPrefix); !ok {
+			return value, nil
+		}
+	}
+	id, encoded, ok := strings.Cut(rest, ":")
+	if !ok {
+		return "", errors.New("malformed encrypted value")
+	}
+	aead, ok := fc.keys[id]
+	if !ok {
+		return "", fmt.Errorf("%w %q", ErrUnknownKey, id)
+	}
+	sealed, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil || len(sealed) < aead.NonceSize() {
+		return "", errors.New("malformed encrypted value")
+	}
+	additional := id
+	if bound {
+		additional += ":" + userID
+	}
+	nonce, ciphertext := sealed[:aead.NonceSize()], sealed[aead.NonceSize():]
+	plaintext, err := aead.Open(nil, nonce, ciphertext, []byte(additional))
+	if err != nil {
+		return "", err
+	}
+	return string(plaintext), nil
+}
+
+// Index returns the blind index of the value under the primary key: a
+// keyed hash that values equal but for case share, so they can be looked
+// up without being decrypted.
+func (fc *FieldCipher) Index(value string) string {
+	return fc.index(fc.primary, value)
+}
+
+// Indexes returns the blind indexes of the value under every key, the
+// primary key's first, to find values indexed before a rotation.
+func (fc *FieldCipher) Indexes(value string) []string {
+	indexes := []string{fc.Index(value)}
+	for _, id := range fc.ids {
+		if id != fc.primary {
+			indexes = append(indexes, fc.index(id, value))
+		}
+	}
+	return indexes
+}
+
+func (fc *FieldCipher) index(id, value string) string {
+	mac := hmac.New(sha256.New, fc.indexKeys[id])
+	mac.Write([]byte(strings.ToLower(value)))
+	return indexPrefix + id + ":" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Current reports whether the value is already encrypted with the
+// primary key and bound to its user.
+func (fc *FieldCipher) Current(value string) bool {
+	return strings.HasPrefix(value, encryptedPrefix+fc.primary+":")
+}
+
+// encrypted reports whether the value is encrypted in either form.
+func encrypted(value string) bool {
+	return strings.HasPrefix(value, encryptedPrefix) || strings.HasPrefix(value, legacyPrefix)
+}
+
+// EncryptingStore encrypts PII fields on the way into the wrapped Store
+// and decrypts them on the way out.
+type EncryptingStore struct {
+	Store
+	cipher *FieldCipher
+}
+
+func NewEncryptingStore(next Store, cipher *FieldCipher) *EncryptingStore {
+	return &EncryptingStore{Store: next, cipher: cipher}
+}
+
+func (e *EncryptingStore) encrypt(user User) (User, error) {
+	email, err := e.cipher.Encrypt(user.ID, user.Email)
+	if err != nil {
+		return User{}, err
+	}
+	user.EmailIndex = ""
+	if user.Email != "" {
+		user.EmailIndex = e.cipher.Index(user.Email)
+	}
+	user.Email = email
+	if user.Phone != "" {
+		if user.Phone, err = e.cipher.Encrypt(user.ID, user.Phone); err != nil {
+			return User{}, err
+		}
+	}
+	return user, nil
+}
+
+func (e *EncryptingStore) decrypt(user User) (User, error) {
+	email, err := e.cipher.Decrypt(user.ID, user.Email)
+	if err != nil {
+		return User{}, fmt.Errorf("decrypt user %s: %w", user.ID, err)
+	}
+	user.Email, user.EmailIndex = email, ""
+	if user.Phone, err = e.cipher.Decrypt(user.ID, user.Phone); err != nil {
+		return User{}, fmt.Errorf("decrypt user %s: %w", user.ID, err)
+	}
+	return user, nil
+}
+
+func (e *EncryptingStore) decryptAll(users []User) ([]User, error) {
+	for i := range users {
+		user, err := e.decrypt(users[i])
+		if err != nil {
+			return nil, err
+		}
+		users[i] = user
+	}
+	return users, nil
+}
+
+func (e *EncryptingStore) Create(ctx context.Context, user User) error {
+	user, err := e.encrypt(user)
+	if err != nil {
+		return err
+	}
+	return e.Store.Create(ctx, user)
+}
+
+func (e *EncryptingStore) Update(ctx context.Context, user User) error {
+	user, err := e.encrypt(user)
+	if err != nil {
+		return err
+	}
+	return e.Store.Update(ctx, user)
+}
+
+func (e *EncryptingStore) Upsert(ctx context.Context, user User) (bool, error) {
+	user, err := e.encrypt(user)
+	if err != nil {
+		return false, err
+	}
+	return e.Store.Upsert(ctx, user)
+}
+
+func (e *EncryptingStore) Get(ctx context.Context, id string) (User, error) {
+	user, err := e.Store.Get(ctx, id)
+	if err != nil {
+		return User{}, err
+	}
+	return e.decrypt(user)
+}
+
+func (e *EncryptingStore) GetMany(ctx context.Context, ids []string) ([]User, []string, error) {
+	users, missing, err := e.Store.GetMany(ctx, ids)
+	if err != nil {
+		return nil, nil, err
+	}
+	users, err = e.decryptAll(users)
+	return users, missing, err
+}
+
+// GetByEmail looks the user up by the email's blind index under each
+// key, since randomized ciphertexts can't be matched by the wrapped store,
+// and then by the plain address, for users written before encryption was
+// enabled.
+func (e *EncryptingStore) GetByEmail(ctx context.Context, email string) (User, error) {
+	if email == "" {
+		return User{}, ErrUserNotFound
+	}
+	for _, index := range append(e.cipher.Indexes(email), email) {
+		user, err := e.Store.GetByEmail(ctx, index)
+		if errors.Is(err, ErrUserNotFound) {
+			continue
+		}
+		if err != nil {
+			return User{}, err
+		}
+		if user, err = e.decrypt(user); err != nil || strings.EqualFold(user.Email, email) {
+			return user, err
+		}
+	}
+	return User{}, ErrUserNotFound
+}
+
+func (e *EncryptingStore) GetAll(ctx context.Context) ([]User, error) {
+	users, err := e.Store.GetAll(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return e.decryptAll(users)
+}
+
+func (e *EncryptingStore) GetByStatus(ctx context.Context, status string) ([]User, error) {
+	users, err := e.Store.GetByStatus(ctx, status)
+	if err != nil {
+		return nil, err
+	}
+	return e.decryptAll(users)
+}
+
+func (e *EncryptingStore) ForEach(ctx context.Context, fn func(User) error) error {
+	return e.Store.ForEach(ctx, func(user User) error {
+		user, err := e.decrypt(user)
+		if err != nil {
+			return err
+		}
+		return fn(user)
+	})
+}
+
+func (e *EncryptingStore) Transition(ctx context.Context, id, status string) (User, error) {
+	user, err := e.Store.Transition(ctx, id, status)
+	if err != nil {
+		return User{}, err
+	}
+	return e.decrypt(user)
+}
+
+// Merge binds to the target the values the wrapped store copied over
+// from the source, which are still bound to the source's ID, swapping
+// the target again if it changes in between.
+func (e *EncryptingStore) Merge(ctx context.Context, sourceID, targetID string) (User, error) {
+	stored, err := e.Store.Merge(ctx, sourceID, targetID)
+	if err != nil {
+		return User{}, err
+	}
+	for {
+		user, moved, err := e.decryptMoved(stored, sourceID)
+		if err != nil || !moved {
+			return user, err
+		}
+		rebound, err := e.encrypt(user)
+		if err != nil {
+			return User{}, err
+		}
+		swapped, err := e.Store.CompareAndSwap(ctx, stored, rebound)
+		if swapped || errors.Is(err, ErrUserNotFound) {
+			return user, nil
+		}
+		if err != nil {
+			return User{}, err
+		}
+		if stored, err = e.Store.Get(Fresh(ctx), targetID); err != nil {
+			return User{}, err
+		}
+	}
+}
+
+// decryptMoved decrypts a user whose values may still be bound to the
+// user they were moved from, reporting whether any were.
+func (e *EncryptingStore) decryptMoved(user User, fromID string) (User, bool, error) {
+	moved := false
+	for _, field := range []*string{&user.Email, &user.Phone} {
+		value, err := e.cipher.Decrypt(user.ID, *field)
+		if err != nil {
+			if value, err = e.cipher.Decrypt(fromID, *field); err != nil {
+				return User{}, false, fmt.Errorf("decrypt user %s: %w", user.ID, err)
+			}
+			moved = true
+		}
+		*field = value
+	}
+	user.EmailIndex = ""
+	return user, moved, nil
+}
+
+// CompareAndSwap compares old with the stored user decrypted, and swaps
+// in user encrypted if the stored record is still the one compared.
+func (e *EncryptingStore) CompareAndSwap(ctx context.Context, old, user User) (bool, error) {
+	stored, err := e.Store.Get(Fresh(ctx), old.ID)
+	if err != nil {
+		return false, err
+	}
+	current, err := e.decrypt(stored)
+	if err != nil {
+		return false, err
+	}
+	if !reflect.DeepEqual(current, old) {
+		return false, nil
+	}
+	if user, err = e.encrypt(user); err != nil {
+		return false, err
+	}
+	return e.Store.CompareAndSwap(ctx, stored, user)
+}
+
+// Reencrypt rewrites every stored value not yet encrypted with the
+// primary key and bound to its user, including plaintext written before
+// encryption was enabled, and returns how many users it changed. A user
+// is only swapped if its record hasn't changed since it was read, so
+// concurrent writes, which encrypt under the primary key anyway, are
+// never undone, and no events are recorded since the users stay the
+// same.
+func (e *EncryptingStore) Reencrypt(ctx context.Context) (int, error) {
+	count := 0
+	err := e.Store.ForEach(ctx, func(stored User) error {
+		if e.cipher.Current(stored.Email) && (stored.Phone == "" || e.cipher.Current(stored.Phone)) {
+			return nil
+		}
+		user, err := e.decrypt(stored)
+		if err != nil {
+			return err
+		}
+		if user, err = e.encrypt(user); err != nil {
+			return err
+		}
+		swapped, err := e.Store.CompareAndSwap(ctx, stored, user)
+		if err != nil && !errors.Is(err, ErrUserNotFound) {
+			return err
+		}
+		if swapped {
+			count++
+		}
+		return nil
+	})
+	return count, err
+}
+
+// Dump decrypts the records, so a backup can be restored under other
+// keys or without encryption.
+func (e *EncryptingStore) Dump(ctx context.Context) ([]UserRecord, error) {
+	records, err := e.Store.Dump(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for i := range records {
+		if records[i].User, err = e.decrypt(records[i].User); err != nil {
+			return nil, err
+		}
+	}
+	return records, nil
+}
+
+func (e *EncryptingStore) Restore(ctx context.Context, rec UserRecord, mode string) (string, error) {
+	user, err := e.encrypt(rec.User)
+	if err != nil {
+		return "", err
+	}
+	rec.User = user
+	return e.Store.Restore(ctx, rec, mode)
+}
@@ -0,0 +1,68 @@
+package store
+
+import (
+	"time"
+
+	"user-service/internal/trace"
+)
+
+const (
+	EventUserCreated   = "user.created"
+	EventUserUpdated   = "user.updated"
+	EventUserDeleted   = "user.deleted"
+	EventUserForgotten = "user.forgotten"
+	EventUserSuspended = "user.suspended"
+	EventUserActivated = "user.activated"
+	EventUserLocked    = "user.locked"
+	EventUserMerged    = "user.merged"
+
+	EventPreferencesUpdated = "user.preferences_updated"
+
+	EventLoginSucceeded = "security.login_succeeded"
+	EventLoginFailed    = "security.login_failed"
+	EventLoginIPBlocked = "security.ip_blocked"
+
+	EventTwoFactorEnabled = "security.2fa_enabled"
+)
+
+// Event is a change recorded in the outbox. RequestID, TraceParent and
+// TraceState identify the request that caused it, if any.
+type Event struct {
+	Seq         uint64            `json:"seq"`
+	Type        string            `json:"type"`
+	UserID      string            `json:"user_id,omitempty"`
+	Time        time.Time         `json:"time"`
+	Data        map[string]string `json:"data,omitempty"`
+	RequestID   string            `json:"request_id,omitempty"`
+	TraceParent string            `json:"traceparent,omitempty"`
+	TraceState  string            `json:"tracestate,omitempty"`
+}
+
+func NewEvent(eventType, userID string) Event {
+	return Event{Type: eventType, UserID: userID, Time: time.Now().UTC()}
+}
+
+// With returns a copy of the event with the key set in its data.
+func (e Event) With(key, value string) Event {
+	data := make(map[string]string, len(e.Data)+1)
+	for k, v := range e.Data {
+		data[k] = v
+	}
+	data[key] = value
+	e.Data = data
+	return e
+}
+
+// Trace returns the trace of the request that caused the event.
+func (e Event) Trace() trace.Context {
+	return trace.Context{RequestID: e.RequestID, Parent: e.TraceParent, State: e.TraceState}
+}
+
+// WithTrace returns a copy of the event carrying the trace, unless it
+// already carries one.
+func (e Event) WithTrace(t trace.Context) Event {
+	if e.RequestID == "" && e.TraceParent == "" {
+		e.RequestID, e.TraceParent, e.TraceState = t.RequestID, t.Parent, t.State
+	}
+	return e
+}
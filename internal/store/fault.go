@@ -0,0 +1,245 @@
+package store
+
+import (
+	"context"
+	"time"
+
+	"user-service/internal/fault"
+)
+
+// FaultStore injects the store faults of a fault.Injector before calls
+// reach next: latency delays the call and an error fault fails it with
+// fault.ErrInjected without calling next. It sits under the circuit
+// breaker, so injected errors are retried and counted like real ones.
+type FaultStore struct {
+	next   Store
+	faults *fault.Injector
+}
+
+func NewFaultStore(next Store, faults *fault.Injector) *FaultStore {
+	return &FaultStore{next: next, faults: faults}
+}
+
+func (f *FaultStore) Create(ctx context.Context, user User) error {
+	if err := f.faults.Store(ctx, "Create"); err != nil {
+		return err
+	}
+	return f.next.Create(ctx, user)
+}
+
+func (f *FaultStore) Get(ctx context.Context, id string) (User, error) {
+	if err := f.faults.Store(ctx, "Get"); err != nil {
+		return User{}, err
+	}
+	return f.next.Get(ctx, id)
+}
+
+func (f *FaultStore) GetMany(ctx context.Context, ids []string) ([]User, []string, error) {
+	if err := f.faults.Store(ctx, "GetMany"); err != nil {
+		return nil, nil, err
+	}
+	return f.next.GetMany(ctx, ids)
+}
+
+func (f *FaultStore) GetByEmail(ctx context.Context, email string) (User, error) {
+	if err := f.faults.Store(ctx, "GetByEmail"); err != nil {
+		return User{}, err
+	}
+	return f.next.GetByEmail(ctx, email)
+}
+
+func (f *FaultStore) GetAll(ctx context.Context) ([]User, error) {
+	if err := f.faults.Store(ctx, "GetAll"); err != nil {
+		return nil, err
+	}
+	return f.next.GetAll(ctx)
+}
+
+func (f *FaultStore) GetByStatus(ctx context.Context, status string) ([]User, error) {
+	if err := f.faults.Store(ctx, "GetByStatus"); err != nil {
+		return nil, err
+	}
+	return f.next.GetByStatus(ctx, status)
+}
+
+func (f *FaultStore) ForEach(ctx context.Context, fn func(User) error) error {
+	if err := f.faults.Store(ctx, "ForEach"); err != nil {
+		return err
+	}
+	return f.next.ForEach(ctx, fn)
+}
+
+func (f *FaultStore) Stats(ctx context.Context) (Stats, error) {
+	if err := f.faults.Store(ctx, "Stats"); err != nil {
+		return Stats{}, err
+	}
+	return f.next.Stats(ctx)
+}
+
+func (f *FaultStore) Update(ctx context.Context, user User) error {
+	if err := f.faults.Store(ctx, "Update"); err != nil {
+		return err
+	}
+	return f.next.Update(ctx, user)
+}
+
+func (f *FaultStore) CompareAndSwap(ctx context.Context, old, user User) (bool, error) {
+	if err := f.faults.Store(ctx, "CompareAndSwap"); err != nil {
+		return false, err
+	}
+	return f.next.CompareAndSwap(ctx, old, user)
+}
+
+func (f *FaultStore) Upsert(ctx context.Context, user User) (bool, error) {
+	if err := f.faults.Store(ctx, "Upsert"); err != nil {
+		return false, err
+	}
+	return f.next.Upsert(ctx, user)
+}
+
+func (f *FaultStore) Transition(ctx context.Context, id, status string) (User, error) {
+	if err := f.faults.Store(ctx, "Transition"); err != nil {
+		return User{}, err
+	}
+	return f.next.Transition(ctx, id, status)
+}
+
+func (f *FaultStore) Delete(ctx context.Context, id string) error {
+	if err := f.faults.Store(ctx, "Delete"); err != nil {
+		return err
+	}
+	return f.next.Delete(ctx, id)
+}
+
+func (f *FaultStore) Forget(ctx context.Context, id, requestedBy string) error {
+	if err := f.faults.Store(ctx, "Forget"); err != nil {
+		return err
+	}
+	return f.next.Forget(ctx, id, requestedBy)
+}
+
+func (f *FaultStore) Merge(ctx context.Context, sourceID, targetID string) (User, error) {
+	if err := f.faults.Store(ctx, "Merge"); err != nil {
+		return User{}, err
+	}
+	return f.next.Merge(ctx, sourceID, targetID)
+}
+
+func (f *FaultStore) RecordLoginAttempt(ctx context.Context, attempt LoginAttempt, policy LoginPolicy) error {
+	if err := f.faults.Store(ctx, "RecordLoginAttempt"); err != nil {
+		return err
+	}
+	return f.next.RecordLoginAttempt(ctx, attempt, policy)
+}
+
+func (f *FaultStore) IPBlocked(ctx context.Context, ip string, policy LoginPolicy) (bool, error) {
+	if err := f.faults.Store(ctx, "IPBlocked"); err != nil {
+		return false, err
+	}
+	return f.next.IPBlocked(ctx, ip, policy)
+}
+
+func (f *FaultStore) ReleaseExpiredLock(ctx context.Context, id string, now time.Time) (bool, error) {
+	if err := f.faults.Store(ctx, "ReleaseExpiredLock"); err != nil {
+		return false, err
+	}
+	return f.next.ReleaseExpiredLock(ctx, id, now)
+}
+
+func (f *FaultStore) LoginHistory(ctx context.Context, id string) ([]LoginAttempt, error) {
+	if err := f.faults.Store(ctx, "LoginHistory"); err != nil {
+		return nil, err
+	}
+	return f.next.LoginHistory(ctx, id)
+}
+
+func (f *FaultStore) SetupTwoFactor(ctx context.Context, id string, tf TwoFactor) error {
+	if err := f.faults.Store(ctx, "SetupTwoFactor"); err != nil {
+		return err
+	}
+	return f.next.SetupTwoFactor(ctx, id, tf)
+}
+
+func (f *FaultStore) TwoFactorEnabled(ctx context.Context, id string) (bool, error) {
+	if err := f.faults.Store(ctx, "TwoFactorEnabled"); err != nil {
+		return false, err
+	}
+	return f.next.TwoFactorEnabled(ctx, id)
+}
+
+func (f *FaultStore) VerifyTwoFactor(ctx context.Context, id, code string, allowRecovery bool, now time.Time) error {
+	if err := f.faults.Store(ctx, "VerifyTwoFactor"); err != nil {
+		return err
+	}
+	return f.next.VerifyTwoFactor(ctx, id, code, allowRecovery, now)
+}
+
+func (f *FaultStore) GetPreferences(ctx context.Context, id string) (map[string]any, error) {
+	if err := f.faults.Store(ctx, "GetPreferences"); err != nil {
+		return nil, err
+	}
+	return f.next.GetPreferences(ctx, id)
+}
+
+func (f *FaultStore) SetPreferences(ctx context.Context, id string, values map[string]any) error {
+	if err := f.faults.Store(ctx, "SetPreferences"); err != nil {
+		return err
+	}
+	return f.next.SetPreferences(ctx, id, values)
+}
+
+func (f *FaultStore) Emit(ctx context.Context, event Event) error {
+	if err := f.faults.Store(ctx, "Emit"); err != nil {
+		return err
+	}
+	return f.next.Emit(ctx, event)
+}
+
+func (f *FaultStore) PendingEvents(ctx context.Context, limit int) ([]Event, error) {
+	if err := f.faults.Store(ctx, "PendingEvents"); err != nil {
+		return nil, err
+	}
+	return f.next.PendingEvents(ctx, limit)
+}
+
+func (f *FaultStore) MarkSent(ctx context.Context, seq uint64, at time.Time) error {
+	if err := f.faults.Store(ctx, "MarkSent"); err != nil {
+		return err
+	}
+	return f.next.MarkSent(ctx, seq, at)
+}
+
+func (f *FaultStore) EventsForUser(ctx context.Context, id string) ([]Event, error) {
+	if err := f.faults.Store(ctx, "EventsForUser"); err != nil {
+		return nil, err
+	}
+	return f.next.EventsForUser(ctx, id)
+}
+
+func (f *FaultStore) EventsSince(ctx context.Context, seq uint64, limit int) ([]Event, error) {
+	if err := f.faults.Store(ctx, "EventsSince"); err != nil {
+		return nil, err
+	}
+	return f.next.EventsSince(ctx, seq, limit)
+}
+
+func (f *FaultStore) LastEventSeq(ctx context.Context) (uint64, error) {
+	if err := f.faults.Store(ctx, "LastEventSeq"); err != nil {
+		return 0, err
+	}
+	return f.next.LastEventSeq(ctx)
+}
+
+func (f *FaultStore) Dump(ctx context.Context) ([]UserRecord, error) {
+	if err := f.faults.Store(ctx, "Dump"); err != nil {
+		return nil, err
+	}
+	return f.next.Dump(ctx)
+}
+
+func (f *FaultStore) Restore(ctx context.Context, rec UserRecord, mode string) (string, error) {
+	if err := f.faults.Store(ctx, "Restore"); err != nil {
+		return "", err
+	}
+	return f.next.Restore(ctx, rec, mode)
+}
@@ -0,0 +1,139 @@
+package store
+
+import (
+	"context"
+	"time"
+)
+
+const maxLoginHistory = 50
+
+// LoginPolicy controls when repeated failed logins lock an account or
+// block the calling IP.
+type LoginPolicy struct {
+	MaxFailures     int
+	LockoutDuration time.Duration
+	IPMaxFailures   int
+	IPWindow        time.Duration
+}
+
+type LoginAttempt struct {
+	UserID  string    `json:"user_id,omitempty"`
+	IP      string    `json:"ip"`
+	Time    time.Time `json:"time"`
+	Success bool      `json:"success"`
+	Reason  string    `json:"reason,omitempty"`
+}
+
+// RecordLoginAttempt stores the attempt in the user's history and the
+// IP's failure window. Once a user reaches the policy's consecutive
+// failure limit the account is locked until the lockout expires.
+func (s *UserStore) RecordLoginAttempt(ctx context.Context, attempt LoginAttempt, policy LoginPolicy) error {
+	if !attempt.Success {
+		s.ipMu.Lock()
+		cutoff := attempt.Time.Add(-policy.IPWindow)
+		s.ipFailures[attempt.IP] = append(s.ipFailures[attempt.IP], attempt.Time)
+		s.pruneIP(attempt.IP, cutoff)
+		// IPs that stop failing are never asked about again, so once a
+		// window the whole map is swept.
+		if s.ipSweptAt.Before(cutoff) {
+			for ip := range s.ipFailures {
+				s.pruneIP(ip, cutoff)
+			}
+			s.ipSweptAt = attempt.Time
+		}
+		s.ipMu.Unlock()
+	}
+
+	sh := s.shardFor(attempt.UserID)
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+	user, exists := sh.users[attempt.UserID]
+	if !exists {
+		return nil
+	}
+
+	history := append(sh.loginHistory[user.ID], attempt)
+	if len(history) > maxLoginHistory {
+		history = history[len(history)-maxLoginHistory:]
+	}
+	sh.loginHistory[user.ID] = history
+
+	if attempt.Success {
+		delete(sh.loginFailures, user.ID)
+		return nil
+	}
+
+	if user.Status != StatusActive {
+		return nil
+	}
+	sh.loginFailures[user.ID]++
+	if sh.loginFailures[user.ID] < policy.MaxFailures {
+		return nil
+	}
+	until := attempt.Time.Add(policy.LockoutDuration)
+	user.Status = StatusLocked
+	user.LockedUntil = &until
+	sh.set(user)
+	delete(sh.loginFailures, user.ID)
+	s.appendEvent(ctx, NewEvent(EventUserLocked, user.ID).With("reason", "too many failed logins"))
+	return nil
+}
+
+// IPBlocked reports whether the IP hit the failure limit within the window.
+func (s *UserStore) IPBlocked(ctx context.Context, ip string, policy LoginPolicy) (bool, error) {
+	s.ipMu.Lock()
+	defer s.ipMu.Unlock()
+	return len(s.pruneIP(ip, time.Now().Add(-policy.IPWindow))) >= policy.IPMaxFailures, nil
+}
+
+// pruneIP drops the IP's failures from before cutoff, and the IP itself
+// once none are left. The caller holds ipMu.
+func (s *UserStore) pruneIP(ip string, cutoff time.Time) []time.Time {
+	failures := pruneBefore(s.ipFailures[ip], cutoff)
+	if len(failures) == 0 {
+		delete(s.ipFailures, ip)
+		return nil
+	}
+	s.ipFailures[ip] = failures
+	return failures
+}
+
+// ReleaseExpiredLock reactivates the user if their temporary lockout has
+// passed, reporting whether it did.
+func (s *UserStore) ReleaseExpiredLock(ctx context.Context, id string, now time.Time) (bool, error) {
+	sh := s.shardFor(id)
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+	user, exists := sh.users[id]
+	if !exists {
+		return false, ErrUserNotFound
+	}
+	if user.Status != StatusLocked || user.LockedUntil == nil || now.Before(*user.LockedUntil) {
+		return false, nil
+	}
+	user.Status = StatusActive
+	user.LockedUntil = nil
+	sh.set(user)
+	s.appendEvent(ctx, NewEvent(EventUserActivated, id).With("reason", "lockout expired"))
+	return true, nil
+}
+
+func (s *UserStore) LoginHistory(ctx context.Context, id string) ([]LoginAttempt, error) {
+	sh := s.shardFor(id)
+	sh.mu.RLock()
+	defer sh.mu.RUnlock()
+	if _, exists := sh.users[id]; !exists {
+		return nil, ErrUserNotFound
+	}
+	history := make([]LoginAttempt, len(sh.loginHistory[id]))
+	copy(history, sh.loginHistory[id])
+	return history, nil
+}
+
+func pruneBefore(times []time.Time, cutoff time.Time) []time.Time {
+	i := 0
+	for i < len(times) && times[i].Before(cutoff) {
+		i++
+	}
+	return times[i:]
+}
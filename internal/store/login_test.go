@@ -0,0 +1,35 @@
+package store
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestIPFailuresAreForgotten(t *testing.T) {
+	ctx := context.Background()
+	s := NewUserStore()
+	policy := LoginPolicy{MaxFailures: 5, IPWindow: time.Minute, IPMaxFailures: 3}
+	start := time.Now().Add(-time.Hour)
+	for _, ip := range []string{"10.0.0.1", "10.0.0.2"} {
+		if err := s.RecordLoginAttempt(ctx, LoginAttempt{UserID: "nobody", IP: ip, Time: start}, policy); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Asking about an IP whose failures have all expired drops it.
+	if blocked, err := s.IPBlocked(ctx, "10.0.0.1", policy); err != nil || blocked {
+		t.Fatalf("IPBlocked = %v, %v", blocked, err)
+	}
+	if _, ok := s.ipFailures["10.0.0.1"]; ok {
+		t.Fatal("10.0.0.1 is still tracked after its failures expired")
+	}
+
+	// Another IP failing a window later sweeps the rest.
+	if err := s.RecordLoginAttempt(ctx, LoginAttempt{UserID: "nobody", IP: "10.0.0.3", Time: start.Add(2 * time.Minute)}, policy); err != nil {
+		t.Fatal(err)
+	}
+	if len(s.ipFailures) != 1 || len(s.ipFailures["10.0.0.3"]) != 1 {
+		t.Fatalf("tracking %v; want only 10.0.0.3", s.ipFailures)
+	}
+}
@@ -0,0 +1,65 @@
+package store
+
+import (
+	"context"
+	"errors"
+)
+
+// ErrUserMerged rejects a change to a user that was merged into another,
+// or a merge involving one.
+var ErrUserMerged = errors.New("user has been merged")
+
+// lockPair locks the shards of two users in shard order, so concurrent
+// merges can't deadlock, and returns the function that unlocks them.
+func (s *UserStore) lockPair(a, b string) func() {
+	i, j := s.shardIndex(a), s.shardIndex(b)
+	if i == j {
+		s.shards[i].mu.Lock()
+		return s.shards[i].mu.Unlock
+	}
+	if i > j {
+		i, j = j, i
+	}
+	s.shards[i].mu.Lock()
+	s.shards[j].mu.Lock()
+	return func() {
+		s.shards[j].mu.Unlock()
+		s.shards[i].mu.Unlock()
+	}
+}
+
+// Merge folds the source user into the target the way a merging restore
+// folds in a backup: the target keeps what it has and gains the fields it
+// left empty, the custom fields it lacks, the source's 2FA setup if it has
+// none, and the source's login history. The source is left as a tombstone
+// with the merged status and MergedInto pointing at the target, holding no
+// credentials or personal data. It emits user.merged for the target. The
+// IDs must differ.
+func (s *UserStore) Merge(ctx context.Context, sourceID, targetID string) (User, error) {
+	defer s.lockPair(sourceID, targetID)()
+	ssh, tsh := s.shardFor(sourceID), s.shardFor(targetID)
+	source, ok := ssh.users[sourceID]
+	if !ok {
+		return User{}, ErrUserNotFound
+	}
+	target, ok := tsh.users[targetID]
+	if !ok {
+		return User{}, ErrUserNotFound
+	}
+	if source.MergedInto != "" || target.MergedInto != "" {
+		return User{}, ErrUserMerged
+	}
+
+	rec := mergeRecord(tsh.record(targetID, target), ssh.record(sourceID, source))
+	failures := tsh.loginFailures[targetID]
+	tsh.put(rec)
+	if failures > 0 {
+		tsh.loginFailures[targetID] = failures
+	}
+
+	ssh.remove(sourceID)
+	ssh.set(User{ID: sourceID, Status: StatusMerged, MergedInto: targetID})
+
+	s.appendEvent(ctx, NewEvent(EventUserMerged, targetID).With("source_id", sourceID))
+	return tsh.users[targetID], nil
+}
@@ -0,0 +1,119 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"user-service/internal/trace"
+)
+
+// ErrCursorExpired rejects a read of events that are no longer retained,
+// or from a cursor this store never issued.
+var ErrCursorExpired = errors.New("event cursor expired")
+
+// maxSentOutbox bounds how many already published entries are retained.
+const maxSentOutbox = 10000
+
+// OutboxEntry is an event recorded alongside the mutation that caused it.
+type OutboxEntry struct {
+	Event  Event
+	SentAt *time.Time
+}
+
+// appendEvent records the event in the outbox. Callers hold the shard
+// lock of the user it describes, so events about one user are sequenced
+// in the order their changes were made.
+func (s *UserStore) appendEvent(ctx context.Context, event Event) {
+	s.outboxMu.Lock()
+	defer s.outboxMu.Unlock()
+	s.appendEventLocked(ctx, event)
+}
+
+// appendEventLocked is appendEvent for callers already holding s.outboxMu.
+func (s *UserStore) appendEventLocked(ctx context.Context, event Event) {
+	event = event.WithTrace(trace.From(ctx))
+	s.nextSeq++
+	event.Seq = s.nextSeq
+	s.outbox = append(s.outbox, OutboxEntry{Event: event})
+}
+
+// Emit records an event that isn't tied to a store mutation.
+func (s *UserStore) Emit(ctx context.Context, event Event) error {
+	s.appendEvent(ctx, event)
+	return nil
+}
+
+// PendingEvents returns up to limit unsent events in sequence order.
+func (s *UserStore) PendingEvents(ctx context.Context, limit int) ([]Event, error) {
+	s.outboxMu.RLock()
+	defer s.outboxMu.RUnlock()
+	var pending []Event
+	for _, entry := range s.outbox {
+		if entry.SentAt != nil {
+			continue
+		}
+		pending = append(pending, entry.Event)
+		if len(pending) == limit {
+			break
+		}
+	}
+	return pending, nil
+}
+
+// MarkSent flags the event as published and drops the oldest sent
+// entries beyond the retention limit.
+func (s *UserStore) MarkSent(ctx context.Context, seq uint64, at time.Time) error {
+	s.outboxMu.Lock()
+	defer s.outboxMu.Unlock()
+	for i := range s.outbox {
+		if s.outbox[i].Event.Seq == seq {
+			s.outbox[i].SentAt = &at
+			break
+		}
+	}
+
+	sent := 0
+	for _, entry := range s.outbox {
+		if entry.SentAt != nil {
+			sent++
+		}
+	}
+	drop := 0
+	for drop < len(s.outbox) && sent > maxSentOutbox && s.outbox[drop].SentAt != nil {
+		drop++
+		sent--
+	}
+	s.outbox = s.outbox[drop:]
+	return nil
+}
+
+// EventsSince returns up to limit retained events with sequence numbers
+// after seq, in order. Entries are only ever dropped from the front of
+// the outbox, so the retained sequence numbers are contiguous and a gap
+// between seq and the oldest of them means events were lost to the
+// reader.
+func (s *UserStore) EventsSince(ctx context.Context, seq uint64, limit int) ([]Event, error) {
+	s.outboxMu.RLock()
+	defer s.outboxMu.RUnlock()
+	oldest := s.nextSeq - uint64(len(s.outbox)) + 1
+	if seq+1 < oldest || seq > s.nextSeq {
+		return nil, ErrCursorExpired
+	}
+	entries := s.outbox[seq+1-oldest:]
+	if len(entries) > limit {
+		entries = entries[:limit]
+	}
+	events := make([]Event, len(entries))
+	for i, entry := range entries {
+		events[i] = entry.Event
+	}
+	return events, nil
+}
+
+// LastEventSeq returns the sequence number of the latest event, or 0.
+func (s *UserStore) LastEventSeq(ctx context.Context) (uint64, error) {
+	s.outboxMu.RLock()
+	defer s.outboxMu.RUnlock()
+	return s.nextSeq, nil
+}
@@ -0,0 +1,37 @@
+package store
+
+import (
+	"context"
+	"maps"
+)
+
+// GetPreferences returns the preference values stored for the user. Keys
+// without a stored value are absent; resolving defaults is up to the
+// caller.
+func (s *UserStore) GetPreferences(ctx context.Context, id string) (map[string]any, error) {
+	sh := s.shardFor(id)
+	sh.mu.RLock()
+	defer sh.mu.RUnlock()
+	if _, exists := sh.users[id]; !exists {
+		return nil, ErrUserNotFound
+	}
+	return maps.Clone(sh.preferences[id]), nil
+}
+
+// SetPreferences replaces the user's stored preference values; an empty
+// map clears them.
+func (s *UserStore) SetPreferences(ctx context.Context, id string, values map[string]any) error {
+	sh := s.shardFor(id)
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+	if _, exists := sh.users[id]; !exists {
+		return ErrUserNotFound
+	}
+	if len(values) == 0 {
+		delete(sh.preferences, id)
+	} else {
+		sh.preferences[id] = maps.Clone(values)
+	}
+	s.appendEvent(ctx, NewEvent(EventPreferencesUpdated, id))
+	return nil
+}
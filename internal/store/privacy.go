@@ -0,0 +1,43 @@
+package store
+
+import "context"
+
+// EventsForUser returns the retained outbox events about the user.
+func (s *UserStore) EventsForUser(ctx context.Context, id string) ([]Event, error) {
+	s.outboxMu.RLock()
+	defer s.outboxMu.RUnlock()
+	events := make([]Event, 0)
+	for _, entry := range s.outbox {
+		if entry.Event.UserID == id {
+			events = append(events, entry.Event)
+		}
+	}
+	return events, nil
+}
+
+// Forget erases the user and everything held about them. Retained events
+// keep only their type and time, and a user.forgotten event records the
+// erasure itself.
+func (s *UserStore) Forget(ctx context.Context, id, requestedBy string) error {
+	sh := s.shardFor(id)
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+	if _, exists := sh.users[id]; !exists {
+		return ErrUserNotFound
+	}
+	sh.remove(id)
+
+	s.outboxMu.Lock()
+	defer s.outboxMu.Unlock()
+	for i := range s.outbox {
+		if s.outbox[i].Event.UserID == id {
+			s.outbox[i].Event.Data = nil
+		}
+	}
+	event := NewEvent(EventUserForgotten, id)
+	if requestedBy != "" {
+		event = event.With("requested_by", requestedBy)
+	}
+	s.appendEventLocked(ctx, event)
+	return nil
+}
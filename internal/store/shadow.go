@@ -0,0 +1,436 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"expvar"
+	"log"
+	"math/rand"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+)
+
+var shadowMetrics = expvar.NewMap("store_shadow")
+
+// ShadowConfig controls how a ShadowStore checks its shadow.
+type ShadowConfig struct {
+	// CompareRate is the fraction of reads compared against the shadow.
+	CompareRate float64
+	// QueueSize bounds the comparisons waiting to run; reads beyond it
+	// are not compared.
+	QueueSize int
+}
+
+// ShadowStore serves everything from the primary store and repeats every
+// successful write on a shadow store, so a new backend can run on real
+// traffic before it is trusted. Shadow failures are logged and counted,
+// never returned. Sampled reads are repeated on the shadow in the
+// background and any difference is logged by field name, without values.
+//
+// Writes to one user are serialized across both stores so they apply in
+// the same order. A user written before the shadow was added is copied
+// to it the first time a write finds it missing there.
+type ShadowStore struct {
+	Store
+	shadow  Store
+	cfg     ShadowConfig
+	compare chan func()
+	locks   [64]sync.Mutex
+}
+
+func NewShadowStore(primary, shadow Store, cfg ShadowConfig) *ShadowStore {
+	s := &ShadowStore{Store: primary, shadow: shadow, cfg: cfg, compare: make(chan func(), max(cfg.QueueSize, 1))}
+	go func() {
+		for fn := range s.compare {
+			fn()
+		}
+	}()
+	return s
+}
+
+func (s *ShadowStore) stripe(id string) int {
+	h := uint32(2166136261)
+	for i := 0; i < len(id); i++ {
+		h ^= uint32(id[i])
+		h *= 16777619
+	}
+	return int(h % uint32(len(s.locks)))
+}
+
+func (s *ShadowStore) lock(id string) func() {
+	mu := &s.locks[s.stripe(id)]
+	mu.Lock()
+	return mu.Unlock
+}
+
+// lockPair locks the stripes of two users in order, like
+// UserStore.lockPair.
+func (s *ShadowStore) lockPair(a, b string) func() {
+	i, j := s.stripe(a), s.stripe(b)
+	if i == j {
+		s.locks[i].Lock()
+		return s.locks[i].Unlock
+	}
+	if i > j {
+		i, j = j, i
+	}
+	s.locks[i].Lock()
+	s.locks[j].Lock()
+	return func() {
+		s.locks[j].Unlock()
+		s.locks[i].Unlock()
+	}
+}
+
+// mirror runs a write on the shadow once it has succeeded on the primary.
+// The shadow write outlives the caller's cancellation so the stores
+// don't drift apart when a client hangs up.
+func (s *ShadowStore) mirror(ctx context.Context, op, id string, fn func(ctx context.Context, shadow Store) error) {
+	ctx = context.WithoutCancel(ctx)
+	shadowMetrics.Add("writes", 1)
+	err := fn(ctx, s.shadow)
+	if errors.Is(err, ErrUserNotFound) && id != "" {
+		err = s.backfill(ctx, id, fn)
+	}
+	if err != nil {
+		shadowMetrics.Add("write_errors", 1)
+		log.Printf("shadow: %s %s: %v", op, id, err)
+	}
+}
+
+// backfill copies the user from the primary and retries the write.
+func (s *ShadowStore) backfill(ctx context.Context, id string, fn func(ctx context.Context, shadow Store) error) error {
+	user, err := s.Store.Get(ctx, id)
+	if errors.Is(err, ErrUserNotFound) {
+		// Deleted on the primary; nothing to copy.
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if _, err := s.shadow.Upsert(ctx, user); err != nil {
+		return err
+	}
+	shadowMetrics.Add("backfilled", 1)
+	return fn(ctx, s.shadow)
+}
+
+func (s *ShadowStore) Create(ctx context.Context, user User) error {
+	defer s.lock(user.ID)()
+	if err := s.Store.Create(ctx, user); err != nil {
+		return err
+	}
+	s.mirror(ctx, "create", user.ID, func(ctx context.Context, shadow Store) error {
+		return shadow.Create(ctx, user)
+	})
+	return nil
+}
+
+func (s *ShadowStore) Update(ctx context.Context, user User) error {
+	defer s.lock(user.ID)()
+	if err := s.Store.Update(ctx, user); err != nil {
+		return err
+	}
+	s.mirror(ctx, "update", user.ID, func(ctx context.Context, shadow Store) error {
+		return shadow.Update(ctx, user)
+	})
+	return nil
+}
+
+func (s *ShadowStore) Upsert(ctx context.Context, user User) (bool, error) {
+	defer s.lock(user.ID)()
+	created, err := s.Store.Upsert(ctx, user)
+	if err != nil {
+		return false, err
+	}
+	s.mirror(ctx, "upsert", user.ID, func(ctx context.Context, shadow Store) error {
+		_, err := shadow.Upsert(ctx, user)
+		return err
+	})
+	return created, nil
+}
+
+// CompareAndSwap swaps on the shadow too when it swapped on the primary;
+// a shadow that has drifted keeps its record and shows up in comparisons.
+func (s *ShadowStore) CompareAndSwap(ctx context.Context, old, user User) (bool, error) {
+	defer s.lock(old.ID)()
+	swapped, err := s.Store.CompareAndSwap(ctx, old, user)
+	if err != nil || !swapped {
+		return swapped, err
+	}
+	s.mirror(ctx, "compare_and_swap", old.ID, func(ctx context.Context, shadow Store) error {
+		_, err := shadow.CompareAndSwap(ctx, old, user)
+		return err
+	})
+	return true, nil
+}
+
+func (s *ShadowStore) Transition(ctx context.Context, id, status string) (User, error) {
+	defer s.lock(id)()
+	user, err := s.Store.Transition(ctx, id, status)
+	if err != nil {
+		return User{}, err
+	}
+	s.mirror(ctx, "transition", id, func(ctx context.Context, shadow Store) error {
+		_, err := shadow.Transition(ctx, id, status)
+		return err
+	})
+	return user, nil
+}
+
+func (s *ShadowStore) Delete(ctx context.Context, id string) error {
+	defer s.lock(id)()
+	if err := s.Store.Delete(ctx, id); err != nil {
+		return err
+	}
+	s.mirror(ctx, "delete", "", func(ctx context.Context, shadow Store) error {
+		return ignoreNotFound(shadow.Delete(ctx, id))
+	})
+	return nil
+}
+
+func (s *ShadowStore) Forget(ctx context.Context, id, requestedBy string) error {
+	defer s.lock(id)()
+	if err := s.Store.Forget(ctx, id, requestedBy); err != nil {
+		return err
+	}
+	s.mirror(ctx, "forget", "", func(ctx context.Context, shadow Store) error {
+		return ignoreNotFound(shadow.Forget(ctx, id, requestedBy))
+	})
+	return nil
+}
+
+// Merge backfills both users on the shadow before mirroring, since a
+// merge needs both to be there.
+func (s *ShadowStore) Merge(ctx context.Context, sourceID, targetID string) (User, error) {
+	defer s.lockPair(sourceID, targetID)()
+	source, err := s.Store.Get(ctx, sourceID)
+	if err != nil {
+		return User{}, err
+	}
+	user, err := s.Store.Merge(ctx, sourceID, targetID)
+	if err != nil {
+		return User{}, err
+	}
+	s.mirror(ctx, "merge", targetID, func(ctx context.Context, shadow Store) error {
+		if _, err := shadow.Get(ctx, sourceID); errors.Is(err, ErrUserNotFound) {
+			if _, err := shadow.Upsert(ctx, source); err != nil {
+				return err
+			}
+			shadowMetrics.Add("backfilled", 1)
+		}
+		_, err := shadow.Merge(ctx, sourceID, targetID)
+		return err
+	})
+	return user, nil
+}
+
+func (s *ShadowStore) Restore(ctx context.Context, rec UserRecord, mode string) (string, error) {
+	defer s.lock(rec.User.ID)()
+	outcome, err := s.Store.Restore(ctx, rec, mode)
+	if err != nil || outcome == RestoreSkipped {
+		return outcome, err
+	}
+	s.mirror(ctx, "restore", "", func(ctx context.Context, shadow Store) error {
+		_, err := shadow.Restore(ctx, rec, mode)
+		return err
+	})
+	return outcome, nil
+}
+
+// ignoreNotFound treats removing a user the shadow never had as done.
+func ignoreNotFound(err error) error {
+	if errors.Is(err, ErrUserNotFound) {
+		return nil
+	}
+	return err
+}
+
+func (s *ShadowStore) RecordLoginAttempt(ctx context.Context, attempt LoginAttempt, policy LoginPolicy) error {
+	defer s.lock(attempt.UserID)()
+	if err := s.Store.RecordLoginAttempt(ctx, attempt, policy); err != nil {
+		return err
+	}
+	s.mirror(ctx, "record login attempt", attempt.UserID, func(ctx context.Context, shadow Store) error {
+		return shadow.RecordLoginAttempt(ctx, attempt, policy)
+	})
+	return nil
+}
+
+func (s *ShadowStore) ReleaseExpiredLock(ctx context.Context, id string, now time.Time) (bool, error) {
+	defer s.lock(id)()
+	released, err := s.Store.ReleaseExpiredLock(ctx, id, now)
+	if err != nil || !released {
+		return released, err
+	}
+	s.mirror(ctx, "release lock", id, func(ctx context.Context, shadow Store) error {
+		_, err := shadow.ReleaseExpiredLock(ctx, id, now)
+		return err
+	})
+	return true, nil
+}
+
+func (s *ShadowStore) SetupTwoFactor(ctx context.Context, id string, tf TwoFactor) error {
+	defer s.lock(id)()
+	if err := s.Store.SetupTwoFactor(ctx, id, tf); err != nil {
+		return err
+	}
+	s.mirror(ctx, "setup 2fa", id, func(ctx context.Context, shadow Store) error {
+		return shadow.SetupTwoFactor(ctx, id, tf)
+	})
+	return nil
+}
+
+func (s *ShadowStore) VerifyTwoFactor(ctx context.Context, id, code string, allowRecovery bool, now time.Time) error {
+	defer s.lock(id)()
+	if err := s.Store.VerifyTwoFactor(ctx, id, code, allowRecovery, now); err != nil {
+		return err
+	}
+	s.mirror(ctx, "verify 2fa", id, func(ctx context.Context, shadow Store) error {
+		return shadow.VerifyTwoFactor(ctx, id, code, allowRecovery, now)
+	})
+	return nil
+}
+
+func (s *ShadowStore) SetPreferences(ctx context.Context, id string, values map[string]any) error {
+	defer s.lock(id)()
+	if err := s.Store.SetPreferences(ctx, id, values); err != nil {
+		return err
+	}
+	s.mirror(ctx, "set preferences", id, func(ctx context.Context, shadow Store) error {
+		return shadow.SetPreferences(ctx, id, values)
+	})
+	return nil
+}
+
+// Emit and MarkSent keep the shadow's outbox in step. Sequence numbers
+// only line up when the shadow started out empty alongside the primary.
+func (s *ShadowStore) Emit(ctx context.Context, event Event) error {
+	if err := s.Store.Emit(ctx, event); err != nil {
+		return err
+	}
+	s.mirror(ctx, "emit", "", func(ctx context.Context, shadow Store) error {
+		return shadow.Emit(ctx, event)
+	})
+	return nil
+}
+
+func (s *ShadowStore) MarkSent(ctx context.Context, seq uint64, at time.Time) error {
+	if err := s.Store.MarkSent(ctx, seq, at); err != nil {
+		return err
+	}
+	s.mirror(ctx, "mark sent", "", func(ctx context.Context, shadow Store) error {
+		return shadow.MarkSent(ctx, seq, at)
+	})
+	return nil
+}
+
+// check queues a comparison of a read the primary answered, sampled at
+// the compare rate. It is dropped when the queue is full.
+func (s *ShadowStore) check(fn func()) {
+	if rand.Float64() >= s.cfg.CompareRate {
+		return
+	}
+	select {
+	case s.compare <- fn:
+	default:
+		shadowMetrics.Add("compare_dropped", 1)
+	}
+}
+
+// compareUser compares the user the primary returned with the shadow's.
+// When they differ the primary is read again, and a user it no longer
+// returns the same way changed in between and is not reported.
+func (s *ShadowStore) compareUser(op, key string, served User, servedErr error, read func(ctx context.Context, st Store) (User, error)) {
+	ctx := context.Background()
+	shadowMetrics.Add("reads_compared", 1)
+	user, err := read(ctx, s.shadow)
+	if sameRead(served, servedErr, user, err) {
+		return
+	}
+	again, againErr := read(ctx, s.Store)
+	if !sameRead(served, servedErr, again, againErr) {
+		return
+	}
+	shadowMetrics.Add("divergences", 1)
+	switch {
+	case servedErr != nil || err != nil:
+		log.Printf("shadow: %s %s diverged: primary %v, shadow %v", op, key, errString(servedErr), errString(err))
+	default:
+		log.Printf("shadow: %s %s diverged in %s", op, key, strings.Join(diffFields(served, user), ", "))
+	}
+}
+
+func sameRead(a User, aErr error, b User, bErr error) bool {
+	if aErr != nil || bErr != nil {
+		return errString(aErr) == errString(bErr)
+	}
+	return reflect.DeepEqual(a, b)
+}
+
+func errString(err error) string {
+	if err == nil {
+		return "found"
+	}
+	return err.Error()
+}
+
+// diffFields names the User fields that differ.
+func diffFields(a, b User) []string {
+	va, vb := reflect.ValueOf(a), reflect.ValueOf(b)
+	var fields []string
+	for i := 0; i < va.NumField(); i++ {
+		if !reflect.DeepEqual(va.Field(i).Interface(), vb.Field(i).Interface()) {
+			fields = append(fields, va.Type().Field(i).Name)
+		}
+	}
+	return fields
+}
+
+func (s *ShadowStore) Get(ctx context.Context, id string) (User, error) {
+	user, err := s.Store.Get(ctx, id)
+	if err == nil || errors.Is(err, ErrUserNotFound) {
+		s.check(func() {
+			s.compareUser("get", id, user, err, func(ctx context.Context, st Store) (User, error) {
+				return st.Get(ctx, id)
+			})
+		})
+	}
+	return user, err
+}
+
+func (s *ShadowStore) GetByEmail(ctx context.Context, email string) (User, error) {
+	user, err := s.Store.GetByEmail(ctx, email)
+	if err == nil || errors.Is(err, ErrUserNotFound) {
+		s.check(func() {
+			// The email would put PII in the log; the user ID doesn't.
+			key := user.ID
+			if key == "" {
+				key = "(by email)"
+			}
+			s.compareUser("get by email", key, user, err, func(ctx context.Context, st Store) (User, error) {
+				return st.GetByEmail(ctx, email)
+			})
+		})
+	}
+	return user, err
+}
+
+// GetMany compares each requested user on its own.
+func (s *ShadowStore) GetMany(ctx context.Context, ids []string) ([]User, []string, error) {
+	found, missing, err := s.Store.GetMany(ctx, ids)
+	if err != nil {
+		return found, missing, err
+	}
+	for _, user := range found {
+		user := user
+		s.check(func() {
+			s.compareUser("get", user.ID, user, nil, func(ctx context.Context, st Store) (User, error) {
+				return st.Get(ctx, user.ID)
+			})
+		})
+	}
+	return found, missing, nil
+}
@@ -0,0 +1,95 @@
+package store
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"log"
+	"strings"
+	"testing"
+)
+
+func TestShadowMirrorsAndBackfills(t *testing.T) {
+	ctx := context.Background()
+	primary, shadow := NewUserStore(), NewUserStore()
+	// Written before the shadow was added, so only the primary has it.
+	if err := primary.Create(ctx, User{ID: "old", Name: "Old", Email: "old@example.com"}); err != nil {
+		t.Fatal(err)
+	}
+	s := NewShadowStore(primary, shadow, ShadowConfig{})
+
+	if err := s.Create(ctx, User{ID: "new", Name: "New", Email: "new@example.com"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Update(ctx, User{ID: "old", Name: "Renamed", Email: "old@example.com"}); err != nil {
+		t.Fatal(err)
+	}
+	for _, id := range []string{"new", "old"} {
+		want, err := primary.Get(ctx, id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, err := shadow.Get(ctx, id); err != nil || got.Name != want.Name {
+			t.Fatalf("shadow has %+v, %v; want %+v", got, err, want)
+		}
+	}
+
+	if err := s.Delete(ctx, "new"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := shadow.Get(ctx, "new"); !errors.Is(err, ErrUserNotFound) {
+		t.Fatalf("shadow Get after delete = %v, want %v", err, ErrUserNotFound)
+	}
+}
+
+func TestShadowReportsDivergedReads(t *testing.T) {
+	ctx := context.Background()
+	primary, shadow := NewUserStore(), NewUserStore()
+	s := NewShadowStore(primary, shadow, ShadowConfig{})
+	if err := s.Create(ctx, User{ID: "1", Name: "Jane", Email: "jane@example.com"}); err != nil {
+		t.Fatal(err)
+	}
+	read := func(ctx context.Context, st Store) (User, error) { return st.Get(ctx, "1") }
+
+	var out bytes.Buffer
+	defer log.SetOutput(log.Writer())
+	log.SetOutput(&out)
+
+	served, err := primary.Get(ctx, "1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.compareUser("get", "1", served, nil, read)
+	if out.Len() != 0 {
+		t.Fatalf("matching reads logged %q", out.String())
+	}
+
+	if err := shadow.Update(ctx, User{ID: "1", Name: "Secret Name", Email: "jane@example.com"}); err != nil {
+		t.Fatal(err)
+	}
+	s.compareUser("get", "1", served, nil, read)
+	if line := out.String(); !strings.Contains(line, "get 1 diverged in Name") || strings.Contains(line, "Secret Name") {
+		t.Fatalf("logged %q; want the field named without its value", line)
+	}
+
+	// A read the primary no longer answers the same way changed in
+	// between, so it is not a divergence.
+	out.Reset()
+	if err := primary.Update(ctx, User{ID: "1", Name: "Changed", Email: "jane@example.com"}); err != nil {
+		t.Fatal(err)
+	}
+	s.compareUser("get", "1", served, nil, read)
+	if out.Len() != 0 {
+		t.Fatalf("a read that raced a write logged %q", out.String())
+	}
+
+	out.Reset()
+	if err := shadow.Delete(ctx, "1"); err != nil {
+		t.Fatal(err)
+	}
+	served, _ = primary.Get(ctx, "1")
+	s.compareUser("get", "1", served, nil, read)
+	if line := out.String(); !strings.Contains(line, "primary found, shadow "+ErrUserNotFound.Error()) {
+		t.Fatalf("logged %q; want the missing user reported", line)
+	}
+}
@@ -0,0 +1,111 @@
+package store
+
+import (
+	"context"
+	"time"
+)
+
+// statsRetention is how many days of creation counts a shard keeps.
+const statsRetention = 366
+
+// Stats are counts over the stored users. Total leaves out merged
+// tombstones, which ByStatus counts under the merged status. ByScope
+// counts each user once per scope they hold, and ByTenant the users that
+// belong to a tenant. CreatedPerDay counts users created on each UTC day,
+// keyed 2006-01-02, whether or not they still exist; days with none are
+// absent.
+type Stats struct {
+	Total         int
+	ByStatus      map[string]int
+	ByScope       map[string]int
+	ByTenant      map[string]int
+	CreatedPerDay map[string]int
+}
+
+// shardStats are a shard's share of the Stats, kept up to date as its
+// users change so reading them never scans users.
+type shardStats struct {
+	total    int
+	byStatus map[string]int
+	byScope  map[string]int
+	byTenant map[string]int
+	created  map[string]int
+}
+
+func newShardStats() shardStats {
+	return shardStats{byStatus: make(map[string]int), byScope: make(map[string]int), byTenant: make(map[string]int), created: make(map[string]int)}
+}
+
+// count adds the user to the counts, or takes them away when delta is -1.
+func (st *shardStats) count(user User, delta int) {
+	if user.Status != StatusMerged {
+		st.total += delta
+	}
+	countKey(st.byStatus, user.Status, delta)
+	for _, scope := range user.Scopes {
+		countKey(st.byScope, scope, delta)
+	}
+	if user.Tenant != "" {
+		countKey(st.byTenant, user.Tenant, delta)
+	}
+}
+
+// countCreated counts a user created at the time, dropping days past the
+// retention whenever a new day starts.
+func (st *shardStats) countCreated(at time.Time) {
+	day := at.UTC().Format(time.DateOnly)
+	if _, ok := st.created[day]; !ok {
+		cutoff := at.UTC().AddDate(0, 0, -statsRetention).Format(time.DateOnly)
+		for d := range st.created {
+			if d < cutoff {
+				delete(st.created, d)
+			}
+		}
+	}
+	st.created[day]++
+}
+
+func countKey(counts map[string]int, key string, delta int) {
+	if counts[key] += delta; counts[key] <= 0 {
+		delete(counts, key)
+	}
+}
+
+// set stores the user, keeping the shard's counts and the email index in
+// step. Callers must hold sh.mu.
+func (sh *shard) set(user User) {
+	old, ok := sh.users[user.ID]
+	if ok {
+		sh.stats.count(old, -1)
+	}
+	if key := emailKey(user); !ok || emailKey(old) != key {
+		sh.emails.remove(emailKey(old), user.ID)
+		sh.emails.add(key, user.ID)
+	}
+	sh.users[user.ID] = user
+	sh.stats.count(user, 1)
+}
+
+// Stats adds up the shards' counts, each read under its own lock, so the
+// result is not a snapshot across shards.
+func (s *UserStore) Stats(ctx context.Context) (Stats, error) {
+	stats := Stats{ByStatus: make(map[string]int), ByScope: make(map[string]int), ByTenant: make(map[string]int), CreatedPerDay: make(map[string]int)}
+	for _, sh := range s.shards {
+		sh.mu.RLock()
+		stats.Total += sh.stats.total
+		for status, n := range sh.stats.byStatus {
+			stats.ByStatus[status] += n
+		}
+		for scope, n := range sh.stats.byScope {
+			stats.ByScope[scope] += n
+		}
+		for tenant, n := range sh.stats.byTenant {
+			stats.ByTenant[tenant] += n
+		}
+		for day, n := range sh.stats.created {
+			stats.CreatedPerDay[day] += n
+		}
+		sh.mu.RUnlock()
+	}
+	return stats, nil
+}
@@ -0,0 +1,411 @@
+// Package store defines the persistence interface behind the service and
+// its in-memory implementation and decorators.
+package store
+
+import (
+	"context"
+	"errors"
+	"maps"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	ErrUserNotFound      = errors.New("user not found")
+	ErrUserExists        = errors.New("user already exists")
+	ErrInvalidTransition = errors.New("invalid status transition")
+)
+
+// Store is the persistence backend behind the handlers. Every method
+// takes the request context so backends can honor cancellation and
+// deadlines.
+type Store interface {
+	Create(ctx context.Context, user User) error
+	Get(ctx context.Context, id string) (User, error)
+	GetMany(ctx context.Context, ids []string) ([]User, []string, error)
+	GetByEmail(ctx context.Context, email string) (User, error)
+	GetAll(ctx context.Context) ([]User, error)
+	GetByStatus(ctx context.Context, status string) ([]User, error)
+	ForEach(ctx context.Context, fn func(User) error) error
+	Stats(ctx context.Context) (Stats, error)
+	Update(ctx context.Context, user User) error
+	Upsert(ctx context.Context, user User) (bool, error)
+	CompareAndSwap(ctx context.Context, old, user User) (bool, error)
+	Transition(ctx context.Context, id, status string) (User, error)
+	Delete(ctx context.Context, id string) error
+	Forget(ctx context.Context, id, requestedBy string) error
+	Merge(ctx context.Context, sourceID, targetID string) (User, error)
+
+	RecordLoginAttempt(ctx context.Context, attempt LoginAttempt, policy LoginPolicy) error
+	IPBlocked(ctx context.Context, ip string, policy LoginPolicy) (bool, error)
+	ReleaseExpiredLock(ctx context.Context, id string, now time.Time) (bool, error)
+	LoginHistory(ctx context.Context, id string) ([]LoginAttempt, error)
+
+	SetupTwoFactor(ctx context.Context, id string, tf TwoFactor) error
+	TwoFactorEnabled(ctx context.Context, id string) (bool, error)
+	VerifyTwoFactor(ctx context.Context, id, code string, allowRecovery bool, now time.Time) error
+
+	GetPreferences(ctx context.Context, id string) (map[string]any, error)
+	SetPreferences(ctx context.Context, id string, values map[string]any) error
+
+	Emit(ctx context.Context, event Event) error
+	PendingEvents(ctx context.Context, limit int) ([]Event, error)
+	MarkSent(ctx context.Context, seq uint64, at time.Time) error
+	EventsForUser(ctx context.Context, id string) ([]Event, error)
+	EventsSince(ctx context.Context, seq uint64, limit int) ([]Event, error)
+	LastEventSeq(ctx context.Context) (uint64, error)
+
+	Dump(ctx context.Context) ([]UserRecord, error)
+	Restore(ctx context.Context, rec UserRecord, mode string) (string, error)
+}
+
+// domainErrors are the expected outcomes of store calls, as opposed to
+// backend failures.
+var domainErrors = []error{
+	ErrUserNotFound,
+	ErrUserExists,
+	ErrInvalidTransition,
+	ErrTwoFactorEnabled,
+	ErrTwoFactorNotSetUp,
+	ErrInvalidTwoFactorCode,
+	ErrCursorExpired,
+	ErrUnknownRestoreMode,
+	ErrUserMerged,
+}
+
+// IsDomainError reports whether err is an expected outcome rather than a
+// backend failure.
+func IsDomainError(err error) bool {
+	for _, target := range domainErrors {
+		if errors.Is(err, target) {
+			return true
+		}
+	}
+	return false
+}
+
+// DefaultShards is how many shards NewUserStore splits users across.
+const DefaultShards = 32
+
+// UserStore is the in-memory Store. Users and everything held about them
+// are split across shards by ID, each with its own lock, so writes to
+// different users don't contend. The email index, the outbox and the IP
+// failure windows are shared and guarded separately.
+type UserStore struct {
+	shards []*shard
+	emails *emailIndex
+
+	ipMu       sync.Mutex
+	ipFailures map[string][]time.Time
+	ipSweptAt  time.Time
+
+	outboxMu sync.RWMutex
+	outbox   []OutboxEntry
+	nextSeq  uint64
+}
+
+// shard holds the users whose IDs hash to it.
+type shard struct {
+	mu            sync.RWMutex
+	users         map[string]User
+	loginHistory  map[string][]LoginAttempt
+	loginFailures map[string]int
+	twoFactor     map[string]TwoFactor
+	preferences   map[string]map[string]any
+	stats         shardStats
+	emails        *emailIndex
+}
+
+func NewUserStore() *UserStore {
+	return NewShardedUserStore(DefaultShards)
+}
+
+// NewShardedUserStore returns a UserStore with the given number of
+// shards; one shard behaves like a single store-wide lock.
+func NewShardedUserStore(shards int) *UserStore {
+	if shards < 1 {
+		shards = 1
+	}
+	s := &UserStore{
+		shards:     make([]*shard, shards),
+		emails:     newEmailIndex(),
+		ipFailures: make(map[string][]time.Time),
+	}
+	for i := range s.shards {
+		s.shards[i] = &shard{
+			users:         make(map[string]User),
+			loginHistory:  make(map[string][]LoginAttempt),
+			loginFailures: make(map[string]int),
+			twoFactor:     make(map[string]TwoFactor),
+			preferences:   make(map[string]map[string]any),
+			stats:         newShardStats(),
+			emails:        s.emails,
+		}
+	}
+	return s
+}
+
+// shardFor returns the shard holding the user, picked by an FNV-1a hash
+// of the ID.
+func (s *UserStore) shardFor(id string) *shard {
+	return s.shards[s.shardIndex(id)]
+}
+
+func (s *UserStore) shardIndex(id string) int {
+	h := uint32(2166136261)
+	for i := 0; i < len(id); i++ {
+		h ^= uint32(id[i])
+		h *= 16777619
+	}
+	return int(h % uint32(len(s.shards)))
+}
+
+// Create stores a new user, failing with ErrUserExists if the ID is
+// taken.
+func (s *UserStore) Create(ctx context.Context, user User) error {
+	sh := s.shardFor(user.ID)
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+	if _, exists := sh.users[user.ID]; exists {
+		return ErrUserExists
+	}
+	if user.Status == "" {
+		user.Status = StatusActive
+	}
+	sh.set(newUser(user))
+	sh.stats.countCreated(time.Now())
+	s.appendEvent(ctx, NewEvent(EventUserCreated, user.ID))
+	return nil
+}
+
+func (s *UserStore) Get(ctx context.Context, id string) (User, error) {
+	sh := s.shardFor(id)
+	sh.mu.RLock()
+	defer sh.mu.RUnlock()
+	user, exists := sh.users[id]
+	if !exists {
+		return User{}, ErrUserNotFound
+	}
+	return user, nil
+}
+
+// GetMany looks up several users, returning the users found in request
+// order and the IDs that don't exist. Each shard is read under its own
+// lock, so the result is not a snapshot across shards.
+func (s *UserStore) GetMany(ctx context.Context, ids []string) ([]User, []string, error) {
+	found := make([]User, 0, len(ids))
+	missing := make([]string, 0)
+	for _, id := range ids {
+		if user, err := s.Get(ctx, id); err == nil {
+			found = append(found, user)
+		} else {
+			missing = append(missing, id)
+		}
+	}
+	return found, missing, nil
+}
+
+// GetByEmail returns the user with the email, ignoring case, or the one
+// with the lowest ID if several have it. The user is found through the
+// email index and checked again under its shard's lock, in case it
+// changed in between.
+func (s *UserStore) GetByEmail(ctx context.Context, email string) (User, error) {
+	key := strings.ToLower(email)
+	if key == "" {
+		return User{}, ErrUserNotFound
+	}
+	for _, id := range s.emails.lookup(key) {
+		if user, err := s.Get(ctx, id); err == nil && emailKey(user) == key {
+			return user, nil
+		}
+	}
+	return User{}, ErrUserNotFound
+}
+
+func (s *UserStore) GetAll(ctx context.Context) ([]User, error) {
+	return s.filter(func(User) bool { return true }), nil
+}
+
+// GetByStatus returns all users with the given status.
+func (s *UserStore) GetByStatus(ctx context.Context, status string) ([]User, error) {
+	return s.filter(func(u User) bool { return u.Status == status }), nil
+}
+
+// ForEach calls fn for every user, stopping at the first error fn
+// returns. Users are copied out one shard at a time and fn runs without
+// any lock held, so a long iteration never stalls writers and fn may
+// itself write to the store. Each shard is a consistent snapshot, but
+// changes to shards not yet visited may or may not be seen.
+func (s *UserStore) ForEach(ctx context.Context, fn func(User) error) error {
+	var chunk []User
+	for _, sh := range s.shards {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		sh.mu.RLock()
+		chunk = chunk[:0]
+		for _, user := range sh.users {
+			chunk = append(chunk, user)
+		}
+		sh.mu.RUnlock()
+
+		for _, user := range chunk {
+			if err := fn(user); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// filter collects the users matching keep, one shard at a time.
+func (s *UserStore) filter(keep func(User) bool) []User {
+	users := make([]User, 0)
+	for _, sh := range s.shards {
+		sh.mu.RLock()
+		for _, user := range sh.users {
+			if keep(user) {
+				users = append(users, user)
+			}
+		}
+		sh.mu.RUnlock()
+	}
+	return users
+}
+
+// Update replaces an existing user. The status is kept as is; it only
+// changes through Transition. The password hash and scopes are kept
+// unless new ones are given.
+func (s *UserStore) Update(ctx context.Context, user User) error {
+	sh := s.shardFor(user.ID)
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+	existing, exists := sh.users[user.ID]
+	if !exists {
+		return ErrUserNotFound
+	}
+	sh.set(MergeStored(user, existing))
+	s.appendEvent(ctx, NewEvent(EventUserUpdated, user.ID))
+	return nil
+}
+
+// Upsert stores the user, reporting whether it was newly created.
+func (s *UserStore) Upsert(ctx context.Context, user User) (bool, error) {
+	sh := s.shardFor(user.ID)
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+	existing, exists := sh.users[user.ID]
+	if exists {
+		sh.set(MergeStored(user, existing))
+		s.appendEvent(ctx, NewEvent(EventUserUpdated, user.ID))
+		return false, nil
+	}
+	user.Status = StatusActive
+	sh.set(newUser(user))
+	sh.stats.countCreated(time.Now())
+	s.appendEvent(ctx, NewEvent(EventUserCreated, user.ID))
+	return true, nil
+}
+
+// CompareAndSwap replaces the stored user with user if it is still old,
+// reporting whether it did. It records no event, so callers that change
+// the user rather than how it is stored emit their own.
+func (s *UserStore) CompareAndSwap(ctx context.Context, old, user User) (bool, error) {
+	sh := s.shardFor(old.ID)
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+	existing, exists := sh.users[old.ID]
+	if !exists {
+		return false, ErrUserNotFound
+	}
+	if !reflect.DeepEqual(existing, old) {
+		return false, nil
+	}
+	user.ID = old.ID
+	user.CustomFields = maps.Clone(user.CustomFields)
+	sh.set(user)
+	return true, nil
+}
+
+// MergeStored returns the user Update stores in place of existing. The
+// status, lockout, merge and tenant fields are kept from existing, since
+// a replacement may not change them, and so are the password hash,
+// scopes and custom fields when the replacement leaves them out. Custom
+// fields that are given are copied so the caller can't change the stored
+// map; their values are JSON scalars.
+func MergeStored(user, existing User) User {
+	user.Status = existing.Status
+	user.LockedUntil = existing.LockedUntil
+	user.MergedInto = existing.MergedInto
+	user.Tenant = existing.Tenant
+	if user.PasswordHash == "" {
+		user.PasswordHash = existing.PasswordHash
+	}
+	if user.Scopes == nil {
+		user.Scopes = existing.Scopes
+	}
+	if user.CustomFields == nil {
+		user.CustomFields = existing.CustomFields
+	} else {
+		user.CustomFields = maps.Clone(user.CustomFields)
+	}
+	return user
+}
+
+// newUser readies a user for its first write: the lockout and merge
+// fields are only ever set by the store itself, and custom fields are
+// copied so the caller can't change the stored map.
+func newUser(user User) User {
+	user.LockedUntil = nil
+	user.MergedInto = ""
+	user.CustomFields = maps.Clone(user.CustomFields)
+	return user
+}
+
+// Transition moves the user to the given status if the lifecycle allows it.
+func (s *UserStore) Transition(ctx context.Context, id, status string) (User, error) {
+	sh := s.shardFor(id)
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+	user, exists := sh.users[id]
+	if !exists {
+		return User{}, ErrUserNotFound
+	}
+	if !CanTransition(user.Status, status) {
+		return User{}, ErrInvalidTransition
+	}
+	user.Status = status
+	user.LockedUntil = nil
+	sh.set(user)
+	delete(sh.loginFailures, id)
+	s.appendEvent(ctx, NewEvent(statusEvents[status], id))
+	return user, nil
+}
+
+func (s *UserStore) Delete(ctx context.Context, id string) error {
+	sh := s.shardFor(id)
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+	if _, exists := sh.users[id]; !exists {
+		return ErrUserNotFound
+	}
+	sh.remove(id)
+	s.appendEvent(ctx, NewEvent(EventUserDeleted, id))
+	return nil
+}
+
+// remove drops the user and everything held about them, and takes them
+// out of the shard's counts and the email index. Callers must hold sh.mu.
+func (sh *shard) remove(id string) {
+	if user, ok := sh.users[id]; ok {
+		sh.stats.count(user, -1)
+		sh.emails.remove(emailKey(user), id)
+	}
+	delete(sh.users, id)
+	delete(sh.loginHistory, id)
+	delete(sh.loginFailures, id)
+	delete(sh.twoFactor, id)
+	delete(sh.preferences, id)
+}
@@ -0,0 +1,217 @@
+package store_test
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"encoding/base64"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"user-service/internal/store"
+	"user-service/internal/store/storetest"
+)
+
+// backends are the memory store on its own, with one shard and the
+// default number, and under each decorator the service may wrap it in.
+var backends = []struct {
+	name     string
+	newStore storetest.Factory
+}{
+	{"Memory", func(testing.TB) store.Store { return store.NewUserStore() }},
+	// One shard is a single store-wide lock, the baseline sharding is
+	// measured against.
+	{"SingleShard", func(testing.TB) store.Store { return store.NewShardedUserStore(1) }},
+	{"Breaker", func(testing.TB) store.Store {
+		return store.NewBreakerStore(store.NewUserStore(), store.BreakerConfig{Threshold: 5, Cooldown: time.Second})
+	}},
+	{"Bloom", func(tb testing.TB) store.Store {
+		s, err := store.NewBloomStore(context.Background(), store.NewUserStore(), store.BloomConfig{ExpectedUsers: 1000, FalsePositiveRate: 0.01})
+		if err != nil {
+			tb.Fatal(err)
+		}
+		return s
+	}},
+	{"Coalescing", func(testing.TB) store.Store { return store.NewCoalescingStore(store.NewUserStore()) }},
+	// Every read is compared against the shadow as well.
+	{"Shadow", func(testing.TB) store.Store {
+		return store.NewShadowStore(store.NewUserStore(), store.NewUserStore(), store.ShadowConfig{CompareRate: 1, QueueSize: 100})
+	}},
+	{"Encrypting", func(tb testing.TB) store.Store {
+		cipher, err := store.ParseFieldCipher("k1:" + base64.StdEncoding.EncodeToString([]byte(strings.Repeat("k", 32))))
+		if err != nil {
+			tb.Fatal(err)
+		}
+		return store.NewEncryptingStore(store.NewUserStore(), cipher)
+	}},
+}
+
+func TestConformance(t *testing.T) {
+	for _, backend := range backends {
+		t.Run(backend.name, func(t *testing.T) {
+			storetest.Run(t, backend.newStore)
+		})
+	}
+}
+
+func BenchmarkUserStore(b *testing.B) {
+	for _, backend := range backends {
+		b.Run(backend.name, func(b *testing.B) {
+			storetest.Bench(b, backend.newStore)
+		})
+	}
+}
+
+// blockingStore holds up the first Get, after it has read the user,
+// until release is closed.
+type blockingStore struct {
+	store.Store
+	blocked atomic.Bool
+	started chan struct{}
+	release chan struct{}
+}
+
+func (b *blockingStore) Get(ctx context.Context, id string) (store.User, error) {
+	user, err := b.Store.Get(ctx, id)
+	if b.blocked.CompareAndSwap(false, true) {
+		close(b.started)
+		<-b.release
+	}
+	return user, err
+}
+
+func TestCoalescingFreshReadSeesWrite(t *testing.T) {
+	ctx := context.Background()
+	backend := &blockingStore{Store: store.NewUserStore(), started: make(chan struct{}), release: make(chan struct{})}
+	s := store.NewCoalescingStore(backend)
+	if err := s.Create(ctx, store.User{ID: "1", Name: "Before", Email: "1@example.com"}); err != nil {
+		t.Fatal(err)
+	}
+
+	stale := make(chan store.User)
+	go func() {
+		user, _ := s.Get(ctx, "1")
+		stale <- user
+	}()
+	<-backend.started
+	if err := s.Update(ctx, store.User{ID: "1", Name: "After", Email: "1@example.com"}); err != nil {
+		t.Fatal(err)
+	}
+	got, err := s.Get(store.Fresh(ctx), "1")
+	close(backend.release)
+	if err != nil || got.Name != "After" {
+		t.Fatalf("fresh Get = %+v, %v; want the updated user", got, err)
+	}
+	if user := <-stale; user.Name != "Before" {
+		t.Fatalf("in-flight Get = %+v, want the user as it was", user)
+	}
+}
+
+func TestEncryptingGetByEmailAcrossKeys(t *testing.T) {
+	ctx := context.Background()
+	key := func(c string) string { return base64.StdEncoding.EncodeToString([]byte(strings.Repeat(c, 32))) }
+	old, err := store.ParseFieldCipher("k1:" + key("a"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	rotated, err := store.ParseFieldCipher("k2:" + key("b") + ",k1:" + key("a"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	backend := store.NewUserStore()
+	if err := backend.Create(ctx, store.User{ID: "plain", Email: "plain@example.com"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.NewEncryptingStore(backend, old).Create(ctx, store.User{ID: "old", Email: "old@example.com"}); err != nil {
+		t.Fatal(err)
+	}
+	s := store.NewEncryptingStore(backend, rotated)
+	if err := s.Create(ctx, store.User{ID: "new", Email: "new@example.com"}); err != nil {
+		t.Fatal(err)
+	}
+	for id, email := range map[string]string{"plain": "Plain@example.com", "old": "OLD@example.com", "new": "new@example.com"} {
+		if got, err := s.GetByEmail(ctx, email); err != nil || got.ID != id || !strings.EqualFold(got.Email, email) {
+			t.Errorf("GetByEmail(%s) = %+v, %v; want user %s", email, got, err, id)
+		}
+	}
+}
+
+func TestEncryptingReencrypt(t *testing.T) {
+	ctx := context.Background()
+	key := []byte(strings.Repeat("a", 32))
+	fc, err := store.ParseFieldCipher("k2:" + base64.StdEncoding.EncodeToString([]byte(strings.Repeat("b", 32))) + ",k1:" + base64.StdEncoding.EncodeToString(key))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// A value from before values were bound to their user, sealed with
+	// the key ID alone.
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		t.Fatal(err)
+	}
+	nonce := make([]byte, aead.NonceSize())
+	legacy := "enc:v1:k1:" + base64.StdEncoding.EncodeToString(aead.Seal(nonce, nonce, []byte("legacy@example.com"), []byte("k1")))
+
+	backend := store.NewUserStore()
+	for _, u := range []store.User{{ID: "legacy", Email: legacy}, {ID: "plain", Email: "plain@example.com"}} {
+		if err := backend.Create(ctx, u); err != nil {
+			t.Fatal(err)
+		}
+	}
+	s := store.NewEncryptingStore(backend, fc)
+	if err := s.Create(ctx, store.User{ID: "current", Email: "current@example.com"}); err != nil {
+		t.Fatal(err)
+	}
+	before, _ := backend.LastEventSeq(ctx)
+	if count, err := s.Reencrypt(ctx); err != nil || count != 2 {
+		t.Fatalf("Reencrypt = %d, %v; want 2", count, err)
+	}
+	if after, _ := backend.LastEventSeq(ctx); after != before {
+		t.Fatalf("Reencrypt recorded events %d to %d, want none", before, after)
+	}
+	for _, id := range []string{"legacy", "plain", "current"} {
+		stored, err := backend.Get(ctx, id)
+		if err != nil || !fc.Current(stored.Email) {
+			t.Errorf("stored %s = %+v, %v; want it under the primary key", id, stored, err)
+		}
+		if got, err := s.GetByEmail(ctx, id+"@example.com"); err != nil || got.ID != id {
+			t.Errorf("GetByEmail(%s) = %+v, %v", id, got, err)
+		}
+	}
+
+	// A value is bound to its user, so one copied onto another fails to
+	// decrypt.
+	stored, _ := backend.Get(ctx, "current")
+	if err := backend.Update(ctx, store.User{ID: "plain", Email: stored.Email}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Get(ctx, "plain"); err == nil {
+		t.Fatal("Get decrypted another user's email")
+	}
+}
+
+func TestEncryptingMergeRebindsValues(t *testing.T) {
+	ctx := context.Background()
+	fc, err := store.ParseFieldCipher("k1:" + base64.StdEncoding.EncodeToString([]byte(strings.Repeat("k", 32))))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := store.NewEncryptingStore(store.NewUserStore(), fc)
+	for _, u := range []store.User{{ID: "1", Email: "1@example.com"}, {ID: "2", Email: "2@example.com", Phone: "+15550000002"}} {
+		if err := s.Create(ctx, u); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if merged, err := s.Merge(ctx, "2", "1"); err != nil || merged.Phone != "+15550000002" {
+		t.Fatalf("Merge = %+v, %v; want the source's phone", merged, err)
+	}
+	if got, err := s.Get(ctx, "1"); err != nil || got.Phone != "+15550000002" {
+		t.Fatalf("Get = %+v, %v; want the phone bound to the target", got, err)
+	}
+}
@@ -0,0 +1,167 @@
+package storetest
+
+import (
+	"context"
+	"strconv"
+	"sync/atomic"
+	"testing"
+
+	"user-service/internal/store"
+)
+
+// benchUsers is how many users the read benchmarks start with.
+const benchUsers = 1000
+
+// Bench runs the store benchmarks against stores made by newStore.
+func Bench(b *testing.B, newStore Factory) {
+	benchmarks := []struct {
+		name string
+		run  func(b *testing.B, s store.Store)
+	}{
+		{"Create", benchCreate},
+		{"CreateParallel", benchCreateParallel},
+		{"Get", benchGet},
+		{"GetParallel", benchGetParallel},
+		{"GetByEmail", benchGetByEmail},
+		{"GetAll", benchGetAll},
+		{"Update", benchUpdate},
+		{"UpdateParallel", benchUpdateParallel},
+		{"MixedParallel", benchMixedParallel},
+	}
+	for _, bm := range benchmarks {
+		bm := bm
+		b.Run(bm.name, func(b *testing.B) {
+			bm.run(b, newStore(b))
+		})
+	}
+}
+
+// seed creates n users and restarts the benchmark timer.
+func seed(b *testing.B, s store.Store, n int) {
+	b.Helper()
+	ctx := context.Background()
+	for i := 0; i < n; i++ {
+		if err := s.Create(ctx, user(strconv.Itoa(i))); err != nil {
+			b.Fatalf("seed: %v", err)
+		}
+	}
+	b.ResetTimer()
+}
+
+func benchCreate(b *testing.B, s store.Store) {
+	ctx := context.Background()
+	for i := 0; i < b.N; i++ {
+		if err := s.Create(ctx, user(strconv.Itoa(i))); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func benchCreateParallel(b *testing.B, s store.Store) {
+	ctx := context.Background()
+	var next atomic.Int64
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			if err := s.Create(ctx, user(strconv.FormatInt(next.Add(1), 10))); err != nil {
+				b.Error(err)
+				return
+			}
+		}
+	})
+}
+
+func benchGet(b *testing.B, s store.Store) {
+	seed(b, s, benchUsers)
+	ctx := context.Background()
+	for i := 0; i < b.N; i++ {
+		if _, err := s.Get(ctx, strconv.Itoa(i%benchUsers)); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func benchGetParallel(b *testing.B, s store.Store) {
+	seed(b, s, benchUsers)
+	ctx := context.Background()
+	var next atomic.Int64
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			id := strconv.Itoa(int(next.Add(1) % benchUsers))
+			if _, err := s.Get(ctx, id); err != nil {
+				b.Error(err)
+				return
+			}
+		}
+	})
+}
+
+func benchGetByEmail(b *testing.B, s store.Store) {
+	seed(b, s, benchUsers)
+	ctx := context.Background()
+	for i := 0; i < b.N; i++ {
+		if _, err := s.GetByEmail(ctx, user(strconv.Itoa(i%benchUsers)).Email); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func benchGetAll(b *testing.B, s store.Store) {
+	seed(b, s, benchUsers)
+	ctx := context.Background()
+	for i := 0; i < b.N; i++ {
+		if _, err := s.GetAll(ctx); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func benchUpdate(b *testing.B, s store.Store) {
+	seed(b, s, benchUsers)
+	ctx := context.Background()
+	for i := 0; i < b.N; i++ {
+		u := user(strconv.Itoa(i % benchUsers))
+		u.Name = "Renamed " + u.ID
+		if err := s.Update(ctx, u); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func benchUpdateParallel(b *testing.B, s store.Store) {
+	seed(b, s, benchUsers)
+	ctx := context.Background()
+	var next atomic.Int64
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			u := user(strconv.Itoa(int(next.Add(1) % benchUsers)))
+			u.Name = "Renamed " + u.ID
+			if err := s.Update(ctx, u); err != nil {
+				b.Error(err)
+				return
+			}
+		}
+	})
+}
+
+// benchMixedParallel is nine reads to every write, across goroutines.
+func benchMixedParallel(b *testing.B, s store.Store) {
+	seed(b, s, benchUsers)
+	ctx := context.Background()
+	var next atomic.Int64
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			n := next.Add(1)
+			u := user(strconv.Itoa(int(n % benchUsers)))
+			var err error
+			if n%10 == 0 {
+				err = s.Update(ctx, u)
+			} else {
+				_, err = s.Get(ctx, u.ID)
+			}
+			if err != nil {
+				b.Error(err)
+				return
+			}
+		}
+	})
+}
@@ -0,0 +1,776 @@
+// Package storetest is a conformance suite for implementations of
+// store.Store. A backend verifies itself from its own tests with
+//
+//	func TestConformance(t *testing.T) {
+//		storetest.Run(t, func(tb testing.TB) store.Store { return newTestBackend(tb) })
+//	}
+//
+// and measures itself with Bench from a benchmark. The factory must
+// return an empty store for every call.
+package storetest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"user-service/internal/store"
+	"user-service/internal/totp"
+)
+
+// Factory returns a new, empty store.
+type Factory func(tb testing.TB) store.Store
+
+var policy = store.LoginPolicy{
+	MaxFailures:     3,
+	LockoutDuration: time.Minute,
+	IPMaxFailures:   5,
+	IPWindow:        time.Minute,
+}
+
+// Run runs every conformance test against stores made by newStore.
+func Run(t *testing.T, newStore Factory) {
+	groups := []struct {
+		name  string
+		tests []test
+	}{
+		{"CRUD", crudTests},
+		{"Status", statusTests},
+		{"List", listTests},
+		{"Login", loginTests},
+		{"TwoFactor", twoFactorTests},
+		{"Preferences", preferenceTests},
+		{"Events", eventTests},
+		{"Stats", statsTests},
+		{"Concurrency", concurrencyTests},
+	}
+	for _, group := range groups {
+		group := group
+		t.Run(group.name, func(t *testing.T) {
+			for _, tc := range group.tests {
+				tc := tc
+				t.Run(tc.name, func(t *testing.T) {
+					tc.run(t, newStore(t))
+				})
+			}
+		})
+	}
+}
+
+type test struct {
+	name string
+	run  func(t *testing.T, s store.Store)
+}
+
+func user(id string) store.User {
+	return store.User{ID: id, Name: "User " + id, Email: "user" + id + "@example.com", Scopes: []string{"users:read"}}
+}
+
+func mustCreate(t *testing.T, s store.Store, users ...store.User) {
+	t.Helper()
+	for _, u := range users {
+		if err := s.Create(context.Background(), u); err != nil {
+			t.Fatalf("Create(%s): %v", u.ID, err)
+		}
+	}
+}
+
+func mustGet(t *testing.T, s store.Store, id string) store.User {
+	t.Helper()
+	u, err := s.Get(context.Background(), id)
+	if err != nil {
+		t.Fatalf("Get(%s): %v", id, err)
+	}
+	return u
+}
+
+func wantErr(t *testing.T, op string, err, target error) {
+	t.Helper()
+	if !errors.Is(err, target) {
+		t.Fatalf("%s: got error %v, want %v", op, err, target)
+	}
+}
+
+func ids(users []store.User) []string {
+	out := make([]string, len(users))
+	for i, u := range users {
+		out[i] = u.ID
+	}
+	return out
+}
+
+func sortedIDs(users []store.User) []string {
+	out := ids(users)
+	sort.Strings(out)
+	return out
+}
+
+func equal(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+var crudTests = []test{
+	{"CreateDefaultsStatusToActive", func(t *testing.T, s store.Store) {
+		mustCreate(t, s, user("1"))
+		if got := mustGet(t, s, "1"); got.Status != store.StatusActive || got.Name != "User 1" {
+			t.Fatalf("Get = %+v, want an active User 1", got)
+		}
+	}},
+	{"CreateKeepsGivenStatus", func(t *testing.T, s store.Store) {
+		u := user("1")
+		u.Status = store.StatusSuspended
+		mustCreate(t, s, u)
+		if got := mustGet(t, s, "1"); got.Status != store.StatusSuspended {
+			t.Fatalf("Status = %q, want %q", got.Status, store.StatusSuspended)
+		}
+	}},
+	{"CreateTakenID", func(t *testing.T, s store.Store) {
+		mustCreate(t, s, user("1"))
+		u := user("1")
+		u.Name = "Someone Else"
+		wantErr(t, "Create", s.Create(context.Background(), u), store.ErrUserExists)
+		if got := mustGet(t, s, "1"); got.Name != "User 1" {
+			t.Fatalf("Name = %q after a refused Create, want User 1", got.Name)
+		}
+		stats, err := s.Stats(context.Background())
+		if err != nil || stats.Total != 1 {
+			t.Fatalf("Stats = %+v, %v; want a total of 1", stats, err)
+		}
+	}},
+	{"CreateIgnoresMergeAndLockFields", func(t *testing.T, s store.Store) {
+		until := time.Now().Add(time.Hour)
+		u := user("1")
+		u.MergedInto, u.LockedUntil = "2", &until
+		mustCreate(t, s, u)
+		if got := mustGet(t, s, "1"); got.MergedInto != "" || got.LockedUntil != nil {
+			t.Fatalf("Get = %+v, want no merged_into or locked_until", got)
+		}
+	}},
+	{"GetMissing", func(t *testing.T, s store.Store) {
+		_, err := s.Get(context.Background(), "missing")
+		wantErr(t, "Get", err, store.ErrUserNotFound)
+	}},
+	{"GetByEmailIgnoresCase", func(t *testing.T, s store.Store) {
+		mustCreate(t, s, user("1"))
+		got, err := s.GetByEmail(context.Background(), "USER1@Example.com")
+		if err != nil || got.ID != "1" {
+			t.Fatalf("GetByEmail = %+v, %v; want user 1", got, err)
+		}
+		_, err = s.GetByEmail(context.Background(), "nobody@example.com")
+		wantErr(t, "GetByEmail", err, store.ErrUserNotFound)
+	}},
+	{"GetByEmailFollowsWrites", func(t *testing.T, s store.Store) {
+		ctx := context.Background()
+		mustCreate(t, s, user("1"), user("2"))
+		u := user("1")
+		u.Email = "renamed@example.com"
+		if err := s.Update(ctx, u); err != nil {
+			t.Fatal(err)
+		}
+		if got, err := s.GetByEmail(ctx, "renamed@example.com"); err != nil || got.ID != "1" {
+			t.Fatalf("GetByEmail(new) = %+v, %v; want user 1", got, err)
+		}
+		_, err := s.GetByEmail(ctx, "user1@example.com")
+		wantErr(t, "GetByEmail(old)", err, store.ErrUserNotFound)
+		if err := s.Delete(ctx, "2"); err != nil {
+			t.Fatal(err)
+		}
+		_, err = s.GetByEmail(ctx, "user2@example.com")
+		wantErr(t, "GetByEmail(deleted)", err, store.ErrUserNotFound)
+	}},
+	{"UpdateMissing", func(t *testing.T, s store.Store) {
+		wantErr(t, "Update", s.Update(context.Background(), user("1")), store.ErrUserNotFound)
+	}},
+	{"UpdateKeepsStoredFields", func(t *testing.T, s store.Store) {
+		u := user("1")
+		u.PasswordHash = "hash"
+		mustCreate(t, s, u)
+		if _, err := s.Transition(context.Background(), "1", store.StatusSuspended); err != nil {
+			t.Fatal(err)
+		}
+		if err := s.Update(context.Background(), store.User{ID: "1", Name: "Renamed", Email: u.Email, Status: store.StatusActive}); err != nil {
+			t.Fatal(err)
+		}
+		got := mustGet(t, s, "1")
+		if got.Name != "Renamed" || got.Status != store.StatusSuspended || got.PasswordHash != "hash" || !equal(got.Scopes, u.Scopes) {
+			t.Fatalf("Get = %+v; want the new name with status, hash and scopes kept", got)
+		}
+	}},
+	{"UpsertCreatesThenReplaces", func(t *testing.T, s store.Store) {
+		created, err := s.Upsert(context.Background(), user("1"))
+		if err != nil || !created {
+			t.Fatalf("first Upsert = %t, %v; want created", created, err)
+		}
+		u := user("1")
+		u.Name = "Replaced"
+		created, err = s.Upsert(context.Background(), u)
+		if err != nil || created {
+			t.Fatalf("second Upsert = %t, %v; want replaced", created, err)
+		}
+		if got := mustGet(t, s, "1"); got.Name != "Replaced" || got.Status != store.StatusActive {
+			t.Fatalf("Get = %+v", got)
+		}
+	}},
+	{"CompareAndSwap", func(t *testing.T, s store.Store) {
+		ctx := context.Background()
+		mustCreate(t, s, user("1"))
+		before, err := s.LastEventSeq(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		old := mustGet(t, s, "1")
+		swapped := old
+		swapped.Name = "Swapped"
+		if ok, err := s.CompareAndSwap(ctx, old, swapped); err != nil || !ok {
+			t.Fatalf("CompareAndSwap = %t, %v; want swapped", ok, err)
+		}
+		stale := swapped
+		stale.Name = "Stale"
+		if ok, err := s.CompareAndSwap(ctx, old, stale); err != nil || ok {
+			t.Fatalf("CompareAndSwap(stale) = %t, %v; want not swapped", ok, err)
+		}
+		if got := mustGet(t, s, "1"); got.Name != "Swapped" {
+			t.Fatalf("Name = %q, want Swapped", got.Name)
+		}
+		if after, err := s.LastEventSeq(ctx); err != nil || after != before {
+			t.Fatalf("LastEventSeq = %d, %v; want %d, no event recorded", after, err, before)
+		}
+		_, err = s.CompareAndSwap(ctx, user("2"), user("2"))
+		wantErr(t, "CompareAndSwap(missing)", err, store.ErrUserNotFound)
+	}},
+	{"Delete", func(t *testing.T, s store.Store) {
+		mustCreate(t, s, user("1"))
+		if err := s.Delete(context.Background(), "1"); err != nil {
+			t.Fatal(err)
+		}
+		_, err := s.Get(context.Background(), "1")
+		wantErr(t, "Get after Delete", err, store.ErrUserNotFound)
+		wantErr(t, "second Delete", s.Delete(context.Background(), "1"), store.ErrUserNotFound)
+	}},
+	{"MergeLeavesTombstone", func(t *testing.T, s store.Store) {
+		source := user("2")
+		source.Phone, source.PasswordHash = "+15550000002", "hash"
+		mustCreate(t, s, user("1"), source)
+		if _, err := s.Merge(context.Background(), "2", "1"); err != nil {
+			t.Fatal(err)
+		}
+		got := mustGet(t, s, "2")
+		want := store.User{ID: "2", Status: store.StatusMerged, MergedInto: "1"}
+		if got.ID != want.ID || got.Status != want.Status || got.MergedInto != want.MergedInto ||
+			got.Name != "" || got.Email != "" || got.Phone != "" || got.PasswordHash != "" || got.Scopes != nil {
+			t.Fatalf("tombstone = %+v, want only %+v", got, want)
+		}
+	}},
+	{"ForgetErasesUser", func(t *testing.T, s store.Store) {
+		mustCreate(t, s, user("1"))
+		if err := s.Forget(context.Background(), "1", "admin"); err != nil {
+			t.Fatal(err)
+		}
+		_, err := s.Get(context.Background(), "1")
+		wantErr(t, "Get after Forget", err, store.ErrUserNotFound)
+		wantErr(t, "Forget missing", s.Forget(context.Background(), "missing", ""), store.ErrUserNotFound)
+	}},
+}
+
+var statusTests = []test{
+	{"AllowedTransition", func(t *testing.T, s store.Store) {
+		mustCreate(t, s, user("1"))
+		got, err := s.Transition(context.Background(), "1", store.StatusSuspended)
+		if err != nil || got.Status != store.StatusSuspended {
+			t.Fatalf("Transition = %+v, %v", got, err)
+		}
+		if got := mustGet(t, s, "1"); got.Status != store.StatusSuspended {
+			t.Fatalf("stored Status = %q", got.Status)
+		}
+	}},
+	{"RejectedTransition", func(t *testing.T, s store.Store) {
+		mustCreate(t, s, user("1"))
+		_, err := s.Transition(context.Background(), "1", store.StatusActive)
+		wantErr(t, "Transition active->active", err, store.ErrInvalidTransition)
+	}},
+	{"TransitionMissing", func(t *testing.T, s store.Store) {
+		_, err := s.Transition(context.Background(), "missing", store.StatusSuspended)
+		wantErr(t, "Transition", err, store.ErrUserNotFound)
+	}},
+}
+
+var listTests = []test{
+	{"GetAllEmpty", func(t *testing.T, s store.Store) {
+		users, err := s.GetAll(context.Background())
+		if err != nil || len(users) != 0 {
+			t.Fatalf("GetAll = %v, %v; want none", users, err)
+		}
+	}},
+	{"GetAllReturnsEachUserOnce", func(t *testing.T, s store.Store) {
+		for i := 0; i < 25; i++ {
+			mustCreate(t, s, user(fmt.Sprintf("%02d", i)))
+		}
+		users, err := s.GetAll(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := sortedIDs(users)
+		for i := range got {
+			if want := fmt.Sprintf("%02d", i); got[i] != want {
+				t.Fatalf("GetAll IDs = %v", got)
+			}
+		}
+	}},
+	{"GetByStatus", func(t *testing.T, s store.Store) {
+		mustCreate(t, s, user("1"), user("2"), user("3"))
+		if _, err := s.Transition(context.Background(), "2", store.StatusSuspended); err != nil {
+			t.Fatal(err)
+		}
+		users, err := s.GetByStatus(context.Background(), store.StatusActive)
+		if err != nil || !equal(sortedIDs(users), []string{"1", "3"}) {
+			t.Fatalf("GetByStatus(active) = %v, %v", sortedIDs(users), err)
+		}
+		users, err = s.GetByStatus(context.Background(), store.StatusLocked)
+		if err != nil || len(users) != 0 {
+			t.Fatalf("GetByStatus(locked) = %v, %v; want none", users, err)
+		}
+	}},
+	{"ForEachVisitsEachUserOnce", func(t *testing.T, s store.Store) {
+		for i := 0; i < 25; i++ {
+			mustCreate(t, s, user(fmt.Sprintf("%02d", i)))
+		}
+		var users []store.User
+		err := s.ForEach(context.Background(), func(u store.User) error {
+			users = append(users, u)
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := sortedIDs(users)
+		if len(got) != 25 {
+			t.Fatalf("ForEach IDs = %v", got)
+		}
+		for i := range got {
+			if want := fmt.Sprintf("%02d", i); got[i] != want {
+				t.Fatalf("ForEach IDs = %v", got)
+			}
+		}
+	}},
+	{"ForEachStopsOnError", func(t *testing.T, s store.Store) {
+		mustCreate(t, s, user("1"), user("2"), user("3"))
+		stop := errors.New("stop")
+		calls := 0
+		err := s.ForEach(context.Background(), func(store.User) error {
+			calls++
+			return stop
+		})
+		if !errors.Is(err, stop) || calls != 1 {
+			t.Fatalf("ForEach = %v after %d calls; want stop after 1", err, calls)
+		}
+	}},
+	{"ForEachAllowsWrites", func(t *testing.T, s store.Store) {
+		mustCreate(t, s, user("1"), user("2"), user("3"))
+		ctx := context.Background()
+		err := s.ForEach(ctx, func(u store.User) error {
+			u.Name = "Renamed"
+			return s.Update(ctx, u)
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, id := range []string{"1", "2", "3"} {
+			if got := mustGet(t, s, id); got.Name != "Renamed" {
+				t.Fatalf("user %s name = %q after ForEach update", id, got.Name)
+			}
+		}
+	}},
+	{"ForEachCanceled", func(t *testing.T, s store.Store) {
+		mustCreate(t, s, user("1"))
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		err := s.ForEach(ctx, func(store.User) error { return nil })
+		wantErr(t, "ForEach", err, context.Canceled)
+	}},
+	{"GetManyKeepsRequestOrder", func(t *testing.T, s store.Store) {
+		mustCreate(t, s, user("1"), user("2"), user("3"))
+		users, missing, err := s.GetMany(context.Background(), []string{"3", "x", "1"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !equal(ids(users), []string{"3", "1"}) || !equal(missing, []string{"x"}) {
+			t.Fatalf("GetMany = %v missing %v", ids(users), missing)
+		}
+	}},
+}
+
+func attempt(id string, ok bool, at time.Time) store.LoginAttempt {
+	return store.LoginAttempt{UserID: id, IP: "192.0.2.1", Time: at, Success: ok}
+}
+
+var loginTests = []test{
+	{"LocksAfterMaxFailures", func(t *testing.T, s store.Store) {
+		mustCreate(t, s, user("1"))
+		now := time.Now()
+		for i := 0; i < policy.MaxFailures; i++ {
+			if err := s.RecordLoginAttempt(context.Background(), attempt("1", false, now), policy); err != nil {
+				t.Fatal(err)
+			}
+		}
+		got := mustGet(t, s, "1")
+		if got.Status != store.StatusLocked || got.LockedUntil == nil {
+			t.Fatalf("after %d failures: %+v, want locked", policy.MaxFailures, got)
+		}
+
+		released, err := s.ReleaseExpiredLock(context.Background(), "1", now)
+		if err != nil || released {
+			t.Fatalf("ReleaseExpiredLock before expiry = %t, %v", released, err)
+		}
+		released, err = s.ReleaseExpiredLock(context.Background(), "1", got.LockedUntil.Add(time.Second))
+		if err != nil || !released {
+			t.Fatalf("ReleaseExpiredLock after expiry = %t, %v", released, err)
+		}
+		if got := mustGet(t, s, "1"); got.Status != store.StatusActive {
+			t.Fatalf("Status = %q after release", got.Status)
+		}
+	}},
+	{"SuccessResetsFailures", func(t *testing.T, s store.Store) {
+		mustCreate(t, s, user("1"))
+		now := time.Now()
+		for i := 0; i < policy.MaxFailures*2; i++ {
+			s.RecordLoginAttempt(context.Background(), attempt("1", i%2 == 1, now), policy)
+		}
+		if got := mustGet(t, s, "1"); got.Status != store.StatusActive {
+			t.Fatalf("Status = %q, want failures reset by successes", got.Status)
+		}
+	}},
+	{"IPBlocked", func(t *testing.T, s store.Store) {
+		now := time.Now()
+		for i := 0; i < policy.IPMaxFailures; i++ {
+			s.RecordLoginAttempt(context.Background(), attempt("", false, now), policy)
+		}
+		blocked, err := s.IPBlocked(context.Background(), "192.0.2.1", policy)
+		if err != nil || !blocked {
+			t.Fatalf("IPBlocked = %t, %v; want blocked", blocked, err)
+		}
+		blocked, _ = s.IPBlocked(context.Background(), "192.0.2.2", policy)
+		if blocked {
+			t.Fatal("unrelated IP is blocked")
+		}
+	}},
+	{"History", func(t *testing.T, s store.Store) {
+		mustCreate(t, s, user("1"))
+		s.RecordLoginAttempt(context.Background(), attempt("1", true, time.Now()), policy)
+		history, err := s.LoginHistory(context.Background(), "1")
+		if err != nil || len(history) != 1 || !history[0].Success {
+			t.Fatalf("LoginHistory = %+v, %v", history, err)
+		}
+		_, err = s.LoginHistory(context.Background(), "missing")
+		wantErr(t, "LoginHistory", err, store.ErrUserNotFound)
+	}},
+}
+
+var twoFactorTests = []test{
+	{"VerifyEnablesOnce", func(t *testing.T, s store.Store) {
+		mustCreate(t, s, user("1"))
+		secret, _ := totp.GenerateSecret()
+		plain, hashed, _ := totp.GenerateRecoveryCodes()
+		ctx := context.Background()
+		if err := s.SetupTwoFactor(ctx, "1", store.TwoFactor{Secret: secret, RecoveryCodes: hashed}); err != nil {
+			t.Fatal(err)
+		}
+		if enabled, _ := s.TwoFactorEnabled(ctx, "1"); enabled {
+			t.Fatal("enabled before verification")
+		}
+
+		now := time.Now()
+		code, _ := totp.Code(secret, now.Unix()/totp.Period)
+		if err := s.VerifyTwoFactor(ctx, "1", code, false, now); err != nil {
+			t.Fatal(err)
+		}
+		if enabled, _ := s.TwoFactorEnabled(ctx, "1"); !enabled {
+			t.Fatal("not enabled after verification")
+		}
+		wantErr(t, "replayed code", s.VerifyTwoFactor(ctx, "1", code, false, now), store.ErrInvalidTwoFactorCode)
+		wantErr(t, "second setup", s.SetupTwoFactor(ctx, "1", store.TwoFactor{Secret: secret}), store.ErrTwoFactorEnabled)
+
+		if err := s.VerifyTwoFactor(ctx, "1", plain[0], true, now); err != nil {
+			t.Fatalf("recovery code: %v", err)
+		}
+		wantErr(t, "reused recovery code", s.VerifyTwoFactor(ctx, "1", plain[0], true, now), store.ErrInvalidTwoFactorCode)
+	}},
+	{"NotSetUp", func(t *testing.T, s store.Store) {
+		mustCreate(t, s, user("1"))
+		wantErr(t, "VerifyTwoFactor", s.VerifyTwoFactor(context.Background(), "1", "000000", false, time.Now()), store.ErrTwoFactorNotSetUp)
+	}},
+}
+
+var preferenceTests = []test{
+	{"SetReplacesAndDeleteClears", func(t *testing.T, s store.Store) {
+		ctx := context.Background()
+		mustCreate(t, s, user("1"))
+		if values, err := s.GetPreferences(ctx, "1"); err != nil || len(values) != 0 {
+			t.Fatalf("GetPreferences before set = %v, %v", values, err)
+		}
+		if err := s.SetPreferences(ctx, "1", map[string]any{"theme": "dark", "page_size": 50.0}); err != nil {
+			t.Fatal(err)
+		}
+		if err := s.SetPreferences(ctx, "1", map[string]any{"theme": "light"}); err != nil {
+			t.Fatal(err)
+		}
+		values, err := s.GetPreferences(ctx, "1")
+		if err != nil || len(values) != 1 || values["theme"] != "light" {
+			t.Fatalf("GetPreferences = %v, %v, want only theme=light", values, err)
+		}
+		values["theme"] = "changed"
+		if again, _ := s.GetPreferences(ctx, "1"); again["theme"] != "light" {
+			t.Fatal("GetPreferences returned the stored map")
+		}
+
+		s.Delete(ctx, "1")
+		mustCreate(t, s, user("1"))
+		if values, _ := s.GetPreferences(ctx, "1"); len(values) != 0 {
+			t.Fatalf("preferences survived delete: %v", values)
+		}
+	}},
+	{"MissingUser", func(t *testing.T, s store.Store) {
+		ctx := context.Background()
+		_, err := s.GetPreferences(ctx, "missing")
+		wantErr(t, "GetPreferences", err, store.ErrUserNotFound)
+		wantErr(t, "SetPreferences", s.SetPreferences(ctx, "missing", map[string]any{"theme": "dark"}), store.ErrUserNotFound)
+	}},
+}
+
+var eventTests = []test{
+	{"MutationsEmitInOrder", func(t *testing.T, s store.Store) {
+		ctx := context.Background()
+		mustCreate(t, s, user("1"))
+		s.Update(ctx, user("1"))
+		s.Delete(ctx, "1")
+		pending, err := s.PendingEvents(ctx, 10)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var types []string
+		for i, e := range pending {
+			types = append(types, e.Type)
+			if i > 0 && e.Seq <= pending[i-1].Seq {
+				t.Fatalf("sequence not increasing: %+v", pending)
+			}
+		}
+		want := []string{store.EventUserCreated, store.EventUserUpdated, store.EventUserDeleted}
+		if !equal(types, want) {
+			t.Fatalf("event types = %v, want %v", types, want)
+		}
+	}},
+	{"MarkSent", func(t *testing.T, s store.Store) {
+		ctx := context.Background()
+		mustCreate(t, s, user("1"), user("2"))
+		pending, _ := s.PendingEvents(ctx, 10)
+		if len(pending) != 2 {
+			t.Fatalf("PendingEvents = %d events, want 2", len(pending))
+		}
+		if err := s.MarkSent(ctx, pending[0].Seq, time.Now()); err != nil {
+			t.Fatal(err)
+		}
+		rest, _ := s.PendingEvents(ctx, 10)
+		if len(rest) != 1 || rest[0].Seq != pending[1].Seq {
+			t.Fatalf("PendingEvents after MarkSent = %+v", rest)
+		}
+		events, _ := s.EventsForUser(ctx, "1")
+		if len(events) != 1 {
+			t.Fatalf("EventsForUser kept %d events, want 1", len(events))
+		}
+	}},
+	{"EventsSinceResumesAfterCursor", func(t *testing.T, s store.Store) {
+		ctx := context.Background()
+		mustCreate(t, s, user("1"), user("2"), user("3"))
+		first, err := s.EventsSince(ctx, 0, 2)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(first) != 2 {
+			t.Fatalf("EventsSince(0, 2) = %d events, want 2", len(first))
+		}
+		rest, err := s.EventsSince(ctx, first[1].Seq, 10)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(rest) != 1 || rest[0].UserID != "3" {
+			t.Fatalf("EventsSince after cursor = %+v, want the third create", rest)
+		}
+		last, err := s.LastEventSeq(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if last != rest[0].Seq {
+			t.Fatalf("LastEventSeq = %d, want %d", last, rest[0].Seq)
+		}
+		if tail, _ := s.EventsSince(ctx, last, 10); len(tail) != 0 {
+			t.Fatalf("EventsSince(latest) = %+v, want none", tail)
+		}
+	}},
+	{"EventsSinceUnknownCursor", func(t *testing.T, s store.Store) {
+		ctx := context.Background()
+		mustCreate(t, s, user("1"))
+		_, err := s.EventsSince(ctx, 100, 10)
+		wantErr(t, "EventsSince", err, store.ErrCursorExpired)
+	}},
+	{"ForgetScrubsEventData", func(t *testing.T, s store.Store) {
+		ctx := context.Background()
+		mustCreate(t, s, user("1"))
+		s.Emit(ctx, store.NewEvent(store.EventLoginFailed, "1").With("ip", "192.0.2.1"))
+		s.Forget(ctx, "1", "admin")
+		events, _ := s.EventsForUser(ctx, "1")
+		for _, e := range events {
+			if e.Type != store.EventUserForgotten && len(e.Data) > 0 {
+				t.Fatalf("event %s kept data %v", e.Type, e.Data)
+			}
+		}
+		if last := events[len(events)-1]; last.Type != store.EventUserForgotten {
+			t.Fatalf("last event = %s, want %s", last.Type, store.EventUserForgotten)
+		}
+	}},
+}
+
+var statsTests = []test{
+	{"CountsFollowChanges", func(t *testing.T, s store.Store) {
+		ctx := context.Background()
+		admin := user("3")
+		admin.Scopes = []string{"users:read", "admin:users"}
+		mustCreate(t, s, user("1"), user("2"), admin)
+		if _, err := s.Transition(ctx, "2", store.StatusSuspended); err != nil {
+			t.Fatal(err)
+		}
+		if err := s.Delete(ctx, "1"); err != nil {
+			t.Fatal(err)
+		}
+		stats, err := s.Stats(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if stats.Total != 2 || stats.ByStatus[store.StatusActive] != 1 || stats.ByStatus[store.StatusSuspended] != 1 {
+			t.Fatalf("Stats = %+v; want 2 users, one active and one suspended", stats)
+		}
+		if stats.ByScope["users:read"] != 2 || stats.ByScope["admin:users"] != 1 {
+			t.Fatalf("ByScope = %v; want users:read 2, admin:users 1", stats.ByScope)
+		}
+		if today := time.Now().UTC().Format(time.DateOnly); stats.CreatedPerDay[today] != 3 {
+			t.Fatalf("CreatedPerDay = %v; want 3 today, deletes included", stats.CreatedPerDay)
+		}
+	}},
+	{"ByTenant", func(t *testing.T, s store.Store) {
+		ctx := context.Background()
+		acme, other := user("1"), user("2")
+		acme.Tenant, other.Tenant = "acme", "other"
+		mustCreate(t, s, acme, other, user("3"))
+		moved := user("2")
+		moved.Tenant = "acme"
+		if err := s.Update(ctx, moved); err != nil {
+			t.Fatal(err)
+		}
+		if got := mustGet(t, s, "2"); got.Tenant != "other" {
+			t.Fatalf("Get = %+v, want the tenant kept through Update", got)
+		}
+		if err := s.Delete(ctx, "1"); err != nil {
+			t.Fatal(err)
+		}
+		stats, err := s.Stats(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(stats.ByTenant) != 1 || stats.ByTenant["other"] != 1 {
+			t.Fatalf("ByTenant = %v; want only other 1", stats.ByTenant)
+		}
+	}},
+	{"MergedUsersLeaveTotal", func(t *testing.T, s store.Store) {
+		ctx := context.Background()
+		mustCreate(t, s, user("1"), user("2"))
+		if _, err := s.Merge(ctx, "2", "1"); err != nil {
+			t.Fatal(err)
+		}
+		stats, err := s.Stats(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if stats.Total != 1 || stats.ByStatus[store.StatusMerged] != 1 || stats.ByScope["users:read"] != 1 {
+			t.Fatalf("Stats = %+v; want 1 user and 1 merged tombstone without scopes", stats)
+		}
+	}},
+}
+
+var concurrencyTests = []test{
+	{"ParallelWrites", func(t *testing.T, s store.Store) {
+		ctx := context.Background()
+		const workers, perWorker = 8, 50
+		var wg sync.WaitGroup
+		errs := make(chan error, workers*perWorker*2)
+		for w := 0; w < workers; w++ {
+			wg.Add(1)
+			go func(w int) {
+				defer wg.Done()
+				for i := 0; i < perWorker; i++ {
+					u := user(fmt.Sprintf("%d-%d", w, i))
+					if err := s.Create(ctx, u); err != nil {
+						errs <- err
+						continue
+					}
+					u.Name = "Updated"
+					if err := s.Update(ctx, u); err != nil {
+						errs <- err
+					}
+					s.GetAll(ctx)
+					s.ForEach(ctx, func(store.User) error { return nil })
+				}
+			}(w)
+		}
+		wg.Wait()
+		close(errs)
+		for err := range errs {
+			t.Fatal(err)
+		}
+
+		users, err := s.GetAll(ctx)
+		if err != nil || len(users) != workers*perWorker {
+			t.Fatalf("GetAll = %d users, %v; want %d", len(users), err, workers*perWorker)
+		}
+		for _, u := range users {
+			if u.Name != "Updated" {
+				t.Fatalf("user %s lost its update", u.ID)
+			}
+		}
+		pending, _ := s.PendingEvents(ctx, workers*perWorker*2)
+		if len(pending) != workers*perWorker*2 {
+			t.Fatalf("PendingEvents = %d, want one per write", len(pending))
+		}
+	}},
+	{"ParallelTransitions", func(t *testing.T, s store.Store) {
+		ctx := context.Background()
+		mustCreate(t, s, user("1"))
+		var wg sync.WaitGroup
+		var mu sync.Mutex
+		succeeded := 0
+		for i := 0; i < 20; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				if _, err := s.Transition(ctx, "1", store.StatusSuspended); err == nil {
+					mu.Lock()
+					succeeded++
+					mu.Unlock()
+				}
+			}()
+		}
+		wg.Wait()
+		if succeeded != 1 {
+			t.Fatalf("%d concurrent active->suspended transitions succeeded, want 1", succeeded)
+		}
+	}},
+}
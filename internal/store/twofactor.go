@@ -0,0 +1,85 @@
+package store
+
+import (
+	"context"
+	"crypto/hmac"
+	"errors"
+	"time"
+
+	"user-service/internal/totp"
+)
+
+var (
+	ErrTwoFactorEnabled     = errors.New("two-factor authentication already enabled")
+	ErrTwoFactorNotSetUp    = errors.New("two-factor authentication not set up")
+	ErrInvalidTwoFactorCode = errors.New("invalid two-factor code")
+)
+
+// TwoFactor holds a user's TOTP secret and hashed recovery codes.
+type TwoFactor struct {
+	Secret        string   `json:"secret"`
+	Enabled       bool     `json:"enabled"`
+	RecoveryCodes []string `json:"recovery_codes,omitempty"`
+	LastStep      int64    `json:"last_step,omitempty"`
+}
+
+// SetupTwoFactor stores a new pending secret and recovery codes for the
+// user, replacing any previous setup that was never verified.
+func (s *UserStore) SetupTwoFactor(ctx context.Context, id string, tf TwoFactor) error {
+	sh := s.shardFor(id)
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+	if _, exists := sh.users[id]; !exists {
+		return ErrUserNotFound
+	}
+	if sh.twoFactor[id].Enabled {
+		return ErrTwoFactorEnabled
+	}
+	sh.twoFactor[id] = tf
+	return nil
+}
+
+func (s *UserStore) TwoFactorEnabled(ctx context.Context, id string) (bool, error) {
+	sh := s.shardFor(id)
+	sh.mu.RLock()
+	defer sh.mu.RUnlock()
+	return sh.twoFactor[id].Enabled, nil
+}
+
+// VerifyTwoFactor checks a TOTP code, or a recovery code when allowed,
+// and enables two-factor authentication on first success. A TOTP code
+// cannot be used twice and a recovery code is consumed on use.
+func (s *UserStore) VerifyTwoFactor(ctx context.Context, id, code string, allowRecovery bool, now time.Time) error {
+	sh := s.shardFor(id)
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+	if _, exists := sh.users[id]; !exists {
+		return ErrUserNotFound
+	}
+	tf, ok := sh.twoFactor[id]
+	if !ok {
+		return ErrTwoFactorNotSetUp
+	}
+
+	if step, ok := totp.Validate(tf.Secret, code, now); ok && step > tf.LastStep {
+		if !tf.Enabled {
+			s.appendEvent(ctx, NewEvent(EventTwoFactorEnabled, id))
+		}
+		tf.LastStep = step
+		tf.Enabled = true
+		sh.twoFactor[id] = tf
+		return nil
+	}
+
+	if allowRecovery && tf.Enabled {
+		hashed := totp.HashRecoveryCode(code)
+		for i, rc := range tf.RecoveryCodes {
+			if hmac.Equal([]byte(rc), []byte(hashed)) {
+				tf.RecoveryCodes = append(tf.RecoveryCodes[:i:i], tf.RecoveryCodes[i+1:]...)
+				sh.twoFactor[id] = tf
+				return nil
+			}
+		}
+	}
+	return ErrInvalidTwoFactorCode
+}
@@ -0,0 +1,68 @@
+package store
+
+import "time"
+
+type User struct {
+	ID            string         `json:"id"`
+	Name          string         `json:"name"`
+	Email         string         `json:"email" pii:"email"`
+	Phone         string         `json:"phone,omitempty" pii:"phone"`
+	PhoneVerified bool           `json:"phone_verified"`
+	CustomFields  map[string]any `json:"custom_fields,omitempty"`
+	Status        string         `json:"status"`
+	Scopes        []string       `json:"scopes,omitempty"`
+	LockedUntil   *time.Time     `json:"locked_until,omitempty"`
+	MergedInto    string         `json:"merged_into,omitempty"`
+	// Tenant is the tenant of the credential that created the user. It
+	// never changes.
+	Tenant       string `json:"tenant,omitempty"`
+	Password     string `json:"password,omitempty"`
+	PasswordHash string `json:"-"`
+	// EmailIndex is the blind index of an encrypted email, set by an
+	// EncryptingStore so the user can be found by email without
+	// decrypting every user.
+	EmailIndex string `json:"-"`
+}
+
+const (
+	StatusActive    = "active"
+	StatusSuspended = "suspended"
+	StatusLocked    = "locked"
+	// StatusMerged marks a user folded into another by a merge. It is
+	// final: no transition leads into or out of it.
+	StatusMerged = "merged"
+)
+
+// transitions lists the statuses each status may move to.
+var transitions = map[string][]string{
+	StatusActive:    {StatusSuspended, StatusLocked},
+	StatusSuspended: {StatusActive},
+	StatusLocked:    {StatusActive, StatusSuspended},
+	StatusMerged:    {},
+}
+
+func ValidStatus(status string) bool {
+	_, ok := transitions[status]
+	return ok
+}
+
+func CanTransition(from, to string) bool {
+	for _, s := range transitions[from] {
+		if s == to {
+			return true
+		}
+	}
+	return false
+}
+
+// StatusEvent names the event published when a user enters the status.
+func StatusEvent(status string) string {
+	return statusEvents[status]
+}
+
+// statusEvents names the event published when a user enters each status.
+var statusEvents = map[string]string{
+	StatusActive:    EventUserActivated,
+	StatusSuspended: EventUserSuspended,
+	StatusLocked:    EventUserLocked,
+}
@@ -0,0 +1,86 @@
+package totp
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// rfcSecret is the SHA-1 key of the RFC 6238 test vectors, base32 encoded.
+const rfcSecret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+func TestCodeMatchesRFC6238(t *testing.T) {
+	// The RFC lists eight digits; six are their last six.
+	for unix, want := range map[int64]string{
+		59:         "287082",
+		1111111109: "081804",
+		1234567890: "005924",
+		2000000000: "279037",
+	} {
+		got, err := Code(rfcSecret, unix/Period)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != want {
+			t.Errorf("Code at %d = %s, want %s", unix, got, want)
+		}
+	}
+	if _, err := Code("not base32!", 1); err == nil {
+		t.Error("Code accepted a secret that is not base32")
+	}
+}
+
+func TestValidateAllowsSkew(t *testing.T) {
+	now := time.Unix(1234567890, 0)
+	current := now.Unix() / Period
+	for step := current - Skew - 1; step <= current+Skew+1; step++ {
+		code, err := Code(rfcSecret, step)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, ok := Validate(rfcSecret, code, now)
+		if want := step >= current-Skew && step <= current+Skew; ok != want || (ok && got != step) {
+			t.Errorf("Validate of step %d = %d, %v; want %v", step-current, got, ok, want)
+		}
+	}
+	if _, ok := Validate(rfcSecret, "05924", now); ok {
+		t.Error("Validate accepted a code with too few digits")
+	}
+}
+
+func TestURI(t *testing.T) {
+	uri := URI("user-service", rfcSecret, "jane@example.com")
+	if !strings.HasPrefix(uri, "otpauth://totp/user-service:jane@example.com?") {
+		t.Fatalf("URI = %s", uri)
+	}
+	for _, param := range []string{"secret=" + rfcSecret, "issuer=user-service", "period=30", "digits=6"} {
+		if !strings.Contains(uri, param) {
+			t.Errorf("URI %s lacks %s", uri, param)
+		}
+	}
+}
+
+func TestRecoveryCodes(t *testing.T) {
+	plain, hashed, err := GenerateRecoveryCodes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(plain) != RecoveryCodeCount || len(hashed) != RecoveryCodeCount {
+		t.Fatalf("got %d codes and %d hashes, want %d", len(plain), len(hashed), RecoveryCodeCount)
+	}
+	seen := make(map[string]bool)
+	for i, code := range plain {
+		if seen[code] {
+			t.Errorf("code %s is repeated", code)
+		}
+		seen[code] = true
+		if hashed[i] == code || hashed[i] != HashRecoveryCode(code) {
+			t.Errorf("hash of %s = %s", code, hashed[i])
+		}
+		// Codes are typed in by hand, so case and surrounding space don't
+		// matter.
+		if HashRecoveryCode(" "+strings.ToUpper(code)+"\n") != hashed[i] {
+			t.Errorf("hash of %s depends on case or spacing", code)
+		}
+	}
+}
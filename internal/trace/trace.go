@@ -0,0 +1,82 @@
+// Package trace carries the ID and W3C trace context of the request that
+// started a change into the events and outbound calls it leads to, so
+// one user action can be followed across services.
+package trace
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+	"regexp"
+	"strings"
+)
+
+// Headers that carry a trace between services.
+const (
+	HeaderRequestID   = "X-Request-ID"
+	HeaderTraceParent = "traceparent"
+	HeaderTraceState  = "tracestate"
+)
+
+// Context is what identifies a request to the services it reaches.
+// Parent is a W3C traceparent whose parent ID is this service's span, and
+// State the tracestate the caller sent with it, passed on as is.
+type Context struct {
+	RequestID string
+	Parent    string
+	State     string
+}
+
+type contextKey struct{}
+
+func With(ctx context.Context, c Context) context.Context {
+	return context.WithValue(ctx, contextKey{}, c)
+}
+
+// From returns the trace the context carries, or a zero Context.
+func From(ctx context.Context) Context {
+	c, _ := ctx.Value(contextKey{}).(Context)
+	return c
+}
+
+// traceParent matches the version, trace ID, parent ID and flags of a
+// traceparent. Versions after 00 may append fields, which are ignored.
+var traceParent = regexp.MustCompile(`^([0-9a-f]{2})-([0-9a-f]{32})-([0-9a-f]{16})-([0-9a-f]{2})(-.*)?$`)
+
+// FromRequest starts this service's span of the trace the request
+// belongs to: it keeps the trace ID and flags of a valid traceparent and
+// gives it a new parent ID, or starts a new sampled trace without one.
+// tracestate is only kept along with the traceparent it belongs to.
+func FromRequest(r *http.Request, requestID string) Context {
+	c := Context{RequestID: requestID}
+	m := traceParent.FindStringSubmatch(r.Header.Get(HeaderTraceParent))
+	switch {
+	case m == nil, m[1] == "ff", m[1] == "00" && m[5] != "",
+		m[2] == strings.Repeat("0", 32), m[3] == strings.Repeat("0", 16):
+		c.Parent = "00-" + randomHex(16) + "-" + randomHex(8) + "-01"
+	default:
+		c.Parent = "00-" + m[2] + "-" + randomHex(8) + "-" + m[4]
+		c.State = r.Header.Get(HeaderTraceState)
+	}
+	return c
+}
+
+// Inject sets the trace's headers on an outbound request.
+func (c Context) Inject(h http.Header) {
+	if c.RequestID != "" {
+		h.Set(HeaderRequestID, c.RequestID)
+	}
+	if c.Parent != "" {
+		h.Set(HeaderTraceParent, c.Parent)
+	}
+	if c.State != "" {
+		h.Set(HeaderTraceState, c.State)
+	}
+}
+
+func randomHex(n int) string {
+	buf := make([]byte, n)
+	rand.Read(buf)
+	return hex.EncodeToString(buf)
+}
@@ -1,180 +1,442 @@
 package main
 
 import (
-	"encoding/json"
+	"context"
+	"flag"
 	"fmt"
+	"io"
 	"log"
 	"net/http"
-	"sync"
+	"os"
+	"os/signal"
+	"strconv"
+	"syscall"
+	"time"
 
-	"github.com/gorilla/mux"
+	"user-service/internal/auth"
+	"user-service/internal/config"
+	"user-service/internal/contract"
+	"user-service/internal/discovery"
+	"user-service/internal/fault"
+	"user-service/internal/flags"
+	"user-service/internal/handler"
+	"user-service/internal/i18n"
+	"user-service/internal/idgen"
+	"user-service/internal/loadtest"
+	"user-service/internal/scheduler"
+	"user-service/internal/secrets"
+	"user-service/internal/seed"
+	"user-service/internal/service"
+	"user-service/internal/store"
 )
 
-type User struct {
-	ID    string `json:"id"`
-	Name  string `json:"name"`
-	Email string `json:"email"`
+func loadBreakerConfig(src *config.Source) store.BreakerConfig {
+	return store.BreakerConfig{
+		Threshold:    src.Int("STORE_BREAKER_THRESHOLD", 5),
+		Cooldown:     src.Duration("STORE_BREAKER_COOLDOWN", 10*time.Second),
+		Retries:      src.Int("STORE_RETRIES", 2),
+		RetryBackoff: src.Duration("STORE_RETRY_BACKOFF", 50*time.Millisecond),
+	}
 }
 
-type UserStore struct {
-	mu    sync.RWMutex
-	users map[string]User
+// loadFieldCipher reads PII_ENCRYPTION_KEYS, a comma-separated list of
+// id:base64-key pairs with 16, 24 or 32 byte keys. The first key is used
+// for new writes. It returns nil when no keys are configured.
+func loadFieldCipher(src *config.Source) (*store.FieldCipher, error) {
+	keys := src.String("PII_ENCRYPTION_KEYS", "")
+	if keys == "" {
+		return nil, nil
+	}
+	fc, err := store.ParseFieldCipher(keys)
+	if err != nil {
+		return nil, fmt.Errorf("PII_ENCRYPTION_KEYS: %w", err)
+	}
+	return fc, nil
 }
 
-func NewUserStore() *UserStore {
-	return &UserStore{
-		users: make(map[string]User),
+// openStore returns the named storage backend. Only the in-memory store
+// exists so far.
+func openStore(src *config.Source, name string) (store.Store, error) {
+	switch name {
+	case "memory":
+		return store.NewShardedUserStore(src.Int("STORE_SHARDS", store.DefaultShards)), nil
+	default:
+		return nil, fmt.Errorf("unknown store %q", name)
 	}
 }
 
-func (s *UserStore) Create(user User) {
-	s.mu.Lock()
-	defer s.mu.Unlock()
-	s.users[user.ID] = user
+// loadStore opens the primary store from --primary-store or
+// STORE_PRIMARY and, when --shadow-store or STORE_SHADOW names another,
+// wraps it to repeat writes and compare reads on the shadow.
+func loadStore(src *config.Source, primaryFlag, shadowFlag string) (store.Store, error) {
+	primaryName, shadowName := primaryFlag, shadowFlag
+	if primaryName == "" {
+		primaryName = src.String("STORE_PRIMARY", "memory")
+	}
+	if shadowName == "" {
+		shadowName = src.String("STORE_SHADOW", "")
+	}
+	primary, err := openStore(src, primaryName)
+	if err != nil {
+		return nil, fmt.Errorf("primary store: %w", err)
+	}
+	if shadowName == "" {
+		return primary, nil
+	}
+	shadow, err := openStore(src, shadowName)
+	if err != nil {
+		return nil, fmt.Errorf("shadow store: %w", err)
+	}
+	log.Printf("store: writing to %s with %s as shadow", primaryName, shadowName)
+	return store.NewShadowStore(primary, shadow, store.ShadowConfig{
+		CompareRate: src.Float("STORE_SHADOW_COMPARE_RATE", 1),
+		QueueSize:   src.Int("STORE_SHADOW_QUEUE_SIZE", 1000),
+	}), nil
 }
 
-func (s *UserStore) Get(id string) (User, bool) {
-	s.mu.RLock()
-	defer s.mu.RUnlock()
-	user, exists := s.users[id]
-	return user, exists
+// subcommands run instead of the server when named as the first argument.
+var subcommands = map[string]func(args []string, out io.Writer) error{
+	"contract": contract.Run,
+	"loadtest": loadtest.Run,
+	"seed":     seed.Run,
 }
 
-func (s *UserStore) GetAll() []User {
-	s.mu.RLock()
-	defer s.mu.RUnlock()
-	users := make([]User, 0, len(s.users))
-	for _, user := range s.users {
-		users = append(users, user)
+// loadSeedUsers returns the users in the seed file, from --seed-file or
+// SEED_FILE, or the built-in samples when neither is set.
+func loadSeedUsers(src *config.Source, flagPath string) ([]store.User, error) {
+	path := flagPath
+	if path == "" {
+		path = src.String("SEED_FILE", "")
 	}
-	return users
+	if path == "" {
+		return seed.Samples(), nil
+	}
+	return seed.Load(path)
 }
 
-func (s *UserStore) Update(user User) bool {
-	s.mu.Lock()
-	defer s.mu.Unlock()
-	if _, exists := s.users[user.ID]; exists {
-		s.users[user.ID] = user
-		return true
-	}
-	return false
+// app is the service wired together from its configuration, ready to
+// serve.
+type app struct {
+	router http.Handler
+	hub    *service.Hub
+	jobs   *scheduler.Scheduler
 }
 
-func (s *UserStore) Delete(id string) bool {
-	s.mu.Lock()
-	defer s.mu.Unlock()
-	if _, exists := s.users[id]; exists {
-		delete(s.users, id)
-		return true
+// newApp builds the service from its configuration and starts its
+// background work, which stops with ctx. The seed file and store names
+// override their settings when not empty.
+func newApp(ctx context.Context, src *config.Source, seedFile, primaryStore, shadowStore string) (*app, error) {
+	secretStore, err := secrets.Load(src)
+	if err != nil {
+		return nil, fmt.Errorf("secrets: %w", err)
+	}
+	var resolver config.SecretResolver
+	var secretCache *secrets.Cache
+	if secretStore != nil {
+		secretCache = secrets.NewCache(secretStore, src.Duration("SECRETS_REFRESH_INTERVAL", 5*time.Minute))
+		resolver = secretCache
+	}
+	if err := src.UseSecrets(resolver); err != nil {
+		return nil, err
+	}
+	seedUsers, err := loadSeedUsers(src, seedFile)
+	if err != nil {
+		return nil, err
 	}
-	return false
-}
 
-var store *UserStore
-
-// CORS middleware
-func corsMiddleware(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		w.Header().Set("Access-Control-Allow-Origin", "*")
-		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
-		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
-		
-		if r.Method == "OPTIONS" {
-			w.WriteHeader(http.StatusOK)
-			return
+	backend, err := loadStore(src, primaryStore, shadowStore)
+	if err != nil {
+		return nil, err
+	}
+	// Store faults sit under the breaker, so they exercise it like real
+	// backend failures would.
+	var faults *fault.Injector
+	if src.String("FAULT_INJECTION_ENABLED", "false") == "true" {
+		rules, err := fault.Load(src)
+		if err != nil {
+			return nil, err
 		}
-		
-		next.ServeHTTP(w, r)
-	})
-}
+		if faults, err = fault.NewInjector(rules); err != nil {
+			return nil, err
+		}
+		backend = store.NewFaultStore(backend, faults)
+		log.Printf("fault injection enabled with %d rules", len(rules))
+	}
+	var userStore store.Store = store.NewBreakerStore(backend, loadBreakerConfig(src))
+	if src.String("STORE_BLOOM_FILTER", "true") == "true" {
+		userStore, err = store.NewBloomStore(context.Background(), userStore, store.BloomConfig{
+			ExpectedUsers:     src.Int("STORE_BLOOM_EXPECTED_USERS", 100000),
+			FalsePositiveRate: src.Float("STORE_BLOOM_FP_RATE", 0.01),
+		})
+		if err != nil {
+			return nil, fmt.Errorf("bloom: %w", err)
+		}
+	}
+	if src.String("STORE_COALESCE_READS", "true") == "true" {
+		userStore = store.NewCoalescingStore(userStore)
+	}
+	fieldCipher, err := loadFieldCipher(src)
+	if err != nil {
+		return nil, err
+	}
+	var piiStore *store.EncryptingStore
+	if fieldCipher != nil {
+		piiStore = store.NewEncryptingStore(userStore, fieldCipher)
+		userStore = piiStore
+	}
 
-func healthCheckHandler(w http.ResponseWriter, r *http.Request) {
-	w.WriteHeader(http.StatusOK)
-	json.NewEncoder(w).Encode(map[string]string{"status": "healthy", "service": "user-service"})
-}
+	tokens := auth.LoadTokenIssuer(src)
+	authenticator := auth.NewAuthenticator(auth.LoadConfig(src), tokens)
+	hooks := service.NewHooks()
+	emails := service.NewEmails(service.LoadEmailPolicy(src))
+	emails.SetDomains(service.LoadDomainPolicy(src))
+	schemas := service.NewSchemas()
+	ids, err := idgen.Load(src)
+	if err != nil {
+		return nil, err
+	}
+	quotas := service.NewQuotas(service.LoadQuotaPolicy(src))
+	users := service.NewUsers(userStore, func() []string { return authenticator.Config().DefaultScopes }, hooks, emails, schemas, ids, quotas)
+	duplicates := service.NewDuplicates(userStore, emails, service.LoadDuplicateRules(src))
+	preferenceSchema, err := service.LoadPreferenceSchema(src)
+	if err != nil {
+		return nil, err
+	}
+	preferences := service.NewPreferences(userStore, preferenceSchema)
+	logins := service.NewLogins(userStore, tokens, emails, service.LoadLoginPolicy(src))
+	authenticator.CheckSubjects(logins.TokenSubjectAllowed)
+	featureFlags := flags.NewSet(flags.Load(src))
+	bodyLog := handler.NewBodyLogger(handler.LoadBodyLogConfig(src))
+	cors := handler.NewCORS(handler.LoadCORSOrigins(src))
+	limiter := handler.NewLimiter(handler.LoadLimitConfig(src))
+	deprecations := handler.NewDeprecations(handler.LoadDeprecationConfig(src))
+	security := handler.NewSecurityHeaders(handler.LoadSecurityHeadersConfig(src))
 
-func createUserHandler(w http.ResponseWriter, r *http.Request) {
-	var user User
-	if err := json.NewDecoder(r.Body).Decode(&user); err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
-		return
+	catalog, err := i18n.Load(src.String("I18N_DIR", ""))
+	if err != nil {
+		return nil, fmt.Errorf("i18n: %w", err)
 	}
 
-	if user.ID == "" || user.Name == "" || user.Email == "" {
-		http.Error(w, "ID, Name, and Email are required", http.StatusBadRequest)
-		return
+	ipFilter, err := handler.NewIPFilter(handler.LoadIPFilterConfig(src))
+	if err != nil {
+		return nil, fmt.Errorf("ip filter: %w", err)
 	}
 
-	store.Create(user)
-	w.WriteHeader(http.StatusCreated)
-	json.NewEncoder(w).Encode(user)
-}
+	maintenance, err := handler.LoadMaintenance(src.String("MAINTENANCE_STATE_FILE", "maintenance.json"))
+	if err != nil {
+		return nil, fmt.Errorf("maintenance: %w", err)
+	}
+
+	src.OnReload(func() { logins.SetPolicy(service.LoadLoginPolicy(src)) }, "LOGIN_")
+	src.OnReload(func() { emails.SetPolicy(service.LoadEmailPolicy(src)) }, "EMAIL_")
+	src.OnReload(func() { emails.SetDomains(service.LoadDomainPolicy(src)) }, "EMAIL_DOMAIN_")
+	src.OnReload(func() { duplicates.SetRules(service.LoadDuplicateRules(src)) }, "DUPLICATE_")
+	src.OnReload(func() {
+		schema, err := service.LoadPreferenceSchema(src)
+		if err != nil {
+			log.Printf("%v, keeping the current schema", err)
+			return
+		}
+		preferences.SetSchema(schema)
+	}, "PREFERENCES")
+	src.OnReload(func() { quotas.SetPolicy(service.LoadQuotaPolicy(src)) }, "TENANT_QUOTAS", "API_KEY_QUOTAS")
+	src.OnReload(func() { authenticator.Reload(auth.LoadConfig(src)) }, "API_KEYS", "API_KEY_SECRETS", "API_KEY_TENANTS", "SIGNATURE_WINDOW", "MAX_BODY_BYTES", "MTLS_IDENTITIES", "DEFAULT_USER_SCOPES")
+	src.OnReload(func() { bodyLog.SetConfig(handler.LoadBodyLogConfig(src)) }, "BODY_LOG_")
+	src.OnReload(func() { cors.SetOrigins(handler.LoadCORSOrigins(src)) }, "CORS_ALLOWED_ORIGINS")
+	src.OnReload(func() { limiter.SetConfig(handler.LoadLimitConfig(src)) }, "CONCURRENCY_")
+	src.OnReload(func() {
+		if err := ipFilter.SetConfig(handler.LoadIPFilterConfig(src)); err != nil {
+			log.Printf("ip filter: %v, keeping the current lists", err)
+		}
+	}, "IP_ALLOWLIST", "IP_DENYLIST", "TRUSTED_PROXIES")
+	if faults != nil {
+		src.OnReload(func() {
+			rules, err := fault.Load(src)
+			if err == nil {
+				err = faults.SetRules(rules)
+			}
+			if err != nil {
+				log.Printf("%v, keeping the current fault rules", err)
+			}
+		}, "FAULT_RULES")
+	}
+	src.OnReload(func() { security.SetConfig(handler.LoadSecurityHeadersConfig(src)) }, "SECURITY_HEADERS")
+	src.OnReload(func() { deprecations.SetConfig(handler.LoadDeprecationConfig(src)) }, "DEPRECATED_USER_FIELDS")
+	src.OnReload(func() { featureFlags.Replace(flags.Load(src)) }, "FEATURE_FLAGS")
+
+	if secretCache != nil {
+		go secretCache.Run(ctx, time.Second, func(paths []string) {
+			result := src.SecretsChanged(paths)
+			log.Printf("secrets: %v changed, applied %v, requires restart %v", paths, result.Applied, result.RequiresRestart)
+		})
+	}
+	if vault, ok := secretStore.(*secrets.Vault); ok {
+		go vault.KeepTokenAlive(ctx)
+	}
+	result, err := seed.Apply(ctx, users, seedUsers)
+	if err != nil {
+		return nil, fmt.Errorf("seed: %w", err)
+	}
+	log.Printf("seed: created %d users, skipped %d existing", result.Created, result.Skipped)
+	if seq, ok := ids.(*idgen.Sequential); ok {
+		// Carry on after the highest numeric ID already stored.
+		userStore.ForEach(ctx, func(user store.User) error {
+			seq.Skip(user.ID)
+			return nil
+		})
+	}
 
-func getUserHandler(w http.ResponseWriter, r *http.Request) {
-	vars := mux.Vars(r)
-	id := vars["id"]
+	hub := service.NewHub()
+	relay := service.NewOutboxRelay(userStore, hooks.Publisher(hub.Publisher(quotas.Publisher(service.LogPublisher{}))),
+		src.Duration("OUTBOX_POLL_INTERVAL", time.Second), src.Int("OUTBOX_BATCH_SIZE", 100))
+	go relay.Run(ctx)
 
-	user, exists := store.Get(id)
-	if !exists {
-		http.Error(w, "User not found", http.StatusNotFound)
-		return
+	deletions := service.NewDeletions(users, userStore, service.LoadDeletionPolicy(src))
+	sms, err := service.LoadSMSSender(src)
+	if err != nil {
+		return nil, fmt.Errorf("sms: %w", err)
+	}
+	phones := service.NewPhones(userStore, sms, service.LoadPhonePolicy(src))
+	hooks.OnDelete("phone-codes", service.Sync, phones.ForgetUser)
+	hooks.OnDelete("delete-requests", service.Async, deletions.UserDeleted)
+	stats := service.NewStats(userStore, src.Duration("STATS_CACHE_TTL", 10*time.Second))
+	jobs := scheduler.Load(src)
+	for _, job := range []struct {
+		name, schedule string
+		run            func(context.Context) error
+	}{
+		{"delete-requests", "1s", deletions.Sweep},
+		{"phone-codes", "1m", phones.ExpireCodes},
+		{"stats-refresh", "1m", stats.Refresh},
+		{"duplicates", "1h", duplicates.Scan},
+	} {
+		if err := jobs.Add(job.name, job.schedule, job.run); err != nil {
+			return nil, fmt.Errorf("jobs: %w", err)
+		}
 	}
+	jobs.Run(ctx)
 
-	json.NewEncoder(w).Encode(user)
-}
+	h := handler.New(handler.Options{
+		Users:        users,
+		Logins:       logins,
+		TwoFactor:    service.NewTwoFactor(userStore),
+		Phones:       phones,
+		Schemas:      schemas,
+		Emails:       emails,
+		Deletions:    deletions,
+		Hub:          hub,
+		Quotas:       quotas,
+		Stats:        stats,
+		Jobs:         jobs,
+		TestData:     service.NewTestData(users, service.LoadTestDataPolicy(src)),
+		Duplicates:   duplicates,
+		Preferences:  preferences,
+		Faults:       faults,
+		Auth:         authenticator,
+		PII:          piiStore,
+		BodyLog:      bodyLog,
+		Deprecations: deprecations,
+		Limiter:      limiter,
+		CheckLimit:   handler.LoadCheckRateLimiter(src),
+		Flags:        featureFlags,
+		Maintenance:  maintenance,
+		CORS:         cors,
+		Security:     security,
+		IPFilter:     ipFilter,
+		Config:       src,
+		Catalog:      catalog,
 
-func getAllUsersHandler(w http.ResponseWriter, r *http.Request) {
-	users := store.GetAll()
-	json.NewEncoder(w).Encode(users)
+		MaxRestoreBytes: int64(src.Int("MAX_RESTORE_BYTES", 1<<30)),
+	})
+	return &app{router: h.Router(), hub: hub, jobs: jobs}, nil
 }
 
-func updateUserHandler(w http.ResponseWriter, r *http.Request) {
-	vars := mux.Vars(r)
-	id := vars["id"]
+func main() {
+	if len(os.Args) > 1 {
+		if run, ok := subcommands[os.Args[1]]; ok {
+			if err := run(os.Args[2:], os.Stdout); err != nil {
+				log.Fatal(err)
+			}
+			return
+		}
+	}
+
+	seedFile := flag.String("seed-file", "", "JSON or CSV file of users to create at startup (default $SEED_FILE)")
+	primaryStore := flag.String("primary-store", "", "Backend that serves reads and writes: memory (default $STORE_PRIMARY or memory)")
+	shadowStore := flag.String("shadow-store", "", "Backend that also receives every write and has reads compared against it (default $STORE_SHADOW)")
+	flag.Parse()
 
-	var user User
-	if err := json.NewDecoder(r.Body).Decode(&user); err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
-		return
+	src, err := config.Load()
+	if err != nil {
+		log.Fatal(err)
 	}
+	ctx := context.Background()
+	// Background work, the scheduled jobs among it, stops on the signals
+	// that shut the server down.
+	stop, cancel := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+	app, err := newApp(stop, src, *seedFile, *primaryStore, *shadowStore)
+	if err != nil {
+		log.Fatal(err)
+	}
+	go src.ReloadOnSIGHUP()
 
-	user.ID = id
-	if !store.Update(user) {
-		http.Error(w, "User not found", http.StatusNotFound)
-		return
+	port := src.Int("PORT", 8080)
+	server := &http.Server{Addr: ":" + strconv.Itoa(port), Handler: app.router}
+	// Answer long polls at once on shutdown instead of holding it up.
+	server.RegisterOnShutdown(app.hub.Close)
+	tlsConfig := auth.LoadTLSConfig(src)
+	if tlsConfig.Enabled() {
+		if server.TLSConfig, err = tlsConfig.ServerConfig(); err != nil {
+			log.Fatalf("tls: %v", err)
+		}
 	}
 
-	json.NewEncoder(w).Encode(user)
-}
+	registry, err := discovery.Load(src)
+	if err != nil {
+		log.Fatalf("discovery: %v", err)
+	}
 
-func deleteUserHandler(w http.ResponseWriter, r *http.Request) {
-	vars := mux.Vars(r)
-	id := vars["id"]
+	serveErr := make(chan error, 1)
+	go func() {
+		if !tlsConfig.Enabled() {
+			fmt.Printf("User Service starting on port %d...\n", port)
+			serveErr <- server.ListenAndServe()
+			return
+		}
+		fmt.Printf("User Service starting on port %d with TLS...\n", port)
+		serveErr <- server.ListenAndServeTLS("", "")
+	}()
 
-	if !store.Delete(id) {
-		http.Error(w, "User not found", http.StatusNotFound)
-		return
+	var registration discovery.Registration
+	registered, stopRegistering := context.WithCancel(ctx)
+	defer stopRegistering()
+	if registry != nil {
+		registration = discovery.LoadRegistration(src, port, tlsConfig.Enabled())
+		go discovery.Keep(registered, registry, registration, src.Duration("SERVICE_REFRESH_INTERVAL", time.Minute))
 	}
 
-	w.WriteHeader(http.StatusNoContent)
-}
+	select {
+	case err := <-serveErr:
+		log.Fatal(err)
+	case <-stop.Done():
+	}
 
-func main() {
-	store = NewUserStore()
-
-	// Add some sample users
-	store.Create(User{ID: "1", Name: "John Doe", Email: "john@example.com"})
-	store.Create(User{ID: "2", Name: "Jane Smith", Email: "jane@example.com"})
-
-	router := mux.NewRouter()
-	router.HandleFunc("/health", healthCheckHandler).Methods("GET")
-	router.HandleFunc("/users", createUserHandler).Methods("POST")
-	router.HandleFunc("/users", getAllUsersHandler).Methods("GET")
-	router.HandleFunc("/users/{id}", getUserHandler).Methods("GET")
-	router.HandleFunc("/users/{id}", updateUserHandler).Methods("PUT")
-	router.HandleFunc("/users/{id}", deleteUserHandler).Methods("DELETE")
-
-	port := "8080"
-	fmt.Printf("User Service starting on port %s...\n", port)
-	log.Fatal(http.ListenAndServe(":"+port, corsMiddleware(router)))
+	// Leave the registry first so no new traffic is sent our way while
+	// the requests in flight finish.
+	shutdown, done := context.WithTimeout(ctx, src.Duration("SHUTDOWN_TIMEOUT", 10*time.Second))
+	defer done()
+	if registry != nil {
+		stopRegistering()
+		if err := registry.Deregister(shutdown, registration.ID); err != nil {
+			log.Printf("discovery: deregister %s: %v", registration.ID, err)
+		}
+	}
+	if err := server.Shutdown(shutdown); err != nil {
+		log.Printf("shutdown: %v", err)
+	}
+	if err := app.jobs.Wait(shutdown); err != nil {
+		log.Printf("shutdown: jobs still running: %v", err)
+	}
+	log.Printf("shutdown: stopped")
 }
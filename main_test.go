@@ -0,0 +1,39 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"user-service/internal/config"
+	"user-service/internal/contract"
+)
+
+// TestContract runs the contract suite against the service as main wires
+// it, served in process.
+func TestContract(t *testing.T) {
+	for _, entry := range contract.ServerEnv(t.TempDir()) {
+		key, value, _ := strings.Cut(entry, "=")
+		t.Setenv(key, value)
+	}
+	src, err := config.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	app, err := newApp(ctx, src, "", "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := httptest.NewServer(app.router)
+	defer srv.Close()
+	defer app.hub.Close()
+
+	var out bytes.Buffer
+	if err := contract.Check(srv.URL, &out); err != nil {
+		t.Fatalf("%v\n%s", err, out.String())
+	}
+}